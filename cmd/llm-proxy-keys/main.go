@@ -7,12 +7,16 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/Instawork/llm-proxy/internal/apikeys"
 	"github.com/Instawork/llm-proxy/internal/config"
+	redis "github.com/redis/go-redis/v9"
+	_ "modernc.org/sqlite" // Registers the driver for the sqlite key backend
 )
 
 const (
@@ -29,11 +33,36 @@ func main() {
 		description = flag.String("desc", "", "Description for the key")
 		costLimit   = flag.Int64("cost-limit", 10000, "Daily cost limit in cents (default: $100)")
 		listKeys    = flag.Bool("list", false, "List all API keys")
+		stale       = flag.String("stale", "", "List only keys not used for this long (e.g. 30d or 72h); implies -list")
 		deleteKey   = flag.String("delete", "", "Delete an API key")
 		disableKey  = flag.String("disable", "", "Disable an API key")
 		enableKey   = flag.String("enable", "", "Enable an API key")
 		showKey     = flag.String("show", "", "Show details of a specific API key")
 		tags        = flag.String("tags", "", "Comma-separated key=value tags")
+		reencrypt   = flag.Bool("reencrypt", false, "Encrypt plaintext provider keys and re-wrap keys sealed with a previous master key")
+		genMaster   = flag.Bool("generate-master-key", false, "Print a new random master key for local encryption")
+		migrateHash = flag.Bool("migrate-hashed-keys", false, "Re-store keys created before hashing under their SHA-256 digest")
+		rotateKey   = flag.String("rotate", "", "Mint a successor for an API key; the old key stays valid for -grace")
+		grace       = flag.Duration("grace", 24*time.Hour, "How long a rotated key stays valid")
+		rotateUp    = flag.String("rotate-upstream", "", "Replace the upstream key (-key) of an API key for -provider (default: its primary provider)")
+		provKeys    = flag.String("provider-keys", "", "Comma-separated provider=key upstream keys for further providers (e.g. anthropic=sk-ant-xxx)")
+		addProvider = flag.String("add-provider", "", "Add or replace the -provider upstream key (-key) on an API key")
+		rmProvider  = flag.String("remove-provider", "", "Remove the -provider upstream key from an API key")
+		setScope    = flag.String("set-scope", "", "Replace the scope of an API key with the scope flags (none clears it)")
+		allowModels = flag.String("allow-models", "", "Scope: comma-separated model globs the key may use (e.g. gpt-4o-mini*)")
+		denyModels  = flag.String("deny-models", "", "Scope: comma-separated model globs the key may not use")
+		allowPaths  = flag.String("allow-endpoints", "", "Scope: comma-separated endpoint paths without provider prefix (e.g. /v1/chat/completions)")
+		maxTokens   = flag.Int("max-tokens", 0, "Scope: maximum max_tokens/max_output_tokens a request may ask for")
+		allowCIDRs  = flag.String("allow-cidrs", "", "Scope: comma-separated source CIDRs the key may be used from")
+		team        = flag.String("team", "", "Team the key belongs to (with create or -set-team)")
+		project     = flag.String("project", "", "Project under -team that the key's cost is attributed to")
+		setTeam     = flag.String("set-team", "", "Move an API key to -team and -project (no -team removes it from its team)")
+		saveTeam    = flag.String("save-team", "", "Create or update a team, or a project with -parent")
+		teamName    = flag.String("team-name", "", "Human-readable name for -save-team")
+		parentTeam  = flag.String("parent", "", "Parent team for -save-team; makes it a project")
+		teamLimits  = flag.String("team-limits", "", "Comma-separated limits for -save-team (e.g. requests_per_minute=600,cost_per_day=200)")
+		listTeams   = flag.Bool("list-teams", false, "List teams and projects")
+		deleteTeam  = flag.String("delete-team", "", "Delete a team or project that no keys or projects use")
 	)
 
 	flag.Usage = func() {
@@ -41,17 +70,47 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Commands:\n")
 		fmt.Fprintf(os.Stderr, "  Create a key:    -provider=openai -key=sk-xxx -desc=\"Production key\" -cost-limit=50000\n")
+		fmt.Fprintf(os.Stderr, "  Rotate key:      -rotate=iw:xxx -grace=48h\n")
+		fmt.Fprintf(os.Stderr, "  Rotate upstream: -rotate-upstream=iw:xxx -key=sk-new [-provider=anthropic]\n")
+		fmt.Fprintf(os.Stderr, "  Multi-provider:  -provider=openai -key=sk-xxx -provider-keys=anthropic=sk-ant-xxx,gemini=AIza-xxx\n")
+		fmt.Fprintf(os.Stderr, "  Add provider:    -add-provider=iw:xxx -provider=gemini -key=AIza-xxx\n")
+		fmt.Fprintf(os.Stderr, "  Remove provider: -remove-provider=iw:xxx -provider=gemini\n")
+		fmt.Fprintf(os.Stderr, "  Scoped key:      -provider=openai -key=sk-xxx -allow-models=gpt-4o-mini* -allow-endpoints=/v1/chat/completions -max-tokens=1024\n")
+		fmt.Fprintf(os.Stderr, "  Change scope:    -set-scope=iw:xxx -allow-cidrs=10.0.0.0/8 (no scope flags clears the scope)\n")
+		fmt.Fprintf(os.Stderr, "  Create team:     -save-team=platform -team-name=Platform -team-limits=requests_per_minute=600,cost_per_day=200\n")
+		fmt.Fprintf(os.Stderr, "  Create project:  -save-team=platform-ci -parent=platform\n")
+		fmt.Fprintf(os.Stderr, "  Team key:        -provider=openai -key=sk-xxx -team=platform -project=platform-ci\n")
+		fmt.Fprintf(os.Stderr, "  Move key:        -set-team=iw:xxx -team=platform (no -team removes it from its team)\n")
+		fmt.Fprintf(os.Stderr, "  List teams:      -list-teams\n")
+		fmt.Fprintf(os.Stderr, "  Delete team:     -delete-team=platform\n")
 		fmt.Fprintf(os.Stderr, "  List keys:       -list\n")
-		fmt.Fprintf(os.Stderr, "  Show key:        -show=iw:xxx\n")
+		fmt.Fprintf(os.Stderr, "  Unused keys:     -stale=30d\n")
+		fmt.Fprintf(os.Stderr, "  Show key:        -show=iw:xxx (or -show=sha256:xxx)\n")
 		fmt.Fprintf(os.Stderr, "  Delete key:      -delete=iw:xxx\n")
 		fmt.Fprintf(os.Stderr, "  Disable key:     -disable=iw:xxx\n")
-		fmt.Fprintf(os.Stderr, "  Enable key:      -enable=iw:xxx\n\n")
+		fmt.Fprintf(os.Stderr, "  Enable key:      -enable=iw:xxx\n")
+		fmt.Fprintf(os.Stderr, "  New master key:  -generate-master-key\n")
+		fmt.Fprintf(os.Stderr, "  Re-encrypt keys: -reencrypt\n")
+		fmt.Fprintf(os.Stderr, "  Hash old keys:   -migrate-hashed-keys\n\n")
+		fmt.Fprintf(os.Stderr, "Keys are stored hashed: -show, -delete, -disable and -enable accept the\n")
+		fmt.Fprintf(os.Stderr, "iw: key itself or the key ID shown by -list.\n\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
 	}
 
 	flag.Parse()
 
+	// Generating a master key needs no configuration or store
+	if *genMaster {
+		key, err := apikeys.GenerateMasterKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to generate master key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(key)
+		return
+	}
+
 	// Set up logger
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
@@ -70,12 +129,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Create API key store
-	store, err := apikeys.NewStore(apikeys.StoreConfig{
-		TableName: yamlConfig.Features.APIKeyManagement.TableName,
-		Region:    yamlConfig.Features.APIKeyManagement.Region,
-		Logger:    logger,
-	})
+	// Create API key store for the configured backend
+	apiKeyConfig := yamlConfig.Features.APIKeyManagement
+	backendConfig := apikeys.BackendConfigFromYAML(apiKeyConfig, logger)
+	backendConfig.ReloadInterval = -1 // one-shot command, no need to watch the key file
+	store, err := apikeys.NewKeyStore(backendConfig)
 	if err != nil {
 		logger.Error("Failed to create API key store", "error", err)
 		os.Exit(1)
@@ -83,26 +141,79 @@ func main() {
 
 	ctx := context.Background()
 
+	scope, err := scopeFromFlags(*allowModels, *denyModels, *allowPaths, *maxTokens, *allowCIDRs)
+	if err != nil {
+		logger.Error("Invalid scope", "error", err)
+		os.Exit(1)
+	}
+
 	// Handle commands
 	switch {
-	case *listKeys:
-		handleList(ctx, store, *provider, logger)
+	case *reencrypt:
+		handleReencrypt(ctx, backendConfig, logger)
+	case *migrateHash:
+		handleMigrateHashedKeys(ctx, backendConfig, logger)
+	case *listTeams:
+		handleListTeams(ctx, store, logger)
+	case *saveTeam != "":
+		handleSaveTeam(ctx, store, *saveTeam, *teamName, *parentTeam, *teamLimits, logger)
+	case *deleteTeam != "":
+		handleDeleteTeam(ctx, store, *deleteTeam, logger)
+	case *setTeam != "":
+		keyID := resolveKeyID(ctx, store, *setTeam, logger)
+		handleSetTeam(ctx, store, keyID, *team, *project, logger)
+		publishInvalidation(ctx, yamlConfig, keyID, logger)
+	case *listKeys || *stale != "":
+		handleList(ctx, store, *provider, *stale, logger)
 	case *showKey != "":
 		handleShow(ctx, store, *showKey, logger)
 	case *deleteKey != "":
-		handleDelete(ctx, store, *deleteKey, logger)
+		keyID := resolveKeyID(ctx, store, *deleteKey, logger)
+		handleDelete(ctx, store, keyID, logger)
+		publishInvalidation(ctx, yamlConfig, keyID, logger)
 	case *disableKey != "":
-		handleDisable(ctx, store, *disableKey, logger)
+		keyID := resolveKeyID(ctx, store, *disableKey, logger)
+		handleDisable(ctx, store, keyID, logger)
+		publishInvalidation(ctx, yamlConfig, keyID, logger)
 	case *enableKey != "":
-		handleEnable(ctx, store, *enableKey, logger)
+		keyID := resolveKeyID(ctx, store, *enableKey, logger)
+		handleEnable(ctx, store, keyID, logger)
+		publishInvalidation(ctx, yamlConfig, keyID, logger)
+	case *rotateKey != "":
+		keyID := resolveKeyID(ctx, store, *rotateKey, logger)
+		handleRotate(ctx, store, keyID, *grace, logger)
+		publishInvalidation(ctx, yamlConfig, keyID, logger)
+	case *rotateUp != "":
+		keyID := resolveKeyID(ctx, store, *rotateUp, logger)
+		handleRotateUpstream(ctx, store, keyID, *provider, *actualKey, logger)
+		publishInvalidation(ctx, yamlConfig, keyID, logger)
+	case *addProvider != "":
+		if *actualKey == "" {
+			logger.Error("-add-provider requires -provider and -key")
+			os.Exit(1)
+		}
+		keyID := resolveKeyID(ctx, store, *addProvider, logger)
+		handleSetProviderKey(ctx, store, keyID, *provider, *actualKey, logger)
+		publishInvalidation(ctx, yamlConfig, keyID, logger)
+	case *rmProvider != "":
+		keyID := resolveKeyID(ctx, store, *rmProvider, logger)
+		handleSetProviderKey(ctx, store, keyID, *provider, "", logger)
+		publishInvalidation(ctx, yamlConfig, keyID, logger)
+	case *setScope != "":
+		keyID := resolveKeyID(ctx, store, *setScope, logger)
+		handleSetScope(ctx, store, keyID, scope, logger)
+		publishInvalidation(ctx, yamlConfig, keyID, logger)
 	case *provider != "" && *actualKey != "":
-		handleCreate(ctx, store, *provider, *actualKey, *description, *costLimit, *tags, logger)
+		handleCreate(ctx, store, *provider, *actualKey, *description, *costLimit, *tags, *provKeys, scope, *team, *project, logger)
 	default:
 		flag.Usage()
 		os.Exit(1)
 	}
 }
 
+// validProviders are the providers a virtual key can hold upstream keys for
+var validProviders = []string{"openai", "anthropic", "gemini"}
+
 // loadConfig loads the configuration from YAML files
 func loadConfig(configDir, environment string) (*config.YAMLConfig, error) {
 	// Build list of config files to load
@@ -121,21 +232,24 @@ func loadConfig(configDir, environment string) (*config.YAMLConfig, error) {
 }
 
 // handleCreate creates a new API key
-func handleCreate(ctx context.Context, store *apikeys.Store, provider, actualKey, description string, costLimit int64, tagsStr string, logger *slog.Logger) {
+func handleCreate(ctx context.Context, store apikeys.KeyStore, provider, actualKey, description string, costLimit int64, tagsStr, providerKeysStr string, scope *apikeys.KeyScope, teamID, projectID string, logger *slog.Logger) {
 	// Validate provider
-	validProviders := []string{"openai", "anthropic", "gemini"}
-	isValid := false
-	for _, p := range validProviders {
-		if provider == p {
-			isValid = true
-			break
-		}
-	}
-	if !isValid {
+	if !slices.Contains(validProviders, provider) {
 		logger.Error("Invalid provider", "provider", provider, "valid", validProviders)
 		os.Exit(1)
 	}
 
+	// Parse upstream keys for further providers
+	providerKeys := make(map[string]string)
+	for _, entry := range splitList(providerKeysStr) {
+		p, key, ok := strings.Cut(entry, "=")
+		if !ok || key == "" || !slices.Contains(validProviders, p) || p == provider {
+			logger.Error("Invalid -provider-keys entry; expected provider=key for a provider other than -provider", "provider", p, "valid", validProviders)
+			os.Exit(1)
+		}
+		providerKeys[p] = key
+	}
+
 	// Parse tags
 	tags := make(map[string]string)
 	if tagsStr != "" {
@@ -153,27 +267,71 @@ func handleCreate(ctx context.Context, store *apikeys.Store, provider, actualKey
 		logger.Error("Failed to create API key", "error", err)
 		os.Exit(1)
 	}
+	// The key has not been handed out yet, so it is never used before these are set
+	updates := make(map[string]interface{})
+	if scope != nil {
+		updates["scope"] = scope
+	}
+	if len(providerKeys) > 0 {
+		updates["provider_keys"] = providerKeys
+	}
+	if len(updates) > 0 {
+		if err := store.UpdateKey(ctx, apiKey.PK, updates); err != nil {
+			logger.Error("Failed to set API key scope and provider keys; delete the key and retry", "key_id", apiKey.PK, "error", err)
+			os.Exit(1)
+		}
+		apiKey.Scope, apiKey.ProviderKeys = scope, providerKeys
+	}
+	if teamID != "" || projectID != "" {
+		if err := apikeys.AssignTeam(ctx, store, apiKey.PK, teamID, projectID); err != nil {
+			logger.Error("Failed to assign API key to team; delete the key and retry", "key_id", apiKey.PK, "error", err)
+			os.Exit(1)
+		}
+		apiKey.TeamID, apiKey.ProjectID = teamID, projectID
+	}
 
 	fmt.Printf("\n✅ API Key Created Successfully!\n\n")
-	fmt.Printf("Key:         %s\n", apiKey.PK)
+	fmt.Printf("Key:         %s\n", apiKey.Key)
+	fmt.Printf("Key ID:      %s\n", apiKey.PK)
 	fmt.Printf("Provider:    %s\n", apiKey.Provider)
+	fmt.Printf("Actual Key:  %s\n", apikeys.MaskSecret(apiKey.ActualKey))
+	printProviderKeys(apiKey)
 	fmt.Printf("Description: %s\n", apiKey.Description)
 	fmt.Printf("Cost Limit:  $%.2f/day\n", float64(apiKey.DailyCostLimit)/100)
 	fmt.Printf("Created:     %s\n", apiKey.CreatedAt.Format(time.RFC3339))
 	if len(apiKey.Tags) > 0 {
 		fmt.Printf("Tags:        %v\n", apiKey.Tags)
 	}
-	fmt.Printf("\n🔑 Use this key in your API requests by replacing your provider key with: %s\n", apiKey.PK)
+	printTeam(apiKey)
+	printScope(apiKey.Scope)
+	fmt.Printf("\n🔑 Use this key in your API requests by replacing your provider key with: %s\n", apiKey.Key)
+	fmt.Printf("⚠️  Only a hash of this key is stored; it cannot be shown again.\n")
 }
 
-// handleList lists all API keys
-func handleList(ctx context.Context, store *apikeys.Store, provider string, logger *slog.Logger) {
+// handleList lists all API keys; a non-empty stale lists only keys unused for that long
+func handleList(ctx context.Context, store apikeys.KeyStore, provider, stale string, logger *slog.Logger) {
 	keys, err := store.ListKeys(ctx, provider)
 	if err != nil {
 		logger.Error("Failed to list API keys", "error", err)
 		os.Exit(1)
 	}
 
+	if stale != "" {
+		age, err := parseAge(stale)
+		if err != nil {
+			logger.Error("Invalid -stale", "value", stale, "error", err)
+			os.Exit(1)
+		}
+		cutoff := time.Now().Add(-age)
+		var unused []*apikeys.APIKey
+		for _, key := range keys {
+			if key.UnusedSince(cutoff) {
+				unused = append(unused, key)
+			}
+		}
+		keys = unused
+	}
+
 	if len(keys) == 0 {
 		fmt.Println("No API keys found")
 		return
@@ -181,32 +339,74 @@ func handleList(ctx context.Context, store *apikeys.Store, provider string, logg
 
 	// Create a tabwriter for formatted output
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "KEY\tPROVIDER\tDESCRIPTION\tCOST LIMIT\tENABLED\tCREATED")
-	fmt.Fprintln(w, "---\t--------\t-----------\t----------\t-------\t-------")
+	// Usage counters are lifetime totals, not a rolling window
+	fmt.Fprintln(w, "PREFIX\tKEY ID\tPROVIDER\tTEAM\tDESCRIPTION\tCOST LIMIT\tENABLED\tCREATED\tLAST USED\tLAST IP\tTOTAL REQUESTS\tTOTAL TOKENS\tTOTAL COST")
+	fmt.Fprintln(w, "------\t------\t--------\t----\t-----------\t----------\t-------\t-------\t---------\t-------\t--------------\t------------\t----------")
 
 	for _, key := range keys {
-		fmt.Fprintf(w, "%s\t%s\t%s\t$%.2f/day\t%v\t%s\n",
-			key.PK,
-			key.Provider,
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t$%.2f/day\t%v\t%s\t%s\t%s\t%d\t%d\t$%.2f\n",
+			displayPrefix(key),
+			keyIDColumn(key),
+			strings.Join(key.Providers(), ","),
+			teamColumn(key),
 			key.Description,
 			float64(key.DailyCostLimit)/100,
 			key.Enabled,
 			key.CreatedAt.Format("2006-01-02"),
+			lastUsedColumn(key),
+			lastIPColumn(key),
+			key.RequestCount,
+			key.TokenCount,
+			key.TotalCost,
 		)
 	}
 	w.Flush()
 }
 
-// handleShow shows details of a specific API key
-func handleShow(ctx context.Context, store *apikeys.Store, keyID string, logger *slog.Logger) {
-	key, err := store.GetKey(ctx, keyID)
+// lastUsedColumn returns the key's last use date for the key list, or never
+func lastUsedColumn(key *apikeys.APIKey) string {
+	if key.LastUsedAt == nil {
+		return "never"
+	}
+	return key.LastUsedAt.Format("2006-01-02")
+}
+
+// lastIPColumn returns the client address of the key's last use, or -
+func lastIPColumn(key *apikeys.APIKey) string {
+	if key.LastUsedIP == "" {
+		return "-"
+	}
+	return key.LastUsedIP
+}
+
+// parseAge parses a duration that may also be given in whole days (e.g. 30d)
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("expected a number of days, e.g. 30d")
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	age, err := time.ParseDuration(s)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("expected a duration such as 30d or 72h")
+	}
+	return age, nil
+}
+
+// handleShow shows details of a specific API key, given as the iw: key or its key ID
+func handleShow(ctx context.Context, store apikeys.KeyStore, keyOrID string, logger *slog.Logger) {
+	keyID := resolveKeyID(ctx, store, keyOrID, logger)
+	key, err := store.LookupKey(ctx, keyID)
 	if err != nil {
 		logger.Error("Failed to get API key", "error", err)
 		os.Exit(1)
 	}
 
 	fmt.Printf("\nAPI Key Details:\n\n")
-	fmt.Printf("Key:         %s\n", key.PK)
+	fmt.Printf("Prefix:      %s\n", displayPrefix(key))
+	fmt.Printf("Key ID:      %s\n", keyIDColumn(key))
 	fmt.Printf("Provider:    %s\n", key.Provider)
 	fmt.Printf("Description: %s\n", key.Description)
 	fmt.Printf("Cost Limit:  $%.2f/day\n", float64(key.DailyCostLimit)/100)
@@ -219,23 +419,378 @@ func handleShow(ctx context.Context, store *apikeys.Store, keyID string, logger
 	if len(key.Tags) > 0 {
 		fmt.Printf("Tags:        %v\n", key.Tags)
 	}
-	// Don't show the actual key for security reasons
-	fmt.Printf("Actual Key:  ***HIDDEN***\n")
+	printTeam(key)
+	if key.LastUsedAt != nil {
+		fmt.Printf("Last Used:   %s from %s\n", key.LastUsedAt.Format(time.RFC3339), key.LastUsedIP)
+	} else {
+		fmt.Printf("Last Used:   never\n")
+	}
+	fmt.Printf("Total Usage: %d requests, %d tokens, $%.2f\n", key.RequestCount, key.TokenCount, key.TotalCost)
+	printScope(key.Scope)
+	if key.RotatedFrom != "" {
+		fmt.Printf("Rotated From: %s\n", keyIDForOutput(key.RotatedFrom))
+	}
+	if key.RotatedTo != "" {
+		fmt.Printf("Rotated To:  %s\n", key.RotatedTo)
+	}
+	if key.LineageID != "" {
+		fmt.Printf("Lineage:     %s\n", key.LineageID)
+	}
+	// Never show the full provider key
+	fmt.Printf("Actual Key:  %s\n", apikeys.MaskSecret(key.ActualKey))
+	printProviderKeys(key)
+}
+
+// handleRotate mints a successor for an API key, keeping the old one valid for grace
+func handleRotate(ctx context.Context, store apikeys.KeyStore, keyID string, grace time.Duration, logger *slog.Logger) {
+	successor, err := apikeys.RotateKey(ctx, store, keyID, grace)
+	if err != nil {
+		logger.Error("Failed to rotate API key", "error", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\n✅ API Key Rotated Successfully!\n\n")
+	fmt.Printf("New Key:     %s\n", successor.Key)
+	fmt.Printf("Key ID:      %s\n", successor.PK)
+	fmt.Printf("Replaces:    %s\n", keyIDForOutput(keyID))
+	if old, err := store.LookupKey(ctx, keyID); err == nil && old.ExpiresAt != nil {
+		fmt.Printf("Old key valid until: %s\n", old.ExpiresAt.Format(time.RFC3339))
+	}
+	fmt.Printf("\n⚠️  Only a hash of the new key is stored; it cannot be shown again.\n")
+	fmt.Printf("⚠️  rate_limiting.overrides.per_key entries for the old key must be re-added under the new key's RL Key ID.\n")
+}
+
+// handleRotateUpstream replaces an API key's upstream key without changing the virtual key
+func handleRotateUpstream(ctx context.Context, store apikeys.KeyStore, keyID, provider, upstreamKey string, logger *slog.Logger) {
+	if upstreamKey == "" {
+		logger.Error("-rotate-upstream requires -key")
+		os.Exit(1)
+	}
+	if err := apikeys.RotateUpstreamKey(ctx, store, keyID, provider, upstreamKey); err != nil {
+		logger.Error("Failed to rotate upstream key", "error", err)
+		os.Exit(1)
+	}
+	if provider == "" {
+		provider = "primary provider"
+	}
+	fmt.Printf("✅ Upstream key for %s rotated on API key %s (%s)\n", provider, keyIDForOutput(keyID), apikeys.MaskSecret(upstreamKey))
+}
+
+// handleSetProviderKey sets the upstream key a virtual key uses for provider;
+// an empty upstreamKey removes the provider
+func handleSetProviderKey(ctx context.Context, store apikeys.KeyStore, keyID, provider, upstreamKey string, logger *slog.Logger) {
+	if !slices.Contains(validProviders, provider) {
+		logger.Error("Invalid provider", "provider", provider, "valid", validProviders)
+		os.Exit(1)
+	}
+	key, err := store.LookupKey(ctx, keyID)
+	if err != nil {
+		logger.Error("Failed to get API key", "error", err)
+		os.Exit(1)
+	}
+	if provider == key.Provider {
+		// The primary upstream key is replaced with -key; it cannot be removed
+		logger.Error("Provider is the key's primary provider", "provider", provider)
+		os.Exit(1)
+	}
+
+	providerKeys := make(map[string]string, len(key.ProviderKeys)+1)
+	for p, v := range key.ProviderKeys {
+		providerKeys[p] = v
+	}
+	if upstreamKey == "" {
+		if _, ok := providerKeys[provider]; !ok {
+			logger.Error("API key has no upstream key for provider", "provider", provider)
+			os.Exit(1)
+		}
+		delete(providerKeys, provider)
+	} else {
+		providerKeys[provider] = upstreamKey
+	}
+
+	if err := store.UpdateKey(ctx, keyID, map[string]interface{}{"provider_keys": providerKeys}); err != nil {
+		logger.Error("Failed to update API key providers", "error", err)
+		os.Exit(1)
+	}
+	key.ProviderKeys = providerKeys
+	fmt.Printf("✅ API key %s now works with: %s\n", keyIDForOutput(keyID), strings.Join(key.Providers(), ", "))
+}
+
+// printProviderKeys prints the masked upstream keys for a key's further providers
+func printProviderKeys(key *apikeys.APIKey) {
+	for _, p := range key.Providers()[1:] {
+		fmt.Printf("  + %-9s %s\n", p+":", apikeys.MaskSecret(key.ProviderKeys[p]))
+	}
+}
+
+// handleSetScope replaces the scope of an API key; a nil scope removes all restrictions
+func handleSetScope(ctx context.Context, store apikeys.KeyStore, keyID string, scope *apikeys.KeyScope, logger *slog.Logger) {
+	if err := store.UpdateKey(ctx, keyID, map[string]interface{}{"scope": scope}); err != nil {
+		logger.Error("Failed to update API key scope", "error", err)
+		os.Exit(1)
+	}
+	if scope == nil {
+		fmt.Printf("✅ Scope cleared for API key %s\n", keyIDForOutput(keyID))
+		return
+	}
+	fmt.Printf("✅ Scope updated for API key %s\n", keyIDForOutput(keyID))
+	printScope(scope)
+}
+
+// scopeFromFlags builds a key scope from the scope flags, or nil when none are set
+func scopeFromFlags(allowModels, denyModels, allowEndpoints string, maxTokens int, allowCIDRs string) (*apikeys.KeyScope, error) {
+	scope := &apikeys.KeyScope{
+		AllowedModels:    splitList(allowModels),
+		DeniedModels:     splitList(denyModels),
+		AllowedEndpoints: splitList(allowEndpoints),
+		MaxTokens:        maxTokens,
+		AllowedCIDRs:     splitList(allowCIDRs),
+	}
+	if scope.IsEmpty() {
+		return nil, nil
+	}
+	if err := scope.Validate(); err != nil {
+		return nil, err
+	}
+	return scope, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// printScope prints a key's scope, if it has one
+func printScope(scope *apikeys.KeyScope) {
+	if scope.IsEmpty() {
+		return
+	}
+	if len(scope.AllowedModels) > 0 {
+		fmt.Printf("Models:      %s\n", strings.Join(scope.AllowedModels, ", "))
+	}
+	if len(scope.DeniedModels) > 0 {
+		fmt.Printf("Denied:      %s\n", strings.Join(scope.DeniedModels, ", "))
+	}
+	if len(scope.AllowedEndpoints) > 0 {
+		fmt.Printf("Endpoints:   %s\n", strings.Join(scope.AllowedEndpoints, ", "))
+	}
+	if scope.MaxTokens > 0 {
+		fmt.Printf("Max Tokens:  %d\n", scope.MaxTokens)
+	}
+	if len(scope.AllowedCIDRs) > 0 {
+		fmt.Printf("Source IPs:  %s\n", strings.Join(scope.AllowedCIDRs, ", "))
+	}
+}
+
+// printTeam prints the team and project a key belongs to, if any
+func printTeam(key *apikeys.APIKey) {
+	if key.TeamID != "" {
+		fmt.Printf("Team:        %s\n", key.TeamID)
+	}
+	if key.ProjectID != "" {
+		fmt.Printf("Project:     %s\n", key.ProjectID)
+	}
+}
+
+// teamColumn returns team/project for the key list, or - without a team
+func teamColumn(key *apikeys.APIKey) string {
+	switch {
+	case key.TeamID == "":
+		return "-"
+	case key.ProjectID == "":
+		return key.TeamID
+	default:
+		return key.TeamID + "/" + key.ProjectID
+	}
+}
+
+// handleSetTeam moves an API key to a team and project; an empty team removes it from its team
+func handleSetTeam(ctx context.Context, store apikeys.KeyStore, keyID, teamID, projectID string, logger *slog.Logger) {
+	if err := apikeys.AssignTeam(ctx, store, keyID, teamID, projectID); err != nil {
+		logger.Error("Failed to assign API key to team", "error", err)
+		os.Exit(1)
+	}
+	if teamID == "" {
+		fmt.Printf("✅ API key %s removed from its team\n", keyIDForOutput(keyID))
+		return
+	}
+	fmt.Printf("✅ API key %s now belongs to team %s\n", keyIDForOutput(keyID), teamColumn(&apikeys.APIKey{TeamID: teamID, ProjectID: projectID}))
+}
+
+// handleSaveTeam creates or updates a team, or a project when parent is set
+func handleSaveTeam(ctx context.Context, store apikeys.KeyStore, id, name, parent, limitsStr string, logger *slog.Logger) {
+	limits, err := parseTeamLimits(limitsStr)
+	if err != nil {
+		logger.Error("Invalid -team-limits", "error", err)
+		os.Exit(1)
+	}
+	team := &apikeys.Team{ID: id, Name: name, ParentID: parent, Limits: limits}
+	if err := apikeys.SaveTeam(ctx, store, team); err != nil {
+		logger.Error("Failed to save team", "error", err)
+		os.Exit(1)
+	}
+
+	kind := "Team"
+	if team.IsProject() {
+		kind = "Project"
+	}
+	fmt.Printf("✅ %s %s saved\n", kind, id)
+	if limits != nil {
+		fmt.Printf("Limits:      %s\n", formatTeamLimits(limits))
+		fmt.Printf("⚠️  Proxies apply team limits at startup; restart them to pick up the change.\n")
+	}
+}
+
+// handleListTeams lists teams and their projects
+func handleListTeams(ctx context.Context, store apikeys.KeyStore, logger *slog.Logger) {
+	teams, err := store.ListTeams(ctx)
+	if err != nil {
+		logger.Error("Failed to list teams", "error", err)
+		os.Exit(1)
+	}
+	if len(teams) == 0 {
+		fmt.Println("No teams found")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPARENT\tNAME\tLIMITS\tCREATED")
+	fmt.Fprintln(w, "--\t------\t----\t------\t-------")
+	for _, t := range teams {
+		parent := t.ParentID
+		if parent == "" {
+			parent = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.ID, parent, t.Name, formatTeamLimits(t.Limits), t.CreatedAt.Format("2006-01-02"))
+	}
+	w.Flush()
+}
+
+// handleDeleteTeam deletes a team or project that no keys or projects use
+func handleDeleteTeam(ctx context.Context, store apikeys.KeyStore, id string, logger *slog.Logger) {
+	if err := apikeys.RemoveTeam(ctx, store, id); err != nil {
+		logger.Error("Failed to delete team", "error", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ Team %s deleted successfully\n", id)
+}
+
+// parseTeamLimits parses name=value limits using the rate_limiting config names, or nil when empty
+func parseTeamLimits(s string) (*config.LimitsConfig, error) {
+	entries := splitList(s)
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	limits := &config.LimitsConfig{}
+	for _, entry := range entries {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected name=value, got %q", entry)
+		}
+		var err error
+		switch name {
+		case "requests_per_minute":
+			limits.RequestsPerMinute, err = strconv.Atoi(value)
+		case "tokens_per_minute":
+			limits.TokensPerMinute, err = strconv.Atoi(value)
+		case "requests_per_day":
+			limits.RequestsPerDay, err = strconv.Atoi(value)
+		case "tokens_per_day":
+			limits.TokensPerDay, err = strconv.Atoi(value)
+		case "cost_per_minute":
+			limits.CostPerMinute, err = strconv.ParseFloat(value, 64)
+		case "cost_per_hour":
+			limits.CostPerHour, err = strconv.ParseFloat(value, 64)
+		case "cost_per_day":
+			limits.CostPerDay, err = strconv.ParseFloat(value, 64)
+		default:
+			return nil, fmt.Errorf("unknown limit %q", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+	return limits, nil
+}
+
+// formatTeamLimits summarises the limits set on a team
+func formatTeamLimits(l *config.LimitsConfig) string {
+	if l == nil {
+		return "-"
+	}
+	var parts []string
+	for _, p := range []struct {
+		name  string
+		value int
+	}{
+		{"rpm", l.RequestsPerMinute}, {"tpm", l.TokensPerMinute}, {"rpd", l.RequestsPerDay}, {"tpd", l.TokensPerDay},
+	} {
+		if p.value > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", p.name, p.value))
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{
+		{"$/min", l.CostPerMinute}, {"$/hour", l.CostPerHour}, {"$/day", l.CostPerDay},
+	} {
+		if p.value > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%.2f", p.name, p.value))
+		}
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, " ")
+}
+
+// resolveKeyID maps an iw: key or key ID given on the command line to the stored record ID
+func resolveKeyID(ctx context.Context, store apikeys.KeyStore, keyOrID string, logger *slog.Logger) string {
+	keyID, err := apikeys.ResolveKeyID(ctx, store, keyOrID)
+	if err != nil {
+		logger.Error("Failed to find API key", "error", err)
+		os.Exit(1)
+	}
+	return keyID
+}
+
+// displayPrefix returns the non-secret prefix of a key, including legacy records stored in plaintext
+func displayPrefix(key *apikeys.APIKey) string {
+	if key.DisplayPrefix != "" {
+		return key.DisplayPrefix
+	}
+	return apikeys.DisplayPrefix(key.PK)
+}
+
+// keyIDColumn returns the key ID to show for a record; legacy plaintext records
+// are shown by prefix until -migrate-hashed-keys has run
+func keyIDColumn(key *apikeys.APIKey) string {
+	if apikeys.IsKeyID(key.PK) {
+		return key.PK
+	}
+	return "(not migrated)"
 }
 
 // handleDelete deletes an API key
-func handleDelete(ctx context.Context, store *apikeys.Store, keyID string, logger *slog.Logger) {
+func handleDelete(ctx context.Context, store apikeys.KeyStore, keyID string, logger *slog.Logger) {
 	err := store.DeleteKey(ctx, keyID)
 	if err != nil {
 		logger.Error("Failed to delete API key", "error", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("✅ API key %s deleted successfully\n", keyID)
+	fmt.Printf("✅ API key %s deleted successfully\n", keyIDForOutput(keyID))
 }
 
 // handleDisable disables an API key
-func handleDisable(ctx context.Context, store *apikeys.Store, keyID string, logger *slog.Logger) {
+func handleDisable(ctx context.Context, store apikeys.KeyStore, keyID string, logger *slog.Logger) {
 	err := store.UpdateKey(ctx, keyID, map[string]interface{}{
 		"enabled": false,
 	})
@@ -244,11 +799,11 @@ func handleDisable(ctx context.Context, store *apikeys.Store, keyID string, logg
 		os.Exit(1)
 	}
 
-	fmt.Printf("✅ API key %s disabled successfully\n", keyID)
+	fmt.Printf("✅ API key %s disabled successfully\n", keyIDForOutput(keyID))
 }
 
 // handleEnable enables an API key
-func handleEnable(ctx context.Context, store *apikeys.Store, keyID string, logger *slog.Logger) {
+func handleEnable(ctx context.Context, store apikeys.KeyStore, keyID string, logger *slog.Logger) {
 	err := store.UpdateKey(ctx, keyID, map[string]interface{}{
 		"enabled": true,
 	})
@@ -257,5 +812,87 @@ func handleEnable(ctx context.Context, store *apikeys.Store, keyID string, logge
 		os.Exit(1)
 	}
 
-	fmt.Printf("✅ API key %s enabled successfully\n", keyID)
+	fmt.Printf("✅ API key %s enabled successfully\n", keyIDForOutput(keyID))
+}
+
+// handleReencrypt seals every provider key with the configured master key.
+// To rotate, generate a new master key, point master_key_file at it, list the
+// old key under previous_master_key_files, then run -reencrypt.
+func handleReencrypt(ctx context.Context, backendConfig apikeys.BackendConfig, logger *slog.Logger) {
+	if backendConfig.Encryption == nil {
+		logger.Error("Encryption is not enabled (features.api_key_management.encryption.enabled)")
+		os.Exit(1)
+	}
+	enc, err := apikeys.NewEncryptorFromConfig(*backendConfig.Encryption)
+	if err != nil {
+		logger.Error("Failed to configure key encryption", "error", err)
+		os.Exit(1)
+	}
+
+	// Operate on stored values directly rather than through the decrypting wrapper
+	rawConfig := backendConfig
+	rawConfig.Encryption = nil
+	raw, err := apikeys.NewKeyStore(rawConfig)
+	if err != nil {
+		logger.Error("Failed to create API key store", "error", err)
+		os.Exit(1)
+	}
+
+	updated, unchanged, err := apikeys.ReencryptKeys(ctx, raw, enc)
+	if err != nil {
+		logger.Error("Failed to re-encrypt API keys", "error", err, "updated", updated)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ Re-encrypted %d keys with %s (%d already current)\n", updated, enc.PrimaryKeyID(), unchanged)
+}
+
+// handleMigrateHashedKeys re-stores keys created before keys were hashed under
+// their digest and removes the plaintext records. It is safe to re-run.
+func handleMigrateHashedKeys(ctx context.Context, backendConfig apikeys.BackendConfig, logger *slog.Logger) {
+	// Copy stored values as-is rather than through the decrypting wrapper
+	rawConfig := backendConfig
+	rawConfig.Encryption = nil
+	raw, err := apikeys.NewKeyStore(rawConfig)
+	if err != nil {
+		logger.Error("Failed to create API key store", "error", err)
+		os.Exit(1)
+	}
+
+	migrated, err := apikeys.MigrateHashedKeys(ctx, raw)
+	if err != nil {
+		logger.Error("Failed to migrate API keys", "error", err, "migrated", migrated)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ Migrated %d keys to hashed storage\n", migrated)
+}
+
+// keyIDForOutput avoids printing legacy plaintext keys in full
+func keyIDForOutput(keyID string) string {
+	if apikeys.IsKeyID(keyID) {
+		return keyID
+	}
+	return apikeys.DisplayPrefix(keyID) + "…"
+}
+
+// publishInvalidation tells running proxies to drop a changed key from their
+// caches. Without a cache Redis configured, proxies pick up the change once
+// the cached entry's TTL expires.
+func publishInvalidation(ctx context.Context, yamlConfig *config.YAMLConfig, keyID string, logger *slog.Logger) {
+	cacheConfig := yamlConfig.Features.APIKeyManagement.Cache
+	if !cacheConfig.Enabled || cacheConfig.Redis == nil {
+		return
+	}
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cacheConfig.Redis.Address,
+		Password: cacheConfig.Redis.Password,
+		DB:       cacheConfig.Redis.DB,
+	})
+	defer rdb.Close()
+
+	if err := apikeys.PublishInvalidation(ctx, rdb, cacheConfig.InvalidationChannel, keyID); err != nil {
+		logger.Warn("Failed to publish cache invalidation; proxies will pick up the change after the cache TTL", "error", err)
+		return
+	}
+	fmt.Printf("📣 Cache invalidation published for %s\n", apikeys.KeyID(keyID))
 }
@@ -14,19 +14,30 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Instawork/llm-proxy/internal/admin"
 	"github.com/Instawork/llm-proxy/internal/apikeys"
+	"github.com/Instawork/llm-proxy/internal/capture"
+	"github.com/Instawork/llm-proxy/internal/coalesce"
 	"github.com/Instawork/llm-proxy/internal/config"
 	"github.com/Instawork/llm-proxy/internal/cost"
+	"github.com/Instawork/llm-proxy/internal/metrics"
 	"github.com/Instawork/llm-proxy/internal/middleware"
 	"github.com/Instawork/llm-proxy/internal/providers"
 	"github.com/Instawork/llm-proxy/internal/ratelimit"
+	"github.com/Instawork/llm-proxy/internal/redaction"
+	"github.com/Instawork/llm-proxy/internal/responsecache"
+	"github.com/Instawork/llm-proxy/internal/tracing"
 	"github.com/gorilla/mux"
+	redis "github.com/redis/go-redis/v9"
+	_ "modernc.org/sqlite" // Registers the driver for the sqlite key backend
 )
 
 // CustomPrettyHandler implements a custom slog.Handler for pretty local output
 type CustomPrettyHandler struct {
-	level slog.Level
-	w     io.Writer
+	level  slog.Level
+	w      io.Writer
+	attrs  []string // preformatted attributes from WithAttrs
+	prefix string   // group prefix from WithGroup
 }
 
 func NewCustomPrettyHandler(w io.Writer, level slog.Level) *CustomPrettyHandler {
@@ -45,10 +56,10 @@ func (h *CustomPrettyHandler) Handle(_ context.Context, r slog.Record) error {
 
 	// Build the message with all attributes inline
 	message := r.Message
-	var allAttrs []string
+	allAttrs := append([]string(nil), h.attrs...)
 
 	r.Attrs(func(a slog.Attr) bool {
-		allAttrs = append(allAttrs, fmt.Sprintf("%s=%v", a.Key, a.Value))
+		allAttrs = append(allAttrs, fmt.Sprintf("%s%s=%v", h.prefix, a.Key, a.Value))
 		return true
 	})
 
@@ -62,11 +73,21 @@ func (h *CustomPrettyHandler) Handle(_ context.Context, r slog.Record) error {
 }
 
 func (h *CustomPrettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	return h // Ignore attributes for pretty output
+	clone := *h
+	clone.attrs = append([]string(nil), h.attrs...)
+	for _, a := range attrs {
+		clone.attrs = append(clone.attrs, fmt.Sprintf("%s%s=%v", h.prefix, a.Key, a.Value))
+	}
+	return &clone
 }
 
 func (h *CustomPrettyHandler) WithGroup(name string) slog.Handler {
-	return h // Ignore groups for pretty output
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.prefix = h.prefix + name + "."
+	return &clone
 }
 
 var logger *slog.Logger
@@ -88,9 +109,36 @@ var globalCostTracker *cost.CostTracker
 // Global API key store instance
 var globalAPIKeyStore providers.APIKeyStore
 
+// Global API key cache instance (nil when caching is disabled)
+var globalAPIKeyCache *apikeys.CachedStore
+
+// Global API key backend, behind any cache, used by the admin API
+var globalAPIKeyBackend apikeys.KeyStore
+
+// Redis client and channel for publishing cache invalidations (nil without pub/sub)
+var (
+	globalInvalidationRedis   *redis.Client
+	globalInvalidationChannel string
+)
+
+// Global API key usage tracker (nil when usage tracking is disabled)
+var globalUsageTracker *apikeys.UsageTracker
+
 // Global rate limiter instance
 var globalRateLimiter ratelimit.RateLimiter
 
+// Global tracer (nil when tracing is disabled)
+var globalTracer *tracing.Tracer
+
+// Global request/response capturer (nil when capture is disabled)
+var globalCapturer *capture.Capturer
+
+// Global response cache (nil when the response cache is disabled)
+var globalResponseCache *responsecache.Cache
+
+// Global configuration reloader, reported on /health
+var globalConfigReloader *config.Reloader
+
 func init() {
 	logLevel := os.Getenv("LOG_LEVEL")
 	var level slog.Level
@@ -293,7 +341,19 @@ func initializeCostTracker(yamlConfig *config.YAMLConfig) *cost.CostTracker {
 	}
 
 	// Load pricing data from config for each provider and model
+	totalModelsConfigured := configurePricing(costTracker, yamlConfig)
+
+	logger.Info("💰 Cost Tracker: Configured pricing", "total_models_configured", totalModelsConfigured)
+	return costTracker
+}
+
+// configurePricing loads pricing data from config into the tracker for each
+// enabled provider and model, returning the number of model names configured.
+// The tracker's previous pricing is replaced in one step, so disabled and
+// removed models lose their pricing.
+func configurePricing(tracker *cost.CostTracker, yamlConfig *config.YAMLConfig) int {
 	totalModelsConfigured := 0
+	pricing := make(map[string]map[string]*cost.ModelPricing)
 
 	for providerName, providerConfig := range yamlConfig.Providers {
 		if !providerConfig.Enabled {
@@ -335,13 +395,17 @@ func initializeCostTracker(yamlConfig *config.YAMLConfig) *cost.CostTracker {
 					}
 				}
 
+				if pricing[providerName] == nil {
+					pricing[providerName] = make(map[string]*cost.ModelPricing)
+				}
+
 				// Set pricing for main model name
-				costTracker.SetPricingForModel(providerName, modelName, &costTrackerPricing)
+				pricing[providerName][modelName] = &costTrackerPricing
 				totalModelsConfigured++
 
 				// Set pricing for all aliases
 				for _, alias := range modelConfig.Aliases {
-					costTracker.SetPricingForModel(providerName, alias, &costTrackerPricing)
+					pricing[providerName][alias] = &costTrackerPricing
 					totalModelsConfigured++
 				}
 			} else {
@@ -350,8 +414,58 @@ func initializeCostTracker(yamlConfig *config.YAMLConfig) *cost.CostTracker {
 		}
 	}
 
-	logger.Info("💰 Cost Tracker: Configured pricing", "total_models_configured", totalModelsConfigured)
-	return costTracker
+	tracker.ReplacePricing(pricing)
+	return totalModelsConfigured
+}
+
+// applyReloadedConfig swaps in the pricing, model enablement and rate limits
+// of a reloaded configuration, after adding the key store's team limits as
+// at startup. pricingOnly is the tracker that prices cost limits when cost
+// tracking is disabled. Other settings take effect on restart.
+func applyReloadedConfig(next *config.YAMLConfig, pricingOnly *cost.CostTracker) error {
+	if globalAPIKeyBackend != nil {
+		// Keep the current config rather than drop every team's limits
+		if err := applyTeamLimits(next, globalAPIKeyBackend); err != nil {
+			return err
+		}
+		if err := next.Validate(); err != nil {
+			return fmt.Errorf("invalid configuration with team limits: %w", err)
+		}
+	}
+	for _, tracker := range []*cost.CostTracker{globalCostTracker, pricingOnly} {
+		if tracker != nil {
+			logger.Info("💰 Cost Tracker: Reloaded pricing", "total_models_configured", configurePricing(tracker, next))
+		}
+	}
+	if globalRateLimiter != nil {
+		globalRateLimiter.SetConfig(next)
+		logger.Info("Rate limiting: Reloaded limits and overrides",
+			"rpm", next.Features.RateLimiting.Limits.RequestsPerMinute,
+			"tpm", next.Features.RateLimiting.Limits.TokensPerMinute)
+		warnKeyHashSecret(next)
+	}
+	return nil
+}
+
+// warnKeyHashSecret warns when the retired key_hash_secret is still set
+func warnKeyHashSecret(cfg *config.YAMLConfig) {
+	if cfg.Features.RateLimiting.KeyHashSecret == "" && os.Getenv("RATE_LIMIT_KEY_HASH_SECRET") == "" {
+		return
+	}
+	logger.Warn("🚦 Rate limit: key_hash_secret and RATE_LIMIT_KEY_HASH_SECRET are ignored; per_key overrides and scope keys use the key store's key ID")
+}
+
+// invalidateAPIKey drops a changed key from this proxy's cache and, with
+// pub/sub configured, from every other proxy's
+func invalidateAPIKey(ctx context.Context, keyID string) {
+	if globalAPIKeyCache != nil {
+		globalAPIKeyCache.Invalidate(keyID)
+	}
+	if globalInvalidationRedis != nil {
+		if err := apikeys.PublishInvalidation(ctx, globalInvalidationRedis, globalInvalidationChannel, keyID); err != nil {
+			logger.Warn("🔑 API Key Cache: Failed to publish invalidation", "key_id", apikeys.KeyID(keyID), "error", err)
+		}
+	}
 }
 
 // initializeAPIKeyStore creates and configures the API key store from config
@@ -364,28 +478,106 @@ func initializeAPIKeyStore(yamlConfig *config.YAMLConfig) providers.APIKeyStore
 
 	// Get API key management configuration
 	apiKeyConfig := yamlConfig.Features.APIKeyManagement
-	if apiKeyConfig.TableName == "" || apiKeyConfig.Region == "" {
+	backend := apiKeyConfig.Backend
+	if backend == "" {
+		backend = apikeys.BackendDynamoDB
+	}
+	if backend == apikeys.BackendDynamoDB && (apiKeyConfig.TableName == "" || apiKeyConfig.Region == "") {
 		logger.Error("🔑 API Key Store: Missing required configuration (table_name or region)")
 		return nil
 	}
 
 	logger.Info("🔑 API Key Store: Initializing API key store",
+		"backend", backend,
 		"table_name", apiKeyConfig.TableName,
-		"region", apiKeyConfig.Region)
+		"region", apiKeyConfig.Region,
+		"path", apiKeyConfig.Path,
+		"encryption", apiKeyConfig.Encryption.Enabled)
 
 	// Create the API key store
-	store, err := apikeys.NewStore(apikeys.StoreConfig{
-		TableName: apiKeyConfig.TableName,
-		Region:    apiKeyConfig.Region,
-		Logger:    logger,
-	})
+	store, err := apikeys.NewKeyStore(apikeys.BackendConfigFromYAML(apiKeyConfig, logger))
 	if err != nil {
 		logger.Error("🔑 API Key Store: Failed to create API key store", "error", err)
 		return nil
 	}
 
 	logger.Info("🔑 API Key Store: Successfully initialized API key store")
-	return store
+	globalAPIKeyBackend = store
+	if err := applyTeamLimits(yamlConfig, store); err != nil {
+		logger.Warn("🔑 API Key Store: Failed to apply team limits", "error", err)
+	}
+
+	if usageConfig := apiKeyConfig.UsageTracking; usageConfig.Enabled {
+		interval := time.Duration(usageConfig.FlushIntervalSeconds) * time.Second
+		globalUsageTracker = apikeys.NewUsageTracker(store, interval, logger)
+		logger.Info("🔑 API Key Usage: Tracking enabled", "flush_interval_seconds", usageConfig.FlushIntervalSeconds)
+	}
+
+	cacheConfig := apiKeyConfig.Cache
+	if !cacheConfig.Enabled {
+		return store
+	}
+
+	cache := apikeys.NewCachedStore(store, apikeys.CacheConfig{
+		MaxEntries:  cacheConfig.MaxEntries,
+		TTL:         time.Duration(cacheConfig.TTLSeconds) * time.Second,
+		NegativeTTL: time.Duration(cacheConfig.NegativeTTLSeconds) * time.Second,
+		StaleGrace:  staleGrace(cacheConfig.StaleGraceSeconds),
+		Logger:      logger,
+	})
+	globalAPIKeyCache = cache
+
+	if cacheConfig.Redis != nil {
+		rdb := redis.NewClient(&redis.Options{
+			Addr:     cacheConfig.Redis.Address,
+			Password: cacheConfig.Redis.Password,
+			DB:       cacheConfig.Redis.DB,
+		})
+		rdb.AddHook(tracing.RedisHook{})
+		go cache.SubscribeInvalidations(context.Background(), rdb, cacheConfig.InvalidationChannel)
+		globalInvalidationRedis = rdb
+		globalInvalidationChannel = cacheConfig.InvalidationChannel
+	}
+
+	logger.Info("🔑 API Key Cache: Enabled",
+		"max_entries", cacheConfig.MaxEntries,
+		"ttl_seconds", cacheConfig.TTLSeconds,
+		"pubsub_invalidation", cacheConfig.Redis != nil)
+	return cache
+}
+
+// applyTeamLimits adds the budgets and limits of teams in the key store to the
+// per_team rate limit overrides. Entries in the config file take precedence.
+func applyTeamLimits(yamlConfig *config.YAMLConfig, store apikeys.TeamStore) error {
+	limits, err := apikeys.TeamLimits(context.Background(), store)
+	if err != nil {
+		return fmt.Errorf("failed to load team limits: %w", err)
+	}
+	overrides := &yamlConfig.Features.RateLimiting.Overrides
+	applied := 0
+	for id, l := range limits {
+		if _, ok := overrides.PerTeam[id]; ok {
+			logger.Info("🔑 API Key Store: Config per_team override wins over stored team limits", "team", id)
+			continue
+		}
+		if overrides.PerTeam == nil {
+			overrides.PerTeam = make(map[string]config.LimitsConfig)
+		}
+		overrides.PerTeam[id] = l
+		applied++
+	}
+	if applied > 0 {
+		logger.Info("🔑 API Key Store: Applied team limits", "teams", applied)
+	}
+	return nil
+}
+
+// staleGrace converts the configured grace period, defaulting to 5 minutes when unset
+func staleGrace(seconds int) time.Duration {
+	if seconds == 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(seconds) * time.Second
 }
 
 // healthHandler provides a simple health check endpoint
@@ -398,6 +590,12 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 			"cost_tracking": globalCostTracker != nil,
 		},
 	}
+	if globalAPIKeyCache != nil {
+		health["api_key_cache"] = globalAPIKeyCache.Stats()
+	}
+	if globalConfigReloader != nil {
+		health["config"] = globalConfigReloader.Stats()
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(health)
 }
@@ -467,6 +665,212 @@ func handleVersionFlag(yamlConfig *config.YAMLConfig) {
 	os.Exit(0)
 }
 
+// initializeTracing creates the tracer and installs it process-wide so
+// middleware, key store, Redis and upstream calls are traced
+func initializeTracing(yamlConfig *config.YAMLConfig) *tracing.Tracer {
+	tracingConfig := yamlConfig.Features.Tracing
+	if !tracingConfig.Enabled {
+		return nil
+	}
+
+	var exporter tracing.Exporter
+	var err error
+	switch tracingConfig.Exporter {
+	case "", "otlp":
+		tracingConfig.Exporter = "otlp"
+		if tracingConfig.Endpoint == "" {
+			tracingConfig.Endpoint = "http://localhost:4318"
+		}
+		exporter, err = tracing.NewOTLPExporter(context.Background(), tracingConfig.Endpoint, tracingConfig.Headers)
+	case "stdout":
+		exporter, err = tracing.NewStdoutExporter(os.Stdout)
+	default:
+		logger.Error("🔭 Tracing: Unknown exporter; tracing disabled", "exporter", tracingConfig.Exporter)
+		return nil
+	}
+	if err != nil {
+		logger.Error("🔭 Tracing: Failed to create exporter; tracing disabled", "exporter", tracingConfig.Exporter, "error", err)
+		return nil
+	}
+
+	tracer := tracing.NewTracer(tracing.Options{
+		ServiceName: tracingConfig.ServiceName,
+		Exporter:    exporter,
+		SampleRatio: tracingConfig.SampleRatio,
+		Logger:      logger,
+	})
+	tracing.SetTracer(tracer)
+	logger.Info("🔭 Tracing: Enabled",
+		"exporter", tracingConfig.Exporter,
+		"endpoint", tracingConfig.Endpoint,
+		"sample_ratio", tracingConfig.SampleRatio)
+	return tracer
+}
+
+// initializeRedaction creates the prompt redactor, or returns nil when
+// redaction is disabled or its detectors are misconfigured
+func initializeRedaction(yamlConfig *config.YAMLConfig) *redaction.Redactor {
+	redactionConfig := yamlConfig.Features.Redaction
+	if !redactionConfig.Enabled {
+		return nil
+	}
+	redactor, err := redaction.New(redactionConfig.Detectors)
+	if err != nil {
+		logger.Error("🛡️ Redaction: Failed to create redactor; redaction disabled", "error", err)
+		return nil
+	}
+	logger.Info("🛡️ Redaction: Enabled",
+		"mode", redactionConfig.ModeFor("", ""),
+		"detectors", redactionConfig.Detectors,
+		"per_key", len(redactionConfig.PerKey),
+		"per_team", len(redactionConfig.PerTeam))
+	return redactor
+}
+
+// initializeResponseCache creates the response cache, or returns nil when it
+// is disabled
+func initializeResponseCache(yamlConfig *config.YAMLConfig) *responsecache.Cache {
+	cacheConfig := yamlConfig.Features.ResponseCache
+	if !cacheConfig.Enabled {
+		return nil
+	}
+
+	var store responsecache.Store
+	switch cacheConfig.Backend {
+	case "", "memory":
+		store = responsecache.NewMemoryStore(cacheConfig.MaxEntries)
+	case "redis":
+		rdb := redis.NewClient(&redis.Options{
+			Addr:     cacheConfig.Redis.Address,
+			Password: cacheConfig.Redis.Password,
+			DB:       cacheConfig.Redis.DB,
+		})
+		rdb.AddHook(tracing.RedisHook{})
+		store = responsecache.NewRedisStore(rdb)
+	default:
+		logger.Error("🗄️ Response Cache: Unknown backend; cache disabled", "backend", cacheConfig.Backend)
+		return nil
+	}
+
+	if !cacheConfig.All && len(cacheConfig.Keys)+len(cacheConfig.Teams) == 0 {
+		logger.Warn("🗄️ Response Cache: No keys or teams opted in; nothing will be cached")
+	}
+	logger.Info("🗄️ Response Cache: Enabled",
+		"backend", cacheConfig.Backend,
+		"scope", cacheConfig.Scope,
+		"all", cacheConfig.All,
+		"keys", len(cacheConfig.Keys),
+		"teams", len(cacheConfig.Teams),
+		"ttl_seconds", cacheConfig.TTLSeconds)
+	return responsecache.New(responsecache.Options{
+		Store:        store,
+		All:          cacheConfig.All,
+		Keys:         cacheConfig.Keys,
+		Teams:        cacheConfig.Teams,
+		TTL:          time.Duration(cacheConfig.TTLSeconds) * time.Second,
+		MaxBodyBytes: cacheConfig.MaxBodyBytes,
+		Logger:       logger,
+	})
+}
+
+// initializeCoalescing creates the coalescer for identical in-flight
+// requests, or returns nil when coalescing is disabled
+func initializeCoalescing(yamlConfig *config.YAMLConfig) *coalesce.Coalescer {
+	coalescingConfig := yamlConfig.Features.Coalescing
+	if !coalescingConfig.Enabled {
+		return nil
+	}
+	if !coalescingConfig.All && len(coalescingConfig.Keys)+len(coalescingConfig.Routes) == 0 {
+		logger.Warn("🔗 Coalescing: No keys or routes opted in; nothing will be coalesced")
+	}
+	logger.Info("🔗 Coalescing: Enabled",
+		"all", coalescingConfig.All,
+		"keys", len(coalescingConfig.Keys),
+		"routes", coalescingConfig.Routes)
+	return coalesce.New(coalesce.Options{
+		All:          coalescingConfig.All,
+		Keys:         coalescingConfig.Keys,
+		Routes:       coalescingConfig.Routes,
+		MaxBodyBytes: coalescingConfig.MaxBodyBytes,
+	})
+}
+
+// initializeCapture creates the request/response capturer, or returns nil when
+// capture is disabled or its sink cannot be created. Captured bodies are
+// masked with redactor when it is set.
+func initializeCapture(yamlConfig *config.YAMLConfig, redactor *redaction.Redactor) *capture.Capturer {
+	captureConfig := yamlConfig.Features.Capture
+	if !captureConfig.Enabled {
+		return nil
+	}
+
+	var sink capture.Sink
+	sinkConfig := captureConfig.Sink
+	switch sinkConfig.Type {
+	case "", "file":
+		fileConfig := config.CaptureFileConfig{}
+		if sinkConfig.File != nil {
+			fileConfig = *sinkConfig.File
+		}
+		if fileConfig.Path == "" {
+			fileConfig.Path = "logs/captures.jsonl"
+		}
+		sink = capture.NewFileSink(fileConfig.Path, int64(fileConfig.MaxSizeMB)*1024*1024, fileConfig.MaxFiles)
+		logger.Info("📼 Capture: Writing to file", "path", fileConfig.Path)
+	case "s3":
+		if sinkConfig.S3 == nil {
+			logger.Error("📼 Capture: S3 sink configuration not found; capture disabled")
+			return nil
+		}
+		s3Sink, err := capture.NewS3Sink(context.Background(), capture.S3Options{
+			Bucket:            sinkConfig.S3.Bucket,
+			Prefix:            sinkConfig.S3.Prefix,
+			Region:            sinkConfig.S3.Region,
+			Endpoint:          sinkConfig.S3.Endpoint,
+			BatchSize:         sinkConfig.S3.BatchSize,
+			FlushInterval:     time.Duration(sinkConfig.S3.FlushInterval) * time.Second,
+			MaxRetries:        sinkConfig.S3.MaxRetries,
+			MaxPendingRecords: sinkConfig.S3.MaxPendingRecords,
+			Logger:            logger,
+		})
+		if err != nil {
+			logger.Error("📼 Capture: Failed to create S3 sink; capture disabled", "error", err)
+			return nil
+		}
+		sink = s3Sink
+		logger.Info("📼 Capture: Writing to S3", "bucket", sinkConfig.S3.Bucket, "endpoint", sinkConfig.S3.Endpoint)
+	default:
+		logger.Error("📼 Capture: Unknown sink; capture disabled", "type", sinkConfig.Type)
+		return nil
+	}
+
+	if !captureConfig.All && len(captureConfig.Keys)+len(captureConfig.Users)+len(captureConfig.Teams) == 0 {
+		logger.Warn("📼 Capture: No keys, users or teams opted in; nothing will be captured")
+	}
+	logger.Info("📼 Capture: Enabled",
+		"all", captureConfig.All,
+		"keys", len(captureConfig.Keys),
+		"users", len(captureConfig.Users),
+		"teams", len(captureConfig.Teams),
+		"sample_rate", captureConfig.SampleRate)
+	var redactors []capture.Redactor
+	if redactor != nil {
+		redactors = append(redactors, middleware.CaptureRedactor(redactor, yamlConfig.Features.Redaction))
+	}
+	return capture.New(capture.Options{
+		Sink:         sink,
+		All:          captureConfig.All,
+		Keys:         captureConfig.Keys,
+		Users:        captureConfig.Users,
+		Teams:        captureConfig.Teams,
+		SampleRate:   captureConfig.SampleRate,
+		MaxBodyBytes: captureConfig.MaxBodyBytes,
+		QueueSize:    captureConfig.QueueSize,
+		Redactors:    redactors,
+		Logger:       logger,
+	})
+}
+
 // runServer starts and runs the LLM proxy server
 func runServer(yamlConfig *config.YAMLConfig) {
 	// Get port from environment variable or use default
@@ -478,12 +882,22 @@ func runServer(yamlConfig *config.YAMLConfig) {
 	// Log configuration
 	yamlConfig.LogConfiguration(logger)
 
+	// Reloads compare against the configuration as loaded, before team
+	// limits from the key store are added to it
+	loadedHash, err := yamlConfig.Hash()
+	if err != nil {
+		logger.Warn("Failed to hash configuration", "error", err)
+	}
+
 	// Create router
 	r := mux.NewRouter()
 
 	// Initialize global provider manager
 	globalProviderManager = providers.NewProviderManager()
 
+	// Tracing must be set up before the middleware chain is built
+	globalTracer = initializeTracing(yamlConfig)
+
 	// Initialize cost tracker
 	globalCostTracker = initializeCostTracker(yamlConfig)
 	if globalCostTracker != nil {
@@ -505,7 +919,28 @@ func runServer(yamlConfig *config.YAMLConfig) {
 				"rpm", yamlConfig.Features.RateLimiting.Limits.RequestsPerMinute,
 				"tpm", yamlConfig.Features.RateLimiting.Limits.TokensPerMinute,
 				"rpd", yamlConfig.Features.RateLimiting.Limits.RequestsPerDay,
-				"tpd", yamlConfig.Features.RateLimiting.Limits.TokensPerDay)
+				"tpd", yamlConfig.Features.RateLimiting.Limits.TokensPerDay,
+				"cost_per_minute", yamlConfig.Features.RateLimiting.Limits.CostPerMinute,
+				"cost_per_hour", yamlConfig.Features.RateLimiting.Limits.CostPerHour,
+				"cost_per_day", yamlConfig.Features.RateLimiting.Limits.CostPerDay)
+			warnKeyHashSecret(yamlConfig)
+		}
+	}
+
+	// Cost limits need pricing data; reuse the cost tracker when available,
+	// otherwise load pricing into a tracker used only for estimation. Pricing
+	// is loaded even without cost limits, since a reload or an admin override
+	// can add them later.
+	var costEstimator ratelimit.CostEstimator
+	var pricingOnly *cost.CostTracker
+	if globalRateLimiter != nil {
+		if globalCostTracker != nil {
+			costEstimator = globalCostTracker
+		} else {
+			pricingOnly = cost.NewCostTracker()
+			pricingOnly.SetLogger(logger)
+			logger.Info("Rate limiting: Loaded pricing for cost limits", "total_models_configured", configurePricing(pricingOnly, yamlConfig))
+			costEstimator = pricingOnly
 		}
 	}
 
@@ -521,30 +956,112 @@ func runServer(yamlConfig *config.YAMLConfig) {
 	}
 
 	// Add middleware (order matters for streaming)
-	r.Use(middleware.MetaURLRewritingMiddleware(globalProviderManager)) // URL rewriting must happen first
+	r.Use(tracing.ServerMiddleware())                                                    // Outermost so every other span nests under the request
+	r.Use(middleware.RequestIDMiddleware(yamlConfig.Features.RequestID.ForwardHeader())) // Before logging so every line carries the ID
+	if yamlConfig.Features.TrustTeamHeader {
+		r.Use(middleware.TeamHeaderMiddleware)
+	}
+	r.Use(tracing.Middleware("meta_url_rewriting", middleware.MetaURLRewritingMiddleware(globalProviderManager))) // URL rewriting must happen first
+	r.Use(tracing.Middleware("logging", middleware.LoggingMiddleware(globalProviderManager)))                     // Before the rest so their logs carry request attributes
 
 	// Add API key validation middleware if API key management is enabled
 	if globalAPIKeyStore != nil {
-		r.Use(middleware.APIKeyValidationMiddleware(globalProviderManager, globalAPIKeyStore))
+		r.Use(tracing.Middleware("api_key_validation", middleware.APIKeyValidationMiddleware(globalProviderManager, globalAPIKeyStore, yamlConfig)))
+	}
+	if globalUsageTracker != nil {
+		r.Use(tracing.Middleware("key_usage", middleware.KeyUsageMiddleware(globalUsageTracker, yamlConfig)))
+	}
+
+	metricsCfg := yamlConfig.Features.Metrics
+	if metricsCfg.Enabled {
+		r.Use(tracing.Middleware("metrics", middleware.MetricsMiddleware(globalProviderManager)))
+	}
+	// Redact before rate limiting and capture so neither sees raw prompts
+	redactor := initializeRedaction(yamlConfig)
+	if redactor != nil {
+		r.Use(tracing.Middleware("redaction", middleware.RedactionMiddleware(globalProviderManager, redactor, yamlConfig.Features.Redaction)))
+	}
+
+	// CORS headers go on every response, including cached and shared ones
+	r.Use(tracing.Middleware("cors", middleware.CORSMiddleware(globalProviderManager)))
+
+	// Callbacks that price requests prefer the cost tracker and fall back to
+	// the rate limiter's pricing
+	var pricing ratelimit.CostEstimator
+	if globalCostTracker != nil {
+		pricing = globalCostTracker
+	} else if costEstimator != nil {
+		pricing = costEstimator
 	}
 
-	r.Use(middleware.LoggingMiddleware(globalProviderManager))
+	// Capture must wrap token parsing, the response cache and coalescing,
+	// which hand it the response
+	globalCapturer = initializeCapture(yamlConfig, redactor)
+	if globalCapturer != nil {
+		r.Use(tracing.Middleware("capture", middleware.CaptureMiddleware(globalProviderManager, globalCapturer, pricing)))
+	}
+
+	// Cached and shared responses count against request limits but reserve
+	// no tokens or cost
+	admit := middleware.RequestLimitGate(globalProviderManager, yamlConfig, globalRateLimiter)
+
+	// Serve repeated requests from the cache before they are rate limited
+	globalResponseCache = initializeResponseCache(yamlConfig)
+	if globalResponseCache != nil {
+		var onHit []middleware.MetadataCallback
+		if globalCostTracker != nil {
+			onHit = append(onHit, func(r *http.Request, metadata *providers.LLMResponseMetadata) {
+				provider := middleware.GetProviderFromRequest(globalProviderManager, r)
+				if err := globalCostTracker.TrackCachedRequest(metadata,
+					middleware.ExtractRequestIDFromRequest(r),
+					middleware.ExtractUserIDFromRequest(r, provider),
+					middleware.ExtractTeamIDFromRequest(r),
+					middleware.ExtractProjectIDFromRequest(r),
+					middleware.ExtractIPAddressFromRequest(r),
+					r.URL.Path); err != nil {
+					logger.Warn("Failed to track cached request", "error", err)
+				}
+			})
+		}
+		r.Use(tracing.Middleware("response_cache", middleware.ResponseCacheMiddleware(globalProviderManager, globalResponseCache, yamlConfig.Features.ResponseCache, admit, onHit...)))
+	}
+	// Share in-flight identical requests before they are rate limited
+	if coalescer := initializeCoalescing(yamlConfig); coalescer != nil {
+		var onShared []middleware.MetadataCallback
+		if globalCostTracker != nil {
+			onShared = append(onShared, func(r *http.Request, metadata *providers.LLMResponseMetadata) {
+				provider := middleware.GetProviderFromRequest(globalProviderManager, r)
+				if err := globalCostTracker.TrackCoalescedRequest(metadata,
+					middleware.ExtractRequestIDFromRequest(r),
+					middleware.ExtractUserIDFromRequest(r, provider),
+					middleware.ExtractTeamIDFromRequest(r),
+					middleware.ExtractProjectIDFromRequest(r),
+					middleware.ExtractIPAddressFromRequest(r),
+					r.URL.Path); err != nil {
+					logger.Warn("Failed to track coalesced request", "error", err)
+				}
+			})
+		}
+		r.Use(tracing.Middleware("coalescing", middleware.CoalescingMiddleware(globalProviderManager, coalescer, admit, onShared...)))
+	}
 	if globalRateLimiter != nil {
-		r.Use(middleware.RateLimitingMiddleware(globalProviderManager, yamlConfig, globalRateLimiter))
+		r.Use(tracing.Middleware("rate_limiting", middleware.RateLimitingMiddleware(globalProviderManager, yamlConfig, globalRateLimiter, costEstimator)))
 	}
-	r.Use(middleware.CORSMiddleware(globalProviderManager))
 
 	// Create callbacks for cost tracking
-	var callbacks []middleware.MetadataCallback
+	callbacks := []middleware.MetadataCallback{middleware.AccessLogCallback(pricing)}
 
 	// Add cost tracking callback if enabled
 	if globalCostTracker != nil {
 		costTrackingCallback := func(r *http.Request, metadata *providers.LLMResponseMetadata) {
 			if metadata.TotalTokens > 0 {
 				provider := middleware.GetProviderFromRequest(globalProviderManager, r)
+				requestID := middleware.ExtractRequestIDFromRequest(r)
 				userID := middleware.ExtractUserIDFromRequest(r, provider)
+				teamID := middleware.ExtractTeamIDFromRequest(r)
+				projectID := middleware.ExtractProjectIDFromRequest(r)
 				ipAddress := middleware.ExtractIPAddressFromRequest(r)
-				if err := globalCostTracker.TrackRequest(metadata, userID, ipAddress, r.URL.Path); err != nil {
+				if err := globalCostTracker.TrackRequest(metadata, requestID, userID, teamID, projectID, ipAddress, r.URL.Path, middleware.ExtractRedactionsFromRequest(r)); err != nil {
 					logger.Warn("Failed to track request cost", "error", err)
 				}
 			}
@@ -552,12 +1069,82 @@ func runServer(yamlConfig *config.YAMLConfig) {
 		callbacks = append(callbacks, costTrackingCallback)
 	}
 
-	r.Use(middleware.TokenParsingMiddleware(globalProviderManager, callbacks...)) // Add token parsing middleware with callbacks
-	r.Use(middleware.StreamingMiddleware(globalProviderManager))
+	// Add token and cost totals to the key's usage counters
+	if globalUsageTracker != nil {
+		keyUsageCallback := func(r *http.Request, metadata *providers.LLMResponseMetadata) {
+			recordID := middleware.ExtractKeyRecordIDFromRequest(r)
+			if recordID == "" || metadata.TotalTokens == 0 {
+				return
+			}
+			usage := apikeys.KeyUsage{Tokens: int64(metadata.TotalTokens)}
+			if pricing != nil {
+				if c, err := pricing.EstimateCost(metadata.Provider, metadata.Model, metadata.InputTokens, metadata.OutputTokens); err == nil {
+					usage.Cost = c
+				}
+			}
+			globalUsageTracker.Record(recordID, usage)
+		}
+		callbacks = append(callbacks, keyUsageCallback)
+	}
+
+	// Count tokens and cost for the metrics endpoint
+	if metricsCfg.Enabled {
+		callbacks = append(callbacks, middleware.MetricsCallback(pricing))
+	}
+
+	// Add GenAI attributes to the request span
+	if globalTracer != nil {
+		callbacks = append(callbacks, middleware.TracingCallback())
+	}
+
+	r.Use(tracing.Middleware("token_parsing", middleware.TokenParsingMiddleware(globalProviderManager, callbacks...))) // Add token parsing middleware with callbacks
+	r.Use(tracing.Middleware("streaming", middleware.StreamingMiddleware(globalProviderManager)))
 
 	// Health check endpoint
 	r.HandleFunc("/health", healthHandler).Methods("GET", "HEAD")
 
+	// Prometheus metrics endpoint
+	if metricsCfg.Enabled {
+		if metricsCfg.Path == "" {
+			metricsCfg.Path = "/metrics"
+		}
+		if globalCostTracker != nil {
+			metrics.NewGaugeFunc("llm_proxy_cost_queue_depth", "Cost records waiting for async workers", func() float64 {
+				return float64(globalCostTracker.QueueDepth())
+			})
+		}
+		r.Handle(metricsCfg.Path, metrics.Handler()).Methods("GET")
+		logger.Info("Metrics: ENABLED", "path", metricsCfg.Path)
+	}
+
+	// Admin API (operator-only, token protected)
+	if yamlConfig.Features.Admin.Enabled {
+		adminToken := yamlConfig.Features.Admin.Token
+		if adminToken == "" {
+			adminToken = os.Getenv("ADMIN_TOKEN")
+		}
+		if adminToken == "" {
+			logger.Error("Admin API: enabled but no token configured (set features.admin.token or ADMIN_TOKEN); not registering routes")
+		} else {
+			adminHandler := admin.NewHandler(adminToken, globalRateLimiter, logger)
+			if globalAPIKeyBackend != nil {
+				auditLog, err := admin.NewAuditLog(yamlConfig.Features.Admin.AuditLogPath, logger)
+				if err != nil {
+					logger.Error("Admin API: failed to open audit log; key administration disabled", "error", err)
+				} else {
+					adminHandler.EnableKeys(admin.KeysConfig{
+						Store:      globalAPIKeyBackend,
+						Audit:      auditLog,
+						Invalidate: invalidateAPIKey,
+					})
+					logger.Info("Admin API: key administration ENABLED", "audit_log", yamlConfig.Features.Admin.AuditLogPath)
+				}
+			}
+			adminHandler.RegisterRoutes(r)
+			logger.Info("Admin API: ENABLED", "path", "/admin/")
+		}
+	}
+
 	// Register routes for all providers centrally
 	for name, provider := range globalProviderManager.GetAllProviders() {
 		// Direct provider routes
@@ -624,6 +1211,28 @@ func runServer(yamlConfig *config.YAMLConfig) {
 		}
 	}()
 
+	// Reload pricing, model enablement and rate limits on SIGHUP and, when
+	// enabled, on changes to the configuration files
+	globalConfigReloader = config.NewReloader(yamlConfig, loadedHash, config.LoadEnvironmentConfig, func(next *config.YAMLConfig) error {
+		return applyReloadedConfig(next, pricingOnly)
+	}, logger)
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			logger.Info("🔄 Received SIGHUP; reloading configuration")
+			_ = globalConfigReloader.Reload()
+		}
+	}()
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	if reloadConfig := yamlConfig.Features.ConfigReload; reloadConfig.Watch {
+		basePath, envPath := config.EnvironmentConfigPaths()
+		interval := time.Duration(reloadConfig.IntervalSeconds) * time.Second
+		logger.Info("🔄 Config Reload: Watching configuration files", "paths", []string{basePath, envPath}, "interval", interval.String())
+		go globalConfigReloader.Watch(watchCtx, interval, basePath, envPath)
+	}
+
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -651,6 +1260,40 @@ func runServer(yamlConfig *config.YAMLConfig) {
 		logger.Info("✅ Cost tracking workers stopped and queue flushed")
 	}
 
+	// Write usage recorded since the last flush
+	if globalUsageTracker != nil {
+		if err := globalUsageTracker.Close(ctx); err != nil {
+			logger.Error("Failed to flush API key usage", "error", err)
+		} else {
+			logger.Info("✅ API key usage flushed")
+		}
+	}
+
+	// Write captured records that are still queued
+	if globalCapturer != nil {
+		if err := globalCapturer.Close(ctx); err != nil {
+			logger.Error("Failed to flush captured requests", "error", err)
+		} else {
+			logger.Info("✅ Captured requests flushed")
+		}
+	}
+
+	// Release the response cache's Redis connections
+	if globalResponseCache != nil {
+		if err := globalResponseCache.Close(); err != nil {
+			logger.Error("Failed to close response cache", "error", err)
+		}
+	}
+
+	// Export spans that are still queued
+	if globalTracer != nil {
+		if err := globalTracer.Shutdown(ctx); err != nil {
+			logger.Error("Failed to flush traces", "error", err)
+		} else {
+			logger.Info("✅ Traces flushed")
+		}
+	}
+
 	logger.Info("👋 Server shutdown complete")
 }
 
@@ -0,0 +1,79 @@
+// Package admin provides operator-only HTTP endpoints protected by an admin token.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Instawork/llm-proxy/internal/ratelimit"
+	"github.com/gorilla/mux"
+)
+
+// Handler serves the admin API
+type Handler struct {
+	token   string
+	limiter ratelimit.RateLimiter
+	logger  *slog.Logger
+	keys    *KeysConfig // nil unless EnableKeys was called
+}
+
+// NewHandler creates an admin handler. limiter may be nil when rate limiting is disabled.
+func NewHandler(token string, limiter ratelimit.RateLimiter, logger *slog.Logger) *Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Handler{token: token, limiter: limiter, logger: logger}
+}
+
+// RegisterRoutes registers all admin routes under /admin
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(h.requireToken)
+
+	if h.limiter != nil {
+		admin.HandleFunc("/ratelimit/usage", h.handleRateLimitUsage).Methods("GET")
+		admin.HandleFunc("/ratelimit/reset", h.handleRateLimitReset).Methods("POST")
+		admin.HandleFunc("/ratelimit/overrides", h.handleSetOverride).Methods("PUT")
+		admin.HandleFunc("/ratelimit/overrides", h.handleClearOverride).Methods("DELETE")
+	}
+
+	if h.keys != nil && h.keys.Store != nil {
+		admin.HandleFunc("/keys", h.handleListKeys).Methods("GET")
+		admin.HandleFunc("/keys", h.handleCreateKey).Methods("POST")
+		admin.HandleFunc("/keys/{id}", h.handleGetKey).Methods("GET")
+		admin.HandleFunc("/keys/{id}", h.handleUpdateKey).Methods("PATCH")
+		admin.HandleFunc("/keys/{id}", h.handleDeleteKey).Methods("DELETE")
+		admin.HandleFunc("/keys/{id}/enable", h.handleSetKeyEnabled(true)).Methods("POST")
+		admin.HandleFunc("/keys/{id}/disable", h.handleSetKeyEnabled(false)).Methods("POST")
+		admin.HandleFunc("/keys/{id}/rotate", h.handleRotateKey).Methods("POST")
+	}
+}
+
+// requireToken rejects requests without a matching admin token, accepted as
+// "Authorization: Bearer <token>" or "X-Admin-Token: <token>".
+func (h *Handler) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Admin-Token")
+		if auth := r.Header.Get("Authorization"); token == "" && strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if h.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
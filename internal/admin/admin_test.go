@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/ratelimit"
+	"github.com/gorilla/mux"
+)
+
+func newTestRouter(t *testing.T) (*mux.Router, ratelimit.RateLimiter) {
+	t.Helper()
+	cfg := config.GetDefaultYAMLConfig()
+	cfg.Features.RateLimiting.Enabled = true
+	cfg.Features.RateLimiting.Limits = config.LimitsConfig{RequestsPerMinute: 10}
+	lim := ratelimit.NewMemoryLimiter(cfg)
+	r := mux.NewRouter()
+	NewHandler("secret", lim, nil).RegisterRoutes(r)
+	return r, lim
+}
+
+func doRequest(r http.Handler, method, path string, body interface{}, token string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	r, _ := newTestRouter(t)
+	if rr := doRequest(r, "GET", "/admin/ratelimit/usage", nil, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rr.Code)
+	}
+	if rr := doRequest(r, "GET", "/admin/ratelimit/usage", nil, "wrong"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with wrong token, got %d", rr.Code)
+	}
+	if rr := doRequest(r, "GET", "/admin/ratelimit/usage", nil, "secret"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 with token, got %d", rr.Code)
+	}
+}
+
+func TestAdminRateLimitUsageResetAndOverride(t *testing.T) {
+	r, lim := newTestRouter(t)
+	ctx := context.Background()
+	scope := ratelimit.ScopeKeys{TeamID: "eng"}
+	for i := 0; i < 3; i++ {
+		if res, _ := lim.CheckAndReserve(ctx, "", scope, 5, 0, time.Now()); !res.Allowed {
+			t.Fatalf("reservation %d should be allowed", i)
+		}
+	}
+
+	// Usage filtered by prefix
+	rr := doRequest(r, "GET", "/admin/ratelimit/usage?prefix=team:", nil, "secret")
+	var usage struct {
+		Scopes []ratelimit.ScopeUsage `json:"scopes"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &usage); err != nil {
+		t.Fatalf("invalid usage response: %v", err)
+	}
+	if len(usage.Scopes) != 1 || usage.Scopes[0].ScopeKey != "team:eng" || usage.Scopes[0].Minute.Requests != 3 || usage.Scopes[0].Minute.Tokens != 15 {
+		t.Fatalf("unexpected usage: %+v", usage.Scopes)
+	}
+
+	// Temporary override tightens the team limit
+	rr = doRequest(r, "PUT", "/admin/ratelimit/overrides", map[string]interface{}{
+		"scope_key":   "team:eng",
+		"limits":      map[string]int{"requests_per_minute": 3},
+		"ttl_seconds": 60,
+	}, "secret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 setting override, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if res, _ := lim.CheckAndReserve(ctx, "", scope, 0, 0, time.Now()); res.Allowed {
+		t.Fatalf("override should block the fourth request")
+	}
+
+	// Reset clears counters so the team can proceed under the override
+	if rr := doRequest(r, "POST", "/admin/ratelimit/reset", map[string]string{"scope_key": "team:eng"}, "secret"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 resetting scope, got %d", rr.Code)
+	}
+	if res, _ := lim.CheckAndReserve(ctx, "", scope, 0, 0, time.Now()); !res.Allowed {
+		t.Fatalf("request after reset should be allowed")
+	}
+
+	if rr := doRequest(r, "DELETE", "/admin/ratelimit/overrides?scope_key=team:eng", nil, "secret"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 clearing override, got %d", rr.Code)
+	}
+	if rr := doRequest(r, "PUT", "/admin/ratelimit/overrides", map[string]string{"scope_key": "team:eng"}, "secret"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for override without expiry, got %d", rr.Code)
+	}
+	past := map[string]interface{}{"scope_key": "team:eng", "expires_at": time.Now().Add(-time.Minute)}
+	if rr := doRequest(r, "PUT", "/admin/ratelimit/overrides", past, "secret"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for override with a past expiry, got %d", rr.Code)
+	}
+}
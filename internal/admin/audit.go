@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry records one key administration change
+type AuditEntry struct {
+	Time       time.Time              `json:"time"`
+	Action     string                 `json:"action"`
+	KeyID      string                 `json:"key_id,omitempty"`
+	Actor      string                 `json:"actor,omitempty"` // X-Admin-Actor header, e.g. the portal user
+	RemoteAddr string                 `json:"remote_addr,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"` // Never contains secrets
+	Error      string                 `json:"error,omitempty"`   // Set when the change failed
+}
+
+// AuditLog appends audit entries as JSON lines to a file, and always to the logger
+type AuditLog struct {
+	mu     sync.Mutex
+	w      io.WriteCloser
+	logger *slog.Logger
+}
+
+// NewAuditLog opens path for appending. With an empty path entries only go to the logger.
+func NewAuditLog(path string, logger *slog.Logger) (*AuditLog, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	a := &AuditLog{logger: logger}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		a.w = f
+	}
+	return a, nil
+}
+
+// Record writes an entry, stamping the time if unset
+func (a *AuditLog) Record(e AuditEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	a.logger.Info("📝 Admin audit",
+		"action", e.Action,
+		"key_id", e.KeyID,
+		"actor", e.Actor,
+		"remote_addr", e.RemoteAddr,
+		"details", e.Details,
+		"error", e.Error)
+	if a.w == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// Close closes the audit log file
+func (a *AuditLog) Close() error {
+	if a.w == nil {
+		return nil
+	}
+	return a.w.Close()
+}
@@ -0,0 +1,431 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/apikeys"
+	"github.com/gorilla/mux"
+)
+
+// defaultRotationGrace is how long a rotated key stays valid when the request does not say
+const defaultRotationGrace = 24 * time.Hour
+
+// KeysConfig enables the /admin/keys endpoints
+type KeysConfig struct {
+	Store apikeys.KeyStore
+	Audit *AuditLog
+	// Invalidate is called with the ID of every changed key so caches drop it (optional)
+	Invalidate func(ctx context.Context, keyID string)
+}
+
+// EnableKeys turns on the key administration API. Call before RegisterRoutes.
+func (h *Handler) EnableKeys(cfg KeysConfig) {
+	if cfg.Audit == nil {
+		cfg.Audit, _ = NewAuditLog("", h.logger)
+	}
+	h.keys = &cfg
+}
+
+// keyView is an API key as returned by the admin API, with upstream keys masked
+type keyView struct {
+	ID             string            `json:"id"`
+	DisplayPrefix  string            `json:"display_prefix,omitempty"`
+	Provider       string            `json:"provider"`
+	Providers      []string          `json:"providers"`
+	ActualKey      string            `json:"actual_key"`
+	ProviderKeys   map[string]string `json:"provider_keys,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	DailyCostLimit int64             `json:"daily_cost_limit"`
+	Enabled        bool              `json:"enabled"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	ExpiresAt      *time.Time        `json:"expires_at,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	Scope          *apikeys.KeyScope `json:"scope,omitempty"`
+	TeamID         string            `json:"team_id,omitempty"`
+	ProjectID      string            `json:"project_id,omitempty"`
+	RotatedFrom    string            `json:"rotated_from,omitempty"`
+	RotatedTo      string            `json:"rotated_to,omitempty"`
+	LineageID      string            `json:"lineage_id,omitempty"`
+	LastUsedAt     *time.Time        `json:"last_used_at,omitempty"`
+	LastUsedIP     string            `json:"last_used_ip,omitempty"`
+	RequestCount   int64             `json:"request_count"`
+	TokenCount     int64             `json:"token_count"`
+	TotalCost      float64           `json:"total_cost"`
+}
+
+func newKeyView(k *apikeys.APIKey) keyView {
+	v := keyView{
+		ID:             k.PK,
+		DisplayPrefix:  k.DisplayPrefix,
+		Provider:       k.Provider,
+		Providers:      k.Providers(),
+		ActualKey:      apikeys.MaskSecret(k.ActualKey),
+		Description:    k.Description,
+		DailyCostLimit: k.DailyCostLimit,
+		Enabled:        k.Enabled,
+		CreatedAt:      k.CreatedAt,
+		UpdatedAt:      k.UpdatedAt,
+		ExpiresAt:      k.ExpiresAt,
+		Tags:           k.Tags,
+		Scope:          k.Scope,
+		TeamID:         k.TeamID,
+		ProjectID:      k.ProjectID,
+		RotatedFrom:    k.RotatedFrom,
+		RotatedTo:      k.RotatedTo,
+		LineageID:      k.LineageID,
+		LastUsedAt:     k.LastUsedAt,
+		LastUsedIP:     k.LastUsedIP,
+		RequestCount:   k.RequestCount,
+		TokenCount:     k.TokenCount,
+		TotalCost:      k.TotalCost,
+	}
+	// Legacy records are stored under the plaintext key; never return it
+	if !apikeys.IsKeyID(k.PK) {
+		v.ID = apikeys.MaskSecret(k.PK)
+	}
+	if v.RotatedFrom != "" && !apikeys.IsKeyID(v.RotatedFrom) {
+		v.RotatedFrom = apikeys.MaskSecret(v.RotatedFrom)
+	}
+	if v.RotatedTo != "" && !apikeys.IsKeyID(v.RotatedTo) {
+		v.RotatedTo = apikeys.MaskSecret(v.RotatedTo)
+	}
+	if v.DisplayPrefix == "" && !apikeys.IsKeyID(k.PK) {
+		v.DisplayPrefix = apikeys.DisplayPrefix(k.PK)
+	}
+	if len(k.ProviderKeys) > 0 {
+		v.ProviderKeys = make(map[string]string, len(k.ProviderKeys))
+		for p, upstream := range k.ProviderKeys {
+			v.ProviderKeys[p] = apikeys.MaskSecret(upstream)
+		}
+	}
+	return v
+}
+
+type createKeyRequest struct {
+	Provider       string            `json:"provider"`
+	ActualKey      string            `json:"actual_key"`
+	ProviderKeys   map[string]string `json:"provider_keys,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	DailyCostLimit int64             `json:"daily_cost_limit"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	ExpiresAt      *time.Time        `json:"expires_at,omitempty"`
+	Scope          *apikeys.KeyScope `json:"scope,omitempty"`
+	TeamID         string            `json:"team_id,omitempty"`
+	ProjectID      string            `json:"project_id,omitempty"`
+}
+
+// updateKeyRequest changes only the fields that are present. An empty scope
+// object removes the key's scope.
+type updateKeyRequest struct {
+	Description    *string           `json:"description,omitempty"`
+	DailyCostLimit *int64            `json:"daily_cost_limit,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	ExpiresAt      *time.Time        `json:"expires_at,omitempty"`
+	Scope          *apikeys.KeyScope `json:"scope,omitempty"`
+}
+
+type rotateKeyRequest struct {
+	GraceSeconds int `json:"grace_seconds,omitempty"`
+}
+
+// handleListKeys lists keys, optionally filtered by ?provider= and ?team=
+func (h *Handler) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.Store.ListKeys(r.Context(), r.URL.Query().Get("provider"))
+	if err != nil {
+		h.logger.Error("Admin: failed to list API keys", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list keys")
+		return
+	}
+	team := r.URL.Query().Get("team")
+	views := make([]keyView, 0, len(keys))
+	for _, k := range keys {
+		if team != "" && k.TeamID != team {
+			continue
+		}
+		views = append(views, newKeyView(k))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": views})
+}
+
+// handleCreateKey creates a key and returns its iw: key, which is never shown again
+func (h *Handler) handleCreateKey(w http.ResponseWriter, r *http.Request) {
+	var req createKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Provider == "" || req.ActualKey == "" {
+		writeError(w, http.StatusBadRequest, "provider and actual_key are required")
+		return
+	}
+	if _, ok := req.ProviderKeys[req.Provider]; ok {
+		writeError(w, http.StatusBadRequest, "provider_keys must not include the primary provider")
+		return
+	}
+	if err := req.Scope.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid scope: %v", err))
+		return
+	}
+	details := map[string]interface{}{
+		"provider":         req.Provider,
+		"description":      req.Description,
+		"daily_cost_limit": req.DailyCostLimit,
+	}
+
+	ctx := r.Context()
+	store := h.keys.Store
+	apiKey, err := store.CreateKey(ctx, req.Provider, req.ActualKey, req.Description, req.DailyCostLimit, req.Tags)
+	if err != nil {
+		h.keyMutationFailed(w, r, "create", "", details, err)
+		return
+	}
+
+	// The key has not been handed out yet, so it is never used before these are set
+	updates := make(map[string]interface{})
+	if !req.Scope.IsEmpty() {
+		updates["scope"] = req.Scope
+	}
+	if len(req.ProviderKeys) > 0 {
+		updates["provider_keys"] = req.ProviderKeys
+	}
+	if req.ExpiresAt != nil {
+		updates["expires_at"] = *req.ExpiresAt
+	}
+	if len(updates) > 0 {
+		err = store.UpdateKey(ctx, apiKey.PK, updates)
+	}
+	if err == nil && (req.TeamID != "" || req.ProjectID != "") {
+		err = apikeys.AssignTeam(ctx, store, apiKey.PK, req.TeamID, req.ProjectID)
+	}
+	if err != nil {
+		if delErr := store.DeleteKey(ctx, apiKey.PK); delErr != nil {
+			h.logger.Error("Admin: failed to remove partially created API key", "key_id", apiKey.PK, "error", delErr)
+		}
+		h.keyMutationFailed(w, r, "create", apiKey.PK, details, err)
+		return
+	}
+
+	created, err := store.LookupKey(ctx, apiKey.PK)
+	if err != nil {
+		created = apiKey
+	}
+	h.audit(r, "create", apiKey.PK, details, nil)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"key": apiKey.Key, "api_key": newKeyView(created)})
+}
+
+// handleGetKey shows a key with its upstream keys masked
+func (h *Handler) handleGetKey(w http.ResponseWriter, r *http.Request) {
+	keyID, ok := h.resolveKey(w, r)
+	if !ok {
+		return
+	}
+	k, err := h.keys.Store.LookupKey(r.Context(), keyID)
+	if err != nil {
+		writeError(w, keyErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, newKeyView(k))
+}
+
+// handleUpdateKey changes a key's limit, description, tags, expiry or scope
+func (h *Handler) handleUpdateKey(w http.ResponseWriter, r *http.Request) {
+	keyID, ok := h.resolveKey(w, r)
+	if !ok {
+		return
+	}
+	var req updateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	updates := make(map[string]interface{})
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.DailyCostLimit != nil {
+		updates["daily_cost_limit"] = *req.DailyCostLimit
+	}
+	if req.Tags != nil {
+		updates["tags"] = req.Tags
+	}
+	if req.ExpiresAt != nil {
+		updates["expires_at"] = *req.ExpiresAt
+	}
+	if req.Scope != nil {
+		if err := req.Scope.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid scope: %v", err))
+			return
+		}
+		var scope *apikeys.KeyScope
+		if !req.Scope.IsEmpty() {
+			scope = req.Scope
+		}
+		updates["scope"] = scope
+	}
+	if len(updates) == 0 {
+		writeError(w, http.StatusBadRequest, "no updatable fields given")
+		return
+	}
+
+	if err := h.keys.Store.UpdateKey(r.Context(), keyID, updates); err != nil {
+		h.keyMutationFailed(w, r, "update", keyID, updates, err)
+		return
+	}
+	h.keyChanged(r, "update", keyID, updates)
+	h.writeKey(w, r, keyID)
+}
+
+// handleSetKeyEnabled returns a handler that enables or disables a key
+func (h *Handler) handleSetKeyEnabled(enabled bool) http.HandlerFunc {
+	action := "disable"
+	if enabled {
+		action = "enable"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		keyID, ok := h.resolveKey(w, r)
+		if !ok {
+			return
+		}
+		if err := h.keys.Store.UpdateKey(r.Context(), keyID, map[string]interface{}{"enabled": enabled}); err != nil {
+			h.keyMutationFailed(w, r, action, keyID, nil, err)
+			return
+		}
+		h.keyChanged(r, action, keyID, nil)
+		h.writeKey(w, r, keyID)
+	}
+}
+
+// handleRotateKey mints a successor key; the old key stays valid for grace_seconds (default 24h)
+func (h *Handler) handleRotateKey(w http.ResponseWriter, r *http.Request) {
+	keyID, ok := h.resolveKey(w, r)
+	if !ok {
+		return
+	}
+	var req rotateKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.GraceSeconds < 0 {
+			writeError(w, http.StatusBadRequest, "grace_seconds must be a non-negative integer")
+			return
+		}
+	}
+	grace := defaultRotationGrace
+	if req.GraceSeconds > 0 {
+		grace = time.Duration(req.GraceSeconds) * time.Second
+	}
+	details := map[string]interface{}{"grace_seconds": int(grace.Seconds())}
+
+	successor, err := apikeys.RotateKey(r.Context(), h.keys.Store, keyID, grace)
+	if err != nil {
+		h.keyMutationFailed(w, r, "rotate", keyID, details, err)
+		return
+	}
+	details["successor"] = successor.PK
+	h.keyChanged(r, "rotate", keyID, details)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"key": successor.Key, "api_key": newKeyView(successor)})
+}
+
+// handleDeleteKey deletes a key
+func (h *Handler) handleDeleteKey(w http.ResponseWriter, r *http.Request) {
+	keyID, ok := h.resolveKey(w, r)
+	if !ok {
+		return
+	}
+	if err := h.keys.Store.DeleteKey(r.Context(), keyID); err != nil {
+		h.keyMutationFailed(w, r, "delete", keyID, nil, err)
+		return
+	}
+	h.keyChanged(r, "delete", keyID, nil)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted", "id": keyID})
+}
+
+// resolveKey maps the {id} route variable, a key ID or iw: key, to the stored record ID
+func (h *Handler) resolveKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	keyID, err := apikeys.ResolveKeyID(r.Context(), h.keys.Store, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, keyErrorStatus(err), err.Error())
+		return "", false
+	}
+	return keyID, true
+}
+
+// writeKey responds with the current state of a key after a change
+func (h *Handler) writeKey(w http.ResponseWriter, r *http.Request, keyID string) {
+	k, err := h.keys.Store.LookupKey(r.Context(), keyID)
+	if err != nil {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "updated", "id": keyID})
+		return
+	}
+	writeJSON(w, http.StatusOK, newKeyView(k))
+}
+
+// keyChanged audits a successful mutation and drops the key from caches
+func (h *Handler) keyChanged(r *http.Request, action, keyID string, details map[string]interface{}) {
+	h.audit(r, action, keyID, details, nil)
+	if h.keys.Invalidate != nil {
+		h.keys.Invalidate(r.Context(), keyID)
+	}
+}
+
+// keyMutationFailed audits a failed mutation and writes the error response
+func (h *Handler) keyMutationFailed(w http.ResponseWriter, r *http.Request, action, keyID string, details map[string]interface{}, err error) {
+	h.audit(r, action, keyID, details, err)
+	status := keyErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		h.logger.Error("Admin: API key change failed", "action", action, "key_id", apikeys.KeyID(keyID), "error", err)
+		writeError(w, status, fmt.Sprintf("failed to %s key", action))
+		return
+	}
+	writeError(w, status, err.Error())
+}
+
+func (h *Handler) audit(r *http.Request, action, keyID string, details map[string]interface{}, err error) {
+	entry := AuditEntry{
+		Action:     action,
+		KeyID:      apikeys.KeyID(keyID), // legacy records are stored under the plaintext key
+		Actor:      r.Header.Get("X-Admin-Actor"),
+		RemoteAddr: r.RemoteAddr,
+		Details:    auditDetails(details),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if auditErr := h.keys.Audit.Record(entry); auditErr != nil {
+		h.logger.Error("Admin: failed to write audit log", "action", action, "key_id", apikeys.KeyID(keyID), "error", auditErr)
+	}
+}
+
+// auditDetails copies details without upstream keys
+func auditDetails(details map[string]interface{}) map[string]interface{} {
+	if len(details) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(details))
+	for k, v := range details {
+		if k == "actual_key" || k == "provider_keys" {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// keyErrorStatus maps key store errors to HTTP status codes
+func keyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, apikeys.ErrKeyNotFound), errors.Is(err, apikeys.ErrTeamNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, apikeys.ErrReadOnly), errors.Is(err, apikeys.ErrAlreadyRotated), errors.Is(err, apikeys.ErrKeyExists):
+		return http.StatusConflict
+	case errors.Is(err, apikeys.ErrKeyExpired), errors.Is(err, apikeys.ErrKeyDisabled):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,246 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Instawork/llm-proxy/internal/apikeys"
+	"github.com/gorilla/mux"
+)
+
+func newKeysTestRouter(t *testing.T) (*mux.Router, *apikeys.MemoryStore, string, *[]string) {
+	t.Helper()
+	store := apikeys.NewMemoryStore()
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := NewAuditLog(auditPath, nil)
+	if err != nil {
+		t.Fatalf("NewAuditLog: %v", err)
+	}
+	t.Cleanup(func() { audit.Close() })
+
+	var invalidated []string
+	h := NewHandler("secret", nil, nil)
+	h.EnableKeys(KeysConfig{
+		Store:      store,
+		Audit:      audit,
+		Invalidate: func(_ context.Context, keyID string) { invalidated = append(invalidated, keyID) },
+	})
+	r := mux.NewRouter()
+	h.RegisterRoutes(r)
+	return r, store, auditPath, &invalidated
+}
+
+func TestAdminKeysLifecycle(t *testing.T) {
+	r, store, auditPath, invalidated := newKeysTestRouter(t)
+	ctx := context.Background()
+
+	if rr := doRequest(r, "GET", "/admin/keys", nil, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rr.Code)
+	}
+
+	// Create returns the plaintext key once and masks the upstream key
+	rr := doRequest(r, "POST", "/admin/keys", map[string]interface{}{
+		"provider":         "openai",
+		"actual_key":       "sk-upstream-secret-1234",
+		"description":      "ci",
+		"daily_cost_limit": 500,
+		"scope":            map[string]interface{}{"allowed_models": []string{"gpt-4o*"}},
+	}, "secret")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating key, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Key    string  `json:"key"`
+		APIKey keyView `json:"api_key"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("invalid create response: %v", err)
+	}
+	if !strings.HasPrefix(created.Key, apikeys.KeyPrefix) || created.APIKey.ID != apikeys.HashKey(created.Key) {
+		t.Fatalf("unexpected created key: %+v", created)
+	}
+	if strings.Contains(rr.Body.String(), "sk-upstream-secret-1234") {
+		t.Fatalf("create response leaked the upstream key: %s", rr.Body.String())
+	}
+	if !created.APIKey.Scope.RestrictsModel() {
+		t.Fatalf("scope was not applied: %+v", created.APIKey.Scope)
+	}
+	id := created.APIKey.ID
+
+	// Show and list never include the upstream key
+	for _, path := range []string{"/admin/keys/" + id, "/admin/keys?provider=openai"} {
+		rr = doRequest(r, "GET", path, nil, "secret")
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), id) || strings.Contains(rr.Body.String(), "sk-upstream-secret-1234") {
+			t.Fatalf("GET %s: unexpected response %d: %s", path, rr.Code, rr.Body.String())
+		}
+	}
+	if rr = doRequest(r, "GET", "/admin/keys/"+apikeys.HashKey("iw:missing"), nil, "secret"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown key, got %d", rr.Code)
+	}
+
+	// Update limit and tags; the plaintext key resolves to the record too
+	rr = doRequest(r, "PATCH", "/admin/keys/"+created.Key, map[string]interface{}{
+		"daily_cost_limit": 900,
+		"tags":             map[string]string{"env": "ci"},
+	}, "secret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 updating key, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if k, _ := store.LookupKey(ctx, id); k.DailyCostLimit != 900 || k.Tags["env"] != "ci" || k.Description != "ci" {
+		t.Fatalf("update not applied: %+v", k)
+	}
+
+	// Disable and enable
+	if rr = doRequest(r, "POST", "/admin/keys/"+id+"/disable", nil, "secret"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 disabling key, got %d", rr.Code)
+	}
+	if _, err := store.GetKey(ctx, created.Key); err == nil {
+		t.Fatalf("disabled key should not be usable")
+	}
+	if rr = doRequest(r, "POST", "/admin/keys/"+id+"/enable", nil, "secret"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 enabling key, got %d", rr.Code)
+	}
+
+	// Rotate issues a successor; rotating again conflicts
+	rr = doRequest(r, "POST", "/admin/keys/"+id+"/rotate", map[string]int{"grace_seconds": 60}, "secret")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 rotating key, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var rotated struct {
+		Key    string  `json:"key"`
+		APIKey keyView `json:"api_key"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &rotated)
+	if rotated.APIKey.RotatedFrom != id || rotated.APIKey.DailyCostLimit != 900 {
+		t.Fatalf("unexpected successor: %+v", rotated.APIKey)
+	}
+	if rr = doRequest(r, "POST", "/admin/keys/"+id+"/rotate", nil, "secret"); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 rotating twice, got %d", rr.Code)
+	}
+
+	// Delete
+	if rr = doRequest(r, "DELETE", "/admin/keys/"+id, nil, "secret"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 deleting key, got %d", rr.Code)
+	}
+	if _, err := store.LookupKey(ctx, id); err == nil {
+		t.Fatalf("deleted key still present")
+	}
+
+	if len(*invalidated) != 5 {
+		t.Fatalf("expected 5 invalidations, got %v", *invalidated)
+	}
+
+	// Every mutation, including the failed rotation, is audited without secrets
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	if strings.Contains(string(data), "sk-upstream-secret-1234") || strings.Contains(string(data), created.Key) {
+		t.Fatalf("audit log leaked a secret: %s", data)
+	}
+	var actions []string
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		if e.KeyID != id {
+			t.Fatalf("audit entry for wrong key: %+v", e)
+		}
+		action := e.Action
+		if e.Error != "" {
+			action += "!"
+		}
+		actions = append(actions, action)
+	}
+	want := "create,update,disable,enable,rotate,rotate!,delete"
+	if got := strings.Join(actions, ","); got != want {
+		t.Fatalf("audit actions = %s, want %s", got, want)
+	}
+}
+
+func TestAdminKeysValidation(t *testing.T) {
+	r, store, _, _ := newKeysTestRouter(t)
+
+	cases := []map[string]interface{}{
+		{"provider": "openai"},
+		{"provider": "openai", "actual_key": "sk-1", "provider_keys": map[string]string{"openai": "sk-2"}},
+		{"provider": "openai", "actual_key": "sk-1", "scope": map[string]interface{}{"allowed_cidrs": []string{"not-a-cidr"}}},
+	}
+	for _, body := range cases {
+		if rr := doRequest(r, "POST", "/admin/keys", body, "secret"); rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %v, got %d: %s", body, rr.Code, rr.Body.String())
+		}
+	}
+
+	// A failed team assignment does not leave a half-created key behind
+	rr := doRequest(r, "POST", "/admin/keys", map[string]interface{}{
+		"provider": "openai", "actual_key": "sk-1", "team_id": "nope",
+	}, "secret")
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown team, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if keys, _ := store.ListKeys(context.Background(), ""); len(keys) != 0 {
+		t.Fatalf("expected no keys after failed create, got %d", len(keys))
+	}
+}
+
+func TestAdminKeysLegacyKeyAudit(t *testing.T) {
+	r, store, auditPath, _ := newKeysTestRouter(t)
+	// Records created before key hashing are stored under the plaintext key
+	if err := store.PutKey(context.Background(), &apikeys.APIKey{PK: "iw:legacy-secret", Provider: "openai", ActualKey: "sk-legacy", Enabled: true}); err != nil {
+		t.Fatalf("PutKey: %v", err)
+	}
+	if rr := doRequest(r, "POST", "/admin/keys/iw:legacy-secret/disable", nil, "secret"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 disabling legacy key, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	if strings.Contains(string(data), "iw:legacy-secret") || !strings.Contains(string(data), apikeys.HashKey("iw:legacy-secret")) {
+		t.Fatalf("expected the audit log to name the key by its hashed ID: %s", data)
+	}
+}
+
+func TestAdminKeysRotateLegacyKey(t *testing.T) {
+	r, store, _, _ := newKeysTestRouter(t)
+	ctx := context.Background()
+	if err := store.PutKey(ctx, &apikeys.APIKey{PK: "iw:legacy-secret", Provider: "openai", ActualKey: "sk-legacy", Enabled: true}); err != nil {
+		t.Fatalf("PutKey: %v", err)
+	}
+	// A successor written before rotation links were hashed
+	if err := store.PutKey(ctx, &apikeys.APIKey{PK: apikeys.HashKey("iw:older"), Provider: "openai", ActualKey: "sk-older", Enabled: true, RotatedFrom: "iw:older-secret"}); err != nil {
+		t.Fatalf("PutKey: %v", err)
+	}
+
+	rr := doRequest(r, "POST", "/admin/keys/iw:legacy-secret/rotate", nil, "secret")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 rotating legacy key, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var rotated struct {
+		APIKey keyView `json:"api_key"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &rotated)
+	if rotated.APIKey.RotatedFrom != apikeys.HashKey("iw:legacy-secret") {
+		t.Fatalf("expected the successor to link to the hashed ID, got %q", rotated.APIKey.RotatedFrom)
+	}
+
+	for _, id := range []string{rotated.APIKey.ID, "iw:legacy-secret", apikeys.HashKey("iw:older")} {
+		rr := doRequest(r, "GET", "/admin/keys/"+id, nil, "secret")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d", id, rr.Code)
+		}
+		if body := rr.Body.String(); strings.Contains(body, "iw:legacy-secret") || strings.Contains(body, "iw:older-secret") {
+			t.Fatalf("GET %s returned a plaintext key: %s", id, body)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/ratelimit"
+)
+
+// handleRateLimitUsage lists current counters, optionally filtered by ?prefix=team:
+func (h *Handler) handleRateLimitUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := h.limiter.Usage(r.Context(), r.URL.Query().Get("prefix"), time.Now())
+	if err != nil {
+		h.logger.Error("Admin: failed to read rate limit usage", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to read usage")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"scopes": usage})
+}
+
+type resetRequest struct {
+	ScopeKey string `json:"scope_key"`
+}
+
+// handleRateLimitReset clears all counters for a scope key
+func (h *Handler) handleRateLimitReset(w http.ResponseWriter, r *http.Request) {
+	var req resetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ScopeKey == "" {
+		writeError(w, http.StatusBadRequest, "scope_key is required")
+		return
+	}
+	if err := h.limiter.Reset(r.Context(), req.ScopeKey, time.Now()); err != nil {
+		h.logger.Error("Admin: failed to reset rate limit scope", "scope_key", req.ScopeKey, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to reset scope")
+		return
+	}
+	h.logger.Info("Admin: reset rate limit scope", "scope_key", req.ScopeKey)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reset", "scope_key": req.ScopeKey})
+}
+
+type overrideRequest struct {
+	ScopeKey   string              `json:"scope_key"`
+	Limits     config.LimitsConfig `json:"limits"`
+	TTLSeconds int                 `json:"ttl_seconds,omitempty"`
+	ExpiresAt  *time.Time          `json:"expires_at,omitempty"`
+}
+
+// handleSetOverride applies temporary limits to a scope key. Expiry is given
+// either as ttl_seconds or an RFC 3339 expires_at.
+func (h *Handler) handleSetOverride(w http.ResponseWriter, r *http.Request) {
+	var req overrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ScopeKey == "" {
+		writeError(w, http.StatusBadRequest, "scope_key is required")
+		return
+	}
+	var expiresAt time.Time
+	switch {
+	case req.ExpiresAt != nil:
+		expiresAt = *req.ExpiresAt
+	case req.TTLSeconds > 0:
+		expiresAt = time.Now().Add(time.Duration(req.TTLSeconds) * time.Second)
+	}
+	// The limiter rejects past expiries, so both backends answer the same
+	err := h.limiter.SetOverride(r.Context(), req.ScopeKey, req.Limits, expiresAt)
+	if errors.Is(err, ratelimit.ErrOverrideExpired) {
+		writeError(w, http.StatusBadRequest, "a future expires_at or positive ttl_seconds is required")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Admin: failed to set rate limit override", "scope_key", req.ScopeKey, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to set override")
+		return
+	}
+	h.logger.Info("Admin: set rate limit override", "scope_key", req.ScopeKey, "expires_at", expiresAt)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "override_set", "scope_key": req.ScopeKey, "expires_at": expiresAt})
+}
+
+// handleClearOverride removes a temporary override given ?scope_key=
+func (h *Handler) handleClearOverride(w http.ResponseWriter, r *http.Request) {
+	scopeKey := r.URL.Query().Get("scope_key")
+	if scopeKey == "" {
+		writeError(w, http.StatusBadRequest, "scope_key is required")
+		return
+	}
+	if err := h.limiter.ClearOverride(r.Context(), scopeKey); err != nil {
+		h.logger.Error("Admin: failed to clear rate limit override", "scope_key", scopeKey, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to clear override")
+		return
+	}
+	h.logger.Info("Admin: cleared rate limit override", "scope_key", scopeKey)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "override_cleared", "scope_key": scopeKey})
+}
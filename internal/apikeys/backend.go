@@ -0,0 +1,247 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/config"
+)
+
+// Supported key store backends
+const (
+	BackendDynamoDB = "dynamodb"
+	BackendFile     = "file"
+	BackendSQLite   = "sqlite"
+	BackendMemory   = "memory"
+)
+
+// ErrReadOnly is returned by mutating operations on read-only backends such as the file store
+var ErrReadOnly = errors.New("API key store is read-only")
+
+// KeyStore is implemented by every API key backend. GetKey and
+// ValidateAndGetActualKey take the iw: key presented by a client; the other
+// methods address records by their stored ID (see HashKey).
+type KeyStore interface {
+	KeyGetter
+	TeamStore
+	// LookupKey returns a record by ID whether or not it is usable
+	LookupKey(ctx context.Context, id string) (*APIKey, error)
+	// PutKey writes a complete record, failing with ErrKeyExists if the ID is taken
+	PutKey(ctx context.Context, apiKey *APIKey) error
+	CreateKey(ctx context.Context, provider, actualKey, description string, dailyCostLimit int64, tags map[string]string) (*APIKey, error)
+	UpdateKey(ctx context.Context, key string, updates map[string]interface{}) error
+	DeleteKey(ctx context.Context, key string) error
+	ListKeys(ctx context.Context, provider string) ([]*APIKey, error)
+	ValidateAndGetActualKey(ctx context.Context, key string) (string, string, error)
+	ValidateAndGetProviderKey(ctx context.Context, key, provider string) (string, error)
+	// RecordUsage adds to a key's usage counters and sets when and where it was last used
+	RecordUsage(ctx context.Context, id string, usage KeyUsage) error
+}
+
+var (
+	_ KeyStore = (*Store)(nil)
+	_ KeyStore = (*MemoryStore)(nil)
+	_ KeyStore = (*FileStore)(nil)
+	_ KeyStore = (*SQLStore)(nil)
+	_ KeyStore = (*EncryptedStore)(nil)
+)
+
+// BackendConfig selects and configures a key store backend
+type BackendConfig struct {
+	Backend        string            // dynamodb (default), file, sqlite or memory
+	TableName      string            // dynamodb
+	Region         string            // dynamodb
+	Path           string            // file and sqlite
+	ReloadInterval time.Duration     // file: how often to check for changes (default: 5s)
+	Encryption     *EncryptionConfig // when set, provider keys are sealed at rest
+	Logger         *slog.Logger
+}
+
+// NewKeyStore creates the configured key store backend, wrapped with
+// encryption of provider keys when cfg.Encryption is set
+func NewKeyStore(cfg BackendConfig) (KeyStore, error) {
+	store, err := newBackend(cfg)
+	if err != nil || cfg.Encryption == nil {
+		return store, err
+	}
+	enc, err := NewEncryptorFromConfig(*cfg.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure key encryption: %w", err)
+	}
+	return NewEncryptedStore(store, enc, cfg.Logger), nil
+}
+
+// newBackend creates the unwrapped key store for cfg.Backend
+func newBackend(cfg BackendConfig) (KeyStore, error) {
+	switch cfg.Backend {
+	case "", BackendDynamoDB:
+		return NewStore(StoreConfig{TableName: cfg.TableName, Region: cfg.Region, Logger: cfg.Logger})
+	case BackendFile:
+		return NewFileStore(FileStoreConfig{Path: cfg.Path, ReloadInterval: cfg.ReloadInterval, Logger: cfg.Logger})
+	case BackendSQLite:
+		return NewSQLiteStore(cfg.Path, cfg.Logger)
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unsupported API key backend: %s (supported: dynamodb, file, sqlite, memory)", cfg.Backend)
+	}
+}
+
+// validateAndGetActualKey implements ValidateAndGetActualKey on top of any KeyGetter
+func validateAndGetActualKey(ctx context.Context, g KeyGetter, key string) (string, string, error) {
+	// If key doesn't have our prefix, return it as-is (passthrough)
+	if !strings.HasPrefix(key, KeyPrefix) {
+		return key, "", nil
+	}
+
+	apiKey, err := g.GetKey(ctx, key)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid API key: %w", err)
+	}
+
+	// Return the actual provider key and provider name
+	return apiKey.ActualKey, apiKey.Provider, nil
+}
+
+// validateAndGetProviderKey implements ValidateAndGetProviderKey on top of any KeyGetter
+func validateAndGetProviderKey(ctx context.Context, g KeyGetter, key, provider string) (string, error) {
+	// If key doesn't have our prefix, return it as-is (passthrough)
+	if !strings.HasPrefix(key, KeyPrefix) {
+		return key, nil
+	}
+
+	apiKey, err := g.GetKey(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("invalid API key: %w", err)
+	}
+	upstream, ok := apiKey.UpstreamKey(provider)
+	if !ok {
+		return "", fmt.Errorf("%w %s", ErrProviderNotAllowed, provider)
+	}
+	return upstream, nil
+}
+
+// checkKeyFormat rejects keys without the iw: prefix
+func checkKeyFormat(key string) error {
+	if !strings.HasPrefix(key, KeyPrefix) {
+		return fmt.Errorf("invalid key format: must start with %s", KeyPrefix)
+	}
+	return nil
+}
+
+// newAPIKey builds a freshly generated, enabled key record stored under the
+// key's digest, with the plaintext key set in Key for the caller
+func newAPIKey(provider, actualKey, description string, dailyCostLimit int64, tags map[string]string) (*APIKey, error) {
+	newKey, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &APIKey{
+		PK:             HashKey(newKey),
+		DisplayPrefix:  DisplayPrefix(newKey),
+		Key:            newKey,
+		Provider:       provider,
+		ActualKey:      actualKey,
+		DailyCostLimit: dailyCostLimit,
+		Description:    description,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Enabled:        true,
+		Tags:           tags,
+	}, nil
+}
+
+// applyUpdates applies the UpdateKey field map to a key record. Field names
+// and value types match those accepted by the DynamoDB store.
+func applyUpdates(k *APIKey, updates map[string]interface{}, now time.Time) error {
+	for field, value := range updates {
+		var ok bool
+		switch field {
+		case "actual_key":
+			k.ActualKey, ok = value.(string)
+		case "daily_cost_limit":
+			k.DailyCostLimit, ok = value.(int64)
+		case "description":
+			k.Description, ok = value.(string)
+		case "enabled":
+			k.Enabled, ok = value.(bool)
+		case "expires_at":
+			var t time.Time
+			if t, ok = value.(time.Time); ok {
+				k.ExpiresAt = &t
+			}
+		case "tags":
+			k.Tags, ok = value.(map[string]string)
+		case "provider_keys":
+			k.ProviderKeys, ok = value.(map[string]string)
+		case "rotated_from":
+			k.RotatedFrom, ok = value.(string)
+		case "rotated_to":
+			k.RotatedTo, ok = value.(string)
+		case "team_id":
+			k.TeamID, ok = value.(string)
+		case "project_id":
+			k.ProjectID, ok = value.(string)
+		case "scope":
+			k.Scope, ok = value.(*KeyScope)
+		default:
+			ok = true // unknown fields are ignored, as in the DynamoDB store
+		}
+		if !ok {
+			return fmt.Errorf("invalid value for %s: %T", field, value)
+		}
+	}
+	k.UpdatedAt = now
+	return nil
+}
+
+// loggableUpdates returns updates with provider keys masked for logging
+func loggableUpdates(updates map[string]interface{}) map[string]interface{} {
+	actualKey, hasActual := updates["actual_key"].(string)
+	providerKeys, hasProviderKeys := updates["provider_keys"].(map[string]string)
+	if !hasActual && !hasProviderKeys {
+		return updates
+	}
+	masked := make(map[string]interface{}, len(updates))
+	for k, v := range updates {
+		masked[k] = v
+	}
+	if hasActual {
+		masked["actual_key"] = MaskSecret(actualKey)
+	}
+	if hasProviderKeys {
+		maskedKeys := make(map[string]string, len(providerKeys))
+		for p, v := range providerKeys {
+			maskedKeys[p] = MaskSecret(v)
+		}
+		masked["provider_keys"] = maskedKeys
+	}
+	return masked
+}
+
+// BackendConfigFromYAML builds a BackendConfig from the api_key_management config section
+func BackendConfigFromYAML(km config.APIKeyManagementConfig, logger *slog.Logger) BackendConfig {
+	cfg := BackendConfig{
+		Backend:        km.Backend,
+		TableName:      km.TableName,
+		Region:         km.Region,
+		Path:           km.Path,
+		ReloadInterval: time.Duration(km.ReloadIntervalSeconds) * time.Second,
+		Logger:         logger,
+	}
+	if km.Encryption.Enabled {
+		cfg.Encryption = &EncryptionConfig{
+			Provider:               km.Encryption.Provider,
+			MasterKeyFile:          km.Encryption.MasterKeyFile,
+			MasterKeyEnv:           km.Encryption.MasterKeyEnv,
+			PreviousMasterKeyFiles: km.Encryption.PreviousMasterKeyFiles,
+			KMSKeyID:               km.Encryption.KMSKeyID,
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,268 @@
+package apikeys
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeyGetter is the lookup used by CachedStore; *Store implements it.
+type KeyGetter interface {
+	GetKey(ctx context.Context, key string) (*APIKey, error)
+}
+
+// CacheConfig holds configuration for the API key cache
+type CacheConfig struct {
+	MaxEntries  int           // Maximum cached keys before LRU eviction (default: 10000)
+	TTL         time.Duration // How long valid keys are served from cache (default: 60s)
+	NegativeTTL time.Duration // How long missing/disabled/expired keys are cached (default: 10s)
+	StaleGrace  time.Duration // How long past TTL a valid key may be served if the store is unreachable (default: 5m)
+	Logger      *slog.Logger
+}
+
+// CacheStats holds cache counters reported on /health
+type CacheStats struct {
+	Entries       int    `json:"entries"`
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	NegativeHits  uint64 `json:"negative_hits"`
+	StaleServed   uint64 `json:"stale_served"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+}
+
+type cacheEntry struct {
+	key       string  // KeyID of the client key, so plaintext keys are not held as map keys
+	apiKey    *APIKey // nil for negative entries
+	err       error   // reason for negative entries
+	fetchedAt time.Time
+	expiresAt time.Time
+}
+
+// keyFetch is a backend lookup in flight, shared by concurrent misses
+type keyFetch struct {
+	done   chan struct{}
+	apiKey *APIKey
+	err    error
+}
+
+// CachedStore is an LRU cache with TTL in front of a KeyGetter. It caches
+// valid keys for TTL and invalid keys (missing, disabled, expired) for
+// NegativeTTL. When the underlying store fails, a previously valid entry is
+// served for up to StaleGrace past its expiry. Concurrent misses for a key
+// share one backend lookup.
+type CachedStore struct {
+	backend KeyGetter
+	cfg     CacheConfig
+	logger  *slog.Logger
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	fetches map[string]*keyFetch
+	// generations counts invalidations of keys with a fetch in flight, so a
+	// fetch that raced an invalidation does not cache what it read
+	generations map[string]uint64
+
+	hits, misses, negativeHits, staleServed, evictions, invalidations atomic.Uint64
+
+	// now is overridable in tests
+	now func() time.Time
+}
+
+// NewCachedStore wraps backend with an in-process cache
+func NewCachedStore(backend KeyGetter, cfg CacheConfig) *CachedStore {
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = 10000
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 60 * time.Second
+	}
+	if cfg.NegativeTTL <= 0 {
+		cfg.NegativeTTL = 10 * time.Second
+	}
+	if cfg.StaleGrace < 0 {
+		cfg.StaleGrace = 0
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &CachedStore{
+		backend:     backend,
+		cfg:         cfg,
+		logger:      logger,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		fetches:     make(map[string]*keyFetch),
+		generations: make(map[string]uint64),
+		now:         time.Now,
+	}
+}
+
+// GetKey returns the key from cache or the backend
+func (c *CachedStore) GetKey(ctx context.Context, key string) (*APIKey, error) {
+	now := c.now()
+	id := KeyID(key)
+
+	c.mu.Lock()
+	entry := c.getLocked(id)
+	var stale *cacheEntry
+	if entry != nil {
+		if now.Before(entry.expiresAt) {
+			c.mu.Unlock()
+			if entry.apiKey == nil {
+				c.negativeHits.Add(1)
+				return nil, entry.err
+			}
+			c.hits.Add(1)
+			// The key may have expired since it was cached
+			if err := entry.apiKey.Usable(now); err != nil {
+				return nil, err
+			}
+			return entry.apiKey, nil
+		}
+		if entry.apiKey != nil {
+			stale = entry
+		}
+	}
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	apiKey, err := c.fetch(ctx, id, key, now)
+	if err == nil || isKeyStateError(err) {
+		return apiKey, err
+	}
+
+	// Store unreachable: fall back to a stale valid entry within the grace period
+	if stale != nil && now.Before(stale.expiresAt.Add(c.cfg.StaleGrace)) {
+		if usableErr := stale.apiKey.Usable(now); usableErr == nil {
+			c.staleServed.Add(1)
+			c.logger.Warn("🔑 API Key Cache: Serving stale entry, store unavailable",
+				"key_prefix", keyPrefix(key), "age", now.Sub(stale.fetchedAt).String(), "error", err)
+			return stale.apiKey, nil
+		}
+	}
+	return nil, err
+}
+
+// ValidateAndGetActualKey validates an API key and returns the actual provider key
+func (c *CachedStore) ValidateAndGetActualKey(ctx context.Context, key string) (string, string, error) {
+	return validateAndGetActualKey(ctx, c, key)
+}
+
+// ValidateAndGetProviderKey validates an API key and returns its upstream key for provider
+func (c *CachedStore) ValidateAndGetProviderKey(ctx context.Context, key, provider string) (string, error) {
+	return validateAndGetProviderKey(ctx, c, key, provider)
+}
+
+// Invalidate drops a key, given as an iw: key or record ID, from the cache so
+// the next lookup hits the store
+func (c *CachedStore) Invalidate(key string) {
+	id := KeyID(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[id]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, id)
+	}
+	if _, ok := c.fetches[id]; ok {
+		c.generations[id]++
+	}
+	c.invalidations.Add(1)
+}
+
+// Stats returns a snapshot of cache counters
+func (c *CachedStore) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Entries:       entries,
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		NegativeHits:  c.negativeHits.Load(),
+		StaleServed:   c.staleServed.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+	}
+}
+
+func (c *CachedStore) getLocked(key string) *cacheEntry {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry)
+}
+
+// fetch looks key up in the backend and caches the result, joining a lookup
+// already in flight for the same key. A result read across an Invalidate is
+// returned but not cached, so it cannot reinstate the record being dropped.
+func (c *CachedStore) fetch(ctx context.Context, id, key string, now time.Time) (*APIKey, error) {
+	c.mu.Lock()
+	if f, ok := c.fetches[id]; ok {
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.apiKey, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	f := &keyFetch{done: make(chan struct{})}
+	c.fetches[id] = f
+	generation := c.generations[id]
+	c.mu.Unlock()
+
+	// Waiters share the result, so one caller going away must not fail it
+	f.apiKey, f.err = c.backend.GetKey(context.WithoutCancel(ctx), key)
+
+	c.mu.Lock()
+	if c.generations[id] == generation {
+		switch {
+		case f.err == nil:
+			c.putLocked(&cacheEntry{key: id, apiKey: f.apiKey, fetchedAt: now, expiresAt: now.Add(c.cfg.TTL)})
+		case isKeyStateError(f.err):
+			c.putLocked(&cacheEntry{key: id, err: f.err, fetchedAt: now, expiresAt: now.Add(c.cfg.NegativeTTL)})
+		}
+	}
+	delete(c.fetches, id)
+	delete(c.generations, id)
+	c.mu.Unlock()
+	close(f.done)
+	return f.apiKey, f.err
+}
+
+func (c *CachedStore) putLocked(entry *cacheEntry) {
+	if el, ok := c.entries[entry.key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.cfg.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.evictions.Add(1)
+	}
+}
+
+// isKeyStateError reports whether err describes the key itself rather than a store failure
+func isKeyStateError(err error) bool {
+	return errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrKeyExpired) || errors.Is(err, ErrKeyDisabled)
+}
+
+// keyPrefix returns a short, loggable prefix of an iw: key
+func keyPrefix(key string) string {
+	if len(key) > len(KeyPrefix)+6 {
+		return key[:len(KeyPrefix)+6]
+	}
+	return key
+}
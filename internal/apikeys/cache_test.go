@@ -0,0 +1,241 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeGetter struct {
+	keys  map[string]*APIKey
+	err   error
+	calls int
+}
+
+func (f *fakeGetter) GetKey(ctx context.Context, key string) (*APIKey, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	k, ok := f.keys[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if err := k.Usable(time.Now()); err != nil {
+		return nil, err
+	}
+	copied := *k
+	return &copied, nil
+}
+
+func newTestCache(backend KeyGetter, cfg CacheConfig) (*CachedStore, *time.Time) {
+	c := NewCachedStore(backend, cfg)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestCachedStoreHitAndExpiry(t *testing.T) {
+	backend := &fakeGetter{keys: map[string]*APIKey{
+		"iw:abc": {PK: "iw:abc", ActualKey: "sk-real", Provider: "openai", Enabled: true},
+	}}
+	c, now := newTestCache(backend, CacheConfig{TTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		actual, provider, err := c.ValidateAndGetActualKey(context.Background(), "iw:abc")
+		if err != nil || actual != "sk-real" || provider != "openai" {
+			t.Fatalf("lookup %d: got %q %q %v", i, actual, provider, err)
+		}
+	}
+	if backend.calls != 1 {
+		t.Fatalf("expected 1 backend call, got %d", backend.calls)
+	}
+
+	*now = now.Add(2 * time.Minute)
+	if _, err := c.GetKey(context.Background(), "iw:abc"); err != nil {
+		t.Fatalf("unexpected error after TTL: %v", err)
+	}
+	if backend.calls != 2 {
+		t.Fatalf("expected refetch after TTL, got %d calls", backend.calls)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCachedStoreNegativeCaching(t *testing.T) {
+	backend := &fakeGetter{keys: map[string]*APIKey{
+		"iw:off": {PK: "iw:off", ActualKey: "sk-off", Enabled: false},
+	}}
+	c, now := newTestCache(backend, CacheConfig{NegativeTTL: 10 * time.Second})
+
+	for _, key := range []string{"iw:missing", "iw:off"} {
+		for i := 0; i < 2; i++ {
+			if _, err := c.GetKey(context.Background(), key); err == nil {
+				t.Fatalf("expected error for %s", key)
+			}
+		}
+	}
+	if backend.calls != 2 {
+		t.Fatalf("expected negative entries to be cached, got %d backend calls", backend.calls)
+	}
+	if _, err := c.GetKey(context.Background(), "iw:off"); !errors.Is(err, ErrKeyDisabled) {
+		t.Fatalf("expected ErrKeyDisabled, got %v", err)
+	}
+
+	*now = now.Add(11 * time.Second)
+	c.GetKey(context.Background(), "iw:missing")
+	if backend.calls != 3 {
+		t.Fatalf("expected refetch after negative TTL, got %d calls", backend.calls)
+	}
+	if c.Stats().NegativeHits != 3 {
+		t.Fatalf("expected 3 negative hits, got %d", c.Stats().NegativeHits)
+	}
+}
+
+func TestCachedStoreServesStaleWhenStoreUnavailable(t *testing.T) {
+	backend := &fakeGetter{keys: map[string]*APIKey{
+		"iw:abc": {PK: "iw:abc", ActualKey: "sk-real", Enabled: true},
+	}}
+	c, now := newTestCache(backend, CacheConfig{TTL: time.Minute, StaleGrace: 5 * time.Minute})
+
+	if _, err := c.GetKey(context.Background(), "iw:abc"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	backend.err = errors.New("dynamodb unreachable")
+	*now = now.Add(3 * time.Minute)
+	k, err := c.GetKey(context.Background(), "iw:abc")
+	if err != nil || k.ActualKey != "sk-real" {
+		t.Fatalf("expected stale entry, got %v %v", k, err)
+	}
+	if c.Stats().StaleServed != 1 {
+		t.Fatalf("expected stale_served=1, got %d", c.Stats().StaleServed)
+	}
+
+	// Beyond TTL + grace the store error surfaces
+	*now = now.Add(5 * time.Minute)
+	if _, err := c.GetKey(context.Background(), "iw:abc"); err == nil {
+		t.Fatal("expected error beyond stale grace period")
+	}
+}
+
+func TestCachedStoreInvalidateAndEviction(t *testing.T) {
+	backend := &fakeGetter{keys: map[string]*APIKey{
+		"iw:a": {PK: "iw:a", Enabled: true},
+		"iw:b": {PK: "iw:b", Enabled: true},
+		"iw:c": {PK: "iw:c", Enabled: true},
+	}}
+	c, _ := newTestCache(backend, CacheConfig{MaxEntries: 2})
+
+	c.GetKey(context.Background(), "iw:a")
+	c.GetKey(context.Background(), "iw:b")
+	c.GetKey(context.Background(), "iw:a") // a is now most recently used
+	c.GetKey(context.Background(), "iw:c") // evicts b
+
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Fatalf("unexpected stats after eviction: %+v", stats)
+	}
+
+	// Disabling a key and invalidating it takes effect immediately
+	backend.keys["iw:a"].Enabled = false
+	c.Invalidate(HashKey("iw:a")) // operators only know the record ID
+	if _, err := c.GetKey(context.Background(), "iw:a"); !errors.Is(err, ErrKeyDisabled) {
+		t.Fatalf("expected ErrKeyDisabled after invalidation, got %v", err)
+	}
+}
+
+func TestCachedStorePassthrough(t *testing.T) {
+	backend := &fakeGetter{}
+	c, _ := newTestCache(backend, CacheConfig{})
+
+	actual, provider, err := c.ValidateAndGetActualKey(context.Background(), "sk-direct")
+	if err != nil || actual != "sk-direct" || provider != "" {
+		t.Fatalf("expected passthrough, got %q %q %v", actual, provider, err)
+	}
+	if backend.calls != 0 {
+		t.Fatalf("expected no backend calls, got %d", backend.calls)
+	}
+}
+
+// blockingGetter holds lookups until release is closed
+type blockingGetter struct {
+	fakeGetter
+	mu      sync.Mutex
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingGetter) GetKey(ctx context.Context, key string) (*APIKey, error) {
+	b.mu.Lock()
+	b.calls++
+	b.mu.Unlock()
+	b.started <- struct{}{}
+	<-b.release
+	k := *b.keys[key]
+	return &k, nil
+}
+
+func TestCachedStoreSharesConcurrentMisses(t *testing.T) {
+	backend := &blockingGetter{
+		fakeGetter: fakeGetter{keys: map[string]*APIKey{"iw:a": {PK: "iw:a", Enabled: true}}},
+		started:    make(chan struct{}, 10),
+		release:    make(chan struct{}),
+	}
+	c, _ := newTestCache(backend, CacheConfig{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.GetKey(context.Background(), "iw:a"); err != nil {
+				t.Errorf("GetKey: %v", err)
+			}
+		}()
+	}
+	<-backend.started
+	// Give the other callers time to join the lookup in flight
+	time.Sleep(20 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+
+	if backend.calls != 1 {
+		t.Fatalf("expected one backend lookup for concurrent misses, got %d", backend.calls)
+	}
+}
+
+func TestCachedStoreInvalidateDuringFetch(t *testing.T) {
+	backend := &blockingGetter{
+		fakeGetter: fakeGetter{keys: map[string]*APIKey{"iw:a": {PK: "iw:a", Enabled: true}}},
+		started:    make(chan struct{}, 10),
+		release:    make(chan struct{}),
+	}
+	c, _ := newTestCache(backend, CacheConfig{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.GetKey(context.Background(), "iw:a")
+	}()
+	<-backend.started
+	// The key changes while the lookup that read the old record is in flight
+	c.Invalidate("iw:a")
+	close(backend.release)
+	<-done
+
+	if _, err := c.GetKey(context.Background(), "iw:a"); err != nil {
+		t.Fatalf("GetKey: %v", err)
+	}
+	<-backend.started
+	if backend.calls != 2 {
+		t.Fatalf("expected the read racing the invalidation not to be cached, got %d lookups", backend.calls)
+	}
+	if stats := c.Stats(); stats.Entries != 1 {
+		t.Fatalf("expected the fresh read to be cached: %+v", stats)
+	}
+}
@@ -0,0 +1,552 @@
+package apikeys
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/config"
+	"gopkg.in/yaml.v3"
+	_ "modernc.org/sqlite"
+)
+
+// backendFactory creates a store pre-populated with seed keys
+type backendFactory struct {
+	name     string
+	readOnly bool
+	new      func(t *testing.T, seed []*APIKey) KeyStore
+}
+
+func conformanceBackends() []backendFactory {
+	return []backendFactory{
+		{name: BackendMemory, new: func(t *testing.T, seed []*APIKey) KeyStore {
+			return NewMemoryStore(seed...)
+		}},
+		{name: BackendFile, readOnly: true, new: newSeededFileStore},
+		{name: BackendSQLite, new: newSeededSQLiteStore},
+	}
+}
+
+func newSeededFileStore(t *testing.T, seed []*APIKey) KeyStore {
+	var f keyFile
+	for _, k := range seed {
+		enabled := k.Enabled
+		// Hashed records are written by ID, legacy ones as plaintext keys
+		fk := fileKey{Key: k.PK}
+		if IsKeyID(k.PK) {
+			fk = fileKey{KeyID: k.PK, DisplayPrefix: k.DisplayPrefix}
+		}
+		fk.Provider, fk.ActualKey, fk.ProviderKeys, fk.Description = k.Provider, k.ActualKey, k.ProviderKeys, k.Description
+		fk.DailyCostLimit, fk.Enabled, fk.CreatedAt = k.DailyCostLimit, &enabled, k.CreatedAt
+		fk.ExpiresAt, fk.Tags, fk.Scope = k.ExpiresAt, k.Tags, k.Scope
+		f.Keys = append(f.Keys, fk)
+	}
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		t.Fatalf("marshal key file: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.yml")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write key file: %v", err)
+	}
+	s, err := NewFileStore(FileStoreConfig{Path: path, ReloadInterval: -1})
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	return s
+}
+
+func newSeededSQLiteStore(t *testing.T, seed []*APIKey) KeyStore {
+	if !slices.Contains(sql.Drivers(), sqliteDriver) {
+		t.Fatal("no sqlite database/sql driver linked into the test binary")
+	}
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "keys.db"), nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	for _, k := range seed {
+		if err := s.insert(context.Background(), k); err != nil {
+			t.Fatalf("seed %s: %v", k.PK, err)
+		}
+	}
+	return s
+}
+
+func conformanceSeed() []*APIKey {
+	created := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	past := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	return []*APIKey{
+		{PK: HashKey("iw:active"), DisplayPrefix: "iw:active", Provider: "openai", ActualKey: "sk-active", ProviderKeys: map[string]string{"anthropic": "sk-ant-active"}, Description: "active", DailyCostLimit: 100, CreatedAt: created, UpdatedAt: created, Enabled: true, Tags: map[string]string{"team": "eng"},
+			Scope: &KeyScope{AllowedModels: []string{"gpt-4o*"}, MaxTokens: 256}},
+		{PK: "iw:disabled", Provider: "openai", ActualKey: "sk-disabled", CreatedAt: created.Add(time.Second), UpdatedAt: created, Enabled: false},
+		{PK: "iw:expired", Provider: "anthropic", ActualKey: "sk-expired", CreatedAt: created.Add(2 * time.Second), UpdatedAt: created, ExpiresAt: &past, Enabled: true},
+	}
+}
+
+func TestKeyStoreConformance(t *testing.T) {
+	for _, backend := range conformanceBackends() {
+		t.Run(backend.name, func(t *testing.T) {
+			t.Run("GetKey", func(t *testing.T) {
+				s := backend.new(t, conformanceSeed())
+				ctx := context.Background()
+
+				k, err := s.GetKey(ctx, "iw:active")
+				if err != nil {
+					t.Fatalf("GetKey(active): %v", err)
+				}
+				if k.ActualKey != "sk-active" || k.Provider != "openai" || k.DailyCostLimit != 100 || k.Tags["team"] != "eng" {
+					t.Fatalf("unexpected key: %+v", k)
+				}
+				if k.Scope == nil || k.Scope.MaxTokens != 256 || !slices.Equal(k.Scope.AllowedModels, []string{"gpt-4o*"}) {
+					t.Fatalf("unexpected scope: %+v", k.Scope)
+				}
+
+				for key, want := range map[string]error{
+					"iw:missing":  ErrKeyNotFound,
+					"iw:disabled": ErrKeyDisabled,
+					"iw:expired":  ErrKeyExpired,
+				} {
+					if _, err := s.GetKey(ctx, key); !errors.Is(err, want) {
+						t.Errorf("GetKey(%s): expected %v, got %v", key, want, err)
+					}
+				}
+				if _, err := s.GetKey(ctx, "sk-raw"); err == nil {
+					t.Error("expected error for key without iw: prefix")
+				}
+			})
+
+			t.Run("ValidateAndGetActualKey", func(t *testing.T) {
+				s := backend.new(t, conformanceSeed())
+				ctx := context.Background()
+
+				actual, provider, err := s.ValidateAndGetActualKey(ctx, "iw:active")
+				if err != nil || actual != "sk-active" || provider != "openai" {
+					t.Fatalf("got %q %q %v", actual, provider, err)
+				}
+				actual, provider, err = s.ValidateAndGetActualKey(ctx, "sk-passthrough")
+				if err != nil || actual != "sk-passthrough" || provider != "" {
+					t.Fatalf("passthrough: got %q %q %v", actual, provider, err)
+				}
+				if _, _, err := s.ValidateAndGetActualKey(ctx, "iw:disabled"); !errors.Is(err, ErrKeyDisabled) {
+					t.Fatalf("expected ErrKeyDisabled, got %v", err)
+				}
+			})
+
+			t.Run("ValidateAndGetProviderKey", func(t *testing.T) {
+				s := backend.new(t, conformanceSeed())
+				ctx := context.Background()
+
+				for provider, want := range map[string]string{"openai": "sk-active", "anthropic": "sk-ant-active"} {
+					if got, err := s.ValidateAndGetProviderKey(ctx, "iw:active", provider); err != nil || got != want {
+						t.Errorf("ValidateAndGetProviderKey(%s) = %q, %v; want %q", provider, got, err, want)
+					}
+				}
+				if _, err := s.ValidateAndGetProviderKey(ctx, "iw:active", "gemini"); !errors.Is(err, ErrProviderNotAllowed) {
+					t.Errorf("expected ErrProviderNotAllowed, got %v", err)
+				}
+				if got, err := s.ValidateAndGetProviderKey(ctx, "sk-passthrough", "gemini"); err != nil || got != "sk-passthrough" {
+					t.Errorf("passthrough: got %q %v", got, err)
+				}
+				if _, err := s.ValidateAndGetProviderKey(ctx, "iw:disabled", "openai"); !errors.Is(err, ErrKeyDisabled) {
+					t.Errorf("expected ErrKeyDisabled, got %v", err)
+				}
+			})
+
+			t.Run("ListKeys", func(t *testing.T) {
+				s := backend.new(t, conformanceSeed())
+				ctx := context.Background()
+
+				all, err := s.ListKeys(ctx, "")
+				if err != nil {
+					t.Fatalf("ListKeys: %v", err)
+				}
+				if len(all) != 3 {
+					t.Fatalf("expected 3 keys, got %d", len(all))
+				}
+				openai, err := s.ListKeys(ctx, "openai")
+				if err != nil {
+					t.Fatalf("ListKeys(openai): %v", err)
+				}
+				if len(openai) != 2 {
+					t.Fatalf("expected 2 openai keys, got %d", len(openai))
+				}
+			})
+
+			t.Run("Mutations", func(t *testing.T) {
+				s := backend.new(t, conformanceSeed())
+				ctx := context.Background()
+
+				created, err := s.CreateKey(ctx, "anthropic", "sk-new", "new key", 500, map[string]string{"env": "dev"})
+				if backend.readOnly {
+					if !errors.Is(err, ErrReadOnly) {
+						t.Fatalf("CreateKey: expected ErrReadOnly, got %v", err)
+					}
+					if err := s.UpdateKey(ctx, "iw:active", map[string]interface{}{"enabled": false}); !errors.Is(err, ErrReadOnly) {
+						t.Fatalf("UpdateKey: expected ErrReadOnly, got %v", err)
+					}
+					if err := s.DeleteKey(ctx, "iw:active"); !errors.Is(err, ErrReadOnly) {
+						t.Fatalf("DeleteKey: expected ErrReadOnly, got %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("CreateKey: %v", err)
+				}
+				if created.PK != HashKey(created.Key) || created.DisplayPrefix != DisplayPrefix(created.Key) {
+					t.Fatalf("expected record stored under digest, got PK=%s prefix=%s", created.PK, created.DisplayPrefix)
+				}
+				got, err := s.GetKey(ctx, created.Key)
+				if err != nil || got.ActualKey != "sk-new" || got.Tags["env"] != "dev" || !got.Enabled {
+					t.Fatalf("GetKey(created): %+v %v", got, err)
+				}
+				if got.Key != "" {
+					t.Fatal("plaintext key must not be stored")
+				}
+				if _, err := s.GetKey(ctx, created.PK); err == nil {
+					t.Fatal("the stored digest must not be usable as a key")
+				}
+
+				if err := s.UpdateKey(ctx, created.PK, map[string]interface{}{
+					"enabled":          false,
+					"description":      "updated",
+					"daily_cost_limit": int64(900),
+				}); err != nil {
+					t.Fatalf("UpdateKey: %v", err)
+				}
+				if _, err := s.GetKey(ctx, created.Key); !errors.Is(err, ErrKeyDisabled) {
+					t.Fatalf("expected disabled after update, got %v", err)
+				}
+				if err := s.UpdateKey(ctx, created.PK, map[string]interface{}{"enabled": true}); err != nil {
+					t.Fatalf("UpdateKey(enable): %v", err)
+				}
+				got, err = s.GetKey(ctx, created.Key)
+				if err != nil || got.Description != "updated" || got.DailyCostLimit != 900 {
+					t.Fatalf("GetKey(updated): %+v %v", got, err)
+				}
+				if got.Scope != nil {
+					t.Fatalf("new keys are unscoped, got %+v", got.Scope)
+				}
+
+				if err := s.UpdateKey(ctx, created.PK, map[string]interface{}{"provider_keys": map[string]string{"openai": "sk-oai-new"}}); err != nil {
+					t.Fatalf("UpdateKey(provider_keys): %v", err)
+				}
+				if upstream, err := s.ValidateAndGetProviderKey(ctx, created.Key, "openai"); err != nil || upstream != "sk-oai-new" {
+					t.Fatalf("ValidateAndGetProviderKey after update: %q %v", upstream, err)
+				}
+
+				scope := &KeyScope{AllowedEndpoints: []string{"/v1/chat/completions"}, AllowedCIDRs: []string{"10.0.0.0/8"}}
+				if err := s.UpdateKey(ctx, created.PK, map[string]interface{}{"scope": scope}); err != nil {
+					t.Fatalf("UpdateKey(scope): %v", err)
+				}
+				if got, _ = s.GetKey(ctx, created.Key); got.Scope == nil || !slices.Equal(got.Scope.AllowedCIDRs, scope.AllowedCIDRs) {
+					t.Fatalf("scope not stored: %+v", got.Scope)
+				}
+				if err := s.UpdateKey(ctx, created.PK, map[string]interface{}{"scope": (*KeyScope)(nil)}); err != nil {
+					t.Fatalf("UpdateKey(clear scope): %v", err)
+				}
+				if got, _ = s.GetKey(ctx, created.Key); !got.Scope.IsEmpty() {
+					t.Fatalf("scope not cleared: %+v", got.Scope)
+				}
+				if err := s.UpdateKey(ctx, "iw:missing", map[string]interface{}{"enabled": true}); err == nil {
+					t.Fatal("UpdateKey(missing): expected error")
+				}
+
+				if err := s.DeleteKey(ctx, created.PK); err != nil {
+					t.Fatalf("DeleteKey: %v", err)
+				}
+				if _, err := s.GetKey(ctx, created.Key); !errors.Is(err, ErrKeyNotFound) {
+					t.Fatalf("expected ErrKeyNotFound after delete, got %v", err)
+				}
+				if err := s.DeleteKey(ctx, created.PK); err == nil {
+					t.Fatal("DeleteKey(missing): expected error")
+				}
+			})
+
+			t.Run("Rotation", func(t *testing.T) {
+				s := backend.new(t, conformanceSeed())
+				ctx := context.Background()
+				oldID := HashKey("iw:active")
+
+				successor, err := RotateKey(ctx, s, oldID, time.Hour)
+				if backend.readOnly {
+					if !errors.Is(err, ErrReadOnly) {
+						t.Fatalf("RotateKey: expected ErrReadOnly, got %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("RotateKey: %v", err)
+				}
+				got, err := s.GetKey(ctx, successor.Key)
+				if err != nil || got.RotatedFrom != oldID || got.DailyCostLimit != 100 || got.Tags["team"] != "eng" ||
+					got.ProviderKeys["anthropic"] != "sk-ant-active" || got.Scope == nil || got.Scope.MaxTokens != 256 {
+					t.Fatalf("successor did not inherit the old key: %+v %v", got, err)
+				}
+
+				// The old key keeps working until the grace deadline
+				old, err := s.GetKey(ctx, "iw:active")
+				if err != nil || old.RotatedTo != successor.PK || old.ExpiresAt == nil || time.Until(*old.ExpiresAt) > time.Hour {
+					t.Fatalf("old key after rotation: %+v %v", old, err)
+				}
+				if _, err := RotateKey(ctx, s, oldID, time.Hour); !errors.Is(err, ErrAlreadyRotated) {
+					t.Fatalf("expected ErrAlreadyRotated, got %v", err)
+				}
+
+				// Every key in the chain keeps the first key's rate limits
+				next, err := RotateKey(ctx, s, successor.PK, time.Hour)
+				if err != nil {
+					t.Fatalf("RotateKey(successor): %v", err)
+				}
+				for _, k := range []*APIKey{old, got, next} {
+					if k.RateLimitID() != oldID {
+						t.Fatalf("%s: rate limit ID %q, want %q", k.PK, k.RateLimitID(), oldID)
+					}
+				}
+				if stored, err := s.LookupKey(ctx, next.PK); err != nil || stored.LineageID != oldID || stored.RotatedFrom != successor.PK {
+					t.Fatalf("second successor: %+v %v", stored, err)
+				}
+
+				// Rotating the upstream key keeps the virtual key
+				if err := RotateUpstreamKey(ctx, s, successor.PK, "", "sk-rotated"); err != nil {
+					t.Fatalf("RotateUpstreamKey: %v", err)
+				}
+				if err := RotateUpstreamKey(ctx, s, successor.PK, "anthropic", "sk-ant-rotated"); err != nil {
+					t.Fatalf("RotateUpstreamKey(anthropic): %v", err)
+				}
+				for provider, want := range map[string]string{"openai": "sk-rotated", "anthropic": "sk-ant-rotated"} {
+					if upstream, err := s.ValidateAndGetProviderKey(ctx, successor.Key, provider); err != nil || upstream != want {
+						t.Fatalf("%s upstream after rotation: %q %v", provider, upstream, err)
+					}
+				}
+				if err := RotateUpstreamKey(ctx, s, successor.PK, "gemini", "AIza"); !errors.Is(err, ErrProviderNotAllowed) {
+					t.Fatalf("expected ErrProviderNotAllowed, got %v", err)
+				}
+			})
+
+			t.Run("Usage", func(t *testing.T) {
+				s := backend.new(t, conformanceSeed())
+				ctx := context.Background()
+				keyID := HashKey("iw:active")
+				first := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+				before, _ := s.LookupKey(ctx, keyID)
+
+				err := s.RecordUsage(ctx, keyID, KeyUsage{Requests: 2, Tokens: 150, Cost: 0.25, LastUsedAt: first, LastUsedIP: "10.0.0.1"})
+				if backend.readOnly {
+					if !errors.Is(err, ErrReadOnly) {
+						t.Fatalf("RecordUsage: expected ErrReadOnly, got %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("RecordUsage: %v", err)
+				}
+				if err := s.RecordUsage(ctx, keyID, KeyUsage{Requests: 1, Tokens: 50, Cost: 0.5, LastUsedAt: first.Add(time.Second), LastUsedIP: "10.0.0.2"}); err != nil {
+					t.Fatalf("RecordUsage(second): %v", err)
+				}
+				k, err := s.LookupKey(ctx, keyID)
+				if err != nil || k.RequestCount != 3 || k.TokenCount != 200 || k.TotalCost != 0.75 ||
+					k.LastUsedAt == nil || !k.LastUsedAt.Equal(first.Add(time.Second)) || k.LastUsedIP != "10.0.0.2" {
+					t.Fatalf("key after RecordUsage: %+v %v", k, err)
+				}
+				if !k.UpdatedAt.Equal(before.UpdatedAt) {
+					t.Fatalf("RecordUsage changed updated_at: %v", k.UpdatedAt)
+				}
+				if err := s.RecordUsage(ctx, HashKey("iw:missing"), KeyUsage{Requests: 1, LastUsedAt: first}); !errors.Is(err, ErrKeyNotFound) {
+					t.Fatalf("RecordUsage(missing): expected ErrKeyNotFound, got %v", err)
+				}
+
+				// A successor starts with fresh usage
+				successor, err := RotateKey(ctx, s, keyID, time.Hour)
+				if err != nil {
+					t.Fatalf("RotateKey: %v", err)
+				}
+				if got, err := s.LookupKey(ctx, successor.PK); err != nil || got.RequestCount != 0 || got.LastUsedAt != nil {
+					t.Fatalf("successor inherited usage: %+v %v", got, err)
+				}
+			})
+
+			t.Run("Teams", func(t *testing.T) {
+				s := backend.new(t, conformanceSeed())
+				ctx := context.Background()
+				keyID := HashKey("iw:active")
+
+				err := SaveTeam(ctx, s, &Team{ID: "eng", Name: "Engineering", Limits: &config.LimitsConfig{RequestsPerMinute: 60, CostPerDay: 25}})
+				if backend.readOnly {
+					if !errors.Is(err, ErrReadOnly) {
+						t.Fatalf("SaveTeam: expected ErrReadOnly, got %v", err)
+					}
+					if _, err := s.GetTeam(ctx, "eng"); !errors.Is(err, ErrTeamNotFound) {
+						t.Fatalf("GetTeam(missing): expected ErrTeamNotFound, got %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("SaveTeam(eng): %v", err)
+				}
+				if err := SaveTeam(ctx, s, &Team{ID: "eng-ci", ParentID: "eng"}); err != nil {
+					t.Fatalf("SaveTeam(eng-ci): %v", err)
+				}
+
+				team, err := s.GetTeam(ctx, "eng")
+				if err != nil || team.Name != "Engineering" || team.Limits == nil || team.Limits.CostPerDay != 25 || team.CreatedAt.IsZero() {
+					t.Fatalf("GetTeam(eng): %+v %v", team, err)
+				}
+				teams, err := s.ListTeams(ctx)
+				if err != nil || len(teams) != 2 || teams[0].ID != "eng" || teams[1].ParentID != "eng" {
+					t.Fatalf("ListTeams: %+v %v", teams, err)
+				}
+
+				if err := AssignTeam(ctx, s, keyID, "eng", "eng-ci"); err != nil {
+					t.Fatalf("AssignTeam: %v", err)
+				}
+				k, err := s.GetKey(ctx, "iw:active")
+				if err != nil || k.TeamID != "eng" || k.ProjectID != "eng-ci" {
+					t.Fatalf("key after AssignTeam: %+v %v", k, err)
+				}
+				if err := RemoveTeam(ctx, s, "eng-ci"); !errors.Is(err, ErrTeamInUse) {
+					t.Fatalf("RemoveTeam(in use): expected ErrTeamInUse, got %v", err)
+				}
+
+				if err := AssignTeam(ctx, s, keyID, "", ""); err != nil {
+					t.Fatalf("AssignTeam(clear): %v", err)
+				}
+				if err := RemoveTeam(ctx, s, "eng-ci"); err != nil {
+					t.Fatalf("RemoveTeam(eng-ci): %v", err)
+				}
+				if err := RemoveTeam(ctx, s, "eng"); err != nil {
+					t.Fatalf("RemoveTeam(eng): %v", err)
+				}
+				if _, err := s.GetTeam(ctx, "eng"); !errors.Is(err, ErrTeamNotFound) {
+					t.Fatalf("GetTeam after delete: expected ErrTeamNotFound, got %v", err)
+				}
+			})
+		})
+	}
+}
+
+func TestFileStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	write := func(body string, mtime time.Time) {
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Minute)
+	write(`{"keys": [{"key": "iw:one", "provider": "openai", "actual_key": "sk-one"}]}`, start)
+
+	s, err := NewFileStore(FileStoreConfig{Path: path, ReloadInterval: -1})
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if _, err := s.GetKey(ctx, "iw:one"); err != nil {
+		t.Fatalf("GetKey(one): %v", err)
+	}
+
+	write(`{"keys": [{"key": "iw:two", "provider": "openai", "actual_key": "sk-two"}]}`, start.Add(time.Second))
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, err := s.GetKey(ctx, "iw:one"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected iw:one removed, got %v", err)
+	}
+	if _, err := s.GetKey(ctx, "iw:two"); err != nil {
+		t.Fatalf("GetKey(two): %v", err)
+	}
+
+	// A broken file keeps the previous keys
+	write(`{"keys": [`, start.Add(2*time.Second))
+	if err := s.Reload(); err == nil {
+		t.Fatal("expected parse error")
+	}
+	if _, err := s.GetKey(ctx, "iw:two"); err != nil {
+		t.Fatalf("expected previous keys retained, got %v", err)
+	}
+}
+
+func TestMigrateHashedKeys(t *testing.T) {
+	ctx := context.Background()
+	// A successor written before rotation links were hashed
+	successor := &APIKey{PK: HashKey("iw:successor"), Provider: "openai", ActualKey: "sk-successor", Enabled: true, RotatedFrom: "iw:disabled"}
+	s := NewMemoryStore(append(conformanceSeed(), successor)...)
+
+	migrated, err := MigrateHashedKeys(ctx, s)
+	if err != nil || migrated != 3 {
+		t.Fatalf("MigrateHashedKeys: migrated=%d err=%v", migrated, err)
+	}
+	for _, legacy := range []string{"iw:disabled", "iw:expired"} {
+		if _, err := s.LookupKey(ctx, legacy); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("plaintext record %s should be removed, got %v", legacy, err)
+		}
+		rec, err := s.LookupKey(ctx, HashKey(legacy))
+		if err != nil || rec.DisplayPrefix != DisplayPrefix(legacy) {
+			t.Fatalf("hashed record for %s: %+v %v", legacy, rec, err)
+		}
+	}
+	if _, err := s.GetKey(ctx, "iw:disabled"); !errors.Is(err, ErrKeyDisabled) {
+		t.Fatalf("migrated key should keep its state, got %v", err)
+	}
+	if rec, err := s.LookupKey(ctx, successor.PK); err != nil || rec.RotatedFrom != HashKey("iw:disabled") {
+		t.Fatalf("rotated_from should be rewritten to the digest: %+v %v", rec, err)
+	}
+
+	if migrated, err := MigrateHashedKeys(ctx, s); err != nil || migrated != 0 {
+		t.Fatalf("second run should be a no-op: migrated=%d err=%v", migrated, err)
+	}
+}
+
+func TestResolveKeyID(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(conformanceSeed()...)
+
+	for input, want := range map[string]string{
+		"iw:active":          HashKey("iw:active"),
+		HashKey("iw:active"): HashKey("iw:active"),
+		"iw:disabled":        "iw:disabled", // legacy plaintext record
+	} {
+		got, err := ResolveKeyID(ctx, s, input)
+		if err != nil || got != want {
+			t.Errorf("ResolveKeyID(%s) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ResolveKeyID(ctx, s, "iw:missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestSQLiteStoreAddsLineageColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.db")
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	// The schema as created before lineage_id existed
+	if _, err := db.Exec(strings.Replace(sqlSchema, "\tlineage_id       TEXT NOT NULL DEFAULT '',\n", "", 1)); err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+	db.Close()
+
+	s, err := NewSQLiteStore(path, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err := s.PutKey(ctx, &APIKey{PK: HashKey("iw:next"), Provider: "openai", ActualKey: "sk", Enabled: true, LineageID: HashKey("iw:first")}); err != nil {
+		t.Fatalf("PutKey: %v", err)
+	}
+	if k, err := s.LookupKey(ctx, HashKey("iw:next")); err != nil || k.LineageID != HashKey("iw:first") {
+		t.Fatalf("LookupKey: %+v %v", k, err)
+	}
+}
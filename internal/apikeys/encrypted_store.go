@@ -0,0 +1,256 @@
+package apikeys
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// EncryptedStore wraps a KeyStore so ActualKey and ProviderKeys are sealed before they are written
+// and opened after it is read. Each value is bound to its record, and provider
+// keys to their provider, so a sealed value copied elsewhere fails to open.
+// Records written before encryption was enabled are returned as-is until they
+// are re-encrypted with ReencryptKeys.
+type EncryptedStore struct {
+	store  KeyStore
+	enc    *Encryptor
+	logger *slog.Logger
+}
+
+// NewEncryptedStore wraps store with envelope encryption of provider keys
+func NewEncryptedStore(store KeyStore, enc *Encryptor, logger *slog.Logger) *EncryptedStore {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &EncryptedStore{store: store, enc: enc, logger: logger}
+}
+
+// CreateKey creates a new API key record with actualKey sealed. The record is
+// built here rather than by the wrapped store because sealing needs its ID.
+func (s *EncryptedStore) CreateKey(ctx context.Context, provider, actualKey, description string, dailyCostLimit int64, tags map[string]string) (*APIKey, error) {
+	apiKey, err := newAPIKey(provider, actualKey, description, dailyCostLimit, tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API key: %w", err)
+	}
+	if err := s.PutKey(ctx, apiKey); err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+// GetKey retrieves an API key and decrypts its provider keys
+func (s *EncryptedStore) GetKey(ctx context.Context, key string) (*APIKey, error) {
+	apiKey, err := s.store.GetKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.open(ctx, apiKey); err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+// LookupKey retrieves a record by its stored ID and decrypts its provider keys
+func (s *EncryptedStore) LookupKey(ctx context.Context, id string) (*APIKey, error) {
+	apiKey, err := s.store.LookupKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.open(ctx, apiKey); err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+// PutKey seals the provider keys, unless already sealed, and writes the record
+func (s *EncryptedStore) PutKey(ctx context.Context, apiKey *APIKey) error {
+	copied := *apiKey
+	if !IsEncrypted(copied.ActualKey) {
+		sealed, err := s.enc.Seal(ctx, copied.ActualKey, recordAAD(copied.PK, ""))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt provider key: %w", err)
+		}
+		copied.ActualKey = sealed
+	}
+	providerKeys, err := s.sealProviderKeys(ctx, copied.PK, copied.ProviderKeys)
+	if err != nil {
+		return err
+	}
+	copied.ProviderKeys = providerKeys
+	return s.store.PutKey(ctx, &copied)
+}
+
+// UpdateKey seals actual_key and provider_keys, if present, before updating
+func (s *EncryptedStore) UpdateKey(ctx context.Context, key string, updates map[string]interface{}) error {
+	actualKey, hasActual := updates["actual_key"].(string)
+	providerKeys, hasProviderKeys := updates["provider_keys"].(map[string]string)
+	if !hasActual && !hasProviderKeys {
+		return s.store.UpdateKey(ctx, key, updates)
+	}
+
+	copied := make(map[string]interface{}, len(updates))
+	for k, v := range updates {
+		copied[k] = v
+	}
+	if hasActual {
+		sealed, err := s.enc.Seal(ctx, actualKey, recordAAD(key, ""))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt provider key: %w", err)
+		}
+		copied["actual_key"] = sealed
+	}
+	if hasProviderKeys {
+		sealed, err := s.sealProviderKeys(ctx, key, providerKeys)
+		if err != nil {
+			return err
+		}
+		copied["provider_keys"] = sealed
+	}
+	return s.store.UpdateKey(ctx, key, copied)
+}
+
+// DeleteKey deletes an API key
+func (s *EncryptedStore) DeleteKey(ctx context.Context, key string) error {
+	return s.store.DeleteKey(ctx, key)
+}
+
+// RecordUsage adds to a key's usage counters
+func (s *EncryptedStore) RecordUsage(ctx context.Context, id string, usage KeyUsage) error {
+	return s.store.RecordUsage(ctx, id, usage)
+}
+
+// ListKeys lists API keys with provider keys decrypted. Keys that cannot be
+// decrypted are returned with empty provider keys rather than failing the list.
+func (s *EncryptedStore) ListKeys(ctx context.Context, provider string) ([]*APIKey, error) {
+	keys, err := s.store.ListKeys(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		kek := KeyIDOf(k.ActualKey)
+		if err := s.open(ctx, k); err != nil {
+			s.logger.Warn("Failed to decrypt provider key", "key", keyPrefix(k.PK), "kek", kek, "error", err)
+			k.ActualKey, k.ProviderKeys = "", nil
+		}
+	}
+	return keys, nil
+}
+
+// ValidateAndGetActualKey validates an API key and returns the decrypted provider key
+func (s *EncryptedStore) ValidateAndGetActualKey(ctx context.Context, key string) (string, string, error) {
+	return validateAndGetActualKey(ctx, s, key)
+}
+
+// ValidateAndGetProviderKey validates an API key and returns its decrypted upstream key for provider
+func (s *EncryptedStore) ValidateAndGetProviderKey(ctx context.Context, key, provider string) (string, error) {
+	return validateAndGetProviderKey(ctx, s, key, provider)
+}
+
+// PutTeam creates or replaces a team record; teams hold no secrets
+func (s *EncryptedStore) PutTeam(ctx context.Context, team *Team) error {
+	return s.store.PutTeam(ctx, team)
+}
+
+// GetTeam retrieves a team or project by ID
+func (s *EncryptedStore) GetTeam(ctx context.Context, id string) (*Team, error) {
+	return s.store.GetTeam(ctx, id)
+}
+
+// ListTeams lists all teams and projects
+func (s *EncryptedStore) ListTeams(ctx context.Context) ([]*Team, error) {
+	return s.store.ListTeams(ctx)
+}
+
+// DeleteTeam deletes a team or project
+func (s *EncryptedStore) DeleteTeam(ctx context.Context, id string) error {
+	return s.store.DeleteTeam(ctx, id)
+}
+
+// open decrypts apiKey's provider keys in place
+func (s *EncryptedStore) open(ctx context.Context, apiKey *APIKey) error {
+	actualKey, err := s.enc.Open(ctx, apiKey.ActualKey, recordAAD(apiKey.PK, ""))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt provider key: %w", err)
+	}
+	var providerKeys map[string]string
+	if len(apiKey.ProviderKeys) > 0 {
+		providerKeys = make(map[string]string, len(apiKey.ProviderKeys))
+		for p, v := range apiKey.ProviderKeys {
+			if providerKeys[p], err = s.enc.Open(ctx, v, recordAAD(apiKey.PK, p)); err != nil {
+				return fmt.Errorf("failed to decrypt %s provider key: %w", p, err)
+			}
+		}
+	}
+	apiKey.ActualKey, apiKey.ProviderKeys = actualKey, providerKeys
+	return nil
+}
+
+// sealProviderKeys returns a copy of record pk's providerKeys with every value sealed
+func (s *EncryptedStore) sealProviderKeys(ctx context.Context, pk string, providerKeys map[string]string) (map[string]string, error) {
+	if len(providerKeys) == 0 {
+		return providerKeys, nil
+	}
+	sealed := make(map[string]string, len(providerKeys))
+	for p, v := range providerKeys {
+		if IsEncrypted(v) {
+			sealed[p] = v
+			continue
+		}
+		var err error
+		if sealed[p], err = s.enc.Seal(ctx, v, recordAAD(pk, p)); err != nil {
+			return nil, fmt.Errorf("failed to encrypt %s provider key: %w", p, err)
+		}
+	}
+	return sealed, nil
+}
+
+// ReencryptKeys seals every provider key in store with enc's primary key
+// provider: plaintext records are encrypted, and records sealed under a
+// previous master key or without binding to their record are re-sealed.
+// store must be the unwrapped backend. It returns the number of records
+// rewritten and left unchanged.
+func ReencryptKeys(ctx context.Context, store KeyStore, enc *Encryptor) (updated, unchanged int, err error) {
+	keys, err := store.ListKeys(ctx, "")
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, k := range keys {
+		updates := make(map[string]interface{})
+		if !isSealedBy(k.ActualKey, enc.PrimaryKeyID()) {
+			sealed, err := reseal(ctx, enc, k.ActualKey, recordAAD(k.PK, ""))
+			if err != nil {
+				return updated, unchanged, fmt.Errorf("key %s: %w", keyPrefix(k.PK), err)
+			}
+			updates["actual_key"] = sealed
+		}
+		for p, v := range k.ProviderKeys {
+			if isSealedBy(v, enc.PrimaryKeyID()) {
+				continue
+			}
+			sealed, err := reseal(ctx, enc, v, recordAAD(k.PK, p))
+			if err != nil {
+				return updated, unchanged, fmt.Errorf("key %s: %s: %w", keyPrefix(k.PK), p, err)
+			}
+			k.ProviderKeys[p] = sealed
+			updates["provider_keys"] = k.ProviderKeys
+		}
+		if len(updates) == 0 {
+			unchanged++
+			continue
+		}
+		if err := store.UpdateKey(ctx, k.PK, updates); err != nil {
+			return updated, unchanged, fmt.Errorf("key %s: %w", keyPrefix(k.PK), err)
+		}
+		updated++
+	}
+	return updated, unchanged, nil
+}
+
+// reseal opens a plaintext or sealed value and seals it with enc's primary key
+func reseal(ctx context.Context, enc *Encryptor, value, aad string) (string, error) {
+	plaintext, err := enc.Open(ctx, value, aad)
+	if err != nil {
+		return "", err
+	}
+	return enc.Seal(ctx, plaintext, aad)
+}
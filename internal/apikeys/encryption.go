@@ -0,0 +1,373 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedPrefix marks an ActualKey value sealed by Encryptor, bound to its
+// record and provider as associated data. Values sealed before binding carry
+// legacyEncryptedPrefix and still open; values with neither prefix are
+// treated as legacy plaintext so existing records keep working.
+const (
+	encryptedPrefix       = "enc:v2:"
+	legacyEncryptedPrefix = "enc:v1:"
+)
+
+// DefaultMasterKeyEnv is the environment variable read for the local master key
+const DefaultMasterKeyEnv = "LLM_PROXY_MASTER_KEY"
+
+// ErrNoKeyProvider is returned when a value was sealed by a key-encryption key that is not configured
+var ErrNoKeyProvider = errors.New("no key-encryption provider configured for this key")
+
+// KeyProvider wraps and unwraps per-record data encryption keys (DEKs) with a
+// key-encryption key (KEK). Implementations are a local AES-GCM master key
+// or a KMS key.
+type KeyProvider interface {
+	// KeyID identifies the KEK; it is stored alongside each sealed value
+	KeyID() string
+	WrapKey(ctx context.Context, dek []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// KMSClient is the subset of a KMS API needed to wrap data keys. It matches
+// the shape of AWS KMS Encrypt/Decrypt so a thin adapter over the SDK client
+// (or any compatible service) can be passed to NewKMSKeyProvider.
+type KMSClient interface {
+	Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// KMSKeyProvider wraps data keys with a KMS-managed key
+type KMSKeyProvider struct {
+	client KMSClient
+	keyID  string
+}
+
+// NewKMSKeyProvider creates a provider that wraps data keys with the given KMS key
+func NewKMSKeyProvider(client KMSClient, keyID string) *KMSKeyProvider {
+	return &KMSKeyProvider{client: client, keyID: keyID}
+}
+
+// KeyID returns the KMS key identifier
+func (p *KMSKeyProvider) KeyID() string { return "kms:" + p.keyID }
+
+// WrapKey encrypts a data key with KMS
+func (p *KMSKeyProvider) WrapKey(ctx context.Context, dek []byte) ([]byte, error) {
+	return p.client.Encrypt(ctx, p.keyID, dek)
+}
+
+// UnwrapKey decrypts a data key with KMS
+func (p *KMSKeyProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return p.client.Decrypt(ctx, p.keyID, wrapped)
+}
+
+// LocalKeyProvider wraps data keys with a 256-bit AES-GCM master key held in
+// process memory. It is intended for development and small deployments.
+type LocalKeyProvider struct {
+	aead  cipher.AEAD
+	keyID string
+}
+
+// NewLocalKeyProvider creates a provider from a 32-byte master key
+func NewLocalKeyProvider(masterKey []byte) (*LocalKeyProvider, error) {
+	if len(masterKey) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(masterKey))
+	}
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(masterKey)
+	return &LocalKeyProvider{aead: aead, keyID: "local:" + hex.EncodeToString(sum[:4])}, nil
+}
+
+// LoadLocalKeyProvider reads a base64 or hex encoded master key from a file
+// or, when path is empty, from the named environment variable
+func LoadLocalKeyProvider(path, envVar string) (*LocalKeyProvider, error) {
+	var encoded string
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key file: %w", err)
+		}
+		encoded = string(data)
+	} else {
+		if envVar == "" {
+			envVar = DefaultMasterKeyEnv
+		}
+		encoded = os.Getenv(envVar)
+		if encoded == "" {
+			return nil, fmt.Errorf("master key not set: configure master_key_file or %s", envVar)
+		}
+	}
+	key, err := decodeMasterKey(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	return NewLocalKeyProvider(key)
+}
+
+// GenerateMasterKey returns a new random master key, base64 encoded
+func GenerateMasterKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate master key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func decodeMasterKey(s string) ([]byte, error) {
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	if b, err := hex.DecodeString(s); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	return nil, fmt.Errorf("master key must be 32 bytes encoded as base64 or hex")
+}
+
+// KeyID returns a fingerprint of the master key
+func (p *LocalKeyProvider) KeyID() string { return p.keyID }
+
+// WrapKey encrypts a data key with the master key
+func (p *LocalKeyProvider) WrapKey(ctx context.Context, dek []byte) ([]byte, error) {
+	return seal(p.aead, dek, nil)
+}
+
+// UnwrapKey decrypts a data key with the master key
+func (p *LocalKeyProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return open(p.aead, wrapped, nil)
+}
+
+// Encryptor seals provider keys with envelope encryption: each value gets a
+// fresh data key, which is wrapped by the primary KeyProvider. Additional
+// providers are only used to open values sealed before a master key rotation.
+type Encryptor struct {
+	primary   KeyProvider
+	providers map[string]KeyProvider
+}
+
+// NewEncryptor creates an encryptor sealing with primary and opening with
+// primary or any of previous
+func NewEncryptor(primary KeyProvider, previous ...KeyProvider) *Encryptor {
+	e := &Encryptor{primary: primary, providers: map[string]KeyProvider{primary.KeyID(): primary}}
+	for _, p := range previous {
+		if _, exists := e.providers[p.KeyID()]; !exists {
+			e.providers[p.KeyID()] = p
+		}
+	}
+	return e
+}
+
+// IsEncrypted reports whether a stored ActualKey value is sealed
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix) || strings.HasPrefix(value, legacyEncryptedPrefix)
+}
+
+// isSealedBy reports whether value is sealed in the current format under kekID
+func isSealedBy(value, kekID string) bool {
+	return strings.HasPrefix(value, encryptedPrefix) && KeyIDOf(value) == kekID
+}
+
+// recordAAD returns the associated data binding a sealed value to its record
+// and, for per-provider keys, to the provider. The record is named by its
+// hashed ID so values survive the migration of legacy plaintext PKs.
+func recordAAD(pk, provider string) string {
+	aad := "apikeys:" + KeyID(pk)
+	if provider != "" {
+		aad += ":" + provider
+	}
+	return aad
+}
+
+// KeyIDOf returns the KEK identifier of a sealed value, or "" for plaintext
+func KeyIDOf(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	kekID, _, _, err := splitSealed(value)
+	if err != nil {
+		return ""
+	}
+	return kekID
+}
+
+// splitSealed splits a sealed value into its KEK ID, wrapped data key and
+// ciphertext. KEK IDs contain colons (e.g. "local:abcd1234" or a KMS ARN)
+// while the base64 fields do not, so the value is split from the right.
+func splitSealed(value string) (kekID, wrapped, ciphertext string, err error) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		rest = strings.TrimPrefix(value, legacyEncryptedPrefix)
+	}
+	last := strings.LastIndex(rest, ":")
+	if last < 0 {
+		return "", "", "", fmt.Errorf("malformed encrypted key")
+	}
+	mid := strings.LastIndex(rest[:last], ":")
+	if mid < 0 {
+		return "", "", "", fmt.Errorf("malformed encrypted key")
+	}
+	return rest[:mid], rest[mid+1 : last], rest[last+1:], nil
+}
+
+// Seal encrypts plaintext as enc:v2:<kek id>:<wrapped dek>:<ciphertext>,
+// authenticating aad, which must be passed again to Open
+func (e *Encryptor) Seal(ctx context.Context, plaintext, aad string) (string, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(aead, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := e.primary.WrapKey(ctx, dek)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	enc := base64.RawURLEncoding
+	return encryptedPrefix + e.primary.KeyID() + ":" + enc.EncodeToString(wrapped) + ":" + enc.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts a sealed value, failing if it was sealed with different aad.
+// Values sealed before associated data was used ignore aad. Plaintext values
+// are returned unchanged.
+func (e *Encryptor) Open(ctx context.Context, value, aad string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	var additional []byte
+	if strings.HasPrefix(value, encryptedPrefix) {
+		additional = []byte(aad)
+	}
+	kekID, wrappedStr, ciphertextStr, err := splitSealed(value)
+	if err != nil {
+		return "", err
+	}
+
+	provider, ok := e.providers[kekID]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNoKeyProvider, kekID)
+	}
+	enc := base64.RawURLEncoding
+	wrapped, err := enc.DecodeString(wrappedStr)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted key: %w", err)
+	}
+	ciphertext, err := enc.DecodeString(ciphertextStr)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted key: %w", err)
+	}
+	dek, err := provider.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, ciphertext, additional)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// PrimaryKeyID returns the KEK ID new values are sealed with
+func (e *Encryptor) PrimaryKeyID() string {
+	return e.primary.KeyID()
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce || ciphertext
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// MaskSecret returns a redacted form of a provider key suitable for display
+func MaskSecret(s string) string {
+	if IsEncrypted(s) {
+		return "***ENCRYPTED***"
+	}
+	if len(s) <= 12 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + "…" + s[len(s)-4:]
+}
+
+// EncryptionConfig selects the key-encryption provider used to seal provider keys
+type EncryptionConfig struct {
+	Provider               string   // local (default) or kms
+	MasterKeyFile          string   // local: file holding the base64/hex master key
+	MasterKeyEnv           string   // local: env var used when MasterKeyFile is empty (default: LLM_PROXY_MASTER_KEY)
+	PreviousMasterKeyFiles []string // local: retired master keys, used only to decrypt during rotation
+	KMSKeyID               string   // kms: key ID or ARN
+	KMSClient              KMSClient
+}
+
+// NewEncryptorFromConfig builds an Encryptor for the configured provider
+func NewEncryptorFromConfig(cfg EncryptionConfig) (*Encryptor, error) {
+	switch cfg.Provider {
+	case "", "local":
+		primary, err := LoadLocalKeyProvider(cfg.MasterKeyFile, cfg.MasterKeyEnv)
+		if err != nil {
+			return nil, err
+		}
+		var previous []KeyProvider
+		for _, path := range cfg.PreviousMasterKeyFiles {
+			p, err := LoadLocalKeyProvider(path, "")
+			if err != nil {
+				return nil, fmt.Errorf("previous master key %s: %w", path, err)
+			}
+			previous = append(previous, p)
+		}
+		return NewEncryptor(primary, previous...), nil
+	case "kms":
+		if cfg.KMSKeyID == "" {
+			return nil, fmt.Errorf("kms encryption requires kms_key_id")
+		}
+		if cfg.KMSClient == nil {
+			return nil, fmt.Errorf("kms encryption requires a KMS client; none is linked into this binary")
+		}
+		return NewEncryptor(NewKMSKeyProvider(cfg.KMSClient, cfg.KMSKeyID)), nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption provider: %s (supported: local, kms)", cfg.Provider)
+	}
+}
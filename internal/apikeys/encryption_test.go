@@ -0,0 +1,235 @@
+package apikeys
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKeyProvider(t *testing.T, fill byte) *LocalKeyProvider {
+	t.Helper()
+	p, err := NewLocalKeyProvider(bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatalf("NewLocalKeyProvider: %v", err)
+	}
+	return p
+}
+
+func TestEncryptorSealOpen(t *testing.T) {
+	ctx := context.Background()
+	enc := NewEncryptor(testKeyProvider(t, 1))
+
+	a, err := enc.Seal(ctx, "sk-secret", "record-a")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	b, _ := enc.Seal(ctx, "sk-secret", "record-a")
+	if a == b {
+		t.Fatal("expected a fresh data key and nonce per seal")
+	}
+	if !IsEncrypted(a) || strings.Contains(a, "sk-secret") {
+		t.Fatalf("unexpected sealed value: %s", a)
+	}
+	if KeyIDOf(a) != enc.PrimaryKeyID() {
+		t.Fatalf("expected KEK %s, got %s", enc.PrimaryKeyID(), KeyIDOf(a))
+	}
+
+	plaintext, err := enc.Open(ctx, a, "record-a")
+	if err != nil || plaintext != "sk-secret" {
+		t.Fatalf("Open: %q %v", plaintext, err)
+	}
+
+	// A value opened with different associated data is rejected
+	if _, err := enc.Open(ctx, a, "record-b"); err == nil {
+		t.Fatal("expected error opening with different associated data")
+	}
+
+	// Values sealed before associated data was used still open
+	legacy, _ := enc.Seal(ctx, "sk-v1", "")
+	legacy = legacyEncryptedPrefix + strings.TrimPrefix(legacy, encryptedPrefix)
+	if v, err := enc.Open(ctx, legacy, "record-a"); err != nil || v != "sk-v1" {
+		t.Fatalf("Open(v1): %q %v", v, err)
+	}
+
+	// Legacy plaintext values pass through
+	if v, err := enc.Open(ctx, "sk-legacy", "record-a"); err != nil || v != "sk-legacy" {
+		t.Fatalf("Open(plaintext): %q %v", v, err)
+	}
+
+	// A different master key cannot open the value
+	other := NewEncryptor(testKeyProvider(t, 2))
+	if _, err := other.Open(ctx, a, "record-a"); !errors.Is(err, ErrNoKeyProvider) {
+		t.Fatalf("expected ErrNoKeyProvider, got %v", err)
+	}
+
+	// Tampering is detected
+	tampered := a[:len(a)-2] + "AA"
+	if _, err := enc.Open(ctx, tampered, "record-a"); err == nil {
+		t.Fatal("expected error for tampered ciphertext")
+	}
+}
+
+type fakeKMS struct {
+	inner *LocalKeyProvider
+	calls int
+}
+
+func (f *fakeKMS) Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error) {
+	f.calls++
+	return f.inner.WrapKey(ctx, plaintext)
+}
+
+func (f *fakeKMS) Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	f.calls++
+	return f.inner.UnwrapKey(ctx, ciphertext)
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	kms := &fakeKMS{inner: testKeyProvider(t, 3)}
+	enc := NewEncryptor(NewKMSKeyProvider(kms, "arn:aws:kms:us-west-2:123:key/abc"))
+
+	sealed, err := enc.Seal(ctx, "sk-kms", "record")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if KeyIDOf(sealed) != "kms:arn:aws:kms:us-west-2:123:key/abc" {
+		t.Fatalf("unexpected KEK ID: %s", KeyIDOf(sealed))
+	}
+	if v, err := enc.Open(ctx, sealed, "record"); err != nil || v != "sk-kms" {
+		t.Fatalf("Open: %q %v", v, err)
+	}
+	if kms.calls != 2 {
+		t.Fatalf("expected one wrap and one unwrap call, got %d", kms.calls)
+	}
+}
+
+func TestEncryptedStoreAndRotation(t *testing.T) {
+	ctx := context.Background()
+	oldKey := testKeyProvider(t, 4)
+	newKey := testKeyProvider(t, 5)
+
+	raw := NewMemoryStore(&APIKey{PK: "iw:legacy", Provider: "openai", ActualKey: "sk-legacy", Enabled: true})
+	store := NewEncryptedStore(raw, NewEncryptor(oldKey), nil)
+
+	created, err := store.CreateKey(ctx, "openai", "sk-created", "", 0, nil)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	if err := store.UpdateKey(ctx, created.PK, map[string]interface{}{"provider_keys": map[string]string{"gemini": "AIza-created"}}); err != nil {
+		t.Fatalf("UpdateKey(provider_keys): %v", err)
+	}
+	if created.ActualKey != "sk-created" {
+		t.Fatalf("expected plaintext on returned key, got %q", created.ActualKey)
+	}
+	stored, _ := raw.LookupKey(ctx, created.PK)
+	if !IsEncrypted(stored.ActualKey) || !IsEncrypted(stored.ProviderKeys["gemini"]) {
+		t.Fatalf("expected ciphertext at rest, got %q %q", stored.ActualKey, stored.ProviderKeys["gemini"])
+	}
+	if upstream, err := store.ValidateAndGetProviderKey(ctx, created.Key, "gemini"); err != nil || upstream != "AIza-created" {
+		t.Fatalf("ValidateAndGetProviderKey: %q %v", upstream, err)
+	}
+	if actual, _, err := store.ValidateAndGetActualKey(ctx, created.Key); err != nil || actual != "sk-created" {
+		t.Fatalf("ValidateAndGetActualKey: %q %v", actual, err)
+	}
+
+	// Rotate: new primary key, old key retained for decryption
+	rotated := NewEncryptor(newKey, oldKey)
+	updated, unchanged, err := ReencryptKeys(ctx, raw, rotated)
+	if err != nil || updated != 2 || unchanged != 0 {
+		t.Fatalf("ReencryptKeys: updated=%d unchanged=%d err=%v", updated, unchanged, err)
+	}
+	for _, pk := range []string{"iw:legacy", created.PK} {
+		k, _ := raw.LookupKey(ctx, pk)
+		if KeyIDOf(k.ActualKey) != newKey.KeyID() {
+			t.Fatalf("%s not sealed with new key: %q", pk, k.ActualKey)
+		}
+		for p, v := range k.ProviderKeys {
+			if KeyIDOf(v) != newKey.KeyID() {
+				t.Fatalf("%s %s key not sealed with new key: %q", pk, p, v)
+			}
+		}
+	}
+
+	// After rotation the old key is no longer needed
+	store = NewEncryptedStore(raw, NewEncryptor(newKey), nil)
+	if actual, _, err := store.ValidateAndGetActualKey(ctx, "iw:legacy"); err != nil || actual != "sk-legacy" {
+		t.Fatalf("after rotation: %q %v", actual, err)
+	}
+	if updated, unchanged, _ := ReencryptKeys(ctx, raw, NewEncryptor(newKey)); updated != 0 || unchanged != 2 {
+		t.Fatalf("second run should be a no-op: updated=%d unchanged=%d", updated, unchanged)
+	}
+}
+
+func TestEncryptedStoreBindsValues(t *testing.T) {
+	ctx := context.Background()
+	enc := NewEncryptor(testKeyProvider(t, 6))
+	raw := NewMemoryStore()
+	store := NewEncryptedStore(raw, enc, nil)
+
+	a, err := store.CreateKey(ctx, "openai", "sk-a", "", 0, nil)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	b, _ := store.CreateKey(ctx, "openai", "sk-b", "", 0, nil)
+	if err := store.UpdateKey(ctx, a.PK, map[string]interface{}{"provider_keys": map[string]string{"gemini": "AIza-a"}}); err != nil {
+		t.Fatalf("UpdateKey(provider_keys): %v", err)
+	}
+	storedA, _ := raw.LookupKey(ctx, a.PK)
+
+	// A sealed value copied to another record does not open there
+	if err := raw.UpdateKey(ctx, b.PK, map[string]interface{}{"actual_key": storedA.ActualKey}); err != nil {
+		t.Fatalf("UpdateKey: %v", err)
+	}
+	if _, _, err := store.ValidateAndGetActualKey(ctx, b.Key); err == nil {
+		t.Fatal("expected a value sealed for another record to fail to open")
+	}
+
+	// Nor does a provider key moved to another provider
+	moved := map[string]string{"anthropic": storedA.ProviderKeys["gemini"]}
+	if err := raw.UpdateKey(ctx, a.PK, map[string]interface{}{"provider_keys": moved}); err != nil {
+		t.Fatalf("UpdateKey: %v", err)
+	}
+	if _, err := store.ValidateAndGetProviderKey(ctx, a.Key, "anthropic"); err == nil {
+		t.Fatal("expected a value sealed for another provider to fail to open")
+	}
+}
+
+func TestReencryptKeysUpgradesUnboundValues(t *testing.T) {
+	ctx := context.Background()
+	enc := NewEncryptor(testKeyProvider(t, 7))
+
+	// Values sealed before binding used the v1 envelope and no associated data
+	v1, _ := enc.Seal(ctx, "sk-v1", "")
+	v1 = legacyEncryptedPrefix + strings.TrimPrefix(v1, encryptedPrefix)
+	raw := NewMemoryStore(&APIKey{PK: HashKey("iw:v1"), Provider: "openai", ActualKey: v1, Enabled: true})
+
+	updated, unchanged, err := ReencryptKeys(ctx, raw, enc)
+	if err != nil || updated != 1 || unchanged != 0 {
+		t.Fatalf("ReencryptKeys: updated=%d unchanged=%d err=%v", updated, unchanged, err)
+	}
+	k, _ := raw.LookupKey(ctx, HashKey("iw:v1"))
+	if !strings.HasPrefix(k.ActualKey, encryptedPrefix) {
+		t.Fatalf("expected the current envelope, got %q", k.ActualKey)
+	}
+	store := NewEncryptedStore(raw, enc, nil)
+	if actual, _, err := store.ValidateAndGetActualKey(ctx, "iw:v1"); err != nil || actual != "sk-v1" {
+		t.Fatalf("after upgrade: %q %v", actual, err)
+	}
+}
+
+func TestMaskSecret(t *testing.T) {
+	if got := MaskSecret("sk-proj-abcdefghijklmnop"); got != "sk-p…mnop" {
+		t.Fatalf("unexpected mask: %s", got)
+	}
+	if got := MaskSecret("short"); got != "*****" {
+		t.Fatalf("unexpected mask for short key: %s", got)
+	}
+	for _, prefix := range []string{encryptedPrefix, legacyEncryptedPrefix} {
+		if got := MaskSecret(prefix + "local:abcd:x:y"); got != "***ENCRYPTED***" {
+			t.Fatalf("unexpected mask for sealed value: %s", got)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package apikeys
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileStoreConfig holds configuration for the file-backed key store
+type FileStoreConfig struct {
+	Path           string        // YAML or JSON file (by extension)
+	ReloadInterval time.Duration // How often to check the file for changes (default: 5s, negative disables)
+	Logger         *slog.Logger
+}
+
+// keyFile is the on-disk format of the file store:
+//
+//	keys:
+//	  - key_id: sha256:9f86d0...   # HashKey of the iw: key (preferred)
+//	    display_prefix: iw:01234567
+//	    provider: openai
+//	    actual_key: sk-...
+//	    provider_keys:           # optional, upstream keys for further providers
+//	      anthropic: sk-ant-...
+//	    description: CI key
+//	    daily_cost_limit: 5000
+//	    enabled: true            # default true
+//	    expires_at: 2026-01-01T00:00:00Z
+//	    tags: {team: platform}
+//	    scope:                   # optional, see KeyScope
+//	      allowed_models: ["gpt-4o-mini*"]
+//	      allowed_endpoints: [/v1/chat/completions]
+//	      max_tokens: 1024
+//	      allowed_cidrs: [10.0.0.0/8]
+//	    team_id: platform        # optional, see Team
+//	    project_id: platform-ci
+//	teams:
+//	  - id: platform
+//	    name: Platform
+//	    limits: {requests_per_minute: 600, cost_per_day: 200}
+//	  - id: platform-ci
+//	    parent_id: platform
+//
+// A plaintext `key: iw:...` may be given instead of key_id; it is hashed on load.
+type keyFile struct {
+	Keys  []fileKey `yaml:"keys" json:"keys"`
+	Teams []Team    `yaml:"teams,omitempty" json:"teams,omitempty"`
+}
+
+type fileKey struct {
+	Key            string            `yaml:"key,omitempty" json:"key,omitempty"`
+	KeyID          string            `yaml:"key_id,omitempty" json:"key_id,omitempty"`
+	DisplayPrefix  string            `yaml:"display_prefix,omitempty" json:"display_prefix,omitempty"`
+	Provider       string            `yaml:"provider" json:"provider"`
+	ActualKey      string            `yaml:"actual_key" json:"actual_key"`
+	ProviderKeys   map[string]string `yaml:"provider_keys,omitempty" json:"provider_keys,omitempty"`
+	Description    string            `yaml:"description,omitempty" json:"description,omitempty"`
+	DailyCostLimit int64             `yaml:"daily_cost_limit,omitempty" json:"daily_cost_limit,omitempty"`
+	Enabled        *bool             `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	CreatedAt      time.Time         `yaml:"created_at,omitempty" json:"created_at,omitempty"`
+	ExpiresAt      *time.Time        `yaml:"expires_at,omitempty" json:"expires_at,omitempty"`
+	Tags           map[string]string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Scope          *KeyScope         `yaml:"scope,omitempty" json:"scope,omitempty"`
+	TeamID         string            `yaml:"team_id,omitempty" json:"team_id,omitempty"`
+	ProjectID      string            `yaml:"project_id,omitempty" json:"project_id,omitempty"`
+}
+
+// FileStore serves API keys from a YAML or JSON file. It is read-only: keys
+// are managed by editing the file, which is reloaded when its modification
+// time changes. A file that fails to parse leaves the previous keys in place.
+type FileStore struct {
+	path   string
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	keys    map[string]*APIKey
+	teams   map[string]*Team
+	modTime time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewFileStore loads the key file and starts watching it for changes
+func NewFileStore(cfg FileStoreConfig) (*FileStore, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("file key store requires a path")
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	s := &FileStore{
+		path:   cfg.Path,
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	interval := cfg.ReloadInterval
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	if interval > 0 {
+		go s.watch(interval)
+	}
+	return s, nil
+}
+
+// Reload re-reads the key file if it changed since the last load
+func (s *FileStore) Reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat key file: %w", err)
+	}
+
+	s.mu.RLock()
+	unchanged := s.keys != nil && info.ModTime().Equal(s.modTime)
+	s.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %w", err)
+	}
+	keys, teams, err := parseKeyFile(s.path, data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.teams = teams
+	s.modTime = info.ModTime()
+	s.mu.Unlock()
+
+	s.logger.Info("🔑 API Key Store: Loaded key file", "path", s.path, "keys", len(keys), "teams", len(teams))
+	return nil
+}
+
+// Close stops watching the key file
+func (s *FileStore) Close() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+func (s *FileStore) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Reload(); err != nil {
+				s.logger.Error("🔑 API Key Store: Failed to reload key file, keeping previous keys", "path", s.path, "error", err)
+			}
+		}
+	}
+}
+
+func parseKeyFile(path string, data []byte) (map[string]*APIKey, map[string]*Team, error) {
+	var f keyFile
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &f)
+	} else {
+		err = yaml.Unmarshal(data, &f)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse key file %s: %w", path, err)
+	}
+
+	teams, err := parseTeams(path, f.Teams)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make(map[string]*APIKey, len(f.Keys))
+	for i, fk := range f.Keys {
+		id, displayPrefix := fk.KeyID, fk.DisplayPrefix
+		switch {
+		case fk.Key != "" && fk.KeyID != "":
+			return nil, nil, fmt.Errorf("key file %s: entry %d: set either key or key_id, not both", path, i)
+		case fk.Key != "":
+			if err := checkKeyFormat(fk.Key); err != nil {
+				return nil, nil, fmt.Errorf("key file %s: entry %d: %w", path, i, err)
+			}
+			id = HashKey(fk.Key)
+			if displayPrefix == "" {
+				displayPrefix = DisplayPrefix(fk.Key)
+			}
+		case !IsKeyID(fk.KeyID):
+			return nil, nil, fmt.Errorf("key file %s: entry %d: key_id must be %s followed by 64 hex characters", path, i, keyIDPrefix)
+		}
+		if err := fk.Scope.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("key file %s: entry %d: scope: %w", path, i, err)
+		}
+		if err := checkTeamRefs(teams, fk.TeamID, fk.ProjectID); err != nil {
+			return nil, nil, fmt.Errorf("key file %s: entry %d: %w", path, i, err)
+		}
+		if _, dup := keys[id]; dup {
+			return nil, nil, fmt.Errorf("key file %s: entry %d: duplicate key", path, i)
+		}
+		enabled := fk.Enabled == nil || *fk.Enabled
+		keys[id] = &APIKey{
+			PK:             id,
+			DisplayPrefix:  displayPrefix,
+			Provider:       fk.Provider,
+			ActualKey:      fk.ActualKey,
+			ProviderKeys:   fk.ProviderKeys,
+			DailyCostLimit: fk.DailyCostLimit,
+			Description:    fk.Description,
+			CreatedAt:      fk.CreatedAt,
+			UpdatedAt:      fk.CreatedAt,
+			ExpiresAt:      fk.ExpiresAt,
+			Enabled:        enabled,
+			Tags:           fk.Tags,
+			Scope:          fk.Scope,
+			TeamID:         fk.TeamID,
+			ProjectID:      fk.ProjectID,
+		}
+	}
+	return keys, teams, nil
+}
+
+// parseTeams indexes and checks the teams section of a key file
+func parseTeams(path string, list []Team) (map[string]*Team, error) {
+	teams := make(map[string]*Team, len(list))
+	for i := range list {
+		t := list[i]
+		if !teamIDPattern.MatchString(t.ID) {
+			return nil, fmt.Errorf("key file %s: team %d: invalid team ID %q", path, i, t.ID)
+		}
+		if _, dup := teams[t.ID]; dup {
+			return nil, fmt.Errorf("key file %s: team %d: duplicate team %s", path, i, t.ID)
+		}
+		teams[t.ID] = &t
+	}
+	for _, t := range teams {
+		if !t.IsProject() {
+			continue
+		}
+		parent, ok := teams[t.ParentID]
+		if !ok || parent.IsProject() {
+			return nil, fmt.Errorf("key file %s: project %s: parent_id must name a team", path, t.ID)
+		}
+		if t.Limits != nil {
+			return nil, fmt.Errorf("key file %s: project %s: limits can only be set on teams", path, t.ID)
+		}
+	}
+	return teams, nil
+}
+
+// checkTeamRefs checks that a key's team and project exist and belong together
+func checkTeamRefs(teams map[string]*Team, teamID, projectID string) error {
+	if teamID == "" {
+		if projectID != "" {
+			return fmt.Errorf("project_id requires team_id")
+		}
+		return nil
+	}
+	if t, ok := teams[teamID]; !ok || t.IsProject() {
+		return fmt.Errorf("team_id %s is not a team", teamID)
+	}
+	if projectID == "" {
+		return nil
+	}
+	if p, ok := teams[projectID]; !ok || p.ParentID != teamID {
+		return fmt.Errorf("project_id %s is not a project of team %s", projectID, teamID)
+	}
+	return nil
+}
+
+// GetKey retrieves a usable API key by the iw: prefixed key presented by a client
+func (s *FileStore) GetKey(ctx context.Context, key string) (*APIKey, error) {
+	return getUsableKey(ctx, s.LookupKey, key)
+}
+
+// LookupKey retrieves a record by its stored ID
+func (s *FileStore) LookupKey(ctx context.Context, id string) (*APIKey, error) {
+	s.mu.RLock()
+	k, ok := s.keys[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	copied := *k
+	return &copied, nil
+}
+
+// ListKeys lists all API keys, optionally filtered by provider
+func (s *FileStore) ListKeys(ctx context.Context, provider string) ([]*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return listKeys(s.keys, provider), nil
+}
+
+// ValidateAndGetActualKey validates an API key and returns the actual provider key
+func (s *FileStore) ValidateAndGetActualKey(ctx context.Context, key string) (string, string, error) {
+	return validateAndGetActualKey(ctx, s, key)
+}
+
+// ValidateAndGetProviderKey validates an API key and returns its upstream key for provider
+func (s *FileStore) ValidateAndGetProviderKey(ctx context.Context, key, provider string) (string, error) {
+	return validateAndGetProviderKey(ctx, s, key, provider)
+}
+
+// PutKey is not supported; edit the key file instead
+func (s *FileStore) PutKey(ctx context.Context, apiKey *APIKey) error {
+	return ErrReadOnly
+}
+
+// CreateKey is not supported; edit the key file instead
+func (s *FileStore) CreateKey(ctx context.Context, provider, actualKey, description string, dailyCostLimit int64, tags map[string]string) (*APIKey, error) {
+	return nil, ErrReadOnly
+}
+
+// UpdateKey is not supported; edit the key file instead
+func (s *FileStore) UpdateKey(ctx context.Context, key string, updates map[string]interface{}) error {
+	return ErrReadOnly
+}
+
+// DeleteKey is not supported; edit the key file instead
+func (s *FileStore) DeleteKey(ctx context.Context, key string) error {
+	return ErrReadOnly
+}
+
+// RecordUsage is not supported; usage is not tracked for keys in a key file
+func (s *FileStore) RecordUsage(ctx context.Context, id string, usage KeyUsage) error {
+	return ErrReadOnly
+}
+
+// GetTeam retrieves a team or project by ID
+func (s *FileStore) GetTeam(ctx context.Context, id string) (*Team, error) {
+	s.mu.RLock()
+	t, ok := s.teams[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrTeamNotFound
+	}
+	return t.clone(), nil
+}
+
+// ListTeams lists all teams and projects
+func (s *FileStore) ListTeams(ctx context.Context) ([]*Team, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return listTeams(s.teams), nil
+}
+
+// PutTeam is not supported; edit the key file instead
+func (s *FileStore) PutTeam(ctx context.Context, team *Team) error {
+	return ErrReadOnly
+}
+
+// DeleteTeam is not supported; edit the key file instead
+func (s *FileStore) DeleteTeam(ctx context.Context, id string) error {
+	return ErrReadOnly
+}
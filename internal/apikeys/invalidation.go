@@ -0,0 +1,47 @@
+package apikeys
+
+import (
+	"context"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// DefaultInvalidationChannel is the Redis pub/sub channel used for cache invalidation
+const DefaultInvalidationChannel = "llm-proxy:apikeys:invalidate"
+
+// PublishInvalidation notifies running proxies that a key changed so they drop
+// it from their caches. key may be the iw: key, its record ID or a legacy
+// record's plaintext ID; only the hashed ID is published, which caches are
+// keyed by, so plaintext keys never reach the channel.
+func PublishInvalidation(ctx context.Context, rdb *redis.Client, channel, key string) error {
+	if channel == "" {
+		channel = DefaultInvalidationChannel
+	}
+	return rdb.Publish(ctx, channel, KeyID(key)).Err()
+}
+
+// SubscribeInvalidations drops keys from the cache as invalidation messages
+// arrive. It blocks until ctx is cancelled; run it in a goroutine. The
+// subscription is re-established automatically by the Redis client.
+func (c *CachedStore) SubscribeInvalidations(ctx context.Context, rdb *redis.Client, channel string) {
+	if channel == "" {
+		channel = DefaultInvalidationChannel
+	}
+	sub := rdb.Subscribe(ctx, channel)
+	defer sub.Close()
+
+	c.logger.Info("🔑 API Key Cache: Subscribed to invalidations", "channel", channel)
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			c.Invalidate(msg.Payload)
+			c.logger.Debug("🔑 API Key Cache: Invalidated key", "key_prefix", keyPrefix(msg.Payload))
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// keyIDPrefix marks a stored record ID, the SHA-256 digest of an iw: key.
+// iw: keys carry 256 bits of randomness, so an unsalted digest cannot be
+// reversed, and lookups stay a single-key read in every backend.
+const keyIDPrefix = "sha256:"
+
+// displayPrefixLength is how much of the key's random part is kept for humans
+const displayPrefixLength = 8
+
+// ErrKeyExists is returned by PutKey when a record with the same ID exists
+var ErrKeyExists = errors.New("API key already exists")
+
+// HashKey returns the record ID under which an iw: key is stored
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return keyIDPrefix + hex.EncodeToString(sum[:])
+}
+
+// IsKeyID reports whether s is a stored record ID rather than a client key
+func IsKeyID(s string) bool {
+	return strings.HasPrefix(s, keyIDPrefix) && len(s) == len(keyIDPrefix)+sha256.Size*2
+}
+
+// KeyID normalises a client key or record ID to the record ID
+func KeyID(s string) string {
+	if IsKeyID(s) {
+		return s
+	}
+	return HashKey(s)
+}
+
+// DisplayPrefix returns the short, non-secret prefix shown for a key (e.g. "iw:3f9a1c2b")
+func DisplayPrefix(key string) string {
+	if n := len(KeyPrefix) + displayPrefixLength; len(key) > n {
+		return key[:n]
+	}
+	return key
+}
+
+// getUsableKey implements GetKey for a client-presented key on top of a
+// backend's LookupKey: it looks the key up by digest, falls back to a legacy
+// record stored under the plaintext key, and checks the key is usable.
+func getUsableKey(ctx context.Context, lookup func(context.Context, string) (*APIKey, error), key string) (*APIKey, error) {
+	if err := checkKeyFormat(key); err != nil {
+		return nil, err
+	}
+	apiKey, err := lookup(ctx, HashKey(key))
+	if errors.Is(err, ErrKeyNotFound) {
+		// Records created before keys were hashed; removed by -migrate-hashed-keys
+		apiKey, err = lookup(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := apiKey.Usable(time.Now()); err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+// ResolveKeyID maps an operator-supplied iw: key or record ID to the ID of
+// the stored record, including legacy records stored under the plaintext key
+func ResolveKeyID(ctx context.Context, store KeyStore, s string) (string, error) {
+	if IsKeyID(s) {
+		if _, err := store.LookupKey(ctx, s); err != nil {
+			return "", err
+		}
+		return s, nil
+	}
+	id := HashKey(s)
+	_, err := store.LookupKey(ctx, id)
+	if errors.Is(err, ErrKeyNotFound) {
+		if _, legacyErr := store.LookupKey(ctx, s); legacyErr == nil {
+			return s, nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// MigrateHashedKeys rewrites records stored under plaintext iw: keys so they
+// are stored under their digest, then deletes the plaintext records. Links
+// to rotated legacy keys are rewritten to their digest too. It is safe to
+// re-run; store must be the unwrapped backend. It returns the number of
+// records migrated.
+func MigrateHashedKeys(ctx context.Context, store KeyStore) (int, error) {
+	keys, err := store.ListKeys(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, k := range keys {
+		if IsKeyID(k.PK) {
+			if k.RotatedFrom == "" || IsKeyID(k.RotatedFrom) {
+				continue
+			}
+			if err := store.UpdateKey(ctx, k.PK, map[string]interface{}{"rotated_from": KeyID(k.RotatedFrom)}); err != nil {
+				return migrated, fmt.Errorf("key %s: failed to rewrite rotated_from: %w", k.PK, err)
+			}
+			migrated++
+			continue
+		}
+		legacyKey := k.PK
+		rec := *k
+		rec.PK = HashKey(legacyKey)
+		rec.DisplayPrefix = DisplayPrefix(legacyKey)
+		if err := store.PutKey(ctx, &rec); err != nil && !errors.Is(err, ErrKeyExists) {
+			return migrated, fmt.Errorf("key %s: %w", DisplayPrefix(legacyKey), err)
+		}
+		if err := store.DeleteKey(ctx, legacyKey); err != nil {
+			return migrated, fmt.Errorf("key %s: failed to remove plaintext record: %w", DisplayPrefix(legacyKey), err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
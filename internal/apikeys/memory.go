@@ -0,0 +1,183 @@
+package apikeys
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps API keys in process memory. Keys are lost on restart, so
+// it is intended for tests and local development.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	keys  map[string]*APIKey
+	teams map[string]*Team
+}
+
+// NewMemoryStore creates an in-memory key store seeded with the given keys
+func NewMemoryStore(seed ...*APIKey) *MemoryStore {
+	s := &MemoryStore{keys: make(map[string]*APIKey, len(seed)), teams: make(map[string]*Team)}
+	for _, k := range seed {
+		copied := *k
+		s.keys[k.PK] = &copied
+	}
+	return s
+}
+
+// CreateKey creates a new API key record
+func (s *MemoryStore) CreateKey(ctx context.Context, provider, actualKey, description string, dailyCostLimit int64, tags map[string]string) (*APIKey, error) {
+	apiKey, err := newAPIKey(provider, actualKey, description, dailyCostLimit, tags)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.PutKey(ctx, apiKey); err != nil {
+		return nil, fmt.Errorf("failed to create API key: %w", err)
+	}
+	return apiKey, nil
+}
+
+// PutKey writes a complete record, failing with ErrKeyExists if its PK is taken
+func (s *MemoryStore) PutKey(ctx context.Context, apiKey *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.keys[apiKey.PK]; exists {
+		return ErrKeyExists
+	}
+	copied := *apiKey
+	copied.Key = ""
+	s.keys[apiKey.PK] = &copied
+	return nil
+}
+
+// GetKey retrieves a usable API key by the iw: prefixed key presented by a client
+func (s *MemoryStore) GetKey(ctx context.Context, key string) (*APIKey, error) {
+	return getUsableKey(ctx, s.LookupKey, key)
+}
+
+// LookupKey retrieves a record by its stored ID
+func (s *MemoryStore) LookupKey(ctx context.Context, id string) (*APIKey, error) {
+	s.mu.RLock()
+	k, ok := s.keys[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	copied := *k
+	return &copied, nil
+}
+
+// UpdateKey updates an existing API key
+func (s *MemoryStore) UpdateKey(ctx context.Context, key string, updates map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[key]
+	if !ok {
+		return fmt.Errorf("failed to update API key: %w", ErrKeyNotFound)
+	}
+	updated := *k
+	if err := applyUpdates(&updated, updates, time.Now()); err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	s.keys[key] = &updated
+	return nil
+}
+
+// RecordUsage adds usage to a key's counters without touching UpdatedAt
+func (s *MemoryStore) RecordUsage(ctx context.Context, id string, usage KeyUsage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return fmt.Errorf("failed to record API key usage: %w", ErrKeyNotFound)
+	}
+	updated := *k
+	usage.applyTo(&updated)
+	s.keys[id] = &updated
+	return nil
+}
+
+// DeleteKey deletes an API key
+func (s *MemoryStore) DeleteKey(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key]; !ok {
+		return fmt.Errorf("failed to delete API key: %w", ErrKeyNotFound)
+	}
+	delete(s.keys, key)
+	return nil
+}
+
+// ListKeys lists all API keys, optionally filtered by provider
+func (s *MemoryStore) ListKeys(ctx context.Context, provider string) ([]*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return listKeys(s.keys, provider), nil
+}
+
+// ValidateAndGetActualKey validates an API key and returns the actual provider key
+func (s *MemoryStore) ValidateAndGetActualKey(ctx context.Context, key string) (string, string, error) {
+	return validateAndGetActualKey(ctx, s, key)
+}
+
+// ValidateAndGetProviderKey validates an API key and returns its upstream key for provider
+func (s *MemoryStore) ValidateAndGetProviderKey(ctx context.Context, key, provider string) (string, error) {
+	return validateAndGetProviderKey(ctx, s, key, provider)
+}
+
+// PutTeam creates or replaces a team record
+func (s *MemoryStore) PutTeam(ctx context.Context, team *Team) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.teams[team.ID] = team.clone()
+	return nil
+}
+
+// GetTeam retrieves a team or project by ID
+func (s *MemoryStore) GetTeam(ctx context.Context, id string) (*Team, error) {
+	s.mu.RLock()
+	t, ok := s.teams[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrTeamNotFound
+	}
+	return t.clone(), nil
+}
+
+// ListTeams lists all teams and projects
+func (s *MemoryStore) ListTeams(ctx context.Context) ([]*Team, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return listTeams(s.teams), nil
+}
+
+// DeleteTeam deletes a team or project
+func (s *MemoryStore) DeleteTeam(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.teams[id]; !ok {
+		return fmt.Errorf("failed to delete team: %w", ErrTeamNotFound)
+	}
+	delete(s.teams, id)
+	return nil
+}
+
+// listKeys copies keys matching provider (all when empty), ordered by creation time
+func listKeys(keys map[string]*APIKey, provider string) []*APIKey {
+	var out []*APIKey
+	for _, k := range keys {
+		if provider != "" && k.Provider != provider {
+			continue
+		}
+		copied := *k
+		out = append(out, &copied)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].PK < out[j].PK
+	})
+	return out
+}
@@ -0,0 +1,87 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrAlreadyRotated is returned when rotating a key that already has a successor
+var ErrAlreadyRotated = errors.New("API key has already been rotated")
+
+// RotateKey mints a successor for the key stored under id. The successor
+// inherits the upstream keys, limits, tags and scope; the old key stays
+// valid until now+grace (or its existing expiry, if sooner). Both records
+// are linked through RotatedFrom and RotatedTo, and the successor joins the
+// old key's lineage so the two share rate limits during the grace period.
+// The returned key carries the new plaintext iw: key in Key.
+func RotateKey(ctx context.Context, store KeyStore, id string, grace time.Duration) (*APIKey, error) {
+	old, err := store.LookupKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if old.RotatedTo != "" {
+		return nil, fmt.Errorf("%w to %s", ErrAlreadyRotated, old.RotatedTo)
+	}
+	if err := old.Usable(time.Now()); err != nil {
+		return nil, err
+	}
+
+	newKey, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	successor := *old
+	successor.PK = HashKey(newKey)
+	successor.DisplayPrefix = DisplayPrefix(newKey)
+	successor.Key = newKey
+	successor.CreatedAt = now
+	successor.UpdatedAt = now
+	// Legacy records are keyed by the plaintext key; link by its hash instead
+	successor.RotatedFrom = KeyID(old.PK)
+	successor.LineageID = old.RateLimitID()
+	successor.RotatedTo = ""
+	successor.LastUsedAt, successor.LastUsedIP = nil, ""
+	successor.RequestCount, successor.TokenCount, successor.TotalCost = 0, 0, 0
+	if err := store.PutKey(ctx, &successor); err != nil {
+		return nil, fmt.Errorf("failed to create successor key: %w", err)
+	}
+
+	deadline := now.Add(grace)
+	if old.ExpiresAt != nil && old.ExpiresAt.Before(deadline) {
+		deadline = *old.ExpiresAt
+	}
+	if err := store.UpdateKey(ctx, old.PK, map[string]interface{}{
+		"expires_at": deadline,
+		"rotated_to": successor.PK,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to set grace deadline on rotated key (successor %s was created): %w", successor.PK, err)
+	}
+	return &successor, nil
+}
+
+// RotateUpstreamKey replaces the upstream key a virtual key uses for provider
+// without changing the virtual key. An empty provider means the primary one.
+func RotateUpstreamKey(ctx context.Context, store KeyStore, id, provider, upstreamKey string) error {
+	if upstreamKey == "" {
+		return fmt.Errorf("upstream key must not be empty")
+	}
+	k, err := store.LookupKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if provider == "" || provider == k.Provider {
+		return store.UpdateKey(ctx, id, map[string]interface{}{"actual_key": upstreamKey})
+	}
+	if _, ok := k.ProviderKeys[provider]; !ok {
+		return fmt.Errorf("%w %s", ErrProviderNotAllowed, provider)
+	}
+	providerKeys := make(map[string]string, len(k.ProviderKeys))
+	for p, v := range k.ProviderKeys {
+		providerKeys[p] = v
+	}
+	providerKeys[provider] = upstreamKey
+	return store.UpdateKey(ctx, id, map[string]interface{}{"provider_keys": providerKeys})
+}
@@ -0,0 +1,198 @@
+package apikeys
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"unicode/utf8"
+)
+
+// Scope violation codes returned to clients in 403 responses
+const (
+	ScopeModelNotAllowed    = "model_not_allowed"
+	ScopeEndpointNotAllowed = "endpoint_not_allowed"
+	ScopeMaxTokensExceeded  = "max_tokens_exceeded"
+	ScopeMaxTokensRequired  = "max_tokens_required"
+	ScopeSourceNotAllowed   = "source_ip_not_allowed"
+)
+
+// KeyScope restricts what a virtual key may be used for. Empty fields impose
+// no restriction. Model and endpoint patterns are globs in which * matches
+// any run of characters (including /) and ? matches one character.
+type KeyScope struct {
+	// AllowedModels lists the models the key may request
+	AllowedModels []string `dynamodbav:"allowed_models,omitempty" json:"allowed_models,omitempty" yaml:"allowed_models,omitempty"`
+	// DeniedModels lists models the key may not request; it wins over AllowedModels
+	DeniedModels []string `dynamodbav:"denied_models,omitempty" json:"denied_models,omitempty" yaml:"denied_models,omitempty"`
+	// AllowedEndpoints lists provider paths without the provider prefix, e.g. /v1/chat/completions
+	AllowedEndpoints []string `dynamodbav:"allowed_endpoints,omitempty" json:"allowed_endpoints,omitempty" yaml:"allowed_endpoints,omitempty"`
+	// MaxTokens caps max_tokens / max_output_tokens; requests that generate output must set one
+	MaxTokens int `dynamodbav:"max_tokens,omitempty" json:"max_tokens,omitempty" yaml:"max_tokens,omitempty"`
+	// AllowedCIDRs lists the source networks the key may be used from
+	AllowedCIDRs []string `dynamodbav:"allowed_cidrs,omitempty" json:"allowed_cidrs,omitempty" yaml:"allowed_cidrs,omitempty"`
+}
+
+// ScopeError describes a request that falls outside a key's scope
+type ScopeError struct {
+	Code    string
+	Message string
+}
+
+func (e *ScopeError) Error() string {
+	return e.Message
+}
+
+// IsEmpty reports whether the scope imposes no restriction
+func (s *KeyScope) IsEmpty() bool {
+	return s == nil || (len(s.AllowedModels) == 0 && len(s.DeniedModels) == 0 &&
+		len(s.AllowedEndpoints) == 0 && s.MaxTokens == 0 && len(s.AllowedCIDRs) == 0)
+}
+
+// RestrictsModel reports whether CheckModel can reject a request
+func (s *KeyScope) RestrictsModel() bool {
+	return s != nil && (len(s.AllowedModels) > 0 || len(s.DeniedModels) > 0)
+}
+
+// Validate checks that the scope's CIDRs parse and its limits are sane
+func (s *KeyScope) Validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative")
+	}
+	for _, cidr := range s.AllowedCIDRs {
+		if _, err := parseCIDR(cidr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckModel rejects models that are denied or not allowed. An empty model,
+// when a request that should name one does not, is only checked against an
+// allow list; callers skip requests that never name a model.
+func (s *KeyScope) CheckModel(model string) error {
+	if s == nil {
+		return nil
+	}
+	for _, pattern := range s.DeniedModels {
+		if model != "" && globMatch(pattern, model) {
+			return &ScopeError{Code: ScopeModelNotAllowed, Message: fmt.Sprintf("model %s is not allowed for this API key", model)}
+		}
+	}
+	if len(s.AllowedModels) == 0 {
+		return nil
+	}
+	for _, pattern := range s.AllowedModels {
+		if model != "" && globMatch(pattern, model) {
+			return nil
+		}
+	}
+	if model == "" {
+		return &ScopeError{Code: ScopeModelNotAllowed, Message: "this API key is restricted to specific models, but the request model could not be determined"}
+	}
+	return &ScopeError{Code: ScopeModelNotAllowed, Message: fmt.Sprintf("model %s is not allowed for this API key", model)}
+}
+
+// CheckEndpoint rejects endpoints outside AllowedEndpoints. path excludes the
+// provider prefix, e.g. /v1/chat/completions rather than /openai/v1/chat/completions.
+func (s *KeyScope) CheckEndpoint(path string) error {
+	if s == nil || len(s.AllowedEndpoints) == 0 {
+		return nil
+	}
+	for _, pattern := range s.AllowedEndpoints {
+		if globMatch(pattern, path) {
+			return nil
+		}
+	}
+	return &ScopeError{Code: ScopeEndpointNotAllowed, Message: fmt.Sprintf("endpoint %s is not allowed for this API key", path)}
+}
+
+// CheckMaxTokens rejects a requested output token limit above MaxTokens, or
+// no limit (0), which the provider would treat as its own maximum
+func (s *KeyScope) CheckMaxTokens(requested int) error {
+	if s == nil || s.MaxTokens == 0 {
+		return nil
+	}
+	if requested <= 0 {
+		return &ScopeError{Code: ScopeMaxTokensRequired, Message: fmt.Sprintf("this API key requires an output token limit of at most %d", s.MaxTokens)}
+	}
+	if requested <= s.MaxTokens {
+		return nil
+	}
+	return &ScopeError{Code: ScopeMaxTokensExceeded, Message: fmt.Sprintf("requested %d output tokens, this API key allows at most %d", requested, s.MaxTokens)}
+}
+
+// CheckSourceIP rejects source addresses outside AllowedCIDRs
+func (s *KeyScope) CheckSourceIP(addr netip.Addr) error {
+	if s == nil || len(s.AllowedCIDRs) == 0 {
+		return nil
+	}
+	addr = addr.Unmap()
+	for _, cidr := range s.AllowedCIDRs {
+		if prefix, err := parseCIDR(cidr); err == nil && prefix.Contains(addr) {
+			return nil
+		}
+	}
+	source := "unknown"
+	if addr.IsValid() {
+		source = addr.String()
+	}
+	return &ScopeError{Code: ScopeSourceNotAllowed, Message: fmt.Sprintf("source address %s is not allowed for this API key", source)}
+}
+
+// parseCIDR accepts a CIDR or a single address
+func parseCIDR(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", s, err)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", s, err)
+	}
+	return prefix.Masked(), nil
+}
+
+// globMatch matches s against a pattern where * matches any run of
+// characters and ? matches exactly one. It runs on every scoped request, so
+// it backtracks to the last * instead of compiling the pattern.
+func globMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, next := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch c := pattern[p]; c {
+			case '*':
+				star, next = p, i
+				p++
+				continue
+			case '?':
+				_, n := utf8.DecodeRuneInString(s[i:])
+				p, i = p+1, i+n
+				continue
+			default:
+				if s[i] == c {
+					p, i = p+1, i+1
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		// Let the last * swallow one more character and retry from there
+		_, n := utf8.DecodeRuneInString(s[next:])
+		next += n
+		p, i = star+1, next
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,106 @@
+package apikeys
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestKeyScopeChecks(t *testing.T) {
+	scope := &KeyScope{
+		AllowedModels:    []string{"gpt-4o*", "claude-3-5-haiku-*"},
+		DeniedModels:     []string{"gpt-4o-realtime*"},
+		AllowedEndpoints: []string{"/v1/chat/completions", "/v1/messages"},
+		MaxTokens:        1024,
+		AllowedCIDRs:     []string{"10.0.0.0/8", "192.168.1.7"},
+	}
+	if err := scope.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	code := func(err error) string {
+		var scopeErr *ScopeError
+		if errors.As(err, &scopeErr) {
+			return scopeErr.Code
+		}
+		return ""
+	}
+
+	for model, want := range map[string]string{
+		"gpt-4o-mini":                 "",
+		"claude-3-5-haiku-20241022":   "",
+		"gpt-4o-realtime-preview":     ScopeModelNotAllowed,
+		"o1-preview":                  ScopeModelNotAllowed,
+		"":                            ScopeModelNotAllowed,
+		"meta-llama/llama-3-70b-8192": ScopeModelNotAllowed,
+	} {
+		if got := code(scope.CheckModel(model)); got != want {
+			t.Errorf("CheckModel(%q) = %q, want %q", model, got, want)
+		}
+	}
+	if got := code(scope.CheckEndpoint("/v1/embeddings")); got != ScopeEndpointNotAllowed {
+		t.Errorf("CheckEndpoint(embeddings) = %q", got)
+	}
+	if err := scope.CheckEndpoint("/v1/messages"); err != nil {
+		t.Errorf("CheckEndpoint(messages): %v", err)
+	}
+	if err := scope.CheckMaxTokens(1024); err != nil {
+		t.Errorf("CheckMaxTokens(1024): %v", err)
+	}
+	if got := code(scope.CheckMaxTokens(4096)); got != ScopeMaxTokensExceeded {
+		t.Errorf("CheckMaxTokens(4096) = %q", got)
+	}
+	if got := code(scope.CheckMaxTokens(0)); got != ScopeMaxTokensRequired {
+		t.Errorf("CheckMaxTokens(0) = %q", got)
+	}
+	for addr, want := range map[string]string{
+		"10.1.2.3":        "",
+		"::ffff:10.1.2.3": "",
+		"192.168.1.7":     "",
+		"192.168.1.8":     ScopeSourceNotAllowed,
+		"2001:db8::1":     ScopeSourceNotAllowed,
+	} {
+		if got := code(scope.CheckSourceIP(netip.MustParseAddr(addr))); got != want {
+			t.Errorf("CheckSourceIP(%s) = %q, want %q", addr, got, want)
+		}
+	}
+
+	// A deny list alone allows everything else, including unknown models
+	denyOnly := &KeyScope{DeniedModels: []string{"*-preview"}}
+	if err := denyOnly.CheckModel(""); err != nil {
+		t.Errorf("deny-only scope with unknown model: %v", err)
+	}
+	if err := (*KeyScope)(nil).CheckModel("anything"); err != nil {
+		t.Errorf("nil scope: %v", err)
+	}
+	if err := (&KeyScope{AllowedCIDRs: []string{"not-a-cidr"}}).Validate(); err == nil {
+		t.Error("expected invalid CIDR to fail validation")
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"gpt-4o*", "gpt-4o", true},
+		{"gpt-4o*", "gpt-4o-mini", true},
+		{"gpt-4o*", "gpt-4", false},
+		{"*-preview", "o1-preview", true},
+		{"*-preview", "o1-preview-2", false},
+		{"meta-llama/*", "meta-llama/llama-3-70b", true},
+		{"/v1/*/completions", "/v1/chat/completions", true},
+		{"*a*b", "xaxxab", true},
+		{"*a*b", "xaxxa", false},
+		{"gpt-?o", "gpt-4o", true},
+		{"gpt-?o", "gpt-40o", false},
+		{"?", "é", true},
+		{"a.b", "axb", false},
+		{"", "", true},
+		{"*", "", true},
+	} {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
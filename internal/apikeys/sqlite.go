@@ -0,0 +1,492 @@
+package apikeys
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// sqliteDriver is the database/sql driver name registered by
+// modernc.org/sqlite, which the binaries import for its side effects
+const sqliteDriver = "sqlite"
+
+const sqlSchema = `CREATE TABLE IF NOT EXISTS api_keys (
+	pk               TEXT PRIMARY KEY,
+	display_prefix   TEXT NOT NULL DEFAULT '',
+	provider         TEXT NOT NULL,
+	actual_key       TEXT NOT NULL,
+	daily_cost_limit INTEGER NOT NULL DEFAULT 0,
+	description      TEXT NOT NULL DEFAULT '',
+	created_at       TEXT NOT NULL,
+	updated_at       TEXT NOT NULL,
+	expires_at       TEXT,
+	enabled          INTEGER NOT NULL DEFAULT 1,
+	tags             TEXT,
+	scope            TEXT,
+	provider_keys    TEXT,
+	rotated_from     TEXT NOT NULL DEFAULT '',
+	rotated_to       TEXT NOT NULL DEFAULT '',
+	lineage_id       TEXT NOT NULL DEFAULT '',
+	team_id          TEXT NOT NULL DEFAULT '',
+	project_id       TEXT NOT NULL DEFAULT '',
+	last_used_at     TEXT,
+	last_used_ip     TEXT NOT NULL DEFAULT '',
+	request_count    INTEGER NOT NULL DEFAULT 0,
+	token_count      INTEGER NOT NULL DEFAULT 0,
+	total_cost       REAL NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS api_keys_provider ON api_keys (provider);
+CREATE TABLE IF NOT EXISTS teams (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL DEFAULT '',
+	parent_id  TEXT NOT NULL DEFAULT '',
+	limits     TEXT,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL
+);`
+
+const sqlColumns = "pk, display_prefix, provider, actual_key, daily_cost_limit, description, created_at, updated_at, expires_at, enabled, tags, scope, provider_keys, rotated_from, rotated_to, lineage_id, team_id, project_id, last_used_at, last_used_ip, request_count, token_count, total_cost"
+
+const sqlTeamColumns = "id, name, parent_id, limits, created_at, updated_at"
+
+// SQLStore stores API keys in a SQL database using SQLite-compatible SQL
+type SQLStore struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewSQLiteStore opens (creating if needed) a SQLite database at path
+func NewSQLiteStore(path string, logger *slog.Logger) (*SQLStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sqlite key store requires a path")
+	}
+	if !slices.Contains(sql.Drivers(), sqliteDriver) {
+		return nil, fmt.Errorf("sqlite key store: no %q database/sql driver is linked into this binary", sqliteDriver)
+	}
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	// SQLite allows a single writer; serialise access rather than surfacing SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	return NewSQLStore(db, logger)
+}
+
+// NewSQLStore creates a key store on an open database, creating the table if needed
+func NewSQLStore(db *sql.DB, logger *slog.Logger) (*SQLStore, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if _, err := db.Exec(sqlSchema); err != nil {
+		return nil, fmt.Errorf("failed to create api_keys table: %w", err)
+	}
+	// Databases created before rotated keys shared rate limits lack lineage_id
+	if err := addColumn(db, "api_keys", "lineage_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, fmt.Errorf("failed to migrate api_keys table: %w", err)
+	}
+	return &SQLStore{db: db, logger: logger}, nil
+}
+
+// addColumn adds column to table unless it already exists
+func addColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// Close closes the underlying database
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// CreateKey creates a new API key record
+func (s *SQLStore) CreateKey(ctx context.Context, provider, actualKey, description string, dailyCostLimit int64, tags map[string]string) (*APIKey, error) {
+	apiKey, err := newAPIKey(provider, actualKey, description, dailyCostLimit, tags)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.PutKey(ctx, apiKey); err != nil {
+		return nil, fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	s.logger.Info("Created new API key",
+		"key", apiKey.DisplayPrefix,
+		"provider", provider,
+		"description", description,
+		"daily_cost_limit", dailyCostLimit)
+	return apiKey, nil
+}
+
+// GetKey retrieves a usable API key by the iw: prefixed key presented by a client
+func (s *SQLStore) GetKey(ctx context.Context, key string) (*APIKey, error) {
+	return getUsableKey(ctx, s.LookupKey, key)
+}
+
+// LookupKey retrieves a record by its stored ID
+func (s *SQLStore) LookupKey(ctx context.Context, id string) (*APIKey, error) {
+	apiKey, err := scanKey(s.db.QueryRowContext(ctx, "SELECT "+sqlColumns+" FROM api_keys WHERE pk = ?", id))
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return apiKey, nil
+}
+
+// PutKey writes a complete record, failing with ErrKeyExists if its PK is taken
+func (s *SQLStore) PutKey(ctx context.Context, apiKey *APIKey) error {
+	if _, err := s.LookupKey(ctx, apiKey.PK); err == nil {
+		return ErrKeyExists
+	}
+	return s.insert(ctx, apiKey)
+}
+
+// UpdateKey updates an existing API key
+func (s *SQLStore) UpdateKey(ctx context.Context, key string, updates map[string]interface{}) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	defer tx.Rollback()
+
+	apiKey, err := scanKey(tx.QueryRowContext(ctx, "SELECT "+sqlColumns+" FROM api_keys WHERE pk = ?", key))
+	if err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	if err := applyUpdates(apiKey, updates, time.Now()); err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	tags, err := marshalStringMap(apiKey.Tags)
+	if err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	scope, err := marshalScope(apiKey.Scope)
+	if err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	providerKeys, err := marshalStringMap(apiKey.ProviderKeys)
+	if err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE api_keys SET actual_key = ?, daily_cost_limit = ?, description = ?, updated_at = ?, expires_at = ?, enabled = ?, tags = ?, scope = ?, provider_keys = ?, rotated_from = ?, rotated_to = ?, team_id = ?, project_id = ? WHERE pk = ?`,
+		apiKey.ActualKey, apiKey.DailyCostLimit, apiKey.Description, formatTime(apiKey.UpdatedAt),
+		formatTimePtr(apiKey.ExpiresAt), apiKey.Enabled, tags, scope, providerKeys, apiKey.RotatedFrom, apiKey.RotatedTo,
+		apiKey.TeamID, apiKey.ProjectID, key)
+	if err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+
+	s.logger.Info("Updated API key", "key", key, "updates", loggableUpdates(updates))
+	return nil
+}
+
+// RecordUsage adds usage to a key's counters without touching updated_at
+func (s *SQLStore) RecordUsage(ctx context.Context, id string, usage KeyUsage) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE api_keys SET last_used_at = ?, last_used_ip = COALESCE(NULLIF(?, ''), last_used_ip), request_count = request_count + ?, token_count = token_count + ?, total_cost = total_cost + ? WHERE pk = ?`,
+		formatTime(usage.LastUsedAt), usage.LastUsedIP, usage.Requests, usage.Tokens, usage.Cost, id)
+	if err != nil {
+		return fmt.Errorf("failed to record API key usage: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("failed to record API key usage: %w", ErrKeyNotFound)
+	}
+	return nil
+}
+
+// DeleteKey deletes an API key
+func (s *SQLStore) DeleteKey(ctx context.Context, key string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM api_keys WHERE pk = ?", key)
+	if err != nil {
+		return fmt.Errorf("failed to delete API key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("failed to delete API key: %w", ErrKeyNotFound)
+	}
+
+	s.logger.Info("Deleted API key", "key", key)
+	return nil
+}
+
+// ListKeys lists all API keys, optionally filtered by provider
+func (s *SQLStore) ListKeys(ctx context.Context, provider string) ([]*APIKey, error) {
+	query := "SELECT " + sqlColumns + " FROM api_keys"
+	var args []interface{}
+	if provider != "" {
+		query += " WHERE provider = ?"
+		args = append(args, provider)
+	}
+	query += " ORDER BY created_at, pk"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		apiKey, err := scanKey(rows)
+		if err != nil {
+			s.logger.Warn("Failed to read API key row", "error", err)
+			continue
+		}
+		keys = append(keys, apiKey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// ValidateAndGetActualKey validates an API key and returns the actual provider key
+func (s *SQLStore) ValidateAndGetActualKey(ctx context.Context, key string) (string, string, error) {
+	return validateAndGetActualKey(ctx, s, key)
+}
+
+// ValidateAndGetProviderKey validates an API key and returns its upstream key for provider
+func (s *SQLStore) ValidateAndGetProviderKey(ctx context.Context, key, provider string) (string, error) {
+	return validateAndGetProviderKey(ctx, s, key, provider)
+}
+
+// PutTeam creates or replaces a team record
+func (s *SQLStore) PutTeam(ctx context.Context, team *Team) error {
+	var limits interface{}
+	if team.Limits != nil {
+		b, err := json.Marshal(team.Limits)
+		if err != nil {
+			return fmt.Errorf("failed to save team: %w", err)
+		}
+		limits = string(b)
+	}
+	_, err := s.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO teams ("+sqlTeamColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+		team.ID, team.Name, team.ParentID, limits, formatTime(team.CreatedAt), formatTime(team.UpdatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to save team: %w", err)
+	}
+
+	s.logger.Info("Saved team", "team", team.ID, "parent", team.ParentID)
+	return nil
+}
+
+// GetTeam retrieves a team or project by ID
+func (s *SQLStore) GetTeam(ctx context.Context, id string) (*Team, error) {
+	team, err := scanTeam(s.db.QueryRowContext(ctx, "SELECT "+sqlTeamColumns+" FROM teams WHERE id = ?", id))
+	if errors.Is(err, ErrTeamNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get team: %w", err)
+	}
+	return team, nil
+}
+
+// ListTeams lists all teams and projects
+func (s *SQLStore) ListTeams(ctx context.Context) ([]*Team, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqlTeamColumns+" FROM teams ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list teams: %w", err)
+	}
+	defer rows.Close()
+
+	var teams []*Team
+	for rows.Next() {
+		team, err := scanTeam(rows)
+		if err != nil {
+			s.logger.Warn("Failed to read team row", "error", err)
+			continue
+		}
+		teams = append(teams, team)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list teams: %w", err)
+	}
+	return teams, nil
+}
+
+// DeleteTeam deletes a team or project
+func (s *SQLStore) DeleteTeam(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM teams WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete team: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("failed to delete team: %w", ErrTeamNotFound)
+	}
+
+	s.logger.Info("Deleted team", "team", id)
+	return nil
+}
+
+// insert writes a new key record
+func (s *SQLStore) insert(ctx context.Context, k *APIKey) error {
+	tags, err := marshalStringMap(k.Tags)
+	if err != nil {
+		return err
+	}
+	scope, err := marshalScope(k.Scope)
+	if err != nil {
+		return err
+	}
+	providerKeys, err := marshalStringMap(k.ProviderKeys)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		"INSERT INTO api_keys ("+sqlColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		k.PK, k.DisplayPrefix, k.Provider, k.ActualKey, k.DailyCostLimit, k.Description,
+		formatTime(k.CreatedAt), formatTime(k.UpdatedAt), formatTimePtr(k.ExpiresAt), k.Enabled, tags, scope, providerKeys,
+		k.RotatedFrom, k.RotatedTo, k.LineageID, k.TeamID, k.ProjectID,
+		formatTimePtr(k.LastUsedAt), k.LastUsedIP, k.RequestCount, k.TokenCount, k.TotalCost)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanKey(row rowScanner) (*APIKey, error) {
+	var (
+		k                    APIKey
+		createdAt, updatedAt string
+		expiresAt, tags      sql.NullString
+		scope, providerKeys  sql.NullString
+		lastUsedAt           sql.NullString
+	)
+	err := row.Scan(&k.PK, &k.DisplayPrefix, &k.Provider, &k.ActualKey, &k.DailyCostLimit, &k.Description,
+		&createdAt, &updatedAt, &expiresAt, &k.Enabled, &tags, &scope, &providerKeys, &k.RotatedFrom, &k.RotatedTo, &k.LineageID,
+		&k.TeamID, &k.ProjectID, &lastUsedAt, &k.LastUsedIP, &k.RequestCount, &k.TokenCount, &k.TotalCost)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if k.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return nil, fmt.Errorf("invalid created_at: %w", err)
+	}
+	if k.UpdatedAt, err = time.Parse(time.RFC3339Nano, updatedAt); err != nil {
+		return nil, fmt.Errorf("invalid updated_at: %w", err)
+	}
+	if expiresAt.Valid && expiresAt.String != "" {
+		t, err := time.Parse(time.RFC3339Nano, expiresAt.String)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expires_at: %w", err)
+		}
+		k.ExpiresAt = &t
+	}
+	if lastUsedAt.Valid && lastUsedAt.String != "" {
+		t, err := time.Parse(time.RFC3339Nano, lastUsedAt.String)
+		if err != nil {
+			return nil, fmt.Errorf("invalid last_used_at: %w", err)
+		}
+		k.LastUsedAt = &t
+	}
+	if tags.Valid && tags.String != "" {
+		if err := json.Unmarshal([]byte(tags.String), &k.Tags); err != nil {
+			return nil, fmt.Errorf("invalid tags: %w", err)
+		}
+	}
+	if scope.Valid && scope.String != "" {
+		if err := json.Unmarshal([]byte(scope.String), &k.Scope); err != nil {
+			return nil, fmt.Errorf("invalid scope: %w", err)
+		}
+	}
+	if providerKeys.Valid && providerKeys.String != "" {
+		if err := json.Unmarshal([]byte(providerKeys.String), &k.ProviderKeys); err != nil {
+			return nil, fmt.Errorf("invalid provider_keys: %w", err)
+		}
+	}
+	return &k, nil
+}
+
+func scanTeam(row rowScanner) (*Team, error) {
+	var (
+		t                    Team
+		limits               sql.NullString
+		createdAt, updatedAt string
+	)
+	err := row.Scan(&t.ID, &t.Name, &t.ParentID, &limits, &createdAt, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTeamNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return nil, fmt.Errorf("invalid created_at: %w", err)
+	}
+	if t.UpdatedAt, err = time.Parse(time.RFC3339Nano, updatedAt); err != nil {
+		return nil, fmt.Errorf("invalid updated_at: %w", err)
+	}
+	if limits.Valid && limits.String != "" {
+		if err := json.Unmarshal([]byte(limits.String), &t.Limits); err != nil {
+			return nil, fmt.Errorf("invalid limits: %w", err)
+		}
+	}
+	return &t, nil
+}
+
+func marshalStringMap(tags map[string]string) (interface{}, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func marshalScope(scope *KeyScope) (interface{}, error) {
+	if scope.IsEmpty() {
+		return nil, nil
+	}
+	b, err := json.Marshal(scope)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// sqlTimeFormat is RFC 3339 with fixed-width nanoseconds so stored times sort lexically
+const sqlTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(sqlTimeFormat)
+}
+
+func formatTimePtr(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return formatTime(*t)
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
@@ -23,14 +26,34 @@ const (
 	KeyLength = 32
 )
 
+// Errors returned by GetKey for keys that exist in an unusable state or not at all.
+// Any other error indicates the store itself could not be reached.
+var (
+	ErrKeyNotFound = errors.New("API key not found")
+	ErrKeyExpired  = errors.New("API key has expired")
+	ErrKeyDisabled = errors.New("API key is disabled")
+)
+
+// ErrProviderNotAllowed is returned when a key has no upstream key for the requested provider
+var ErrProviderNotAllowed = errors.New("API key is not configured for provider")
+
 // APIKey represents an API key record in DynamoDB
 type APIKey struct {
-	// PK is the primary key (the iw: prefixed key)
+	// PK is the primary key: the SHA-256 digest of the iw: key (see HashKey).
+	// Records created before keys were hashed use the plaintext key.
 	PK string `dynamodbav:"pk"`
+	// DisplayPrefix is the first characters of the iw: key, kept for humans
+	DisplayPrefix string `dynamodbav:"display_prefix,omitempty"`
+	// Key is the plaintext iw: key. It is only set on the record returned by
+	// CreateKey and is never stored.
+	Key string `dynamodbav:"-"`
 	// Provider is the LLM provider (openai, anthropic, gemini)
 	Provider string `dynamodbav:"provider"`
 	// ActualKey is the real API key for the provider
 	ActualKey string `dynamodbav:"actual_key"`
+	// ProviderKeys maps further providers to their upstream keys, so one
+	// virtual key works across providers (optional)
+	ProviderKeys map[string]string `dynamodbav:"provider_keys,omitempty"`
 	// DailyCostLimit is the 24-hour cost limit in cents
 	DailyCostLimit int64 `dynamodbav:"daily_cost_limit"`
 	// Description is an optional description of the key
@@ -45,6 +68,72 @@ type APIKey struct {
 	Enabled bool `dynamodbav:"enabled"`
 	// Tags for organizational purposes
 	Tags map[string]string `dynamodbav:"tags,omitempty"`
+	// Scope restricts the models, endpoints, output tokens and source networks
+	// the key may be used for (optional)
+	Scope *KeyScope `dynamodbav:"scope,omitempty"`
+	// RotatedFrom is the ID of the key this one replaced (see RotateKey)
+	RotatedFrom string `dynamodbav:"rotated_from,omitempty"`
+	// RotatedTo is the ID of the key that replaced this one
+	RotatedTo string `dynamodbav:"rotated_to,omitempty"`
+	// LineageID is the ID of the first key in this key's rotation chain, set
+	// on successors so the chain shares one set of rate limits (see RateLimitID)
+	LineageID string `dynamodbav:"lineage_id,omitempty"`
+	// TeamID is the team the key belongs to; its budgets and limits apply to the key (optional)
+	TeamID string `dynamodbav:"team_id,omitempty"`
+	// ProjectID is a project under TeamID that the key's cost is attributed to (optional)
+	ProjectID string `dynamodbav:"project_id,omitempty"`
+	// LastUsedAt is when the key last authenticated a request (see UsageTracker)
+	LastUsedAt *time.Time `dynamodbav:"last_used_at,omitempty"`
+	// LastUsedIP is the client address of that request
+	LastUsedIP string `dynamodbav:"last_used_ip,omitempty"`
+	// RequestCount, TokenCount and TotalCost (USD) are running totals since the key was created
+	RequestCount int64   `dynamodbav:"request_count,omitempty"`
+	TokenCount   int64   `dynamodbav:"token_count,omitempty"`
+	TotalCost    float64 `dynamodbav:"total_cost,omitempty"`
+}
+
+// RateLimitID returns the ID the key's rate limits and per_key overrides are
+// kept under: its lineage ID for rotated keys, otherwise its own key ID
+func (k *APIKey) RateLimitID() string {
+	if k.LineageID != "" {
+		return k.LineageID
+	}
+	return KeyID(k.PK)
+}
+
+// UpstreamKey returns the upstream key to use for provider
+func (k *APIKey) UpstreamKey(provider string) (string, bool) {
+	if provider == k.Provider {
+		return k.ActualKey, true
+	}
+	upstream, ok := k.ProviderKeys[provider]
+	return upstream, ok && upstream != ""
+}
+
+// Providers returns every provider the key can be used with, primary first
+func (k *APIKey) Providers() []string {
+	out := []string{k.Provider}
+	for _, p := range slices.Sorted(maps.Keys(k.ProviderKeys)) {
+		if p != k.Provider {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Usable reports whether the key can be used at the given time, returning
+// ErrKeyExpired or ErrKeyDisabled otherwise.
+func (k *APIKey) Usable(now time.Time) error {
+	// Check if key is expired
+	if k.ExpiresAt != nil && k.ExpiresAt.Before(now) {
+		return ErrKeyExpired
+	}
+
+	// Check if key is enabled
+	if !k.Enabled {
+		return ErrKeyDisabled
+	}
+	return nil
 }
 
 // Store handles API key storage in DynamoDB
@@ -169,31 +258,33 @@ func GenerateKey() (string, error) {
 	return KeyPrefix + hex.EncodeToString(bytes), nil
 }
 
-// CreateKey creates a new API key record
+// CreateKey creates a new API key record. Only the key's digest is stored;
+// the plaintext key is returned once in APIKey.Key.
 func (s *Store) CreateKey(ctx context.Context, provider, actualKey, description string, dailyCostLimit int64, tags map[string]string) (*APIKey, error) {
-	// Generate new key
-	newKey, err := GenerateKey()
+	apiKey, err := newAPIKey(provider, actualKey, description, dailyCostLimit, tags)
 	if err != nil {
 		return nil, err
 	}
 
-	now := time.Now()
-	apiKey := &APIKey{
-		PK:             newKey,
-		Provider:       provider,
-		ActualKey:      actualKey,
-		DailyCostLimit: dailyCostLimit,
-		Description:    description,
-		CreatedAt:      now,
-		UpdatedAt:      now,
-		Enabled:        true,
-		Tags:           tags,
+	if err := s.PutKey(ctx, apiKey); err != nil {
+		return nil, fmt.Errorf("failed to create API key: %w", err)
 	}
 
+	s.logger.Info("Created new API key",
+		"key", apiKey.DisplayPrefix,
+		"provider", provider,
+		"description", description,
+		"daily_cost_limit", dailyCostLimit)
+
+	return apiKey, nil
+}
+
+// PutKey writes a complete record, failing with ErrKeyExists if its PK is taken
+func (s *Store) PutKey(ctx context.Context, apiKey *APIKey) error {
 	// Marshal to DynamoDB attribute values
 	av, err := attributevalue.MarshalMap(apiKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal API key: %w", err)
+		return fmt.Errorf("failed to marshal API key: %w", err)
 	}
 
 	// Put item with condition that it doesn't already exist
@@ -202,30 +293,24 @@ func (s *Store) CreateKey(ctx context.Context, provider, actualKey, description
 		Item:                av,
 		ConditionExpression: aws.String("attribute_not_exists(pk)"),
 	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create API key: %w", err)
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrKeyExists
 	}
-
-	s.logger.Info("Created new API key",
-		"key", newKey,
-		"provider", provider,
-		"description", description,
-		"daily_cost_limit", dailyCostLimit)
-
-	return apiKey, nil
+	return err
 }
 
-// GetKey retrieves an API key by its iw: prefixed key
+// GetKey retrieves a usable API key by the iw: prefixed key presented by a client
 func (s *Store) GetKey(ctx context.Context, key string) (*APIKey, error) {
-	// Validate key format
-	if !strings.HasPrefix(key, KeyPrefix) {
-		return nil, fmt.Errorf("invalid key format: must start with %s", KeyPrefix)
-	}
+	return getUsableKey(ctx, s.LookupKey, key)
+}
 
+// LookupKey retrieves a record by its stored ID without checking whether it is usable
+func (s *Store) LookupKey(ctx context.Context, id string) (*APIKey, error) {
 	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(s.tableName),
 		Key: map[string]types.AttributeValue{
-			"pk": &types.AttributeValueMemberS{Value: key},
+			"pk": &types.AttributeValueMemberS{Value: id},
 		},
 	})
 	if err != nil {
@@ -233,28 +318,17 @@ func (s *Store) GetKey(ctx context.Context, key string) (*APIKey, error) {
 	}
 
 	if result.Item == nil {
-		return nil, fmt.Errorf("API key not found")
+		return nil, ErrKeyNotFound
 	}
 
 	var apiKey APIKey
 	if err := attributevalue.UnmarshalMap(result.Item, &apiKey); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal API key: %w", err)
 	}
-
-	// Check if key is expired
-	if apiKey.ExpiresAt != nil && apiKey.ExpiresAt.Before(time.Now()) {
-		return nil, fmt.Errorf("API key has expired")
-	}
-
-	// Check if key is enabled
-	if !apiKey.Enabled {
-		return nil, fmt.Errorf("API key is disabled")
-	}
-
 	return &apiKey, nil
 }
 
-// UpdateKey updates an existing API key
+// UpdateKey updates an existing API key by its stored ID
 func (s *Store) UpdateKey(ctx context.Context, key string, updates map[string]interface{}) error {
 	// Build update expression
 	var updateExpr strings.Builder
@@ -292,6 +366,32 @@ func (s *Store) UpdateKey(ctx context.Context, key string, updates map[string]in
 				av, _ := attributevalue.Marshal(tags)
 				exprAttrValues[":tags"] = av
 			}
+		case "rotated_from":
+			updateExpr.WriteString(", rotated_from = :rotated_from")
+			exprAttrValues[":rotated_from"] = &types.AttributeValueMemberS{Value: value.(string)}
+		case "rotated_to":
+			updateExpr.WriteString(", rotated_to = :rotated_to")
+			exprAttrValues[":rotated_to"] = &types.AttributeValueMemberS{Value: value.(string)}
+		case "team_id":
+			updateExpr.WriteString(", team_id = :team_id")
+			exprAttrValues[":team_id"] = &types.AttributeValueMemberS{Value: value.(string)}
+		case "project_id":
+			updateExpr.WriteString(", project_id = :project_id")
+			exprAttrValues[":project_id"] = &types.AttributeValueMemberS{Value: value.(string)}
+		case "provider_keys":
+			updateExpr.WriteString(", provider_keys = :provider_keys")
+			if providerKeys, ok := value.(map[string]string); ok {
+				av, _ := attributevalue.Marshal(providerKeys)
+				exprAttrValues[":provider_keys"] = av
+			}
+		case "scope":
+			// A nil scope is stored as NULL, which reads back as no scope
+			updateExpr.WriteString(", #scope = :scope")
+			exprAttrNames["#scope"] = "scope"
+			if scope, ok := value.(*KeyScope); ok {
+				av, _ := attributevalue.Marshal(scope)
+				exprAttrValues[":scope"] = av
+			}
 		}
 	}
 
@@ -311,14 +411,47 @@ func (s *Store) UpdateKey(ctx context.Context, key string, updates map[string]in
 
 	_, err := s.client.UpdateItem(ctx, input)
 	if err != nil {
-		return fmt.Errorf("failed to update API key: %w", err)
+		return fmt.Errorf("failed to update API key: %w", notFoundIfConditionFailed(err))
 	}
 
-	s.logger.Info("Updated API key", "key", key, "updates", updates)
+	s.logger.Info("Updated API key", "key", key, "updates", loggableUpdates(updates))
 	return nil
 }
 
-// DeleteKey deletes an API key
+// RecordUsage adds usage to a key's counters without touching updated_at
+func (s *Store) RecordUsage(ctx context.Context, id string, usage KeyUsage) error {
+	lastUsed, err := attributevalue.Marshal(usage.LastUsedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record API key usage: %w", err)
+	}
+	values := map[string]types.AttributeValue{
+		":last_used_at": lastUsed,
+		":requests":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", usage.Requests)},
+		":tokens":       &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", usage.Tokens)},
+		":cost":         &types.AttributeValueMemberN{Value: fmt.Sprintf("%g", usage.Cost)},
+	}
+	set := "SET last_used_at = :last_used_at"
+	if usage.LastUsedIP != "" {
+		set += ", last_used_ip = :last_used_ip"
+		values[":last_used_ip"] = &types.AttributeValueMemberS{Value: usage.LastUsedIP}
+	}
+
+	_, err = s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:          aws.String(set + " ADD request_count :requests, token_count :tokens, total_cost :cost"),
+		ExpressionAttributeValues: values,
+		ConditionExpression:       aws.String("attribute_exists(pk)"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record API key usage: %w", notFoundIfConditionFailed(err))
+	}
+	return nil
+}
+
+// DeleteKey deletes an API key by its stored ID
 func (s *Store) DeleteKey(ctx context.Context, key string) error {
 	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
 		TableName: aws.String(s.tableName),
@@ -328,7 +461,7 @@ func (s *Store) DeleteKey(ctx context.Context, key string) error {
 		ConditionExpression: aws.String("attribute_exists(pk)"),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to delete API key: %w", err)
+		return fmt.Errorf("failed to delete API key: %w", notFoundIfConditionFailed(err))
 	}
 
 	s.logger.Info("Deleted API key", "key", key)
@@ -362,9 +495,13 @@ func (s *Store) ListKeys(ctx context.Context, provider string) ([]*APIKey, error
 			keys = append(keys, &apiKey)
 		}
 	} else {
-		// Scan all keys
+		// Scan all keys, skipping team records stored in the same table
 		result, err := s.client.Scan(ctx, &dynamodb.ScanInput{
-			TableName: aws.String(s.tableName),
+			TableName:        aws.String(s.tableName),
+			FilterExpression: aws.String("NOT begins_with(pk, :team)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":team": &types.AttributeValueMemberS{Value: teamPKPrefix},
+			},
 		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan API keys: %w", err)
@@ -383,19 +520,116 @@ func (s *Store) ListKeys(ctx context.Context, provider string) ([]*APIKey, error
 	return keys, nil
 }
 
-// ValidateAndGetActualKey validates an API key and returns the actual provider key
-func (s *Store) ValidateAndGetActualKey(ctx context.Context, key string) (string, string, error) {
-	// If key doesn't have our prefix, return it as-is (passthrough)
-	if !strings.HasPrefix(key, KeyPrefix) {
-		return key, "", nil
+// teamPKPrefix marks team records, which share the key table
+const teamPKPrefix = "team#"
+
+// teamItem is a team record as stored in the key table
+type teamItem struct {
+	PK string `dynamodbav:"pk"`
+	Team
+}
+
+// PutTeam creates or replaces a team record
+func (s *Store) PutTeam(ctx context.Context, team *Team) error {
+	av, err := attributevalue.MarshalMap(teamItem{PK: teamPKPrefix + team.ID, Team: *team})
+	if err != nil {
+		return fmt.Errorf("failed to marshal team: %w", err)
 	}
+	if _, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      av,
+	}); err != nil {
+		return fmt.Errorf("failed to save team: %w", err)
+	}
+
+	s.logger.Info("Saved team", "team", team.ID, "parent", team.ParentID)
+	return nil
+}
 
-	// Look up the key in DynamoDB
-	apiKey, err := s.GetKey(ctx, key)
+// GetTeam retrieves a team or project by ID
+func (s *Store) GetTeam(ctx context.Context, id string) (*Team, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: teamPKPrefix + id},
+		},
+	})
 	if err != nil {
-		return "", "", fmt.Errorf("invalid API key: %w", err)
+		return nil, fmt.Errorf("failed to get team: %w", err)
+	}
+	if result.Item == nil {
+		return nil, ErrTeamNotFound
 	}
 
-	// Return the actual provider key and provider name
-	return apiKey.ActualKey, apiKey.Provider, nil
+	var item teamItem
+	if err := attributevalue.UnmarshalMap(result.Item, &item); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal team: %w", err)
+	}
+	return &item.Team, nil
+}
+
+// ListTeams lists all teams and projects
+func (s *Store) ListTeams(ctx context.Context) ([]*Team, error) {
+	result, err := s.client.Scan(ctx, &dynamodb.ScanInput{
+		TableName:        aws.String(s.tableName),
+		FilterExpression: aws.String("begins_with(pk, :team)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":team": &types.AttributeValueMemberS{Value: teamPKPrefix},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan teams: %w", err)
+	}
+
+	teams := make([]*Team, 0, len(result.Items))
+	for _, av := range result.Items {
+		var item teamItem
+		if err := attributevalue.UnmarshalMap(av, &item); err != nil {
+			s.logger.Warn("Failed to unmarshal team", "error", err)
+			continue
+		}
+		teams = append(teams, &item.Team)
+	}
+	sortTeams(teams)
+	return teams, nil
+}
+
+// DeleteTeam deletes a team or project
+func (s *Store) DeleteTeam(ctx context.Context, id string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: teamPKPrefix + id},
+		},
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return fmt.Errorf("failed to delete team: %w", ErrTeamNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete team: %w", err)
+	}
+
+	s.logger.Info("Deleted team", "team", id)
+	return nil
+}
+
+// notFoundIfConditionFailed maps a failed attribute_exists(pk) condition to ErrKeyNotFound
+func notFoundIfConditionFailed(err error) error {
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+// ValidateAndGetActualKey validates an API key and returns the actual provider key
+func (s *Store) ValidateAndGetActualKey(ctx context.Context, key string) (string, string, error) {
+	return validateAndGetActualKey(ctx, s, key)
+}
+
+// ValidateAndGetProviderKey validates an API key and returns its upstream key for provider
+func (s *Store) ValidateAndGetProviderKey(ctx context.Context, key, provider string) (string, error) {
+	return validateAndGetProviderKey(ctx, s, key, provider)
 }
@@ -0,0 +1,202 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/config"
+)
+
+// Errors returned by team operations
+var (
+	ErrTeamNotFound = errors.New("team not found")
+	ErrTeamInUse    = errors.New("team still has keys or projects")
+)
+
+// teamIDPattern keeps team IDs usable in rate limit scope keys and metric tags
+var teamIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Team groups keys for budgets, rate limits and cost attribution. A team with
+// a ParentID is a project under that team; projects only attribute cost, so
+// budgets and limits are set on the top-level team and shared by all its keys.
+type Team struct {
+	// ID is the stable identifier used on keys, in limits and in cost records
+	ID string `dynamodbav:"team_id" json:"id" yaml:"id"`
+	// Name is an optional human-readable name
+	Name string `dynamodbav:"name,omitempty" json:"name,omitempty" yaml:"name,omitempty"`
+	// ParentID is the owning team for projects, empty for teams
+	ParentID string `dynamodbav:"parent_id,omitempty" json:"parent_id,omitempty" yaml:"parent_id,omitempty"`
+	// Limits are the team's rate limits and cost budgets (optional). They
+	// apply as rate_limiting.overrides.per_team entries unless the config
+	// already has one for the team.
+	Limits *config.LimitsConfig `dynamodbav:"limits,omitempty" json:"limits,omitempty" yaml:"limits,omitempty"`
+	// CreatedAt is when the team was created
+	CreatedAt time.Time `dynamodbav:"created_at" json:"created_at,omitempty" yaml:"created_at,omitempty"`
+	// UpdatedAt is when the team was last updated
+	UpdatedAt time.Time `dynamodbav:"updated_at" json:"updated_at,omitempty" yaml:"updated_at,omitempty"`
+}
+
+// IsProject reports whether the team is a project under another team
+func (t *Team) IsProject() bool {
+	return t.ParentID != ""
+}
+
+// clone returns a copy of the team that does not share its limits
+func (t *Team) clone() *Team {
+	copied := *t
+	if t.Limits != nil {
+		limits := *t.Limits
+		copied.Limits = &limits
+	}
+	return &copied
+}
+
+// TeamStore is implemented by every API key backend alongside KeyStore
+type TeamStore interface {
+	// PutTeam creates or replaces a team record
+	PutTeam(ctx context.Context, team *Team) error
+	GetTeam(ctx context.Context, id string) (*Team, error)
+	// ListTeams returns teams and projects ordered by ID
+	ListTeams(ctx context.Context) ([]*Team, error)
+	DeleteTeam(ctx context.Context, id string) error
+}
+
+// SaveTeam validates a team or project and writes it, preserving CreatedAt
+// of an existing record
+func SaveTeam(ctx context.Context, store TeamStore, team *Team) error {
+	if !teamIDPattern.MatchString(team.ID) {
+		return fmt.Errorf("invalid team ID %q: use letters, digits, '.', '_' and '-'", team.ID)
+	}
+	if team.ParentID != "" {
+		if team.ParentID == team.ID {
+			return fmt.Errorf("team %s cannot be its own parent", team.ID)
+		}
+		parent, err := store.GetTeam(ctx, team.ParentID)
+		if err != nil {
+			return fmt.Errorf("failed to get parent team %s: %w", team.ParentID, err)
+		}
+		if parent.IsProject() {
+			return fmt.Errorf("%s is a project; projects cannot be nested", parent.ID)
+		}
+		if team.Limits != nil {
+			return fmt.Errorf("limits can only be set on teams, not projects")
+		}
+	}
+	if team.Limits != nil && (team.Limits.CostPerMinute < 0 || team.Limits.CostPerHour < 0 || team.Limits.CostPerDay < 0) {
+		return fmt.Errorf("team cost limits cannot be negative")
+	}
+
+	now := time.Now()
+	copied := *team
+	copied.CreatedAt, copied.UpdatedAt = now, now
+	existing, err := store.GetTeam(ctx, team.ID)
+	switch {
+	case err == nil:
+		if existing.IsProject() != copied.IsProject() {
+			return fmt.Errorf("cannot convert %s between a team and a project", team.ID)
+		}
+		copied.CreatedAt = existing.CreatedAt
+	case !errors.Is(err, ErrTeamNotFound):
+		return fmt.Errorf("failed to get team: %w", err)
+	}
+	return store.PutTeam(ctx, &copied)
+}
+
+// RemoveTeam deletes a team or project, failing with ErrTeamInUse while
+// keys or projects still reference it
+func RemoveTeam(ctx context.Context, store KeyStore, id string) error {
+	if _, err := store.GetTeam(ctx, id); err != nil {
+		return err
+	}
+	teams, err := store.ListTeams(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list teams: %w", err)
+	}
+	for _, t := range teams {
+		if t.ParentID == id {
+			return fmt.Errorf("%w: project %s", ErrTeamInUse, t.ID)
+		}
+	}
+	keys, err := store.ListKeys(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+	for _, k := range keys {
+		if k.TeamID == id || k.ProjectID == id {
+			return fmt.Errorf("%w: key %s", ErrTeamInUse, keyLabel(k))
+		}
+	}
+	return store.DeleteTeam(ctx, id)
+}
+
+// AssignTeam moves the key stored under id to a team and, optionally, one of
+// the team's projects. An empty teamID removes the key from its team.
+func AssignTeam(ctx context.Context, store KeyStore, id, teamID, projectID string) error {
+	if teamID == "" && projectID != "" {
+		return fmt.Errorf("a project requires a team")
+	}
+	if teamID != "" {
+		team, err := store.GetTeam(ctx, teamID)
+		if err != nil {
+			return fmt.Errorf("failed to get team %s: %w", teamID, err)
+		}
+		if team.IsProject() {
+			return fmt.Errorf("%s is a project of team %s; assign the team and use it as the project", teamID, team.ParentID)
+		}
+	}
+	if projectID != "" {
+		project, err := store.GetTeam(ctx, projectID)
+		if err != nil {
+			return fmt.Errorf("failed to get project %s: %w", projectID, err)
+		}
+		if project.ParentID != teamID {
+			return fmt.Errorf("%s is not a project of team %s", projectID, teamID)
+		}
+	}
+	return store.UpdateKey(ctx, id, map[string]interface{}{
+		"team_id":    teamID,
+		"project_id": projectID,
+	})
+}
+
+// TeamLimits returns the limits of every team that sets them, keyed by team ID
+func TeamLimits(ctx context.Context, store TeamStore) (map[string]config.LimitsConfig, error) {
+	teams, err := store.ListTeams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	limits := make(map[string]config.LimitsConfig)
+	for _, t := range teams {
+		if t.Limits != nil && !t.IsProject() {
+			limits[t.ID] = *t.Limits
+		}
+	}
+	return limits, nil
+}
+
+// keyLabel identifies a key record in messages, masking legacy plaintext IDs
+func keyLabel(k *APIKey) string {
+	if IsKeyID(k.PK) {
+		return k.PK
+	}
+	return MaskSecret(k.PK)
+}
+
+// sortTeams orders teams by ID
+func sortTeams(teams []*Team) {
+	sort.Slice(teams, func(i, j int) bool { return teams[i].ID < teams[j].ID })
+}
+
+// listTeams copies teams ordered by ID
+func listTeams(teams map[string]*Team) []*Team {
+	out := make([]*Team, 0, len(teams))
+	for _, t := range teams {
+		out = append(out, t.clone())
+	}
+	sortTeams(out)
+	return out
+}
@@ -0,0 +1,103 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Instawork/llm-proxy/internal/config"
+)
+
+func TestTeamHierarchy(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(&APIKey{PK: HashKey("iw:k"), Provider: "openai", ActualKey: "sk", Enabled: true})
+	keyID := HashKey("iw:k")
+
+	for _, team := range []*Team{
+		{ID: "eng", Limits: &config.LimitsConfig{CostPerDay: 50}},
+		{ID: "ops"},
+		{ID: "eng-ci", ParentID: "eng"},
+	} {
+		if err := SaveTeam(ctx, s, team); err != nil {
+			t.Fatalf("SaveTeam(%s): %v", team.ID, err)
+		}
+	}
+
+	invalid := map[string]*Team{
+		"bad id":          {ID: "team:eng"},
+		"missing parent":  {ID: "x", ParentID: "nope"},
+		"nested project":  {ID: "x", ParentID: "eng-ci"},
+		"project limits":  {ID: "x", ParentID: "eng", Limits: &config.LimitsConfig{RequestsPerMinute: 1}},
+		"team to project": {ID: "ops", ParentID: "eng"},
+		"negative budget": {ID: "y", Limits: &config.LimitsConfig{CostPerDay: -1}},
+		"own parent":      {ID: "z", ParentID: "z"},
+		"project to team": {ID: "eng-ci"},
+	}
+	for name, team := range invalid {
+		if err := SaveTeam(ctx, s, team); err == nil {
+			t.Errorf("SaveTeam(%s): expected error", name)
+		}
+	}
+
+	for name, ids := range map[string][2]string{
+		"project without team":  {"", "eng-ci"},
+		"project of other team": {"ops", "eng-ci"},
+		"project as team":       {"eng-ci", ""},
+		"unknown team":          {"missing", ""},
+	} {
+		if err := AssignTeam(ctx, s, keyID, ids[0], ids[1]); err == nil {
+			t.Errorf("AssignTeam(%s): expected error", name)
+		}
+	}
+
+	if err := AssignTeam(ctx, s, keyID, "eng", "eng-ci"); err != nil {
+		t.Fatalf("AssignTeam: %v", err)
+	}
+	if err := RemoveTeam(ctx, s, "eng"); !errors.Is(err, ErrTeamInUse) || !strings.Contains(err.Error(), "eng-ci") {
+		t.Fatalf("RemoveTeam(eng): expected ErrTeamInUse naming the project, got %v", err)
+	}
+
+	limits, err := TeamLimits(ctx, s)
+	if err != nil || len(limits) != 1 || limits["eng"].CostPerDay != 50 {
+		t.Fatalf("TeamLimits: %+v %v", limits, err)
+	}
+}
+
+func TestFileStoreTeams(t *testing.T) {
+	valid := `
+keys:
+  - key: iw:file
+    provider: openai
+    actual_key: sk
+    team_id: eng
+    project_id: eng-ci
+teams:
+  - id: eng
+    limits: {requests_per_minute: 60, cost_per_day: 10}
+  - id: eng-ci
+    parent_id: eng
+`
+	keys, teams, err := parseKeyFile("keys.yml", []byte(valid))
+	if err != nil {
+		t.Fatalf("parseKeyFile: %v", err)
+	}
+	k := keys[HashKey("iw:file")]
+	if k == nil || k.TeamID != "eng" || k.ProjectID != "eng-ci" {
+		t.Fatalf("unexpected key: %+v", k)
+	}
+	if teams["eng"].Limits == nil || teams["eng"].Limits.RequestsPerMinute != 60 || teams["eng-ci"].ParentID != "eng" {
+		t.Fatalf("unexpected teams: %+v", teams)
+	}
+
+	for name, data := range map[string]string{
+		"unknown team":    "keys: [{key: 'iw:a', provider: openai, actual_key: sk, team_id: nope}]",
+		"foreign project": "keys: [{key: 'iw:a', provider: openai, actual_key: sk, team_id: a, project_id: b-ci}]\nteams: [{id: a}, {id: b}, {id: b-ci, parent_id: b}]",
+		"orphan project":  "keys: []\nteams: [{id: x, parent_id: nope}]",
+		"duplicate team":  "keys: []\nteams: [{id: a}, {id: a}]",
+	} {
+		if _, _, err := parseKeyFile("keys.yml", []byte(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
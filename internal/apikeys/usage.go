@@ -0,0 +1,176 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultUsageFlushInterval is how often UsageTracker writes batched usage to the store
+const DefaultUsageFlushInterval = 30 * time.Second
+
+// KeyUsage is usage to add to a key's counters. An empty LastUsedIP leaves
+// the stored address unchanged.
+type KeyUsage struct {
+	Requests   int64
+	Tokens     int64
+	Cost       float64 // USD
+	LastUsedAt time.Time
+	LastUsedIP string
+}
+
+// add merges other into u, keeping the most recent use
+func (u *KeyUsage) add(other KeyUsage) {
+	u.Requests += other.Requests
+	u.Tokens += other.Tokens
+	u.Cost += other.Cost
+	if !other.LastUsedAt.Before(u.LastUsedAt) {
+		u.LastUsedAt = other.LastUsedAt
+		if other.LastUsedIP != "" {
+			u.LastUsedIP = other.LastUsedIP
+		}
+	}
+}
+
+// applyTo adds the usage to a key record
+func (u KeyUsage) applyTo(k *APIKey) {
+	k.RequestCount += u.Requests
+	k.TokenCount += u.Tokens
+	k.TotalCost += u.Cost
+	if k.LastUsedAt == nil || u.LastUsedAt.After(*k.LastUsedAt) {
+		t := u.LastUsedAt
+		k.LastUsedAt = &t
+	}
+	if u.LastUsedIP != "" {
+		k.LastUsedIP = u.LastUsedIP
+	}
+}
+
+// UnusedSince reports whether the key has not been used since cutoff. Keys
+// that were never used count from their creation time.
+func (k *APIKey) UnusedSince(cutoff time.Time) bool {
+	lastActive := k.CreatedAt
+	if k.LastUsedAt != nil {
+		lastActive = *k.LastUsedAt
+	}
+	return lastActive.Before(cutoff)
+}
+
+// UsageTracker batches key usage in memory and writes it to the store in the
+// background, so recording use never waits on the store. Usage recorded since
+// the last flush is lost if the process dies without calling Close.
+type UsageTracker struct {
+	store  KeyStore
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	pending map[string]KeyUsage
+
+	readOnlyOnce sync.Once
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// NewUsageTracker creates a tracker that flushes every interval (default
+// DefaultUsageFlushInterval; negative disables background flushing)
+func NewUsageTracker(store KeyStore, interval time.Duration, logger *slog.Logger) *UsageTracker {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if interval == 0 {
+		interval = DefaultUsageFlushInterval
+	}
+	t := &UsageTracker{
+		store:   store,
+		logger:  logger,
+		pending: make(map[string]KeyUsage),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if interval > 0 {
+		go t.run(interval)
+	} else {
+		close(t.done)
+	}
+	return t
+}
+
+// Record adds usage for the key with the given record ID. LastUsedAt defaults to now.
+func (t *UsageTracker) Record(id string, usage KeyUsage) {
+	if id == "" {
+		return
+	}
+	if usage.LastUsedAt.IsZero() {
+		usage.LastUsedAt = time.Now().UTC()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.pending[id]
+	pending.add(usage)
+	t.pending[id] = pending
+}
+
+// Flush writes all pending usage to the store. Usage that fails to write for
+// reasons other than the key being gone is kept for the next flush.
+func (t *UsageTracker) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = make(map[string]KeyUsage)
+	t.mu.Unlock()
+
+	var failed int
+	var firstErr error
+	for id, usage := range batch {
+		err := t.store.RecordUsage(ctx, id, usage)
+		switch {
+		case err == nil, errors.Is(err, ErrKeyNotFound):
+			// Deleted keys have no counters to update
+		case errors.Is(err, ErrReadOnly):
+			t.readOnlyOnce.Do(func() {
+				t.logger.Warn("🔑 API Key Usage: Key store is read-only; usage is not recorded")
+			})
+		default:
+			t.Record(id, usage)
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("failed to record usage for %d of %d keys: %w", failed, len(batch), firstErr)
+	}
+	return nil
+}
+
+// Close stops background flushing and writes any pending usage
+func (t *UsageTracker) Close(ctx context.Context) error {
+	select {
+	case <-t.stop:
+	default:
+		close(t.stop)
+	}
+	<-t.done
+	return t.Flush(ctx)
+}
+
+func (t *UsageTracker) run(interval time.Duration) {
+	defer close(t.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := t.Flush(ctx); err != nil {
+				t.logger.Warn("🔑 API Key Usage: Flush failed", "error", err)
+			}
+			cancel()
+		case <-t.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingUsageStore fails RecordUsage until fail is cleared
+type failingUsageStore struct {
+	*MemoryStore
+	fail  bool
+	calls int
+}
+
+func (s *failingUsageStore) RecordUsage(ctx context.Context, id string, usage KeyUsage) error {
+	s.calls++
+	if s.fail {
+		return errors.New("store unavailable")
+	}
+	return s.MemoryStore.RecordUsage(ctx, id, usage)
+}
+
+func TestUsageTrackerBatches(t *testing.T) {
+	ctx := context.Background()
+	keyID := HashKey("iw:active")
+	store := &failingUsageStore{MemoryStore: NewMemoryStore(conformanceSeed()...), fail: true}
+	tracker := NewUsageTracker(store, -1, nil)
+
+	early := time.Now().Add(-time.Minute)
+	tracker.Record(keyID, KeyUsage{Requests: 1, LastUsedIP: "10.0.0.1"})
+	tracker.Record(keyID, KeyUsage{Tokens: 100, Cost: 0.5, LastUsedAt: early, LastUsedIP: "10.0.0.9"})
+	tracker.Record(keyID, KeyUsage{Requests: 1, Tokens: 20})
+	tracker.Record(HashKey("iw:deleted"), KeyUsage{Requests: 1})
+
+	// A failed flush keeps the batch for the next one
+	if err := tracker.Flush(ctx); err == nil {
+		t.Fatal("Flush: expected error from failing store")
+	}
+	store.fail = false
+	store.calls = 0
+	if err := tracker.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if store.calls != 2 {
+		t.Fatalf("expected one write per key, got %d", store.calls)
+	}
+
+	k, _ := store.LookupKey(ctx, keyID)
+	if k.RequestCount != 2 || k.TokenCount != 120 || k.TotalCost != 0.5 || k.LastUsedAt == nil || k.LastUsedIP != "10.0.0.1" {
+		t.Fatalf("usage not merged: %+v", k)
+	}
+	if !k.LastUsedAt.After(early) {
+		t.Fatalf("older usage overrode the last use: %v", k.LastUsedAt)
+	}
+}
+
+func TestUnusedSince(t *testing.T) {
+	now := time.Now()
+	lastWeek := now.Add(-7 * 24 * time.Hour)
+	cutoff := now.Add(-30 * 24 * time.Hour)
+
+	cases := []struct {
+		name string
+		key  APIKey
+		want bool
+	}{
+		{"never used, old", APIKey{CreatedAt: now.Add(-60 * 24 * time.Hour)}, true},
+		{"never used, new", APIKey{CreatedAt: lastWeek}, false},
+		{"used recently", APIKey{CreatedAt: now.Add(-60 * 24 * time.Hour), LastUsedAt: &lastWeek}, false},
+	}
+	for _, tc := range cases {
+		if got := tc.key.UnusedSince(cutoff); got != tc.want {
+			t.Errorf("%s: UnusedSince = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
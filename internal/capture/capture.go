@@ -0,0 +1,229 @@
+// Package capture records the prompts and completions of opted-in requests
+// for audit and debugging. Records pass through redaction hooks and are
+// written asynchronously to a pluggable Sink.
+package capture
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Instawork/llm-proxy/internal/metrics"
+)
+
+// Default limits used when Options leaves them unset
+const (
+	DefaultMaxBodyBytes = 64 * 1024
+	DefaultQueueSize    = 1000
+)
+
+var recordsTotal = metrics.NewCounterVec("llm_proxy_capture_records_total",
+	"Captured request records, by result (written, dropped, error)", "result")
+
+// countingSink is implemented by sinks that buffer records and count them in
+// recordsTotal once they are stored, rather than when Write returns
+type countingSink interface {
+	countsRecords()
+}
+
+// Record is one captured request and its response
+type Record struct {
+	Timestamp         time.Time `json:"timestamp"`
+	RequestID         string    `json:"request_id"`
+	UpstreamRequestID string    `json:"upstream_request_id,omitempty"`
+	Provider          string    `json:"provider"`
+	Model             string    `json:"model,omitempty"`
+	Method            string    `json:"method"`
+	Path              string    `json:"path"`
+	Status            int       `json:"status"`
+	Streaming         bool      `json:"streaming"`
+	DurationMs        float64   `json:"duration_ms"`
+	UserID            string    `json:"user_id,omitempty"`
+	KeyID             string    `json:"key_id,omitempty"`
+	TeamID            string    `json:"team_id,omitempty"`
+	ProjectID         string    `json:"project_id,omitempty"`
+	Cached            bool      `json:"cached,omitempty"`    // answered from the response cache
+	Coalesced         bool      `json:"coalesced,omitempty"` // shared from an identical in-flight request
+
+	// Request is the request body; Response is the generated text, with
+	// streamed chunks stitched together, or the raw body when no text
+	// could be extracted (e.g. error responses)
+	Request           string `json:"request"`
+	RequestTruncated  bool   `json:"request_truncated,omitempty"`
+	Response          string `json:"response"`
+	ResponseTruncated bool   `json:"response_truncated,omitempty"`
+
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	TotalTokens  int     `json:"total_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+	FinishReason string  `json:"finish_reason,omitempty"`
+}
+
+// Sink stores captured records
+type Sink interface {
+	Write(ctx context.Context, record *Record) error
+	// Close flushes buffered records and releases resources
+	Close(ctx context.Context) error
+}
+
+// Redactor edits a record in place before it is written, e.g. to mask
+// secrets in the request or response
+type Redactor func(record *Record)
+
+// Options configures a Capturer
+type Options struct {
+	Sink Sink
+	// All captures every request; otherwise only requests from the listed
+	// key IDs, users or teams are captured
+	All   bool
+	Keys  []string
+	Users []string
+	Teams []string
+	// SampleRate is the fraction of eligible requests captured (default: 1)
+	SampleRate float64
+	// MaxBodyBytes caps each captured request and response body
+	MaxBodyBytes int
+	QueueSize    int
+	Redactors    []Redactor
+	Logger       *slog.Logger
+}
+
+// Capturer decides which requests are captured and writes their records
+// to the sink from a background worker
+type Capturer struct {
+	sink         Sink
+	all          bool
+	keys         map[string]bool
+	users        map[string]bool
+	teams        map[string]bool
+	sampleRate   float64
+	maxBodyBytes int
+	redactors    []Redactor
+	logger       *slog.Logger
+
+	queue chan *Record
+	done  chan struct{}
+	once  sync.Once
+}
+
+// New creates a Capturer and starts its writer
+func New(opts Options) *Capturer {
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	c := &Capturer{
+		sink:         opts.Sink,
+		all:          opts.All,
+		keys:         toSet(opts.Keys),
+		users:        toSet(opts.Users),
+		teams:        toSet(opts.Teams),
+		sampleRate:   opts.SampleRate,
+		maxBodyBytes: opts.MaxBodyBytes,
+		redactors:    opts.Redactors,
+		logger:       opts.Logger,
+		queue:        make(chan *Record, opts.QueueSize),
+		done:         make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// ShouldCapture reports whether a request from the given key, user and team
+// is opted in and sampled
+func (c *Capturer) ShouldCapture(keyID, userID, teamID string) bool {
+	if c == nil {
+		return false
+	}
+	optedIn := c.all ||
+		(keyID != "" && c.keys[keyID]) ||
+		(userID != "" && c.users[userID]) ||
+		(teamID != "" && c.teams[teamID])
+	if !optedIn {
+		return false
+	}
+	return c.sampleRate >= 1 || rand.Float64() < c.sampleRate
+}
+
+// MaxBodyBytes returns the cap on captured request and response bodies
+func (c *Capturer) MaxBodyBytes() int {
+	return c.maxBodyBytes
+}
+
+// Truncate cuts s to at most max bytes without splitting a UTF-8 sequence,
+// reporting whether it was cut
+func Truncate(s string, max int) (string, bool) {
+	if len(s) <= max {
+		return s, false
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut], true
+}
+
+// Submit redacts record and queues it for the sink. Records are dropped,
+// with a warning, when the queue is full so capture never blocks requests.
+func (c *Capturer) Submit(record *Record) {
+	for _, redact := range c.redactors {
+		redact(record)
+	}
+	select {
+	case c.queue <- record:
+	default:
+		recordsTotal.WithLabelValues("dropped").Inc()
+		c.logger.Warn("📼 Capture: Queue full; dropping record", "request_id", record.RequestID)
+	}
+}
+
+func (c *Capturer) run() {
+	defer close(c.done)
+	for record := range c.queue {
+		c.write(record)
+	}
+}
+
+func (c *Capturer) write(record *Record) {
+	if err := c.sink.Write(context.Background(), record); err != nil {
+		recordsTotal.WithLabelValues("error").Inc()
+		c.logger.Error("📼 Capture: Failed to write record", "request_id", record.RequestID, "error", err)
+		return
+	}
+	if _, ok := c.sink.(countingSink); !ok {
+		recordsTotal.WithLabelValues("written").Inc()
+	}
+}
+
+// Close writes queued records and closes the sink. Submit must not be
+// called after Close.
+func (c *Capturer) Close(ctx context.Context) error {
+	c.once.Do(func() { close(c.queue) })
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return c.sink.Close(ctx)
+}
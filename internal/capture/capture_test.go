@@ -0,0 +1,237 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// memorySink collects records for tests
+type memorySink struct {
+	mu      sync.Mutex
+	records []*Record
+}
+
+func (s *memorySink) Write(_ context.Context, record *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *memorySink) Close(context.Context) error { return nil }
+
+func TestCapturerOptInAndRedaction(t *testing.T) {
+	sink := &memorySink{}
+	c := New(Options{
+		Sink:  sink,
+		Keys:  []string{"key-1"},
+		Teams: []string{"eng"},
+		Redactors: []Redactor{func(r *Record) {
+			r.Request = strings.ReplaceAll(r.Request, "hunter2", "[REDACTED]")
+		}},
+	})
+
+	for _, tc := range []struct {
+		key, user, team string
+		want            bool
+	}{
+		{"key-1", "", "", true},
+		{"", "alice", "eng", true},
+		{"key-2", "alice", "", false},
+		{"", "", "", false},
+	} {
+		if got := c.ShouldCapture(tc.key, tc.user, tc.team); got != tc.want {
+			t.Errorf("ShouldCapture(%q, %q, %q) = %v, want %v", tc.key, tc.user, tc.team, got, tc.want)
+		}
+	}
+
+	c.Submit(&Record{RequestID: "r1", Request: `{"password":"hunter2"}`})
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if len(sink.records) != 1 || sink.records[0].Request != `{"password":"[REDACTED]"}` {
+		t.Fatalf("expected one redacted record, got %+v", sink.records)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got, cut := Truncate("héllo", 2); got != "h" || !cut {
+		t.Fatalf("expected truncation before the split rune, got %q %v", got, cut)
+	}
+	if got, cut := Truncate("hello", 5); got != "hello" || cut {
+		t.Fatalf("expected no truncation, got %q %v", got, cut)
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "captures.jsonl")
+	sink := NewFileSink(path, 600, 2)
+	for i := 0; i < 10; i++ {
+		if err := sink.Write(context.Background(), &Record{RequestID: strings.Repeat("x", 50)}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		// Rotated names have microsecond precision
+		time.Sleep(time.Millisecond)
+	}
+	if err := sink.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	rotated, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "captures-*.jsonl"))
+	if len(rotated) != 2 {
+		t.Fatalf("expected 2 rotated files to be kept, got %v", rotated)
+	}
+	for _, file := range append(rotated, path) {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		if info.Size() > 600 {
+			t.Errorf("%s grew past the rotation size: %d bytes", file, info.Size())
+		}
+	}
+}
+
+func TestS3SinkBatches(t *testing.T) {
+	var mu sync.Mutex
+	objects := make(map[string]string)
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio/") {
+			t.Errorf("unexpected request %s %s auth=%q", r.Method, r.URL.Path, r.Header.Get("Authorization"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		objects[r.URL.Path] = string(body)
+		mu.Unlock()
+	}))
+	defer store.Close()
+
+	sink, err := NewS3Sink(context.Background(), S3Options{
+		Bucket:    "audit",
+		Prefix:    "/llm/",
+		Endpoint:  store.URL,
+		BatchSize: 2,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "minio", SecretAccessKey: "minio123"}, nil
+		}),
+	})
+	if err != nil {
+		t.Fatalf("NewS3Sink: %v", err)
+	}
+	for _, id := range []string{"r1", "r2", "r3"} {
+		if err := sink.Write(context.Background(), &Record{RequestID: id}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	// Close uploads the partial batch
+	if err := sink.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if len(objects) != 2 {
+		t.Fatalf("expected 2 objects, got %v", objects)
+	}
+	lines := 0
+	for path, body := range objects {
+		if !strings.HasPrefix(path, "/audit/llm/") || !strings.HasSuffix(path, ".jsonl") {
+			t.Errorf("unexpected object path %s", path)
+		}
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		for scanner.Scan() {
+			var record Record
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatalf("invalid JSON line %q: %v", scanner.Text(), err)
+			}
+			lines++
+		}
+	}
+	if lines != 3 {
+		t.Fatalf("expected 3 records across objects, got %d", lines)
+	}
+}
+
+func TestS3SinkRetriesAndKeepsFailedBatches(t *testing.T) {
+	var mu sync.Mutex
+	down, flaky := true, 0
+	var uploaded []string
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down || flaky > 0 {
+			flaky--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var record Record
+		_ = json.Unmarshal(body, &record)
+		uploaded = append(uploaded, record.RequestID)
+	}))
+	defer store.Close()
+
+	sink, err := NewS3Sink(context.Background(), S3Options{
+		Bucket:            "audit",
+		Endpoint:          store.URL,
+		BatchSize:         1,
+		MaxRetries:        1,
+		RetryBackoff:      time.Millisecond,
+		MaxPendingRecords: 3,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "minio", SecretAccessKey: "minio123"}, nil
+		}),
+	})
+	if err != nil {
+		t.Fatalf("NewS3Sink: %v", err)
+	}
+	written := recordsTotal.WithLabelValues("written").Value()
+	failed := recordsTotal.WithLabelValues("error").Value()
+	dropped := recordsTotal.WithLabelValues("dropped").Value()
+
+	// While the store is down batches are kept for retry
+	for _, id := range []string{"r1", "r2", "r3"} {
+		if err := sink.Write(context.Background(), &Record{RequestID: id}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if got := recordsTotal.WithLabelValues("written").Value() - written; got != 0 {
+		t.Fatalf("expected nothing counted as written while down, got %v", got)
+	}
+
+	// The next batch drops the oldest past the bound, one failure is
+	// absorbed by a retry and pending batches upload first
+	mu.Lock()
+	down, flaky = false, 1
+	mu.Unlock()
+	if err := sink.Write(context.Background(), &Record{RequestID: "r4"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := sink.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if strings.Join(uploaded, ",") != "r2,r3,r4" {
+		t.Fatalf("uploaded %v, want r2,r3,r4", uploaded)
+	}
+	if got := recordsTotal.WithLabelValues("written").Value() - written; got != 3 {
+		t.Fatalf("written = %v, want 3", got)
+	}
+	// Uploads stop at the first failing batch, so only r1 was attempted while down
+	if got := recordsTotal.WithLabelValues("error").Value() - failed; got != 1 {
+		t.Fatalf("error = %v, want 1", got)
+	}
+	if got := recordsTotal.WithLabelValues("dropped").Value() - dropped; got != 1 {
+		t.Fatalf("dropped = %v, want 1", got)
+	}
+}
@@ -0,0 +1,146 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for FileSink rotation
+const (
+	DefaultMaxFileBytes = 100 * 1024 * 1024
+	DefaultMaxFiles     = 5
+)
+
+// rotatedTimeFormat names rotated files so they sort by age
+const rotatedTimeFormat = "20060102T150405.000000"
+
+// FileSink appends records as JSON lines to a local file. Once the file
+// would grow past maxBytes it is renamed with a timestamp suffix (e.g.
+// captures-20250102T030405.000000.jsonl) and a new file is started; only
+// the newest maxFiles rotated files are kept.
+type FileSink struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink creates a FileSink writing to path. Zero maxBytes or
+// maxFiles use the defaults.
+func NewFileSink(path string, maxBytes int64, maxFiles int) *FileSink {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxFileBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultMaxFiles
+	}
+	return &FileSink{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+}
+
+// Write appends record to the file, rotating it first if needed
+func (s *FileSink) Write(_ context.Context, record *Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal capture record: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write capture record: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	// Captures hold prompts, so keep them private to the proxy's user
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open capture file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat capture file: %w", err)
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close capture file: %w", err)
+	}
+	s.file = nil
+
+	base, ext := s.splitPath()
+	rotated := fmt.Sprintf("%s-%s%s", base, time.Now().UTC().Format(rotatedTimeFormat), ext)
+	if err := os.Rename(s.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate capture file: %w", err)
+	}
+	if err := s.prune(); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// prune removes the oldest rotated files beyond maxFiles
+func (s *FileSink) prune() error {
+	base, ext := s.splitPath()
+	matches, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return fmt.Errorf("failed to list rotated capture files: %w", err)
+	}
+	sort.Strings(matches)
+	for len(matches) > s.maxFiles {
+		if err := os.Remove(matches[0]); err != nil {
+			return fmt.Errorf("failed to remove old capture file: %w", err)
+		}
+		matches = matches[1:]
+	}
+	return nil
+}
+
+func (s *FileSink) splitPath() (base, ext string) {
+	ext = filepath.Ext(s.path)
+	return strings.TrimSuffix(s.path, ext), ext
+}
+
+// Close closes the current file
+func (s *FileSink) Close(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,290 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/requestid"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// Defaults for S3Sink batching and retries
+const (
+	DefaultS3BatchSize     = 100
+	DefaultS3FlushInterval = time.Minute
+	DefaultS3MaxRetries    = 3
+	DefaultS3RetryBackoff  = 500 * time.Millisecond
+	// DefaultS3MaxPendingBatches is how many batches' worth of records are
+	// kept for retry while the store is unreachable
+	DefaultS3MaxPendingBatches = 10
+)
+
+// S3Options configures an S3Sink
+type S3Options struct {
+	Bucket string
+	// Prefix is prepended to object keys, which are
+	// <prefix>/YYYY/MM/DD/<ULID>.jsonl
+	Prefix string
+	Region string // Default: us-east-1
+	// Endpoint is an S3-compatible endpoint such as MinIO's
+	// (http://localhost:9000); objects are addressed path-style. When empty,
+	// AWS S3's virtual-hosted endpoint for Region is used.
+	Endpoint      string
+	BatchSize     int           // Records per object
+	FlushInterval time.Duration // Upload partial batches this often
+	// MaxRetries is how many times an upload is retried, with exponential
+	// backoff from RetryBackoff, before the batch is kept for the next flush.
+	// Negative disables retries.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// MaxPendingRecords bounds the records kept from failed uploads; the
+	// oldest batches are dropped beyond it. Default: 10 batches.
+	MaxPendingRecords int
+	// Credentials sign uploads; the default AWS credential chain (e.g.
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY) is used when nil
+	Credentials aws.CredentialsProvider
+	Client      *http.Client
+	Logger      *slog.Logger
+}
+
+// S3Sink batches records into JSONL objects uploaded to an S3-compatible
+// object store with SigV4-signed PUTs. Batches that fail to upload are kept
+// and retried on later flushes, up to MaxPendingRecords.
+type S3Sink struct {
+	opts   S3Options
+	signer *v4.Signer
+
+	mu    sync.Mutex
+	batch bytes.Buffer
+	count int
+	// pending holds batches awaiting upload, oldest first
+	pending        []*s3Batch
+	pendingRecords int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// s3Batch is a closed batch. Its key is fixed when the batch is closed so
+// retries overwrite, rather than duplicate, an upload that did land.
+type s3Batch struct {
+	key    string
+	body   []byte
+	count  int
+	failed bool // counted as an error on its first failed upload
+}
+
+// NewS3Sink creates an S3Sink and starts its periodic flush
+func NewS3Sink(ctx context.Context, opts S3Options) (*S3Sink, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("s3 capture sink requires a bucket")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultS3BatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultS3FlushInterval
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultS3MaxRetries
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = DefaultS3RetryBackoff
+	}
+	if opts.MaxPendingRecords <= 0 {
+		opts.MaxPendingRecords = DefaultS3MaxPendingBatches * opts.BatchSize
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.Credentials == nil {
+		awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(opts.Region))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+		opts.Credentials = awsConfig.Credentials
+	}
+	opts.Prefix = strings.Trim(opts.Prefix, "/")
+
+	s := &S3Sink{
+		opts:   opts,
+		signer: v4.NewSigner(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.flushLoop()
+	return s, nil
+}
+
+// Write adds record to the current batch, uploading it once full. Upload
+// failures are logged and counted rather than returned, since the record is
+// kept for retry.
+func (s *S3Sink) Write(ctx context.Context, record *Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal capture record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batch.Write(line)
+	s.batch.WriteByte('\n')
+	s.count++
+	if s.count < s.opts.BatchSize {
+		return nil
+	}
+	if err := s.flushLocked(ctx); err != nil {
+		s.opts.Logger.Error("📼 Capture: Failed to upload batch", "error", err)
+	}
+	return nil
+}
+
+// countsRecords marks S3Sink as counting records when they are uploaded
+func (s *S3Sink) countsRecords() {}
+
+func (s *S3Sink) flushLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if err := s.flushLocked(context.Background()); err != nil {
+				s.opts.Logger.Error("📼 Capture: Failed to upload batch", "error", err)
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// flushLocked closes the current batch and uploads pending batches in order,
+// stopping at the first that still fails after retries. Pending batches past
+// MaxPendingRecords are dropped, oldest first, so an unreachable store cannot
+// grow memory without bound.
+func (s *S3Sink) flushLocked(ctx context.Context) error {
+	if s.count > 0 {
+		s.pending = append(s.pending, &s3Batch{
+			key:   s.objectKey(time.Now().UTC()),
+			body:  bytes.Clone(s.batch.Bytes()),
+			count: s.count,
+		})
+		s.pendingRecords += s.count
+		s.batch.Reset()
+		s.count = 0
+	}
+	for s.pendingRecords > s.opts.MaxPendingRecords && len(s.pending) > 1 {
+		dropped := s.pending[0]
+		s.pending = s.pending[1:]
+		s.pendingRecords -= dropped.count
+		recordsTotal.WithLabelValues("dropped").Add(float64(dropped.count))
+		s.opts.Logger.Warn("📼 Capture: Pending uploads full; dropping batch", "key", dropped.key, "records", dropped.count)
+	}
+
+	for len(s.pending) > 0 {
+		b := s.pending[0]
+		if err := s.putWithRetry(ctx, b.key, b.body); err != nil {
+			if !b.failed {
+				b.failed = true
+				recordsTotal.WithLabelValues("error").Add(float64(b.count))
+			}
+			return fmt.Errorf("failed to upload %d capture records to %s (%d records pending): %w", b.count, b.key, s.pendingRecords, err)
+		}
+		s.pending = s.pending[1:]
+		s.pendingRecords -= b.count
+		recordsTotal.WithLabelValues("written").Add(float64(b.count))
+		s.opts.Logger.Debug("📼 Capture: Uploaded batch", "key", b.key, "records", b.count)
+	}
+	return nil
+}
+
+// putWithRetry uploads body, retrying failures with exponential backoff
+func (s *S3Sink) putWithRetry(ctx context.Context, key string, body []byte) error {
+	backoff := s.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := s.put(ctx, key, body)
+		if err == nil || attempt >= s.opts.MaxRetries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (s *S3Sink) objectKey(now time.Time) string {
+	key := now.Format("2006/01/02") + "/" + requestid.New() + ".jsonl"
+	if s.opts.Prefix != "" {
+		key = s.opts.Prefix + "/" + key
+	}
+	return key
+}
+
+func (s *S3Sink) objectURL(key string) string {
+	if s.opts.Endpoint != "" {
+		return strings.TrimRight(s.opts.Endpoint, "/") + "/" + s.opts.Bucket + "/" + key
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.opts.Bucket, s.opts.Region, key)
+}
+
+func (s *S3Sink) put(ctx context.Context, key string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	creds, err := s.opts.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	if err := s.signer.SignHTTP(ctx, creds, req, payloadHash, "s3", s.opts.Region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Close stops the periodic flush and uploads the remaining records
+func (s *S3Sink) Close(ctx context.Context) error {
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked(ctx)
+}
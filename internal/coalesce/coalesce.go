@@ -0,0 +1,160 @@
+// Package coalesce lets concurrent identical requests share one upstream
+// call: the first caller makes the call and later callers with the same
+// key wait for its response instead of making their own.
+package coalesce
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Instawork/llm-proxy/internal/metrics"
+	"github.com/Instawork/llm-proxy/internal/providers"
+)
+
+// DefaultMaxBodyBytes is the largest response shared when Options leaves it unset
+const DefaultMaxBodyBytes = 1024 * 1024
+
+var requestsTotal = metrics.NewCounterVec("llm_proxy_coalesced_requests_total",
+	"Coalescable requests, by result (leader, shared, fallback)", "result")
+
+// Response is a completed response that waiting callers can replay
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Metadata is the usage of the response, when known
+	Metadata *providers.LLMResponseMetadata
+}
+
+// Options configures a Coalescer
+type Options struct {
+	// All coalesces requests from every key to every route; otherwise only
+	// requests from the listed key IDs or to paths under the listed routes
+	All    bool
+	Keys   []string
+	Routes []string
+	// MaxBodyBytes is the largest response shared with waiting callers
+	MaxBodyBytes int
+}
+
+type call struct {
+	done    chan struct{}
+	resp    *Response
+	waiters int
+}
+
+// Coalescer tracks in-flight calls by key
+type Coalescer struct {
+	all          bool
+	keys         map[string]bool
+	routes       []string
+	maxBodyBytes int
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// New creates a Coalescer
+func New(opts Options) *Coalescer {
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	keys := make(map[string]bool, len(opts.Keys))
+	for _, k := range opts.Keys {
+		keys[k] = true
+	}
+	return &Coalescer{
+		all:          opts.All,
+		keys:         keys,
+		routes:       opts.Routes,
+		maxBodyBytes: opts.MaxBodyBytes,
+		calls:        make(map[string]*call),
+	}
+}
+
+// Enabled reports whether requests from keyID to path are coalesced
+func (c *Coalescer) Enabled(keyID, path string) bool {
+	if c.all || (keyID != "" && c.keys[keyID]) {
+		return true
+	}
+	for _, route := range c.routes {
+		if strings.HasPrefix(path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxBodyBytes is the largest response shared with waiting callers
+func (c *Coalescer) MaxBodyBytes() int {
+	return c.maxBodyBytes
+}
+
+// Waiting returns the number of callers waiting on in-flight calls
+func (c *Coalescer) Waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, cl := range c.calls {
+		n += cl.waiters
+	}
+	return n
+}
+
+// Do runs fn unless a call with the same key is in flight, in which case
+// it waits for that call and returns its response with shared set. fn
+// returns nil when its response cannot be shared, e.g. because it was too
+// large or the caller went away; waiting callers then run their own fn.
+// Do returns ctx's error if ctx ends while waiting.
+func (c *Coalescer) Do(ctx context.Context, key string, fn func() *Response) (resp *Response, shared bool, err error) {
+	c.mu.Lock()
+	if cl, ok := c.calls[key]; ok {
+		cl.waiters++
+		c.mu.Unlock()
+		select {
+		case <-cl.done:
+		case <-ctx.Done():
+			c.mu.Lock()
+			cl.waiters--
+			c.mu.Unlock()
+			return nil, false, ctx.Err()
+		}
+		if cl.resp != nil {
+			requestsTotal.WithLabelValues("shared").Inc()
+			return cl.resp, true, nil
+		}
+		requestsTotal.WithLabelValues("fallback").Inc()
+		return fn(), false, nil
+	}
+	cl := &call{done: make(chan struct{})}
+	c.calls[key] = cl
+	c.mu.Unlock()
+	requestsTotal.WithLabelValues("leader").Inc()
+
+	// Release waiting callers even if fn panics
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(cl.done)
+	}()
+	cl.resp = fn()
+	return cl.resp, false, nil
+}
+
+// Key derives the key of a request from its provider, the identity of its
+// caller, variant (anything besides the body the response depends on, such
+// as the path) and its exact body
+func Key(provider, identity, variant string, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{provider, identity, variant} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,112 @@
+package coalesce
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDoSharesOneCall(t *testing.T) {
+	c := New(Options{All: true})
+	release := make(chan struct{})
+	var calls atomic.Int32
+	fn := func() *Response {
+		calls.Add(1)
+		<-release
+		return &Response{Status: 200, Body: []byte("ok")}
+	}
+
+	const callers = 4
+	var wg sync.WaitGroup
+	var sharedCount atomic.Int32
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, shared, err := c.Do(context.Background(), "k", fn)
+			if err != nil || string(resp.Body) != "ok" {
+				t.Errorf("Do = %v, %v", resp, err)
+			}
+			if shared {
+				sharedCount.Add(1)
+			}
+		}()
+	}
+	waitFor(t, func() bool { return c.Waiting() == callers-1 })
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 || sharedCount.Load() != callers-1 {
+		t.Fatalf("expected 1 call shared with %d callers, got %d calls and %d shared", callers-1, calls.Load(), sharedCount.Load())
+	}
+	if c.Waiting() != 0 || len(c.calls) != 0 {
+		t.Fatal("expected the call to be released")
+	}
+}
+
+func TestDoFallsBackWhenUnshareable(t *testing.T) {
+	c := New(Options{All: true})
+	release := make(chan struct{})
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		_, _, _ = c.Do(context.Background(), "k", func() *Response {
+			<-release
+			return nil
+		})
+	}()
+	waitFor(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.calls["k"] != nil
+	})
+
+	// A waiter whose context ends gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := c.Do(ctx, "k", func() *Response { return nil })
+		errs <- err
+	}()
+	waitFor(t, func() bool { return c.Waiting() == 1 })
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	result := make(chan *Response, 1)
+	go func() {
+		resp, shared, _ := c.Do(context.Background(), "k", func() *Response { return &Response{Status: 201} })
+		if shared {
+			t.Error("expected the fallback not to be shared")
+		}
+		result <- resp
+	}()
+	waitFor(t, func() bool { return c.Waiting() == 1 })
+	close(release)
+	<-leaderDone
+	if resp := <-result; resp == nil || resp.Status != 201 {
+		t.Fatalf("expected the waiter to run its own call, got %+v", resp)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	c := New(Options{Keys: []string{"k1"}, Routes: []string{"/openai/v1/embeddings"}})
+	if !c.Enabled("k1", "/anthropic/v1/messages") || !c.Enabled("", "/openai/v1/embeddings") || c.Enabled("k2", "/openai/v1/chat/completions") {
+		t.Fatal("unexpected opt-in decision")
+	}
+}
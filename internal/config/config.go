@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"log/slog"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"strings"
@@ -44,9 +45,172 @@ type YAMLConfig struct {
 
 // FeaturesConfig represents feature toggle configuration
 type FeaturesConfig struct {
+	// TrustTeamHeader takes the team of requests whose key has none from the
+	// client's X-Team-ID header. Only enable it when clients are trusted.
+	TrustTeamHeader bool `yaml:"trust_team_header,omitempty"`
+
 	CostTracking     CostTrackingConfig     `yaml:"cost_tracking"`
 	APIKeyManagement APIKeyManagementConfig `yaml:"api_key_management"`
 	RateLimiting     RateLimitingConfig     `yaml:"rate_limiting"`
+	Admin            AdminConfig            `yaml:"admin,omitempty"`
+	Metrics          MetricsConfig          `yaml:"metrics,omitempty"`
+	Tracing          TracingConfig          `yaml:"tracing,omitempty"`
+	RequestID        RequestIDConfig        `yaml:"request_id,omitempty"`
+	Capture          CaptureConfig          `yaml:"capture,omitempty"`
+	Redaction        RedactionConfig        `yaml:"redaction,omitempty"`
+	ResponseCache    ResponseCacheConfig    `yaml:"response_cache,omitempty"`
+	Coalescing       CoalescingConfig       `yaml:"coalescing,omitempty"`
+	ConfigReload     ConfigReloadConfig     `yaml:"config_reload,omitempty"`
+}
+
+// ConfigReloadConfig represents watching the configuration files for
+// changes. SIGHUP reloads the configuration whether or not Watch is set.
+type ConfigReloadConfig struct {
+	Watch           bool `yaml:"watch,omitempty"`
+	IntervalSeconds int  `yaml:"interval_seconds,omitempty"` // How often files are checked (default: 10)
+}
+
+// RedactionConfig represents PII and secret redaction of prompts. Modes are
+// off, log, mask or block; a key's mode overrides its team's, which
+// overrides Mode.
+type RedactionConfig struct {
+	Enabled   bool              `yaml:"enabled"`
+	Mode      string            `yaml:"mode,omitempty"`      // Default: mask
+	Detectors []string          `yaml:"detectors,omitempty"` // Default: all built-in detectors
+	PerKey    map[string]string `yaml:"per_key,omitempty"`   // Mode by key ID
+	PerTeam   map[string]string `yaml:"per_team,omitempty"`  // Mode by team ID
+}
+
+// ModeFor returns the redaction mode for a request from keyID and teamID
+func (c RedactionConfig) ModeFor(keyID, teamID string) string {
+	if mode, ok := c.PerKey[keyID]; ok && keyID != "" {
+		return mode
+	}
+	if mode, ok := c.PerTeam[teamID]; ok && teamID != "" {
+		return mode
+	}
+	if c.Mode == "" {
+		return "mask"
+	}
+	return c.Mode
+}
+
+// ResponseCacheConfig represents the exact-match cache for non-streaming
+// responses. Caching is opt-in: only requests from the listed keys or teams
+// are cached unless All is set.
+type ResponseCacheConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Backend string   `yaml:"backend,omitempty"` // "memory" (default) or "redis"
+	All     bool     `yaml:"all,omitempty"`
+	Keys    []string `yaml:"keys,omitempty"`  // iw: key IDs
+	Teams   []string `yaml:"teams,omitempty"` // Team IDs
+	// Scope is what shares entries: "key" (default) or "team". Requests
+	// without a team fall back to key scope.
+	Scope        string       `yaml:"scope,omitempty"`
+	TTLSeconds   int          `yaml:"ttl_seconds,omitempty"`    // Default: 3600
+	MaxBodyBytes int          `yaml:"max_body_bytes,omitempty"` // Larger responses are not cached (default: 1048576)
+	MaxEntries   int          `yaml:"max_entries,omitempty"`    // Memory backend (default: 10000)
+	Redis        *RedisConfig `yaml:"redis,omitempty"`
+}
+
+// CoalescingConfig represents sharing one upstream call between concurrent
+// identical non-streaming requests. Coalescing is opt-in: only requests from
+// the listed keys or to the listed routes are coalesced unless All is set.
+// Endpoints that are not idempotent are never coalesced.
+type CoalescingConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	All          bool     `yaml:"all,omitempty"`
+	Keys         []string `yaml:"keys,omitempty"`           // iw: key IDs
+	Routes       []string `yaml:"routes,omitempty"`         // Path prefixes, e.g. /openai/v1/embeddings
+	MaxBodyBytes int      `yaml:"max_body_bytes,omitempty"` // Larger responses are not shared (default: 1048576)
+}
+
+// CaptureConfig represents request/response capture for audit and debugging.
+// Capture is opt-in: only requests from the listed keys, users or teams are
+// recorded unless All is set.
+type CaptureConfig struct {
+	Enabled      bool              `yaml:"enabled"`
+	All          bool              `yaml:"all,omitempty"`
+	Keys         []string          `yaml:"keys,omitempty"`           // iw: key IDs
+	Users        []string          `yaml:"users,omitempty"`          // User IDs as used for rate limits and costs
+	Teams        []string          `yaml:"teams,omitempty"`          // Team IDs
+	SampleRate   float64           `yaml:"sample_rate,omitempty"`    // Fraction of opted-in requests captured (default: 1)
+	MaxBodyBytes int               `yaml:"max_body_bytes,omitempty"` // Cap on each captured request and response (default: 65536)
+	QueueSize    int               `yaml:"queue_size,omitempty"`     // Records waiting to be written before new ones are dropped (default: 1000)
+	Sink         CaptureSinkConfig `yaml:"sink"`
+}
+
+// CaptureSinkConfig selects where captured records are written
+type CaptureSinkConfig struct {
+	Type string               `yaml:"type"` // "file" (default) or "s3"
+	File *CaptureFileConfig   `yaml:"file,omitempty"`
+	S3   *CaptureS3SinkConfig `yaml:"s3,omitempty"`
+}
+
+// CaptureFileConfig represents a local JSONL capture file with rotation
+type CaptureFileConfig struct {
+	Path      string `yaml:"path"`                  // Default: logs/captures.jsonl
+	MaxSizeMB int    `yaml:"max_size_mb,omitempty"` // Rotate once the file reaches this size (default: 100)
+	MaxFiles  int    `yaml:"max_files,omitempty"`   // Rotated files kept (default: 5)
+}
+
+// CaptureS3SinkConfig represents an S3-compatible capture bucket. Credentials
+// come from the default AWS chain, e.g. AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY.
+type CaptureS3SinkConfig struct {
+	Bucket        string `yaml:"bucket"`
+	Prefix        string `yaml:"prefix,omitempty"`
+	Region        string `yaml:"region,omitempty"`         // Default: us-east-1
+	Endpoint      string `yaml:"endpoint,omitempty"`       // S3-compatible endpoint such as MinIO's; uses path-style URLs
+	BatchSize     int    `yaml:"batch_size,omitempty"`     // Records per object (default: 100)
+	FlushInterval int    `yaml:"flush_interval,omitempty"` // Seconds between uploads of partial batches (default: 60)
+	// Failed uploads are retried with backoff, then kept for later flushes
+	MaxRetries        int `yaml:"max_retries,omitempty"`         // Retries per upload (default: 3; negative disables)
+	MaxPendingRecords int `yaml:"max_pending_records,omitempty"` // Records kept from failed uploads (default: 10 batches)
+}
+
+// RequestIDConfig represents request ID propagation configuration
+type RequestIDConfig struct {
+	// UpstreamHeader is the header the request ID is forwarded to providers
+	// in. Defaults to X-Request-ID; "none" stops forwarding.
+	UpstreamHeader string `yaml:"upstream_header,omitempty"`
+}
+
+// ForwardHeader returns the header to forward request IDs upstream in, or
+// an empty string when forwarding is disabled
+func (c RequestIDConfig) ForwardHeader() string {
+	switch c.UpstreamHeader {
+	case "":
+		return "X-Request-ID"
+	case "none":
+		return ""
+	}
+	return c.UpstreamHeader
+}
+
+// TracingConfig represents OpenTelemetry tracing configuration
+type TracingConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	Exporter    string            `yaml:"exporter,omitempty"`     // "otlp" (default) or "stdout"
+	Endpoint    string            `yaml:"endpoint,omitempty"`     // OTLP/HTTP collector (default: http://localhost:4318)
+	Headers     map[string]string `yaml:"headers,omitempty"`      // Extra headers for the collector, e.g. API keys
+	ServiceName string            `yaml:"service_name,omitempty"` // Default: llm-proxy
+	SampleRatio float64           `yaml:"sample_ratio,omitempty"` // Fraction of new traces recorded (default: 1)
+}
+
+// MetricsConfig represents the Prometheus metrics endpoint configuration
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path,omitempty"` // Defaults to /metrics
+}
+
+// AdminConfig represents the operator-only admin API configuration
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Token   string `yaml:"token,omitempty"` // Falls back to the ADMIN_TOKEN env var when empty
+	// AuditLogPath is a file that key changes made through /admin/keys are
+	// appended to as JSON lines; they are always logged as well (optional)
+	AuditLogPath string `yaml:"audit_log_path,omitempty"`
 }
 
 // CostTrackingConfig represents cost tracking feature configuration
@@ -89,9 +253,56 @@ type DatadogTransportConfig struct {
 
 // APIKeyManagementConfig represents API key management configuration
 type APIKeyManagementConfig struct {
-	Enabled   bool   `yaml:"enabled"`
-	TableName string `yaml:"table_name"`
-	Region    string `yaml:"region"`
+	Enabled bool `yaml:"enabled"`
+	// Backend selects the key store: dynamodb (default), file, sqlite or memory
+	Backend   string `yaml:"backend,omitempty"`
+	TableName string `yaml:"table_name"` // dynamodb
+	Region    string `yaml:"region"`     // dynamodb
+	// Path is the key file (file backend, YAML or JSON) or database file (sqlite backend)
+	Path string `yaml:"path,omitempty"`
+	// ReloadIntervalSeconds controls how often the file backend checks for changes (default 5)
+	ReloadIntervalSeconds int                    `yaml:"reload_interval_seconds,omitempty"`
+	Cache                 APIKeyCacheConfig      `yaml:"cache,omitempty"`
+	Encryption            APIKeyEncryptionConfig `yaml:"encryption,omitempty"`
+	// TrustedProxyCIDRs lists load balancers whose X-Forwarded-For is trusted
+	// when checking a key's allowed_cidrs; otherwise the peer address is used
+	TrustedProxyCIDRs []string `yaml:"trusted_proxy_cidrs,omitempty"`
+	// UsageTracking records last use and running request/token/cost totals on each key
+	UsageTracking APIKeyUsageTrackingConfig `yaml:"usage_tracking,omitempty"`
+}
+
+// APIKeyUsageTrackingConfig configures batched usage updates on API key records
+type APIKeyUsageTrackingConfig struct {
+	Enabled              bool `yaml:"enabled"`
+	FlushIntervalSeconds int  `yaml:"flush_interval_seconds,omitempty"` // default 30
+}
+
+// APIKeyEncryptionConfig configures envelope encryption of upstream provider keys at rest
+type APIKeyEncryptionConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Provider string `yaml:"provider,omitempty"` // local (default) or kms
+	// MasterKeyFile holds a 32-byte base64 or hex master key (local provider).
+	// When empty, the key is read from MasterKeyEnv (default LLM_PROXY_MASTER_KEY).
+	MasterKeyFile string `yaml:"master_key_file,omitempty"`
+	MasterKeyEnv  string `yaml:"master_key_env,omitempty"`
+	// PreviousMasterKeyFiles are retired master keys still accepted for
+	// decryption while llm-proxy-keys -reencrypt rotates records
+	PreviousMasterKeyFiles []string `yaml:"previous_master_key_files,omitempty"`
+	KMSKeyID               string   `yaml:"kms_key_id,omitempty"` // kms provider
+}
+
+// APIKeyCacheConfig configures the in-process cache for iw: key lookups
+type APIKeyCacheConfig struct {
+	Enabled            bool `yaml:"enabled"`
+	MaxEntries         int  `yaml:"max_entries,omitempty"`          // default 10000
+	TTLSeconds         int  `yaml:"ttl_seconds,omitempty"`          // default 60
+	NegativeTTLSeconds int  `yaml:"negative_ttl_seconds,omitempty"` // default 10
+	StaleGraceSeconds  int  `yaml:"stale_grace_seconds,omitempty"`  // default 300; serve stale keys while DynamoDB is unreachable
+	// InvalidationChannel is the Redis pub/sub channel llm-proxy-keys publishes
+	// to when a key is disabled, enabled or deleted. Without Redis, changes
+	// take effect once the cached entry's TTL expires.
+	InvalidationChannel string       `yaml:"invalidation_channel,omitempty"`
+	Redis               *RedisConfig `yaml:"redis,omitempty"`
 }
 
 // RateLimitingConfig represents rate limiting feature configuration
@@ -102,20 +313,33 @@ type RateLimitingConfig struct {
 	Overrides  RateLimitOverrides `yaml:"overrides,omitempty"`
 	Estimation EstimationConfig   `yaml:"estimation,omitempty"`
 	Redis      *RedisConfig       `yaml:"redis,omitempty"`
+	// KeyHashSecret is no longer used: scope keys use the key store's key ID.
+	// It is still parsed so existing configs load, and a warning is logged.
+	KeyHashSecret string `yaml:"key_hash_secret,omitempty"`
+	// Upstream enables proactive throttling based on provider rate-limit headers
+	Upstream UpstreamThrottleConfig `yaml:"upstream,omitempty"`
 }
 
 // LimitsConfig contains the per-window limits. Zero or negative means unlimited.
+// Cost limits are expressed in USD and are enforced using the same pricing data
+// as cost tracking, so they are comparable across models.
 type LimitsConfig struct {
-	RequestsPerMinute int `yaml:"requests_per_minute"`
-	TokensPerMinute   int `yaml:"tokens_per_minute"`
-	RequestsPerDay    int `yaml:"requests_per_day"`
-	TokensPerDay      int `yaml:"tokens_per_day"`
+	RequestsPerMinute int     `yaml:"requests_per_minute" json:"requests_per_minute"`
+	TokensPerMinute   int     `yaml:"tokens_per_minute" json:"tokens_per_minute"`
+	RequestsPerDay    int     `yaml:"requests_per_day" json:"requests_per_day"`
+	TokensPerDay      int     `yaml:"tokens_per_day" json:"tokens_per_day"`
+	CostPerMinute     float64 `yaml:"cost_per_minute,omitempty" json:"cost_per_minute,omitempty"`
+	CostPerHour       float64 `yaml:"cost_per_hour,omitempty" json:"cost_per_hour,omitempty"`
+	CostPerDay        float64 `yaml:"cost_per_day,omitempty" json:"cost_per_day,omitempty"`
 }
 
 // RateLimitOverrides allow per-entity limit overrides
 type RateLimitOverrides struct {
+	// PerKey is keyed by the hashed key ID (see llm-proxy-keys -show). Raw keys
+	// are still matched for backward compatibility but should not be committed.
 	PerKey   map[string]LimitsConfig `yaml:"per_key,omitempty"`
 	PerUser  map[string]LimitsConfig `yaml:"per_user,omitempty"`
+	PerTeam  map[string]LimitsConfig `yaml:"per_team,omitempty"`
 	PerModel map[string]LimitsConfig `yaml:"per_model,omitempty"`
 }
 
@@ -125,6 +349,22 @@ type EstimationConfig struct {
 	BytesPerToken         int            `yaml:"bytes_per_token"`
 	CharsPerToken         int            `yaml:"chars_per_token"`
 	ProviderCharsPerToken map[string]int `yaml:"provider_chars_per_token,omitempty"`
+	// DefaultOutputTokens is the output assumed when estimating the cost of a
+	// request that sets no max_tokens (0 counts no output until usage is known)
+	DefaultOutputTokens int `yaml:"default_output_tokens,omitempty"`
+}
+
+// UpstreamThrottleConfig controls proactive throttling using the rate-limit
+// headers returned by providers (x-ratelimit-*, anthropic-ratelimit-*).
+type UpstreamThrottleConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Requests are held back once upstream remaining requests drop to this value
+	MinRemainingRequests int `yaml:"min_remaining_requests,omitempty"`
+	// Tokens kept in reserve below the upstream remaining token count
+	MinRemainingTokens int `yaml:"min_remaining_tokens,omitempty"`
+	// If the upstream window resets within this many milliseconds, wait for it
+	// instead of rejecting the request. 0 always rejects.
+	MaxQueueWaitMs int `yaml:"max_queue_wait_ms,omitempty"`
 }
 
 // RedisConfig contains Redis backend settings
@@ -225,33 +465,41 @@ func loadYAMLConfigWithoutValidation(filename string) (*YAMLConfig, error) {
 	return &config, nil
 }
 
+// environment returns the ENVIRONMENT variable, defaulting to "dev"
+func environment() string {
+	if env := os.Getenv("ENVIRONMENT"); env != "" {
+		return env
+	}
+	return "dev"
+}
+
+// EnvironmentConfigPaths returns the base and environment-specific
+// configuration files read by LoadEnvironmentConfig
+func EnvironmentConfigPaths() (base, env string) {
+	configDir := "configs"
+	return filepath.Join(configDir, "base.yml"), filepath.Join(configDir, fmt.Sprintf("%s.yml", environment()))
+}
+
 // LoadEnvironmentConfig loads base configuration and overlays environment-specific configuration
 // based on the ENVIRONMENT variable (defaults to "dev")
 func LoadEnvironmentConfig() (*YAMLConfig, error) {
-	configDir := "configs"
+	basePath, envConfigPath := EnvironmentConfigPaths()
 
 	// Load base configuration
-	baseConfig, err := LoadYAMLConfig(filepath.Join(configDir, "base.yml"))
+	baseConfig, err := LoadYAMLConfig(basePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load base configuration: %w", err)
 	}
-
-	// Get environment from environment variable, default to "dev"
-	env := os.Getenv("ENVIRONMENT")
-	if env == "" {
-		env = "dev"
-	}
-	slog.Info("Loading environment configuration", "environment", env)
+	slog.Info("Loading environment configuration", "environment", environment())
 
 	// Load environment-specific configuration (skip validation since it's just overrides)
-	envConfigPath := filepath.Join(configDir, fmt.Sprintf("%s.yml", env))
 	envConfig, err := loadYAMLConfigWithoutValidation(envConfigPath)
 	if err != nil {
 		// If environment config doesn't exist, just use base config
 		if os.IsNotExist(err) {
 			return baseConfig, nil
 		}
-		return nil, fmt.Errorf("failed to load environment configuration for %s: %w", env, err)
+		return nil, fmt.Errorf("failed to load environment configuration for %s: %w", environment(), err)
 	}
 
 	// Merge environment config into base config
@@ -413,6 +661,55 @@ func (c *YAMLConfig) Validate() error {
 		}
 	}
 
+	// Validate API key management configuration if enabled
+	if c.Features.APIKeyManagement.Enabled {
+		if err := c.validateAPIKeyManagementConfig(); err != nil {
+			return fmt.Errorf("invalid api key management configuration: %w", err)
+		}
+	}
+
+	// Validate redaction modes if enabled
+	if c.Features.Redaction.Enabled {
+		if err := c.validateRedactionConfig(); err != nil {
+			return fmt.Errorf("invalid redaction configuration: %w", err)
+		}
+	}
+
+	// Validate response cache configuration if enabled
+	if c.Features.ResponseCache.Enabled {
+		if err := c.validateResponseCacheConfig(); err != nil {
+			return fmt.Errorf("invalid response cache configuration: %w", err)
+		}
+	}
+
+	// Validate coalescing configuration if enabled
+	if c.Features.Coalescing.Enabled {
+		co := c.Features.Coalescing
+		if co.MaxBodyBytes < 0 {
+			return fmt.Errorf("invalid coalescing configuration: max_body_bytes cannot be negative")
+		}
+		for _, route := range co.Routes {
+			if !strings.HasPrefix(route, "/") {
+				return fmt.Errorf("invalid coalescing configuration: route %q must start with /", route)
+			}
+		}
+	}
+
+	if c.Features.ConfigReload.IntervalSeconds < 0 {
+		return fmt.Errorf("invalid config reload configuration: interval_seconds cannot be negative")
+	}
+
+	// Validate API key cache configuration if enabled
+	if c.Features.APIKeyManagement.Cache.Enabled {
+		cache := c.Features.APIKeyManagement.Cache
+		if cache.MaxEntries < 0 || cache.TTLSeconds < 0 || cache.NegativeTTLSeconds < 0 || cache.StaleGraceSeconds < 0 {
+			return fmt.Errorf("invalid api key cache configuration: settings cannot be negative")
+		}
+		if cache.Redis != nil && cache.Redis.Address == "" {
+			return fmt.Errorf("invalid api key cache configuration: redis.address is empty")
+		}
+	}
+
 	return nil
 }
 
@@ -475,6 +772,92 @@ func (c *YAMLConfig) GetAllTransports() []TransportConfig {
 	return c.Features.CostTracking.Transports
 }
 
+// validateAPIKeyManagementConfig validates the key store backend settings
+func (c *YAMLConfig) validateAPIKeyManagementConfig() error {
+	km := c.Features.APIKeyManagement
+	switch km.Backend {
+	case "", "dynamodb":
+		if km.TableName == "" || km.Region == "" {
+			return fmt.Errorf("table_name and region are required for the dynamodb backend")
+		}
+	case "file", "sqlite":
+		if km.Path == "" {
+			return fmt.Errorf("path is required for the %s backend", km.Backend)
+		}
+	case "memory":
+		// keys live only for the lifetime of the process
+	default:
+		return fmt.Errorf("unsupported backend: %s (supported: dynamodb, file, sqlite, memory)", km.Backend)
+	}
+	if km.ReloadIntervalSeconds < 0 {
+		return fmt.Errorf("reload_interval_seconds cannot be negative")
+	}
+	for _, cidr := range km.TrustedProxyCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid trusted_proxy_cidrs entry %q: %w", cidr, err)
+		}
+	}
+	if km.Encryption.Enabled {
+		switch km.Encryption.Provider {
+		case "", "local":
+		case "kms":
+			if km.Encryption.KMSKeyID == "" {
+				return fmt.Errorf("encryption.kms_key_id is required for the kms provider")
+			}
+		default:
+			return fmt.Errorf("unsupported encryption provider: %s (supported: local, kms)", km.Encryption.Provider)
+		}
+	}
+	return nil
+}
+
+// validateRedactionConfig checks that every configured mode is known
+func (c *YAMLConfig) validateRedactionConfig() error {
+	rc := c.Features.Redaction
+	modes := map[string]string{"mode": rc.Mode}
+	for id, mode := range rc.PerKey {
+		modes[fmt.Sprintf("per_key[%s]", id)] = mode
+	}
+	for id, mode := range rc.PerTeam {
+		modes[fmt.Sprintf("per_team[%s]", id)] = mode
+	}
+	for name, mode := range modes {
+		switch mode {
+		case "off", "log", "mask", "block":
+		case "":
+			if name != "mode" {
+				return fmt.Errorf("%s: mode is empty", name)
+			}
+		default:
+			return fmt.Errorf("%s: unsupported mode %q (supported: off, log, mask, block)", name, mode)
+		}
+	}
+	return nil
+}
+
+// validateResponseCacheConfig checks the backend, scope and limits
+func (c *YAMLConfig) validateResponseCacheConfig() error {
+	rc := c.Features.ResponseCache
+	switch rc.Backend {
+	case "", "memory":
+	case "redis":
+		if rc.Redis == nil || rc.Redis.Address == "" {
+			return fmt.Errorf("redis backend selected but redis.address is empty")
+		}
+	default:
+		return fmt.Errorf("unsupported backend: %s (supported: memory, redis)", rc.Backend)
+	}
+	switch rc.Scope {
+	case "", "key", "team":
+	default:
+		return fmt.Errorf("unsupported scope: %s (supported: key, team)", rc.Scope)
+	}
+	if rc.TTLSeconds < 0 || rc.MaxBodyBytes < 0 || rc.MaxEntries < 0 {
+		return fmt.Errorf("settings cannot be negative")
+	}
+	return nil
+}
+
 // validateRateLimitingConfig validates the rate limiting configuration
 func (c *YAMLConfig) validateRateLimitingConfig() error {
 	rl := c.Features.RateLimiting
@@ -489,12 +872,35 @@ func (c *YAMLConfig) validateRateLimitingConfig() error {
 		return fmt.Errorf("unsupported backend: %s (supported: memory, redis)", rl.Backend)
 	}
 
+	if err := validateCostLimits("limits", rl.Limits); err != nil {
+		return err
+	}
+	for name, group := range map[string]map[string]LimitsConfig{
+		"per_key":   rl.Overrides.PerKey,
+		"per_user":  rl.Overrides.PerUser,
+		"per_team":  rl.Overrides.PerTeam,
+		"per_model": rl.Overrides.PerModel,
+	} {
+		for id, l := range group {
+			if err := validateCostLimits(fmt.Sprintf("overrides.%s[%s]", name, id), l); err != nil {
+				return err
+			}
+		}
+	}
+
+	if rl.Upstream.MinRemainingRequests < 0 || rl.Upstream.MinRemainingTokens < 0 || rl.Upstream.MaxQueueWaitMs < 0 {
+		return fmt.Errorf("upstream throttle settings cannot be negative")
+	}
+
 	if rl.Estimation.BytesPerToken < 0 {
 		return fmt.Errorf("estimation.bytes_per_token cannot be negative")
 	}
 	if rl.Estimation.CharsPerToken < 0 {
 		return fmt.Errorf("estimation.chars_per_token cannot be negative")
 	}
+	if rl.Estimation.DefaultOutputTokens < 0 {
+		return fmt.Errorf("estimation.default_output_tokens cannot be negative")
+	}
 	if rl.Estimation.MaxSampleBytes < -1 {
 		return fmt.Errorf("estimation.max_sample_bytes cannot be less than -1")
 	}
@@ -509,6 +915,20 @@ func (c *YAMLConfig) validateRateLimitingConfig() error {
 	return nil
 }
 
+// validateCostLimits ensures cost limits are not negative
+func validateCostLimits(path string, l LimitsConfig) error {
+	if l.CostPerMinute < 0 {
+		return fmt.Errorf("%s.cost_per_minute cannot be negative", path)
+	}
+	if l.CostPerHour < 0 {
+		return fmt.Errorf("%s.cost_per_hour cannot be negative", path)
+	}
+	if l.CostPerDay < 0 {
+		return fmt.Errorf("%s.cost_per_day cannot be negative", path)
+	}
+	return nil
+}
+
 // ParsePricing iterates through all models and parses the flexible `Pricing` field
 // into a structured `ModelPricing` object.
 func (c *YAMLConfig) ParsePricing() error {
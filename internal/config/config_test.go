@@ -381,3 +381,58 @@ func TestDefaultConfig(t *testing.T) {
 		t.Error("Expected cost tracking to be enabled in default config")
 	}
 }
+
+func TestRedactionModes(t *testing.T) {
+	rc := RedactionConfig{
+		Enabled: true,
+		PerKey:  map[string]string{"key-1": "off"},
+		PerTeam: map[string]string{"finance": "block"},
+	}
+	for _, tc := range []struct{ key, team, want string }{
+		{"key-1", "finance", "off"},
+		{"key-2", "finance", "block"},
+		{"", "", "mask"},
+	} {
+		if got := rc.ModeFor(tc.key, tc.team); got != tc.want {
+			t.Errorf("ModeFor(%q, %q) = %q, want %q", tc.key, tc.team, got, tc.want)
+		}
+	}
+
+	cfg := &YAMLConfig{Providers: map[string]ProviderConfig{}}
+	cfg.Features.Redaction = rc
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	cfg.Features.Redaction.PerTeam["eng"] = "redact"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an unknown mode to be rejected")
+	}
+}
+
+func TestResponseCacheValidation(t *testing.T) {
+	cfg := &YAMLConfig{Providers: map[string]ProviderConfig{}}
+	cfg.Features.ResponseCache = ResponseCacheConfig{Enabled: true, Scope: "team"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	cfg.Features.ResponseCache.Backend = "redis"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected the redis backend to require an address")
+	}
+	cfg.Features.ResponseCache = ResponseCacheConfig{Enabled: true, Scope: "user"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an unknown scope to be rejected")
+	}
+}
+
+func TestCoalescingValidation(t *testing.T) {
+	cfg := &YAMLConfig{Providers: map[string]ProviderConfig{}}
+	cfg.Features.Coalescing = CoalescingConfig{Enabled: true, Routes: []string{"/openai/v1/embeddings"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	cfg.Features.Coalescing.Routes = []string{"openai/v1/embeddings"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected a relative route to be rejected")
+	}
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultReloadInterval is how often watched configuration files are checked
+// when IntervalSeconds is unset
+const DefaultReloadInterval = 10 * time.Second
+
+// Hash returns a short hex digest of the configuration, identifying it
+// across reloads and instances
+func (c *YAMLConfig) Hash() (string, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal config: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// ReloadStats describes the reloads of a Reloader
+type ReloadStats struct {
+	Hash       string    `json:"config_hash"`
+	Reloads    int       `json:"reloads"`
+	Failures   int       `json:"reload_failures"`
+	LastReload time.Time `json:"last_reload,omitempty"`
+	LastError  string    `json:"last_reload_error,omitempty"`
+}
+
+// Reloader loads new configurations and hands the valid, changed ones to
+// apply. A configuration that fails to load or validate, or that apply
+// returns an error for, is rejected and the current one stays in place.
+// Changes are detected by hashing configurations as loaded, before apply
+// adds anything to them.
+type Reloader struct {
+	load   func() (*YAMLConfig, error)
+	apply  func(*YAMLConfig) error
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	current *YAMLConfig
+	stats   ReloadStats
+}
+
+// NewReloader creates a Reloader for the running configuration current,
+// whose hash as loaded is currentHash
+func NewReloader(current *YAMLConfig, currentHash string, load func() (*YAMLConfig, error), apply func(*YAMLConfig) error, logger *slog.Logger) *Reloader {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	r := &Reloader{load: load, apply: apply, logger: logger, current: current}
+	r.stats.Hash = currentHash
+	return r
+}
+
+// Reload loads the configuration and applies it if it changed. It returns
+// an error, leaving the current configuration in place, when the new one
+// cannot be loaded.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, hash, err := r.loadChanged()
+	if err != nil {
+		r.stats.Failures++
+		r.stats.LastError = err.Error()
+		r.logger.Error("🔄 Config Reload: Rejected new configuration; keeping the current one", "error", err, "config_hash", r.stats.Hash)
+		return fmt.Errorf("failed to reload configuration: %w", err)
+	}
+	if next == nil {
+		r.logger.Info("🔄 Config Reload: Configuration unchanged", "config_hash", hash)
+		return nil
+	}
+	r.current = next
+	r.stats.Hash = hash
+	r.stats.Reloads++
+	r.stats.LastReload = time.Now().UTC()
+	r.stats.LastError = ""
+	r.logger.Info("🔄 Config Reload: Applied new configuration", "config_hash", hash, "reloads", r.stats.Reloads)
+	return nil
+}
+
+// loadChanged loads the configuration and applies it, returning it with its
+// hash, or nil when it has not changed
+func (r *Reloader) loadChanged() (*YAMLConfig, string, error) {
+	next, err := r.load()
+	if err != nil {
+		return nil, "", err
+	}
+	hash, err := next.Hash()
+	if err != nil {
+		return nil, "", err
+	}
+	if hash == r.stats.Hash {
+		return nil, hash, nil
+	}
+	if err := r.apply(next); err != nil {
+		return nil, "", err
+	}
+	return next, hash, nil
+}
+
+// Current returns the configuration in effect
+func (r *Reloader) Current() *YAMLConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Stats returns the reload counters and the current configuration's hash
+func (r *Reloader) Stats() ReloadStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// Watch reloads the configuration whenever one of paths changes, checking
+// every interval until ctx is done. Missing files are watched for creation.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, paths ...string) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	last := fileVersions(paths)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := fileVersions(paths)
+			if current == last {
+				continue
+			}
+			last = current
+			r.logger.Info("🔄 Config Reload: Configuration files changed", "paths", paths)
+			_ = r.Reload()
+		}
+	}
+}
+
+// fileVersions summarizes the modification time and size of paths
+func fileVersions(paths []string) string {
+	var versions string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			versions += fmt.Sprintf("%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+		} else {
+			versions += path + ":missing;"
+		}
+	}
+	return versions
+}
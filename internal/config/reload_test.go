@@ -0,0 +1,97 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloaderKeepsCurrentConfigOnError(t *testing.T) {
+	current := GetDefaultYAMLConfig()
+	next := GetDefaultYAMLConfig()
+	next.Features.RateLimiting.Limits.RequestsPerMinute = 42
+
+	var loadErr error
+	load := func() (*YAMLConfig, error) {
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return next, nil
+	}
+	var applied []*YAMLConfig
+	initial, err := current.Hash()
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	// apply adds settings from elsewhere, which must not count as a change
+	r := NewReloader(current, initial, load, func(c *YAMLConfig) error {
+		applied = append(applied, c)
+		c.Features.RateLimiting.Overrides.PerTeam = map[string]LimitsConfig{"eng": {RequestsPerMinute: 1}}
+		return nil
+	}, nil)
+
+	loadErr = errors.New("invalid merged configuration")
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected the invalid config to be rejected")
+	}
+	if stats := r.Stats(); r.Current() != current || stats.Hash != initial || stats.Failures != 1 || stats.LastError == "" || len(applied) != 0 {
+		t.Fatalf("expected the current config to stay in place, got %+v", stats)
+	}
+
+	loadErr = nil
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	stats := r.Stats()
+	if r.Current() != next || stats.Hash == initial || stats.Reloads != 1 || stats.LastError != "" || len(applied) != 1 {
+		t.Fatalf("expected the new config to be applied, got %+v", stats)
+	}
+
+	// Reloading an unchanged config applies nothing
+	next = GetDefaultYAMLConfig()
+	next.Features.RateLimiting.Limits.RequestsPerMinute = 42
+	if err := r.Reload(); err != nil || r.Stats().Reloads != 1 || len(applied) != 1 {
+		t.Fatalf("expected an unchanged config to be skipped, got %+v", r.Stats())
+	}
+
+	// A config that apply rejects leaves the current one in place
+	next = GetDefaultYAMLConfig()
+	applyErr := errors.New("team limits unavailable")
+	r.apply = func(*YAMLConfig) error { return applyErr }
+	if err := r.Reload(); !errors.Is(err, applyErr) || r.Current() == next || r.Stats().Failures != 2 {
+		t.Fatalf("expected the config to be rejected, got %v, %+v", err, r.Stats())
+	}
+}
+
+func TestReloaderWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "base.yml")
+	if err := os.WriteFile(path, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := make(chan struct{}, 1)
+	load := func() (*YAMLConfig, error) {
+		c := GetDefaultYAMLConfig()
+		c.Features.RateLimiting.Limits.RequestsPerMinute = 7
+		return c, nil
+	}
+	r := NewReloader(GetDefaultYAMLConfig(), "", load, func(*YAMLConfig) error {
+		reloaded <- struct{}{}
+		return nil
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 5*time.Millisecond, path)
+	time.Sleep(20 * time.Millisecond)
+	if err := os.WriteFile(path, []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a file change to trigger a reload")
+	}
+}
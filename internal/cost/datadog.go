@@ -143,19 +143,29 @@ func NewDatadogTransportFromConfig(transportConfig interface{}, logger *slog.Log
 // WriteRecord writes a cost record to Datadog as metrics
 func (dt *DatadogTransport) WriteRecord(record *CostRecord) error {
 	// Create metric tags from the record
-	tags := make([]string, 0, len(dt.tags)+6)
+	tags := make([]string, 0, len(dt.tags)+10)
 	tags = append(tags, dt.tags...)
 	tags = append(tags,
 		fmt.Sprintf("provider:%s", record.Provider),
 		fmt.Sprintf("model:%s", record.Model),
 		fmt.Sprintf("endpoint:%s", record.Endpoint),
 		fmt.Sprintf("streaming:%t", record.IsStreaming),
+		fmt.Sprintf("cached:%t", record.Cached),
+		fmt.Sprintf("coalesced:%t", record.Coalesced),
 	)
 
 	if record.UserID != "" {
 		tags = append(tags, fmt.Sprintf("user_id:%s", record.UserID))
 	}
 
+	if record.TeamID != "" {
+		tags = append(tags, fmt.Sprintf("team_id:%s", record.TeamID))
+	}
+
+	if record.ProjectID != "" {
+		tags = append(tags, fmt.Sprintf("project_id:%s", record.ProjectID))
+	}
+
 	if record.FinishReason != "" {
 		tags = append(tags, fmt.Sprintf("finish_reason:%s", record.FinishReason))
 	}
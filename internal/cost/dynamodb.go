@@ -31,30 +31,38 @@ type DynamoDBTransport struct {
 
 // DynamoDBCostRecord represents a cost record as stored in DynamoDB
 type DynamoDBCostRecord struct {
-	PK           string  `dynamodbav:"pk"`        // Partition key: "COST#YYYY-MM-DD"
-	SK           string  `dynamodbav:"sk"`        // Sort key: "TIMESTAMP#requestId"
-	GSI1PK       string  `dynamodbav:"gsi1pk"`    // ProviderModelIndex partition key: "PROVIDER#providerName"
-	GSI1SK       string  `dynamodbav:"gsi1sk"`    // ProviderModelIndex sort key: "MODEL#modelName#TIMESTAMP"
-	GSI2PK       string  `dynamodbav:"gsi2pk"`    // UserProviderIndex partition key: "USER#userID"
-	GSI2SK       string  `dynamodbav:"gsi2sk"`    // UserProviderIndex sort key: "PROVIDER#providerName#TIMESTAMP"
-	GSI3PK       string  `dynamodbav:"gsi3pk"`    // ModelProviderIndex partition key: "MODEL#modelName"
-	GSI3SK       string  `dynamodbav:"gsi3sk"`    // ModelProviderIndex sort key: "PROVIDER#providerName#TIMESTAMP"
-	TTL          int64   `dynamodbav:"ttl"`       // TTL for automatic cleanup (optional)
-	Timestamp    int64   `dynamodbav:"timestamp"` // Unix timestamp for easier queries
-	RequestID    string  `dynamodbav:"request_id,omitempty"`
-	UserID       string  `dynamodbav:"user_id,omitempty"`
-	IPAddress    string  `dynamodbav:"ip_address,omitempty"`
-	Provider     string  `dynamodbav:"provider"`
-	Model        string  `dynamodbav:"model"`
-	Endpoint     string  `dynamodbav:"endpoint"`
-	IsStreaming  bool    `dynamodbav:"is_streaming"`
-	InputTokens  int     `dynamodbav:"input_tokens"`
-	OutputTokens int     `dynamodbav:"output_tokens"`
-	TotalTokens  int     `dynamodbav:"total_tokens"`
-	InputCost    float64 `dynamodbav:"input_cost"`
-	OutputCost   float64 `dynamodbav:"output_cost"`
-	TotalCost    float64 `dynamodbav:"total_cost"`
-	FinishReason string  `dynamodbav:"finish_reason,omitempty"`
+	PK           string         `dynamodbav:"pk"`               // Partition key: "COST#YYYY-MM-DD"
+	SK           string         `dynamodbav:"sk"`               // Sort key: "TIMESTAMP#requestId"
+	GSI1PK       string         `dynamodbav:"gsi1pk"`           // ProviderModelIndex partition key: "PROVIDER#providerName"
+	GSI1SK       string         `dynamodbav:"gsi1sk"`           // ProviderModelIndex sort key: "MODEL#modelName#TIMESTAMP"
+	GSI2PK       string         `dynamodbav:"gsi2pk"`           // UserProviderIndex partition key: "USER#userID"
+	GSI2SK       string         `dynamodbav:"gsi2sk"`           // UserProviderIndex sort key: "PROVIDER#providerName#TIMESTAMP"
+	GSI3PK       string         `dynamodbav:"gsi3pk"`           // ModelProviderIndex partition key: "MODEL#modelName"
+	GSI3SK       string         `dynamodbav:"gsi3sk"`           // ModelProviderIndex sort key: "PROVIDER#providerName#TIMESTAMP"
+	GSI4PK       string         `dynamodbav:"gsi4pk,omitempty"` // TeamProjectIndex partition key: "TEAM#teamID" (only set for team requests)
+	GSI4SK       string         `dynamodbav:"gsi4sk,omitempty"` // TeamProjectIndex sort key: "PROJECT#projectID#TIMESTAMP"
+	TTL          int64          `dynamodbav:"ttl"`              // TTL for automatic cleanup (optional)
+	Timestamp    int64          `dynamodbav:"timestamp"`        // Unix timestamp for easier queries
+	RequestID    string         `dynamodbav:"request_id,omitempty"`
+	UpstreamID   string         `dynamodbav:"upstream_request_id,omitempty"`
+	UserID       string         `dynamodbav:"user_id,omitempty"`
+	TeamID       string         `dynamodbav:"team_id,omitempty"`
+	ProjectID    string         `dynamodbav:"project_id,omitempty"`
+	IPAddress    string         `dynamodbav:"ip_address,omitempty"`
+	Provider     string         `dynamodbav:"provider"`
+	Model        string         `dynamodbav:"model"`
+	Endpoint     string         `dynamodbav:"endpoint"`
+	IsStreaming  bool           `dynamodbav:"is_streaming"`
+	InputTokens  int            `dynamodbav:"input_tokens"`
+	OutputTokens int            `dynamodbav:"output_tokens"`
+	TotalTokens  int            `dynamodbav:"total_tokens"`
+	InputCost    float64        `dynamodbav:"input_cost"`
+	OutputCost   float64        `dynamodbav:"output_cost"`
+	TotalCost    float64        `dynamodbav:"total_cost"`
+	FinishReason string         `dynamodbav:"finish_reason,omitempty"`
+	Redactions   map[string]int `dynamodbav:"redactions,omitempty"`
+	Cached       bool           `dynamodbav:"cached,omitempty"`
+	Coalesced    bool           `dynamodbav:"coalesced,omitempty"`
 }
 
 // NewDynamoDBTransport creates a new DynamoDB-based transport
@@ -203,6 +211,14 @@ func (dt *DynamoDBTransport) ensureTableExists(ctx context.Context) error {
 				AttributeName: aws.String("gsi3sk"),
 				AttributeType: types.ScalarAttributeTypeS,
 			},
+			{
+				AttributeName: aws.String("gsi4pk"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("gsi4sk"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
 		},
 		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
 			{
@@ -253,6 +269,23 @@ func (dt *DynamoDBTransport) ensureTableExists(ctx context.Context) error {
 					ProjectionType: types.ProjectionTypeAll,
 				},
 			},
+			{
+				// Sparse: only requests attributed to a team are indexed
+				IndexName: aws.String("TeamProjectIndex"),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("gsi4pk"),
+						KeyType:       types.KeyTypeHash,
+					},
+					{
+						AttributeName: aws.String("gsi4sk"),
+						KeyType:       types.KeyTypeRange,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
 		},
 		BillingMode: types.BillingModePayPerRequest,
 	}
@@ -311,9 +344,15 @@ func (dt *DynamoDBTransport) toDynamoDBRecord(record *CostRecord) *DynamoDBCostR
 	dateStr := record.Timestamp.Format("2006-01-02")
 	timestampStr := record.Timestamp.Format("2006-01-02T15:04:05.000Z")
 
-	return &DynamoDBCostRecord{
+	// Records tracked outside the proxy's middleware may lack a proxy ID
+	sortID := record.RequestID
+	if sortID == "" {
+		sortID = record.UpstreamRequestID
+	}
+
+	dynamoRecord := &DynamoDBCostRecord{
 		PK:           fmt.Sprintf("COST#%s", dateStr),
-		SK:           fmt.Sprintf("TIMESTAMP#%s#%s", timestampStr, record.RequestID),
+		SK:           fmt.Sprintf("TIMESTAMP#%s#%s", timestampStr, sortID),
 		GSI1PK:       fmt.Sprintf("PROVIDER#%s", record.Provider),
 		GSI1SK:       fmt.Sprintf("MODEL#%s#%s", record.Model, timestampStr),
 		GSI2PK:       fmt.Sprintf("USER#%s", record.UserID),
@@ -323,6 +362,7 @@ func (dt *DynamoDBTransport) toDynamoDBRecord(record *CostRecord) *DynamoDBCostR
 		TTL:          record.Timestamp.AddDate(1, 0, 0).Unix(), // 1 year TTL
 		Timestamp:    record.Timestamp.Unix(),
 		RequestID:    record.RequestID,
+		UpstreamID:   record.UpstreamRequestID,
 		UserID:       record.UserID,
 		IPAddress:    record.IPAddress,
 		Provider:     record.Provider,
@@ -336,5 +376,15 @@ func (dt *DynamoDBTransport) toDynamoDBRecord(record *CostRecord) *DynamoDBCostR
 		OutputCost:   record.OutputCost,
 		TotalCost:    record.TotalCost,
 		FinishReason: record.FinishReason,
+		TeamID:       record.TeamID,
+		ProjectID:    record.ProjectID,
+		Redactions:   record.Redactions,
+		Cached:       record.Cached,
+		Coalesced:    record.Coalesced,
+	}
+	if record.TeamID != "" {
+		dynamoRecord.GSI4PK = fmt.Sprintf("TEAM#%s", record.TeamID)
+		dynamoRecord.GSI4SK = fmt.Sprintf("PROJECT#%s#%s", record.ProjectID, timestampStr)
 	}
+	return dynamoRecord
 }
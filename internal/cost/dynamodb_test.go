@@ -3,6 +3,7 @@ package cost
 import (
 	"log/slog"
 	"testing"
+	"time"
 
 	"github.com/Instawork/llm-proxy/internal/providers"
 )
@@ -55,7 +56,7 @@ func TestDynamoDBTransportIntegration(t *testing.T) {
 	}
 
 	// Track a test request
-	err = tracker.TrackRequest(metadata, "test-user", "192.168.1.1", "/v1/chat/completions")
+	err = tracker.TrackRequest(metadata, "01JA0000000000000000000000", "test-user", "test-team", "", "192.168.1.1", "/v1/chat/completions", nil)
 	if err != nil {
 		t.Errorf("Failed to track request: %v", err)
 	}
@@ -63,3 +64,22 @@ func TestDynamoDBTransportIntegration(t *testing.T) {
 	// Test passed - successfully wrote cost record to DynamoDB
 	t.Log("Successfully tracked cost record to DynamoDB (write-only)")
 }
+
+func TestToDynamoDBRecordTeamIndex(t *testing.T) {
+	dt := &DynamoDBTransport{}
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	record := dt.toDynamoDBRecord(&CostRecord{Timestamp: ts, RequestID: "r1", Provider: "openai", Model: "gpt-4o", TeamID: "eng", ProjectID: "eng-ci"})
+	if record.TeamID != "eng" || record.ProjectID != "eng-ci" {
+		t.Fatalf("team attributes not copied: %+v", record)
+	}
+	if record.GSI4PK != "TEAM#eng" || record.GSI4SK != "PROJECT#eng-ci#2025-01-02T03:04:05.000Z" {
+		t.Fatalf("unexpected team index keys: %q %q", record.GSI4PK, record.GSI4SK)
+	}
+
+	// Requests without a team stay out of the sparse team index
+	record = dt.toDynamoDBRecord(&CostRecord{Timestamp: ts, RequestID: "r2", Provider: "openai", Model: "gpt-4o"})
+	if record.GSI4PK != "" || record.GSI4SK != "" {
+		t.Fatalf("expected no team index keys, got %q %q", record.GSI4PK, record.GSI4SK)
+	}
+}
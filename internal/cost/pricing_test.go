@@ -0,0 +1,38 @@
+package cost
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplacePricing(t *testing.T) {
+	ct := NewCostTracker()
+	ct.SetPricingForModel("openai", "gpt-4", &ModelPricing{Tiers: []PricingTier{{Input: 30, Output: 60}}})
+
+	// Lookups run concurrently with reloads; run with -race to check the swap
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			_, _, _, _, _, _ = ct.CalculateCostWithFuzzyMatch("openai", "gpt-4", 1000, 1000)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		ct.ReplacePricing(map[string]map[string]*ModelPricing{
+			"openai": {"gpt-4": {Tiers: []PricingTier{{Input: 30, Output: 60}}}},
+		})
+	}
+	wg.Wait()
+
+	ct.ReplacePricing(map[string]map[string]*ModelPricing{
+		"openai": {"gpt-4o": {Tiers: []PricingTier{{Input: 2.5, Output: 10}}}},
+	})
+	_, err := ct.GetPricingForModel("openai", "gpt-4", 1000)
+	assert.Error(t, err, "models missing from the new table should lose their pricing")
+	tier, err := ct.GetPricingForModel("openai", "gpt-4o", 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.5, tier.Input)
+}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"log/slog"
 	"math"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/metrics"
 	"github.com/Instawork/llm-proxy/internal/providers"
 	"github.com/hbollon/go-edlib"
 )
@@ -45,10 +47,13 @@ type ModelPricing struct {
 // CostRecord represents a single request with cost information
 type CostRecord struct {
 	// Timestamp and identification
-	Timestamp time.Time `json:"timestamp"`
-	RequestID string    `json:"request_id,omitempty"`
-	UserID    string    `json:"user_id,omitempty"`
-	IPAddress string    `json:"ip_address,omitempty"`
+	Timestamp         time.Time `json:"timestamp"`
+	RequestID         string    `json:"request_id,omitempty"`          // The proxy's ID (X-Request-ID)
+	UpstreamRequestID string    `json:"upstream_request_id,omitempty"` // The provider's ID for the response, if any
+	UserID            string    `json:"user_id,omitempty"`
+	TeamID            string    `json:"team_id,omitempty"`    // Team for chargeback (see apikeys.Team)
+	ProjectID         string    `json:"project_id,omitempty"` // Project under TeamID, if any
+	IPAddress         string    `json:"ip_address,omitempty"`
 
 	// Request details
 	Provider    string `json:"provider"`
@@ -70,11 +75,23 @@ type CostRecord struct {
 	// Additional metadata
 	FinishReason string `json:"finish_reason,omitempty"`
 	MatchedModel string `json:"matched_model,omitempty"` // The actual model used for pricing (if different from requested model)
+
+	// Redaction audit: detections per detector found in the prompt
+	Redactions map[string]int `json:"redactions,omitempty"`
+
+	// Cached is set when the response was served from the response cache;
+	// the tokens are those of the original response and the cost is zero
+	Cached bool `json:"cached,omitempty"`
+
+	// Coalesced is set when the request shared another in-flight request's
+	// upstream call; the cost is attributed to that request
+	Coalesced bool `json:"coalesced,omitempty"`
 }
 
 // CostTracker manages cost tracking and output through transports
 type CostTracker struct {
-	pricingConfig map[string]map[string]*ModelPricing // provider -> model -> pricing
+	pricingConfig map[string]map[string]*ModelPricing // provider -> model -> pricing; replaced, never mutated, once published
+	pricingMu     sync.RWMutex                        // Guards pricingConfig
 	transports    []Transport                         // Multiple transports for parallel writes
 	logger        *slog.Logger
 
@@ -333,16 +350,48 @@ func (ct *CostTracker) processRemainingRecords(workerID int) {
 
 // SetPricingForModel sets pricing information for a specific provider and model
 func (ct *CostTracker) SetPricingForModel(provider, model string, pricing *ModelPricing) {
-	if ct.pricingConfig[provider] == nil {
-		ct.pricingConfig[provider] = make(map[string]*ModelPricing)
-	}
-	ct.pricingConfig[provider][model] = pricing
+	ct.pricingMu.Lock()
+	defer ct.pricingMu.Unlock()
+	// Copy on write so lookups holding the previous table are unaffected
+	next := make(map[string]map[string]*ModelPricing, len(ct.pricingConfig)+1)
+	for p, models := range ct.pricingConfig {
+		next[p] = models
+	}
+	models := make(map[string]*ModelPricing, len(next[provider])+1)
+	for m, mp := range next[provider] {
+		models[m] = mp
+	}
+	models[model] = pricing
+	next[provider] = models
+	ct.pricingConfig = next
 	ct.logger.Debug("💰 Cost Tracker: Set pricing for model", "provider", provider, "model", model)
 }
 
+// ReplacePricing atomically swaps in a complete pricing table (provider ->
+// model -> pricing), e.g. after a configuration reload. The tracker takes
+// ownership of pricing.
+func (ct *CostTracker) ReplacePricing(pricing map[string]map[string]*ModelPricing) {
+	ct.pricingMu.Lock()
+	ct.pricingConfig = pricing
+	ct.pricingMu.Unlock()
+	ct.logger.Debug("💰 Cost Tracker: Replaced pricing", "providers", len(pricing))
+}
+
+// pricing returns the current pricing table, which must not be modified
+func (ct *CostTracker) pricing() map[string]map[string]*ModelPricing {
+	ct.pricingMu.RLock()
+	defer ct.pricingMu.RUnlock()
+	return ct.pricingConfig
+}
+
 // GetPricingForModel retrieves pricing information for a specific provider and model
 func (ct *CostTracker) GetPricingForModel(provider, model string, inputTokens int) (*PricingTier, error) {
-	if providerPricing, exists := ct.pricingConfig[provider]; exists {
+	return lookupPricing(ct.pricing(), provider, model, inputTokens)
+}
+
+// lookupPricing finds the pricing tier for a provider and model in table
+func lookupPricing(table map[string]map[string]*ModelPricing, provider, model string, inputTokens int) (*PricingTier, error) {
+	if providerPricing, exists := table[provider]; exists {
 		if modelPricing, exists := providerPricing[model]; exists {
 			// Handle overrides first
 			if override, ok := modelPricing.Overrides[model]; ok {
@@ -362,8 +411,8 @@ func (ct *CostTracker) GetPricingForModel(provider, model string, inputTokens in
 }
 
 // findClosestModelMatch uses fuzzy string matching to find the closest model name
-func (ct *CostTracker) findClosestModelMatch(provider, model string) (string, float64, error) {
-	providerPricing, exists := ct.pricingConfig[provider]
+func (ct *CostTracker) findClosestModelMatch(table map[string]map[string]*ModelPricing, provider, model string) (string, float64, error) {
+	providerPricing, exists := table[provider]
 	if !exists {
 		return "", 0, fmt.Errorf("provider %s not found", provider)
 	}
@@ -398,20 +447,23 @@ func (ct *CostTracker) findClosestModelMatch(provider, model string) (string, fl
 
 // GetPricingForModelWithFuzzyMatch retrieves pricing information with fuzzy matching fallback
 func (ct *CostTracker) GetPricingForModelWithFuzzyMatch(provider, model string, inputTokens int) (*PricingTier, string, bool, error) {
+	// Use one table for the whole lookup so a concurrent reload cannot mix them
+	table := ct.pricing()
+
 	// First try exact match
-	pricing, err := ct.GetPricingForModel(provider, model, inputTokens)
+	pricing, err := lookupPricing(table, provider, model, inputTokens)
 	if err == nil {
 		return pricing, model, false, nil // Exact match found
 	}
 
 	// If exact match fails, try fuzzy matching
-	closestModel, similarity, err := ct.findClosestModelMatch(provider, model)
+	closestModel, similarity, err := ct.findClosestModelMatch(table, provider, model)
 	if err != nil {
 		return nil, "", false, fmt.Errorf("no pricing found for model %s and no close match available: %w", model, err)
 	}
 
 	// Get pricing for the closest match
-	pricing, err = ct.GetPricingForModel(provider, closestModel, inputTokens)
+	pricing, err = lookupPricing(table, provider, closestModel, inputTokens)
 	if err != nil {
 		return nil, "", false, fmt.Errorf("found close match %s (%.2f%% similarity) but no pricing available: %w", closestModel, similarity, err)
 	}
@@ -464,8 +516,21 @@ func (ct *CostTracker) CalculateCostWithFuzzyMatch(provider, model string, input
 	return inputCost, outputCost, totalCost, matchedModel, isEstimate, nil
 }
 
-// TrackRequest processes a request and writes cost information to transports (sync or async based on configuration)
-func (ct *CostTracker) TrackRequest(metadata *providers.LLMResponseMetadata, userID, ipAddress, endpoint string) error {
+// EstimateCost returns the unrounded USD cost for the given usage using the same
+// pricing data as cost tracking. It satisfies ratelimit.CostEstimator.
+func (ct *CostTracker) EstimateCost(provider, model string, inputTokens, outputTokens int) (float64, error) {
+	pricing, _, _, err := ct.GetPricingForModelWithFuzzyMatch(provider, model, inputTokens)
+	if err != nil {
+		return 0, err
+	}
+	return (float64(inputTokens)/1_000_000.0)*pricing.Input + (float64(outputTokens)/1_000_000.0)*pricing.Output, nil
+}
+
+// TrackRequest processes a request and writes cost information to transports (sync or async based on configuration).
+// requestID is the proxy's ID for the request; the provider's ID is kept from metadata.
+// teamID and projectID attribute the cost for chargeback and may be empty.
+// redactions counts the prompt's redaction detections by detector, if any.
+func (ct *CostTracker) TrackRequest(metadata *providers.LLMResponseMetadata, requestID, userID, teamID, projectID, ipAddress, endpoint string, redactions map[string]int) error {
 	// Calculate costs with fuzzy matching fallback
 	inputCost, outputCost, totalCost, matchedModel, isEstimate, err := ct.CalculateCostWithFuzzyMatch(
 		metadata.Provider,
@@ -483,23 +548,27 @@ func (ct *CostTracker) TrackRequest(metadata *providers.LLMResponseMetadata, use
 
 	// Create cost record
 	record := &CostRecord{
-		Timestamp:    time.Now(),
-		RequestID:    metadata.RequestID,
-		UserID:       userID,
-		IPAddress:    ipAddress,
-		Provider:     metadata.Provider,
-		Model:        metadata.Model,
-		Endpoint:     endpoint,
-		IsStreaming:  metadata.IsStreaming,
-		InputTokens:  metadata.InputTokens,
-		OutputTokens: metadata.OutputTokens,
-		TotalTokens:  metadata.TotalTokens,
-		InputCost:    inputCost,
-		OutputCost:   outputCost,
-		TotalCost:    totalCost,
-		IsEstimate:   isEstimate,
-		FinishReason: metadata.FinishReason,
-		MatchedModel: matchedModel,
+		Timestamp:         time.Now(),
+		RequestID:         requestID,
+		UpstreamRequestID: metadata.RequestID,
+		UserID:            userID,
+		TeamID:            teamID,
+		ProjectID:         projectID,
+		IPAddress:         ipAddress,
+		Provider:          metadata.Provider,
+		Model:             metadata.Model,
+		Endpoint:          endpoint,
+		IsStreaming:       metadata.IsStreaming,
+		InputTokens:       metadata.InputTokens,
+		OutputTokens:      metadata.OutputTokens,
+		TotalTokens:       metadata.TotalTokens,
+		InputCost:         inputCost,
+		OutputCost:        outputCost,
+		TotalCost:         totalCost,
+		IsEstimate:        isEstimate,
+		FinishReason:      metadata.FinishReason,
+		MatchedModel:      matchedModel,
+		Redactions:        redactions,
 	}
 
 	// Log the cost information
@@ -535,7 +604,59 @@ func (ct *CostTracker) TrackRequest(metadata *providers.LLMResponseMetadata, use
 			"output_tokens", metadata.OutputTokens)
 	}
 
-	// Handle sync vs async processing
+	return ct.submit(record)
+}
+
+// TrackCachedRequest writes a zero-cost record for a request answered from
+// the response cache. metadata describes the cached response.
+func (ct *CostTracker) TrackCachedRequest(metadata *providers.LLMResponseMetadata, requestID, userID, teamID, projectID, ipAddress, endpoint string) error {
+	record := sharedRecord(metadata, requestID, userID, teamID, projectID, ipAddress, endpoint)
+	record.Cached = true
+	ct.logger.Debug("💵 Cost Tracking: Cached request processed",
+		"provider", metadata.Provider,
+		"model", metadata.Model,
+		"total_tokens", metadata.TotalTokens)
+	return ct.submit(record)
+}
+
+// TrackCoalescedRequest writes a zero-cost record for a request that shared
+// another in-flight request's upstream call. metadata describes the shared
+// response, whose cost is attributed to the request that made the call.
+func (ct *CostTracker) TrackCoalescedRequest(metadata *providers.LLMResponseMetadata, requestID, userID, teamID, projectID, ipAddress, endpoint string) error {
+	record := sharedRecord(metadata, requestID, userID, teamID, projectID, ipAddress, endpoint)
+	record.Coalesced = true
+	ct.logger.Debug("💵 Cost Tracking: Coalesced request processed",
+		"provider", metadata.Provider,
+		"model", metadata.Model,
+		"total_tokens", metadata.TotalTokens)
+	return ct.submit(record)
+}
+
+// sharedRecord builds a zero-cost record for a request answered with a
+// response that another request paid for
+func sharedRecord(metadata *providers.LLMResponseMetadata, requestID, userID, teamID, projectID, ipAddress, endpoint string) *CostRecord {
+	return &CostRecord{
+		Timestamp:         time.Now(),
+		RequestID:         requestID,
+		UpstreamRequestID: metadata.RequestID,
+		UserID:            userID,
+		TeamID:            teamID,
+		ProjectID:         projectID,
+		IPAddress:         ipAddress,
+		Provider:          metadata.Provider,
+		Model:             metadata.Model,
+		Endpoint:          endpoint,
+		IsStreaming:       metadata.IsStreaming,
+		InputTokens:       metadata.InputTokens,
+		OutputTokens:      metadata.OutputTokens,
+		TotalTokens:       metadata.TotalTokens,
+		FinishReason:      metadata.FinishReason,
+	}
+}
+
+// submit writes record to the transports, or queues it for the async
+// workers when they are running
+func (ct *CostTracker) submit(record *CostRecord) error {
 	ct.mu.RLock()
 	async := ct.async
 	started := ct.started
@@ -550,7 +671,7 @@ func (ct *CostTracker) TrackRequest(metadata *providers.LLMResponseMetadata, use
 		default:
 			// Queue is full, log warning and fall back to sync processing
 			ct.logger.Warn("💵 Cost Tracking: Async queue is full, falling back to sync processing",
-				"request_id", metadata.RequestID)
+				"request_id", record.RequestID)
 			return ct.writeRecordToTransports(record)
 		}
 	}
@@ -559,18 +680,47 @@ func (ct *CostTracker) TrackRequest(metadata *providers.LLMResponseMetadata, use
 	return ct.writeRecordToTransports(record)
 }
 
+// QueueDepth returns the number of records waiting for async workers
+func (ct *CostTracker) QueueDepth() int {
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+	if ct.queue == nil {
+		return 0
+	}
+	return len(ct.queue)
+}
+
+// transportErrors counts failed cost record writes per transport
+var transportErrors = metrics.NewCounterVec("llm_proxy_cost_transport_errors_total",
+	"Cost records that failed to write, by transport", "transport")
+
 // writeRecordToTransports writes a record to all configured transports and returns any error
 func (ct *CostTracker) writeRecordToTransports(record *CostRecord) error {
 	var lastErr error
 	for _, transport := range ct.transports {
 		if err := transport.WriteRecord(record); err != nil {
 			ct.logger.Warn("Failed to write record to transport", "error", err)
+			transportErrors.WithLabelValues(transportName(transport)).Inc()
 			lastErr = err
 		}
 	}
 	return lastErr
 }
 
+// transportName returns a short metric label for a transport
+func transportName(t Transport) string {
+	switch t.(type) {
+	case *DynamoDBTransport:
+		return "dynamodb"
+	case *DatadogTransport:
+		return "datadog"
+	case *FileTransport:
+		return "file"
+	default:
+		return strings.TrimPrefix(fmt.Sprintf("%T", t), "*")
+	}
+}
+
 // TransportFactory defines a function type for creating transports from configuration
 type TransportFactory func(transportConfig interface{}, logger *slog.Logger) (Transport, error)
 
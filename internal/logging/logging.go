@@ -0,0 +1,32 @@
+// Package logging carries a request-scoped slog.Logger through contexts so
+// every log line for a request shares its request ID, provider, model, user
+// and key attributes.
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+type loggerContextKey struct{}
+
+// FromContext returns the logger stored in ctx, or slog.Default()
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+			return l
+		}
+	}
+	return slog.Default()
+}
+
+// NewContext returns a copy of ctx that carries logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// With returns a copy of ctx whose logger has args added, in slog's
+// alternating key/value form
+func With(ctx context.Context, args ...any) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(args...))
+}
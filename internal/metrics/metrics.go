@@ -0,0 +1,374 @@
+// Package metrics implements Prometheus counters, gauges and histograms and
+// serves them in the Prometheus text exposition format (version 0.0.4)
+// without a client library dependency.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Default is the registry the package-level constructors register with
+var Default = NewRegistry()
+
+// DurationBuckets are histogram buckets, in seconds, for LLM request latencies
+var DurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// LookupBuckets are histogram buckets, in seconds, for store and cache lookups
+var LookupBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Registry holds metrics and writes them in the text exposition format
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// metric is a registered metric family
+type metric interface {
+	name() string
+	help() string
+	kind() string
+	writeSamples(w *bufio.Writer)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds m, panicking on duplicate names as they are programming errors
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.name()] {
+		panic(fmt.Sprintf("metrics: %s registered twice", m.name()))
+	}
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Handler serves the registry's metrics for Prometheus to scrape
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.Write(w)
+	})
+}
+
+// Write writes all metrics, sorted by name
+func (r *Registry) Write(out io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+
+	w := bufio.NewWriter(out)
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name(), escapeHelp(m.help()))
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name(), m.kind())
+		m.writeSamples(w)
+	}
+	return w.Flush()
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	bits atomic.Uint64
+}
+
+// Add increases the counter; negative values are ignored
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	addFloat(&c.bits, v)
+}
+
+// Inc increases the counter by one
+func (c *Counter) Inc() { c.Add(1) }
+
+// Value returns the current value
+func (c *Counter) Value() float64 { return math.Float64frombits(c.bits.Load()) }
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+// Set sets the gauge
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+// Add adds v, which may be negative
+func (g *Gauge) Add(v float64) { addFloat(&g.bits, v) }
+
+// Inc increases the gauge by one
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec decreases the gauge by one
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value returns the current value
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	upperBounds []float64
+	counts      []atomic.Uint64 // per bucket, not cumulative; the last is +Inf
+	count       atomic.Uint64
+	sum         atomic.Uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{upperBounds: buckets, counts: make([]atomic.Uint64, len(buckets)+1)}
+}
+
+// Observe records a value
+func (h *Histogram) Observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.upperBounds, v)].Add(1)
+	h.count.Add(1)
+	addFloat(&h.sum, v)
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 { return h.count.Load() }
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// family holds the children of a labelled metric, keyed by label values
+type family[M any] struct {
+	metricName string
+	metricHelp string
+	labels     []string
+	newChild   func() M
+
+	mu       sync.RWMutex
+	children map[string]*child[M]
+}
+
+type child[M any] struct {
+	values []string
+	metric M
+}
+
+func newFamily[M any](name, help string, labels []string, newChild func() M) *family[M] {
+	return &family[M]{metricName: name, metricHelp: help, labels: labels, newChild: newChild, children: make(map[string]*child[M])}
+}
+
+func (f *family[M]) name() string { return f.metricName }
+func (f *family[M]) help() string { return f.metricHelp }
+
+// with returns the child for the label values, creating it on first use
+func (f *family[M]) with(values []string) M {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.metricName, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.RLock()
+	c, ok := f.children[key]
+	f.mu.RUnlock()
+	if ok {
+		return c.metric
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.children[key]; ok {
+		return c.metric
+	}
+	c = &child[M]{values: append([]string(nil), values...), metric: f.newChild()}
+	f.children[key] = c
+	return c.metric
+}
+
+// sorted returns the children in label order for stable output
+func (f *family[M]) sorted() []*child[M] {
+	f.mu.RLock()
+	out := make([]*child[M], 0, len(f.children))
+	for _, c := range f.children {
+		out = append(out, c)
+	}
+	f.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Join(out[i].values, "\xff") < strings.Join(out[j].values, "\xff")
+	})
+	return out
+}
+
+// labelString formats label pairs, with extra appended, as {a="x",b="y"}
+func (f *family[M]) labelString(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range f.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", l, escapeLabel(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extra[i], escapeLabel(extra[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct{ *family[*Counter] }
+
+// NewCounterVec creates and registers a counter with the given label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newFamily(name, help, labels, func() *Counter { return &Counter{} })}
+	r.register(v)
+	return v
+}
+
+// WithLabelValues returns the counter for the label values, in label order
+func (v *CounterVec) WithLabelValues(values ...string) *Counter { return v.with(values) }
+
+func (v *CounterVec) kind() string { return "counter" }
+
+func (v *CounterVec) writeSamples(w *bufio.Writer) {
+	for _, c := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", v.metricName, v.labelString(c.values), formatFloat(c.metric.Value()))
+	}
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct{ *family[*Gauge] }
+
+// NewGaugeVec creates and registers a gauge with the given label names
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newFamily(name, help, labels, func() *Gauge { return &Gauge{} })}
+	r.register(v)
+	return v
+}
+
+// WithLabelValues returns the gauge for the label values, in label order
+func (v *GaugeVec) WithLabelValues(values ...string) *Gauge { return v.with(values) }
+
+func (v *GaugeVec) kind() string { return "gauge" }
+
+func (v *GaugeVec) writeSamples(w *bufio.Writer) {
+	for _, c := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", v.metricName, v.labelString(c.values), formatFloat(c.metric.Value()))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct{ *family[*Histogram] }
+
+// NewHistogramVec creates and registers a histogram with the given buckets
+// (sorted upper bounds; +Inf is implicit) and label names
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{newFamily(name, help, labels, func() *Histogram { return newHistogram(buckets) })}
+	r.register(v)
+	return v
+}
+
+// WithLabelValues returns the histogram for the label values, in label order
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram { return v.with(values) }
+
+func (v *HistogramVec) kind() string { return "histogram" }
+
+func (v *HistogramVec) writeSamples(w *bufio.Writer) {
+	for _, c := range v.sorted() {
+		h := c.metric
+		var cumulative uint64
+		for i, bound := range h.upperBounds {
+			cumulative += h.counts[i].Load()
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.metricName, v.labelString(c.values, "le", formatFloat(bound)), cumulative)
+		}
+		cumulative += h.counts[len(h.upperBounds)].Load()
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.metricName, v.labelString(c.values, "le", "+Inf"), cumulative)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.metricName, v.labelString(c.values), formatFloat(math.Float64frombits(h.sum.Load())))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.metricName, v.labelString(c.values), cumulative)
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when metrics are written
+type GaugeFunc struct {
+	metricName string
+	metricHelp string
+	fn         func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge that reports fn()
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, metricHelp: help, fn: fn}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.metricName }
+func (g *GaugeFunc) help() string { return g.metricHelp }
+func (g *GaugeFunc) kind() string { return "gauge" }
+
+func (g *GaugeFunc) writeSamples(w *bufio.Writer) {
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.fn()))
+}
+
+// NewCounterVec creates a counter on the Default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewGaugeVec creates a gauge on the Default registry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// NewHistogramVec creates a histogram on the Default registry
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewGaugeFunc creates a function gauge on the Default registry
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return Default.NewGaugeFunc(name, help, fn)
+}
+
+// Handler serves the Default registry
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests.\nSecond line", "provider", "status")
+	open := r.NewGaugeVec("test_open", "Open connections", "provider")
+	latency := r.NewHistogramVec("test_latency_seconds", "Latency", []float64{0.1, 1}, "provider")
+	r.NewGaugeFunc("test_queue_depth", "Queue depth", func() float64 { return 7 })
+
+	requests.WithLabelValues("openai", "200").Inc()
+	requests.WithLabelValues("openai", "200").Add(2)
+	requests.WithLabelValues("openai", "200").Add(-5) // ignored
+	requests.WithLabelValues(`we"ird`, "500").Inc()
+	open.WithLabelValues("openai").Inc()
+	open.WithLabelValues("openai").Inc()
+	open.WithLabelValues("openai").Dec()
+	for _, v := range []float64{0.05, 0.5, 0.5, 3} {
+		latency.WithLabelValues("openai").Observe(v)
+	}
+
+	rr := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	want := `# HELP test_latency_seconds Latency
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{provider="openai",le="0.1"} 1
+test_latency_seconds_bucket{provider="openai",le="1"} 3
+test_latency_seconds_bucket{provider="openai",le="+Inf"} 4
+test_latency_seconds_sum{provider="openai"} 4.05
+test_latency_seconds_count{provider="openai"} 4
+# HELP test_open Open connections
+# TYPE test_open gauge
+test_open{provider="openai"} 1
+# HELP test_queue_depth Queue depth
+# TYPE test_queue_depth gauge
+test_queue_depth 7
+# HELP test_requests_total Requests.\nSecond line
+# TYPE test_requests_total counter
+test_requests_total{provider="openai",status="200"} 3
+test_requests_total{provider="we\"ird",status="500"} 1
+`
+	if got := rr.Body.String(); got != want {
+		t.Fatalf("unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistryRejectsDuplicatesAndBadLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("dup_total", "x", "a")
+
+	mustPanic(t, "duplicate name", func() { r.NewGaugeVec("dup_total", "x") })
+	mustPanic(t, "wrong label count", func() { c.WithLabelValues("a", "b") })
+}
+
+func mustPanic(t *testing.T, name string, fn func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("%s: expected panic", name)
+		}
+	}()
+	fn()
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/Instawork/llm-proxy/internal/apikeys"
+	"github.com/Instawork/llm-proxy/internal/providers"
+)
+
+// checkKeyScope enforces a virtual key's scope on the request, returning an
+// *apikeys.ScopeError when the request falls outside it
+func checkKeyScope(r *http.Request, provider providers.Provider, scope *apikeys.KeyScope, trustedProxies []netip.Prefix) error {
+	if scope.IsEmpty() {
+		return nil
+	}
+	if err := scope.CheckSourceIP(sourceAddr(r, trustedProxies)); err != nil {
+		return err
+	}
+	if err := scope.CheckEndpoint(providerEndpoint(r.URL.Path, provider.GetName())); err != nil {
+		return err
+	}
+	// Only POST bodies name a model; listing models or fetching files and
+	// batches does not, so those are left to the endpoint scope
+	if scope.RestrictsModel() && r.Method == http.MethodPost {
+		model, _ := provider.ExtractRequestModelAndMessages(r)
+		if err := scope.CheckModel(model); err != nil {
+			return err
+		}
+	}
+	if scope.MaxTokens > 0 && r.Method == http.MethodPost && isGenerationEndpoint(r.URL.Path) {
+		if err := scope.CheckMaxTokens(requestMaxTokens(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// providerEndpoint strips the /<provider> prefix from a request path
+func providerEndpoint(path, providerName string) string {
+	if rest, ok := strings.CutPrefix(path, "/"+providerName); ok && (rest == "" || rest[0] == '/') {
+		return rest
+	}
+	return path
+}
+
+// generationSuffixes are the endpoints that generate output tokens, so a
+// key's max_tokens cap applies to them. Embeddings and token counting are
+// left out, as they have no output limit to set.
+var generationSuffixes = []string{
+	"/v1/chat/completions",
+	"/v1/completions",
+	"/v1/responses",
+	"/v1/messages",
+	":generateContent",
+	":streamGenerateContent",
+}
+
+// isGenerationEndpoint reports whether requests to path generate output tokens
+func isGenerationEndpoint(path string) bool {
+	for _, suffix := range generationSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestMaxTokens returns the output token limit requested in a JSON body,
+// or 0 if none is set. It restores the body for downstream handlers.
+func requestMaxTokens(r *http.Request) int {
+	if r.Body == nil || r.Method != http.MethodPost {
+		return 0
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	if err != nil {
+		return 0
+	}
+
+	var data struct {
+		MaxTokens           int `json:"max_tokens"`            // OpenAI chat, Anthropic, Groq
+		MaxCompletionTokens int `json:"max_completion_tokens"` // OpenAI chat
+		MaxOutputTokens     int `json:"max_output_tokens"`     // OpenAI responses
+		GenerationConfig    struct {
+			MaxOutputTokens int `json:"maxOutputTokens"` // Gemini
+		} `json:"generationConfig"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return 0
+	}
+	return max(data.MaxTokens, data.MaxCompletionTokens, data.MaxOutputTokens, data.GenerationConfig.MaxOutputTokens)
+}
+
+// sourceAddr returns the client address. X-Forwarded-For is only honoured
+// when the peer is a trusted proxy, walking from the right past other
+// trusted proxies so clients cannot spoof the entry that is checked.
+func sourceAddr(r *http.Request, trustedProxies []netip.Prefix) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, _ := netip.ParseAddr(host)
+	peer = peer.Unmap()
+	if !isTrustedProxy(peer, trustedProxies) {
+		return peer
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = addr.Unmap()
+		if !isTrustedProxy(addr, trustedProxies) {
+			return addr
+		}
+		peer = addr
+	}
+	return peer
+}
+
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses CIDRs validated at config load, skipping any that do not parse
+func parseTrustedProxies(cidrs []string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, cidr := range cidrs {
+		if p, err := netip.ParsePrefix(cidr); err == nil {
+			out = append(out, p.Masked())
+		}
+	}
+	return out
+}
+
+// writeScopeError writes a structured 403 for a request outside its key's scope
+func writeScopeError(w http.ResponseWriter, err error) {
+	code := "forbidden"
+	var scopeErr *apikeys.ScopeError
+	if errors.As(err, &scopeErr) {
+		code = scopeErr.Code
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{
+			"type":    "permission_error",
+			"code":    code,
+			"message": err.Error(),
+		},
+	})
+}
+
+// resolvedKeyStore answers ValidateAndGetProviderKey for a key the middleware
+// has already fetched, so providers do not look it up a second time
+type resolvedKeyStore struct {
+	key    string
+	apiKey *apikeys.APIKey
+	next   providers.APIKeyStore
+}
+
+func (s resolvedKeyStore) ValidateAndGetProviderKey(ctx context.Context, key, provider string) (string, error) {
+	if key != s.key {
+		return s.next.ValidateAndGetProviderKey(ctx, key, provider)
+	}
+	upstream, ok := s.apiKey.UpstreamKey(provider)
+	if !ok {
+		return "", fmt.Errorf("%w %s", apikeys.ErrProviderNotAllowed, provider)
+	}
+	return upstream, nil
+}
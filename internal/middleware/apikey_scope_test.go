@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Instawork/llm-proxy/internal/apikeys"
+	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/providers"
+)
+
+func TestAPIKeyValidationEnforcesScope(t *testing.T) {
+	store := apikeys.NewMemoryStore(&apikeys.APIKey{
+		PK:        apikeys.HashKey("iw:scoped"),
+		Provider:  "openai",
+		ActualKey: "sk-real",
+		Enabled:   true,
+		Scope: &apikeys.KeyScope{
+			AllowedModels:    []string{"gpt-4o-mini*"},
+			AllowedEndpoints: []string{"/v1/chat/completions"},
+			MaxTokens:        100,
+			AllowedCIDRs:     []string{"10.0.0.0/8"},
+		},
+	})
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+	cfg := config.GetDefaultYAMLConfig()
+	cfg.Features.APIKeyManagement.TrustedProxyCIDRs = []string{"172.16.0.0/12"}
+
+	var upstreamAuth string
+	h := APIKeyValidationMiddleware(pm, store, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		remoteAddr string
+		forwarded  string
+		key        string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "allowed", path: "/openai/v1/chat/completions", body: `{"model":"gpt-4o-mini","max_tokens":50}`, wantStatus: http.StatusOK},
+		{name: "model", path: "/openai/v1/chat/completions", body: `{"model":"gpt-4o","max_tokens":50}`, wantStatus: http.StatusForbidden, wantCode: apikeys.ScopeModelNotAllowed},
+		{name: "endpoint", path: "/openai/v1/embeddings", body: `{"model":"gpt-4o-mini"}`, wantStatus: http.StatusForbidden, wantCode: apikeys.ScopeEndpointNotAllowed},
+		{name: "max tokens", path: "/openai/v1/chat/completions", body: `{"model":"gpt-4o-mini","max_completion_tokens":500}`, wantStatus: http.StatusForbidden, wantCode: apikeys.ScopeMaxTokensExceeded},
+		{name: "max tokens missing", path: "/openai/v1/chat/completions", body: `{"model":"gpt-4o-mini"}`, wantStatus: http.StatusForbidden, wantCode: apikeys.ScopeMaxTokensRequired},
+		{name: "source", path: "/openai/v1/chat/completions", body: `{"model":"gpt-4o-mini"}`, remoteAddr: "203.0.113.5:1234", wantStatus: http.StatusForbidden, wantCode: apikeys.ScopeSourceNotAllowed},
+		{name: "spoofed forwarded for", path: "/openai/v1/chat/completions", body: `{"model":"gpt-4o-mini"}`, remoteAddr: "203.0.113.5:1234", forwarded: "10.0.0.1", wantStatus: http.StatusForbidden, wantCode: apikeys.ScopeSourceNotAllowed},
+		{name: "trusted proxy", path: "/openai/v1/chat/completions", body: `{"model":"gpt-4o-mini","max_tokens":50}`, remoteAddr: "172.16.0.2:1234", forwarded: "203.0.113.5, 10.0.0.1", wantStatus: http.StatusOK},
+		{name: "invalid key", path: "/openai/v1/chat/completions", body: `{"model":"gpt-4o-mini"}`, key: "iw:missing", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamAuth = ""
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			key := tt.key
+			if key == "" {
+				key = "iw:scoped"
+			}
+			req.Header.Set("Authorization", "Bearer "+key)
+			req.RemoteAddr = "10.1.2.3:5555"
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && upstreamAuth != "Bearer sk-real" {
+				t.Fatalf("expected translated provider key, got %q", upstreamAuth)
+			}
+			if tt.wantCode != "" {
+				var body struct {
+					Error struct {
+						Code string `json:"code"`
+					} `json:"error"`
+				}
+				if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body.Error.Code != tt.wantCode {
+					t.Fatalf("error code = %q (%v), want %q", body.Error.Code, err, tt.wantCode)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIKeyValidationMaxTokensEndpoints(t *testing.T) {
+	store := apikeys.NewMemoryStore(&apikeys.APIKey{
+		PK:        apikeys.HashKey("iw:capped"),
+		Provider:  "gemini",
+		ActualKey: "real-gemini-key",
+		Enabled:   true,
+		Scope:     &apikeys.KeyScope{MaxTokens: 100},
+	})
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewGeminiProxy())
+	h := APIKeyValidationMiddleware(pm, store, config.GetDefaultYAMLConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name, path, body string
+		wantStatus       int
+	}{
+		{"capped", "/gemini/v1beta/models/gemini-1.5-flash:generateContent", `{"generationConfig":{"maxOutputTokens":100}}`, http.StatusOK},
+		{"missing", "/gemini/v1beta/models/gemini-1.5-flash:generateContent", `{"contents":[]}`, http.StatusForbidden},
+		{"missing on stream", "/gemini/v1beta/models/gemini-1.5-flash:streamGenerateContent", `{"contents":[]}`, http.StatusForbidden},
+		{"embeddings have no output limit", "/gemini/v1beta/models/text-embedding-004:embedContent", `{"content":{}}`, http.StatusOK},
+		{"token counting has no output limit", "/gemini/v1beta/models/gemini-1.5-flash:countTokens", `{"contents":[]}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set("x-goog-api-key", "iw:capped")
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPIKeyValidationModelScopeSkipsRequestsWithoutModel(t *testing.T) {
+	store := apikeys.NewMemoryStore(&apikeys.APIKey{
+		PK:        apikeys.HashKey("iw:scoped"),
+		Provider:  "openai",
+		ActualKey: "sk-real",
+		Enabled:   true,
+		Scope:     &apikeys.KeyScope{AllowedModels: []string{"gpt-4o-mini*"}},
+	})
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+	h := APIKeyValidationMiddleware(pm, store, config.GetDefaultYAMLConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"list models", "GET", "/openai/v1/models", "", http.StatusOK},
+		{"fetch file", "GET", "/openai/v1/files/file-1", "", http.StatusOK},
+		{"allowed model", "POST", "/openai/v1/chat/completions", `{"model":"gpt-4o-mini"}`, http.StatusOK},
+		{"other model", "POST", "/openai/v1/chat/completions", `{"model":"gpt-4o"}`, http.StatusForbidden},
+		{"unparseable body", "POST", "/openai/v1/chat/completions", `{"model":`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer iw:scoped")
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
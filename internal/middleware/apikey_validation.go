@@ -1,15 +1,45 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"net/netip"
+	"strings"
+	"time"
 
+	"github.com/Instawork/llm-proxy/internal/apikeys"
+	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/logging"
 	"github.com/Instawork/llm-proxy/internal/providers"
+	"github.com/Instawork/llm-proxy/internal/tracing"
 )
 
-// APIKeyValidationMiddleware validates and potentially replaces API keys for all providers
-func APIKeyValidationMiddleware(providerManager *providers.ProviderManager, keyStore providers.APIKeyStore) func(http.Handler) http.Handler {
+// apiKeyContextKey stores the API key presented by the client, before any
+// translation to the upstream provider key.
+const apiKeyContextKey contextKey = "api_key"
+
+// Context keys for the record ID, rate-limit ID, team and project of the iw: key that authenticated the request
+const (
+	keyIDContextKey      contextKey = "key_id"
+	keyRecordContextKey  contextKey = "key_record_id"
+	keyTeamContextKey    contextKey = "key_team_id"
+	keyProjectContextKey contextKey = "key_project_id"
+	keyLimitContextKey   contextKey = "key_rate_limit_id"
+)
+
+// APIKeyValidationMiddleware validates and potentially replaces API keys for
+// all providers. Requests outside an iw: key's scope (models, endpoints,
+// max tokens, source networks) are rejected with a structured 403.
+func APIKeyValidationMiddleware(providerManager *providers.ProviderManager, keyStore providers.APIKeyStore, cfg *config.YAMLConfig) func(http.Handler) http.Handler {
+	var trustedProxies []netip.Prefix
+	if cfg != nil {
+		trustedProxies = parseTrustedProxies(cfg.Features.APIKeyManagement.TrustedProxyCIDRs)
+	}
+	// Scopes need the full key record; stores that only translate keys skip enforcement
+	getter, _ := keyStore.(apikeys.KeyGetter)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Skip validation for health check endpoint
@@ -26,11 +56,47 @@ func APIKeyValidationMiddleware(providerManager *providers.ProviderManager, keyS
 				return
 			}
 
+			// Remember the client's key; validation may replace it with the provider key
+			clientKey := ExtractAPIKeyFromRequest(r)
+			if clientKey != "" {
+				r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, clientKey))
+			}
+
 			// If we have a key store, validate the API key
 			if keyStore != nil {
-				if err := provider.ValidateAPIKey(r, keyStore); err != nil {
+				store := keyStore
+				if getter != nil && strings.HasPrefix(clientKey, apikeys.KeyPrefix) {
+					// Invalid keys fall through to ValidateAPIKey, which reports them as 401s
+					apiKey, err := timedGetKey(r.Context(), getter, clientKey)
+					if err == nil {
+						// Legacy records are stored under the plaintext key, so only its hash leaves this middleware
+						keyID := apikeys.KeyID(apiKey.PK)
+						r = withLogAttrs(r, "key_id", keyID)
+						if apiKey.TeamID != "" {
+							r = withLogAttrs(r, "team_id", apiKey.TeamID)
+						}
+						if entry := accessLogFromRequest(r); entry != nil {
+							entry.keyID = keyID
+							entry.teamID = apiKey.TeamID
+						}
+						if err := checkKeyScope(r, provider, apiKey.Scope, trustedProxies); err != nil {
+							logging.FromContext(r.Context()).Warn("🚫 API key scope violation", "error", err)
+							writeScopeError(w, err)
+							return
+						}
+						store = resolvedKeyStore{key: clientKey, apiKey: apiKey, next: keyStore}
+						// Attribute the request to the key's team so limits and cost follow it
+						ctx := context.WithValue(r.Context(), keyIDContextKey, keyID)
+						ctx = context.WithValue(ctx, keyRecordContextKey, apiKey.PK)
+						ctx = context.WithValue(ctx, keyLimitContextKey, apiKey.RateLimitID())
+						ctx = context.WithValue(ctx, keyTeamContextKey, apiKey.TeamID)
+						r = r.WithContext(context.WithValue(ctx, keyProjectContextKey, apiKey.ProjectID))
+					}
+				}
+
+				if err := provider.ValidateAPIKey(r, store); err != nil {
 					// Log the error
-					log.Printf("❌ API key validation failed for %s: %v", provider.GetName(), err)
+					logging.FromContext(r.Context()).Warn("❌ API key validation failed", "error", err)
 
 					// Return 401 Unauthorized
 					w.Header().Set("Content-Type", "application/json")
@@ -45,3 +111,66 @@ func APIKeyValidationMiddleware(providerManager *providers.ProviderManager, keyS
 		})
 	}
 }
+
+// timedGetKey looks up an iw: key, recording the lookup latency and a span
+func timedGetKey(ctx context.Context, getter apikeys.KeyGetter, key string) (*apikeys.APIKey, error) {
+	ctx, span := tracing.Start(ctx, "apikeys.GetKey", tracing.WithKind(tracing.SpanKindClient))
+	defer span.End()
+	start := time.Now()
+	apiKey, err := getter.GetKey(ctx, key)
+	result := "ok"
+	switch {
+	case errors.Is(err, apikeys.ErrKeyNotFound), errors.Is(err, apikeys.ErrKeyExpired), errors.Is(err, apikeys.ErrKeyDisabled):
+		result = "rejected"
+	case err != nil:
+		result = "error"
+	}
+	keyLookupDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	span.SetAttributes(tracing.String("llm_proxy.key_lookup.result", result))
+	if result == "error" {
+		tracing.RecordError(span, err)
+	}
+	return apiKey, err
+}
+
+// ExtractKeyIDFromRequest returns the hashed ID of the iw: key that
+// authenticated the request, or "" for other keys. It is safe to log.
+func ExtractKeyIDFromRequest(req *http.Request) string {
+	keyID, _ := req.Context().Value(keyIDContextKey).(string)
+	return keyID
+}
+
+// ExtractRateLimitKeyIDFromRequest returns the ID the rate limits of the iw:
+// key that authenticated the request are kept under, shared by every key in
+// its rotation chain, or "" for other keys
+func ExtractRateLimitKeyIDFromRequest(req *http.Request) string {
+	id, _ := req.Context().Value(keyLimitContextKey).(string)
+	return id
+}
+
+// ExtractKeyRecordIDFromRequest returns the stored record ID of the iw: key
+// that authenticated the request. For records not yet migrated to hashed IDs
+// this is the plaintext key, so it must only be used to address the store.
+func ExtractKeyRecordIDFromRequest(req *http.Request) string {
+	recordID, _ := req.Context().Value(keyRecordContextKey).(string)
+	return recordID
+}
+
+// ExtractAPIKeyFromRequest returns the API key presented by the client. If
+// APIKeyValidationMiddleware ran earlier, this is the key as originally sent
+// (e.g. the iw: key), not the translated provider key.
+func ExtractAPIKeyFromRequest(req *http.Request) string {
+	if apiKey, ok := req.Context().Value(apiKeyContextKey).(string); ok && apiKey != "" {
+		return apiKey
+	}
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if apiKey := req.Header.Get("x-api-key"); apiKey != "" {
+		return apiKey
+	}
+	if apiKey := req.Header.Get("x-goog-api-key"); apiKey != "" {
+		return apiKey
+	}
+	return req.URL.Query().Get("key")
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Instawork/llm-proxy/internal/apikeys"
+	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/providers"
+)
+
+func TestAPIKeyValidationMultiProvider(t *testing.T) {
+	store := apikeys.NewMemoryStore(&apikeys.APIKey{
+		PK:           apikeys.HashKey("iw:multi"),
+		Provider:     "openai",
+		ActualKey:    "sk-openai",
+		ProviderKeys: map[string]string{"anthropic": "sk-ant", "gemini": "AIza-gemini"},
+		Enabled:      true,
+	})
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+	pm.RegisterProvider(providers.NewAnthropicProxy())
+	pm.RegisterProvider(providers.NewGeminiProxy())
+	pm.RegisterProvider(providers.NewGroqProxy())
+
+	var upstream *http.Request
+	h := APIKeyValidationMiddleware(pm, store, config.GetDefaultYAMLConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		path       string
+		header     string
+		value      string
+		wantStatus int
+		wantKey    func(r *http.Request) string
+		want       string
+	}{
+		{name: "openai", path: "/openai/v1/chat/completions", header: "Authorization", value: "Bearer iw:multi", wantStatus: http.StatusOK,
+			wantKey: func(r *http.Request) string { return r.Header.Get("Authorization") }, want: "Bearer sk-openai"},
+		{name: "anthropic", path: "/anthropic/v1/messages", header: "x-api-key", value: "iw:multi", wantStatus: http.StatusOK,
+			wantKey: func(r *http.Request) string { return r.Header.Get("x-api-key") }, want: "sk-ant"},
+		{name: "gemini", path: "/gemini/v1beta/models/gemini-1.5-flash:generateContent", header: "x-goog-api-key", value: "iw:multi", wantStatus: http.StatusOK,
+			wantKey: func(r *http.Request) string { return r.Header.Get("x-goog-api-key") }, want: "AIza-gemini"},
+		{name: "provider without upstream key", path: "/groq/openai/v1/chat/completions", header: "Authorization", value: "Bearer iw:multi", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream = nil
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(`{}`))
+			req.Header.Set(tt.header, tt.value)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantKey != nil {
+				if got := tt.wantKey(upstream); got != tt.want {
+					t.Fatalf("upstream key = %q, want %q", got, tt.want)
+				}
+				// Budgets and rate limits stay attributed to the virtual key
+				if got := ExtractAPIKeyFromRequest(upstream); got != "iw:multi" {
+					t.Fatalf("attributed key = %q, want iw:multi", got)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIKeyValidationTeamAttribution(t *testing.T) {
+	store := apikeys.NewMemoryStore(
+		&apikeys.APIKey{PK: apikeys.HashKey("iw:team"), Provider: "openai", ActualKey: "sk-team", Enabled: true, TeamID: "eng", ProjectID: "eng-ci"},
+		&apikeys.APIKey{PK: apikeys.HashKey("iw:solo"), Provider: "openai", ActualKey: "sk-solo", Enabled: true},
+	)
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+
+	var teamID, projectID string
+	h := APIKeyValidationMiddleware(pm, store, config.GetDefaultYAMLConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		teamID, projectID = ExtractTeamIDFromRequest(r), ExtractProjectIDFromRequest(r)
+	}))
+	trusting := TeamHeaderMiddleware(h)
+
+	tests := []struct {
+		name, key, header     string
+		trustHeader           bool
+		wantTeam, wantProject string
+	}{
+		{name: "key team wins over header", key: "iw:team", header: "other", wantTeam: "eng", wantProject: "eng-ci"},
+		{name: "key team wins over trusted header", key: "iw:team", header: "other", trustHeader: true, wantTeam: "eng", wantProject: "eng-ci"},
+		{name: "key without team ignores header", key: "iw:solo", header: "other", wantTeam: ""},
+		{name: "provider key ignores header", key: "sk-raw", header: "other", wantTeam: ""},
+		{name: "key without team uses trusted header", key: "iw:solo", header: "other", trustHeader: true, wantTeam: "other"},
+		{name: "provider key uses trusted header", key: "sk-raw", header: "other", trustHeader: true, wantTeam: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{}`))
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			req.Header.Set("X-Team-ID", tt.header)
+			if tt.trustHeader {
+				trusting.ServeHTTP(httptest.NewRecorder(), req)
+			} else {
+				h.ServeHTTP(httptest.NewRecorder(), req)
+			}
+			if teamID != tt.wantTeam || projectID != tt.wantProject {
+				t.Fatalf("team/project = %q/%q, want %q/%q", teamID, projectID, tt.wantTeam, tt.wantProject)
+			}
+		})
+	}
+}
+
+func TestKeyUsageMiddleware(t *testing.T) {
+	keyID := apikeys.HashKey("iw:used")
+	store := apikeys.NewMemoryStore(&apikeys.APIKey{PK: keyID, Provider: "openai", ActualKey: "sk-used", Enabled: true})
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+	tracker := apikeys.NewUsageTracker(store, -1, nil)
+
+	noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := APIKeyValidationMiddleware(pm, store, config.GetDefaultYAMLConfig())(KeyUsageMiddleware(tracker, nil)(noop))
+	for _, key := range []string{"iw:used", "iw:used", "sk-raw"} {
+		req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.RemoteAddr = "192.0.2.7:1234"
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if err := tracker.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	k, _ := store.LookupKey(context.Background(), keyID)
+	if k.RequestCount != 2 || k.LastUsedAt == nil || k.LastUsedIP != "192.0.2.7" {
+		t.Fatalf("unexpected usage: requests=%d last_used=%v ip=%q", k.RequestCount, k.LastUsedAt, k.LastUsedIP)
+	}
+}
+
+func TestAPIKeyValidationLegacyKeyID(t *testing.T) {
+	// Records created before key hashing are stored under the plaintext key
+	store := apikeys.NewMemoryStore(&apikeys.APIKey{PK: "iw:legacy", Provider: "openai", ActualKey: "sk-legacy", Enabled: true})
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+	tracker := apikeys.NewUsageTracker(store, -1, nil)
+
+	var keyID, recordID string
+	h := APIKeyValidationMiddleware(pm, store, config.GetDefaultYAMLConfig())(KeyUsageMiddleware(tracker, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyID, recordID = ExtractKeyIDFromRequest(r), ExtractKeyRecordIDFromRequest(r)
+	})))
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{}`))
+	req.Header.Set("Authorization", "Bearer iw:legacy")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if keyID != apikeys.HashKey("iw:legacy") {
+		t.Fatalf("key ID = %q, want the hashed key", keyID)
+	}
+	if recordID != "iw:legacy" {
+		t.Fatalf("record ID = %q, want the stored PK", recordID)
+	}
+	if err := tracker.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if k, _ := store.LookupKey(context.Background(), "iw:legacy"); k.RequestCount != 1 {
+		t.Fatalf("expected usage on the legacy record, got %d requests", k.RequestCount)
+	}
+}
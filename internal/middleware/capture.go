@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/capture"
+	"github.com/Instawork/llm-proxy/internal/logging"
+	"github.com/Instawork/llm-proxy/internal/providers"
+	"github.com/Instawork/llm-proxy/internal/ratelimit"
+)
+
+// captureContextKey stores the request's *captureState
+const captureContextKey contextKey = "capture"
+
+// captureState receives the buffered response and its metadata from
+// TokenParsingMiddleware, or from the response cache or coalescing when
+// they answer the request themselves
+type captureState struct {
+	served    bool // a response was produced; requests rejected earlier are not captured
+	body      []byte
+	text      string // the response text, when known without parsing body
+	metadata  *providers.LLMResponseMetadata
+	cached    bool
+	coalesced bool
+}
+
+// captureFromRequest returns the request's capture state, or nil when the
+// request is not being captured
+func captureFromRequest(r *http.Request) *captureState {
+	state, _ := r.Context().Value(captureContextKey).(*captureState)
+	return state
+}
+
+// CaptureMiddleware records the request body, the reassembled response
+// text, metadata and cost of opted-in requests to capturer's sink. It must
+// run after API key validation so key and team opt-ins apply, and before
+// the response cache, coalescing and TokenParsingMiddleware, which hand it
+// the response. Requests rejected before a response is produced, such as
+// rate-limited ones, are not captured.
+func CaptureMiddleware(providerManager *providers.ProviderManager, capturer *capture.Capturer, estimator ratelimit.CostEstimator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provider := GetProviderFromRequest(providerManager, r)
+			if provider == nil || !hasPromptBody(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			keyID := ExtractKeyIDFromRequest(r)
+			userID := ExtractUserIDFromRequest(r, provider)
+			teamID := ExtractTeamIDFromRequest(r)
+			if !capturer.ShouldCapture(keyID, userID, teamID) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var requestBody []byte
+			if r.Body != nil {
+				var err error
+				requestBody, err = io.ReadAll(r.Body)
+				r.Body.Close()
+				if err != nil {
+					logging.FromContext(r.Context()).Warn("📼 Capture: Failed to read request body", "error", err)
+				}
+				r.Body = io.NopCloser(bytes.NewReader(requestBody))
+			}
+
+			start := time.Now()
+			state := &captureState{}
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			r = r.WithContext(context.WithValue(r.Context(), captureContextKey, state))
+			next.ServeHTTP(rec, r)
+			if !state.served {
+				return
+			}
+
+			record := &capture.Record{
+				Timestamp:  start.UTC(),
+				RequestID:  ExtractRequestIDFromRequest(r),
+				Provider:   provider.GetName(),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Status:     rec.status,
+				Streaming:  providerManager.IsStreamingRequest(r),
+				DurationMs: durationMillis(time.Since(start)),
+				UserID:     userID,
+				KeyID:      keyID,
+				TeamID:     teamID,
+				ProjectID:  ExtractProjectIDFromRequest(r),
+				Cached:     state.cached,
+				Coalesced:  state.coalesced,
+			}
+			maxBytes := capturer.MaxBodyBytes()
+			text := state.text
+			if text == "" {
+				text = responseText(provider, state.body, record.Streaming)
+			}
+			record.Request, record.RequestTruncated = capture.Truncate(string(requestBody), maxBytes)
+			record.Response, record.ResponseTruncated = capture.Truncate(text, maxBytes)
+			if m := state.metadata; m != nil {
+				record.Model = m.Model
+				record.UpstreamRequestID = m.RequestID
+				record.InputTokens = m.InputTokens
+				record.OutputTokens = m.OutputTokens
+				record.TotalTokens = m.TotalTokens
+				record.FinishReason = m.FinishReason
+				// Cached and shared responses cost nothing upstream
+				if !state.cached && !state.coalesced {
+					record.CostUSD = estimateCost(estimator, m.Provider, m.Model, m.InputTokens, m.OutputTokens)
+				}
+			}
+			capturer.Submit(record)
+		})
+	}
+}
+
+// responseText returns the generated text of a response, falling back to
+// the (decompressed) body when the provider cannot extract any, e.g. for
+// error responses
+func responseText(provider providers.Provider, body []byte, streaming bool) string {
+	if extractor, ok := provider.(providers.ResponseTextExtractor); ok {
+		if text := extractor.ExtractResponseText(bytes.NewReader(body), streaming); text != "" {
+			return text
+		}
+	}
+	if decompressed, err := decompressForPreview(body); err == nil {
+		return string(decompressed)
+	}
+	return string(body)
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Instawork/llm-proxy/internal/capture"
+	"github.com/Instawork/llm-proxy/internal/providers"
+)
+
+// recordingSink keeps captured records for tests
+type recordingSink struct {
+	records []*capture.Record
+}
+
+func (s *recordingSink) Write(_ context.Context, record *capture.Record) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *recordingSink) Close(context.Context) error { return nil }
+
+func TestCaptureMiddlewareStitchesStream(t *testing.T) {
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+
+	sink := &recordingSink{}
+	capturer := capture.New(capture.Options{Sink: sink, Users: []string{"alice"}, MaxBodyBytes: 16})
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Hel"}}]}`,
+			`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]}`,
+			`{"id":"chatcmpl-1","model":"gpt-4o","choices":[],"usage":{"prompt_tokens":7,"completion_tokens":2,"total_tokens":9}}`,
+			`[DONE]`,
+		} {
+			_, _ = w.Write([]byte("data: " + chunk + "\n\n"))
+		}
+	})
+	handler := CaptureMiddleware(pm, capturer, nil)(TokenParsingMiddleware(pm)(upstream))
+
+	body := `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Say hello"}]}`
+	for _, user := range []string{"alice", "bob"} {
+		req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("X-User-ID", user)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if err := capturer.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if len(sink.records) != 1 {
+		t.Fatalf("expected only the opted-in user to be captured, got %d records", len(sink.records))
+	}
+	record := sink.records[0]
+	if record.Response != "Hello" || record.UserID != "alice" || !record.Streaming {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	if record.Request != body[:16] || !record.RequestTruncated {
+		t.Fatalf("expected the request capped at 16 bytes, got %q (truncated=%v)", record.Request, record.RequestTruncated)
+	}
+	if record.TotalTokens != 9 || record.Model != "gpt-4o" || record.UpstreamRequestID != "chatcmpl-1" {
+		t.Fatalf("metadata not captured: %+v", record)
+	}
+}
+
+func TestCaptureMiddlewareCapturesJSONEndpoints(t *testing.T) {
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+
+	sink := &recordingSink{}
+	capturer := capture.New(capture.Options{Sink: sink, Users: []string{"alice"}})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"object":"list"}`))
+	})
+	handler := CaptureMiddleware(pm, capturer, nil)(TokenParsingMiddleware(pm)(upstream))
+
+	for _, path := range []string{"/openai/v1/responses", "/openai/v1/embeddings"} {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"model":"gpt-4o","input":"hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", "alice")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if err := capturer.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if len(sink.records) != 2 {
+		t.Fatalf("expected Responses and embeddings requests to be captured, got %d records", len(sink.records))
+	}
+	for _, record := range sink.records {
+		if record.Request != `{"model":"gpt-4o","input":"hi"}` || record.Response != `{"object":"list"}` {
+			t.Fatalf("unexpected record %+v", record)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Instawork/llm-proxy/internal/coalesce"
+	"github.com/Instawork/llm-proxy/internal/logging"
+	"github.com/Instawork/llm-proxy/internal/providers"
+	"github.com/Instawork/llm-proxy/internal/requestid"
+)
+
+// CoalescedHeader is set to "true" on responses shared from another
+// request's upstream call
+const CoalescedHeader = "X-LLM-Coalesced"
+
+// coalesceContextKey stores the request's *coalesceState
+const coalesceContextKey contextKey = "coalesce"
+
+// coalesceState receives the response metadata from TokenParsingMiddleware
+type coalesceState struct {
+	metadata *providers.LLMResponseMetadata
+}
+
+// coalesceFromRequest returns the request's coalescing state, or nil when
+// the request's response is not being shared
+func coalesceFromRequest(r *http.Request) *coalesceState {
+	state, _ := r.Context().Value(coalesceContextKey).(*coalesceState)
+	return state
+}
+
+// idempotentSuffixes are the endpoints whose responses depend only on the
+// request, so identical requests may share one, live or from the response
+// cache. Endpoints that create or change state upstream, such as files,
+// batches, fine-tuning, assistant threads and stored Responses API objects,
+// are never coalesced or cached.
+var idempotentSuffixes = []string{
+	"/v1/chat/completions",
+	"/v1/completions",
+	"/v1/embeddings",
+	"/v1/messages",
+	"/v1/messages/count_tokens",
+	":generateContent",
+	":streamGenerateContent",
+	":countTokens",
+	":embedContent",
+	":batchEmbedContents",
+}
+
+// isIdempotentEndpoint reports whether requests to path are idempotent
+func isIdempotentEndpoint(path string) bool {
+	for _, suffix := range idempotentSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CoalescingMiddleware lets concurrent byte-identical non-streaming
+// requests from the same key share one upstream call. The first request
+// goes through the rest of the chain; identical requests that arrive while
+// it is in flight wait and receive a copy of its response, so they reach
+// neither the rate limiter nor the provider; admit, when set, counts them
+// against request limits instead. onShared callbacks receive the shared
+// response's metadata for each waiting request. If the response cannot be
+// shared, waiting requests make their own calls. It must run after API key
+// validation, CORS and CaptureMiddleware, and before rate limiting and
+// TokenParsingMiddleware.
+func CoalescingMiddleware(providerManager *providers.ProviderManager, coalescer *coalesce.Coalescer, admit AdmitFunc, onShared ...MetadataCallback) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provider := GetProviderFromRequest(providerManager, r)
+			if provider == nil || r.Method != http.MethodPost || !isIdempotentEndpoint(r.URL.Path) || providerManager.IsStreamingRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			keyID := ExtractKeyIDFromRequest(r)
+			identity := cacheScope(r, "key", keyID, "")
+			if identity == "" || !coalescer.Enabled(keyID, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("🔗 Coalescing: Failed to read request body", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := coalesce.Key(provider.GetName(), identity, cacheVariant(r), body)
+
+			resp, shared, err := coalescer.Do(r.Context(), key, func() *coalesce.Response {
+				state := &coalesceState{}
+				cw := &cacheWriter{ResponseWriter: w, status: http.StatusOK, maxBytes: coalescer.MaxBodyBytes()}
+				next.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), coalesceContextKey, state)))
+				// A response cut short by the client going away is not shared
+				if cw.overflow || r.Context().Err() != nil {
+					return nil
+				}
+				return &coalesce.Response{
+					Status:   cw.status,
+					Header:   w.Header().Clone(),
+					Body:     cw.body.Bytes(),
+					Metadata: state.metadata,
+				}
+			})
+			if err != nil || !shared {
+				return
+			}
+			if admit != nil && !admit(w, r) {
+				return
+			}
+
+			serveCoalesced(w, resp)
+			entry := accessLogFromRequest(r)
+			if entry != nil {
+				entry.coalesced = true
+			}
+			captured := captureFromRequest(r)
+			if captured != nil {
+				captured.served, captured.coalesced, captured.body = true, true, resp.Body
+			}
+			if resp.Metadata == nil {
+				return
+			}
+			metadata := *resp.Metadata
+			if captured != nil {
+				captured.metadata = &metadata
+			}
+			if entry != nil {
+				entry.model = metadata.Model
+				entry.upstreamID = metadata.RequestID
+				entry.inputTokens = metadata.InputTokens
+				entry.outputTokens = metadata.OutputTokens
+			}
+			logging.FromContext(r.Context()).Debug("🔗 Coalescing: Shared in-flight response", "model", metadata.Model)
+			for _, callback := range onShared {
+				if callback != nil {
+					callback(r, &metadata)
+				}
+			}
+		})
+	}
+}
+
+// serveCoalesced writes a copy of a shared response, keeping the request's
+// own request ID
+func serveCoalesced(w http.ResponseWriter, resp *coalesce.Response) {
+	h := w.Header()
+	for name, values := range resp.Header {
+		if name == requestid.Header || name == "Content-Length" {
+			continue
+		}
+		h[name] = append([]string(nil), values...)
+	}
+	h.Set(CoalescedHeader, "true")
+	h.Set("Content-Length", strconv.Itoa(len(resp.Body)))
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write(resp.Body)
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/capture"
+	"github.com/Instawork/llm-proxy/internal/coalesce"
+	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/providers"
+	"github.com/Instawork/llm-proxy/internal/ratelimit"
+)
+
+func TestCoalescingMiddleware(t *testing.T) {
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+	coalescer := coalesce.New(coalesce.Options{Routes: []string{"/openai/v1/chat/completions", "/openai/v1/files"}})
+
+	release := make(chan struct{})
+	var upstreamCalls atomic.Int32
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		if strings.HasSuffix(r.URL.Path, "/chat/completions") {
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"4"},"finish_reason":"stop"}],"usage":{"prompt_tokens":8,"completion_tokens":1,"total_tokens":9}}`))
+	})
+	var mu sync.Mutex
+	var shared []*providers.LLMResponseMetadata
+	onShared := func(r *http.Request, metadata *providers.LLMResponseMetadata) {
+		mu.Lock()
+		defer mu.Unlock()
+		shared = append(shared, metadata)
+	}
+	handler := CoalescingMiddleware(pm, coalescer, nil, onShared)(TokenParsingMiddleware(pm)(upstream))
+
+	send := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer sk-a")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	const callers = 3
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"2+2?"}]}`
+	recs := make([]*httptest.ResponseRecorder, callers)
+	var wg sync.WaitGroup
+	for i := range recs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recs[i] = send("/openai/v1/chat/completions", body)
+		}(i)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for coalescer.Waiting() < callers-1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for requests to coalesce")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := upstreamCalls.Load(); n != 1 {
+		t.Fatalf("expected 1 upstream call, got %d", n)
+	}
+	coalesced := 0
+	for _, rec := range recs {
+		if rec.Code != http.StatusOK || rec.Body.String() != recs[0].Body.String() || rec.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("unexpected response %d %q", rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get(CoalescedHeader) == "true" {
+			coalesced++
+		}
+	}
+	if coalesced != callers-1 || len(shared) != callers-1 || shared[0].TotalTokens != 9 || shared[0].Provider != "openai" {
+		t.Fatalf("expected %d coalesced responses, got %d with metadata %+v", callers-1, coalesced, shared)
+	}
+
+	// Non-idempotent endpoints are never coalesced, even on opted-in routes
+	if send("/openai/v1/files", `{}`).Header().Get(CoalescedHeader) != "" || upstreamCalls.Load() != 2 {
+		t.Fatal("expected the files endpoint to pass through")
+	}
+	if !isIdempotentEndpoint("/gemini/v1beta/models/gemini-pro:generateContent") || isIdempotentEndpoint("/openai/v1/threads/t1/messages") {
+		t.Fatal("unexpected endpoint classification")
+	}
+}
+
+func TestCoalescingMiddlewareInChain(t *testing.T) {
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+	coalescer := coalesce.New(coalesce.Options{All: true})
+	sink := &recordingSink{}
+	capturer := capture.New(capture.Options{Sink: sink, Users: []string{"alice"}})
+
+	cfg := makeCfg()
+	cfg.Features.RateLimiting.Limits = config.LimitsConfig{RequestsPerMinute: 2}
+	lim := ratelimit.NewMemoryLimiter(cfg)
+
+	release := make(chan struct{})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"4"},"finish_reason":"stop"}],"usage":{"prompt_tokens":8,"completion_tokens":1,"total_tokens":9}}`))
+	})
+	// The production order: CORS and capture wrap coalescing, which runs before rate limiting
+	handler := CORSMiddleware(pm)(CaptureMiddleware(pm, capturer, fakeEstimator{})(
+		CoalescingMiddleware(pm, coalescer, RequestLimitGate(pm, cfg, lim))(
+			RateLimitingMiddleware(pm, cfg, lim, nil)(TokenParsingMiddleware(pm)(upstream)))))
+
+	const callers = 3
+	recs := make([]*httptest.ResponseRecorder, callers)
+	var wg sync.WaitGroup
+	for i := range recs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"2+2?"}]}`))
+			req.Header.Set("Authorization", "Bearer sk-a")
+			req.Header.Set("X-User-ID", "alice")
+			recs[i] = httptest.NewRecorder()
+			handler.ServeHTTP(recs[i], req)
+		}(i)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for coalescer.Waiting() < callers-1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for requests to coalesce")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	// The leader and one waiter fit the request limit; the other waiter does not
+	var ok, limited int
+	for _, rec := range recs {
+		switch rec.Code {
+		case http.StatusOK:
+			ok++
+			if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+				t.Fatal("expected CORS headers on every response")
+			}
+		case http.StatusTooManyRequests:
+			limited++
+		}
+	}
+	if ok != 2 || limited != 1 {
+		t.Fatalf("expected 2 responses and 1 rejection, got %d and %d", ok, limited)
+	}
+
+	if err := capturer.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if len(sink.records) != 2 {
+		t.Fatalf("expected the leader and the shared response to be captured, got %d records", len(sink.records))
+	}
+	var shared int
+	for _, record := range sink.records {
+		if record.Coalesced {
+			shared++
+			if record.CostUSD != 0 || record.Response != "4" || record.TotalTokens != 9 {
+				t.Fatalf("unexpected shared record %+v", record)
+			}
+		}
+	}
+	if shared != 1 {
+		t.Fatalf("expected 1 coalesced record, got %d", shared)
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+
+	"github.com/Instawork/llm-proxy/internal/apikeys"
+	"github.com/Instawork/llm-proxy/internal/config"
+)
+
+// KeyUsageMiddleware records a request and its source address against the
+// iw: key that authenticated it. It must run after APIKeyValidationMiddleware;
+// token and cost usage is added separately once the response has been parsed.
+func KeyUsageMiddleware(tracker *apikeys.UsageTracker, cfg *config.YAMLConfig) func(http.Handler) http.Handler {
+	var trustedProxies []netip.Prefix
+	if cfg != nil {
+		trustedProxies = parseTrustedProxies(cfg.Features.APIKeyManagement.TrustedProxyCIDRs)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if recordID := ExtractKeyRecordIDFromRequest(r); recordID != "" {
+				usage := apikeys.KeyUsage{Requests: 1}
+				if addr := sourceAddr(r, trustedProxies); addr.IsValid() {
+					usage.LastUsedIP = addr.String()
+				}
+				tracker.Record(recordID, usage)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -3,13 +3,20 @@ package middleware
 import (
 	"context"
 	"log/slog"
+	"mime"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/Instawork/llm-proxy/internal/logging"
 	"github.com/Instawork/llm-proxy/internal/providers"
+	"github.com/Instawork/llm-proxy/internal/ratelimit"
+	"github.com/Instawork/llm-proxy/internal/requestid"
 )
 
+// accessLogContextKey stores the request's *accessLogEntry
+const accessLogContextKey contextKey = "access_log"
+
 // isProviderRoute checks if the request is for a provider route
 func isProviderRoute(path string) bool {
 	return strings.HasPrefix(path, "/openai/") ||
@@ -27,6 +34,21 @@ func isAPIEndpoint(path string) bool {
 		strings.Contains(path, ":streamGenerateContent")
 }
 
+// hasPromptBody reports whether r's body may carry prompts: requests to the
+// completion endpoints and any other POST with a JSON body, such as OpenAI's
+// /v1/responses and /v1/embeddings. File uploads and other non-JSON bodies
+// are left alone.
+func hasPromptBody(r *http.Request) bool {
+	if isAPIEndpoint(r.URL.Path) {
+		return true
+	}
+	if r.Method != http.MethodPost {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "" || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
 // getProviderFromPath extracts provider name from the request path
 func getProviderFromPath(path string) string {
 	if strings.HasPrefix(path, "/openai/") {
@@ -41,7 +63,11 @@ func getProviderFromPath(path string) string {
 	return ""
 }
 
-// LoggingMiddleware logs all incoming requests and identifies non-tracked provider routes
+// LoggingMiddleware gives each request a logger carrying its request ID
+// (set by RequestIDMiddleware), method, path and provider, and writes one access log line per request
+// ("Completed request") with status, latency, time to first byte, tokens,
+// cost and the rate-limit outcome. It should run before the other
+// middleware so their logs carry the request's attributes.
 func LoggingMiddleware(providerManager *providers.ProviderManager) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -59,20 +85,23 @@ func LoggingMiddleware(providerManager *providers.ProviderManager) func(http.Han
 			// Determine if this request will be cost tracked
 			willBeTracked := isProvRoute && isAPIEndpt && provider != nil
 
-			// Log the request with additional context for provider routes
-			if isStreaming {
-				slog.Info("Started streaming request",
-					slog.String("method", r.Method),
-					slog.String("path", r.URL.Path),
-					slog.String("remote_addr", r.RemoteAddr),
-					slog.Bool("streaming", true))
-			} else {
-				slog.Info("Started request",
-					slog.String("method", r.Method),
-					slog.String("path", r.URL.Path),
-					slog.String("remote_addr", r.RemoteAddr),
-					slog.Bool("streaming", false))
+			requestLogger := logging.FromContext(r.Context())
+			if id := requestid.FromContext(r.Context()); id != "" {
+				requestLogger = requestLogger.With(slog.String("request_id", id))
+			}
+			requestLogger = requestLogger.With(
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path))
+			if providerName != "" {
+				requestLogger = requestLogger.With(slog.String("provider", providerName))
 			}
+			entry := &accessLogEntry{}
+			ctx := logging.NewContext(r.Context(), requestLogger)
+			r = r.WithContext(context.WithValue(ctx, accessLogContextKey, entry))
+
+			requestLogger.Debug("Started request",
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.Bool("streaming", isStreaming))
 
 			// Log non-tracked provider routes for production monitoring
 			if isProvRoute && !willBeTracked {
@@ -88,51 +117,171 @@ func LoggingMiddleware(providerManager *providers.ProviderManager) func(http.Han
 					reason = "Unknown reason"
 				}
 
-				slog.Log(context.TODO(), level, "Non-tracked provider route",
-					slog.String("method", r.Method),
-					slog.String("path", r.URL.Path),
-					slog.String("provider", providerName),
+				requestLogger.Log(r.Context(), level, "Non-tracked provider route",
 					slog.String("reason", reason),
 					slog.Bool("api_endpoint", isAPIEndpt),
 					slog.Bool("provider_found", provider != nil))
 			}
 
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			// Log the access line even if a handler panics, then let the panic continue
+			defer func() {
+				if p := recover(); p != nil {
+					rec.status = http.StatusInternalServerError
+					entry.log(r, requestLogger, rec, start, isStreaming, isProvRoute, willBeTracked)
+					panic(p)
+				}
+			}()
+
 			// Call the next handler
-			next.ServeHTTP(w, r)
-
-			// Log the completion with cost tracking status
-			duration := time.Since(start)
-
-			// Use the original streaming detection for consistency
-			// The issue might be elsewhere in the streaming detection logic
-			if isStreaming {
-				slog.Info("Completed streaming request",
-					slog.String("method", r.Method),
-					slog.String("path", r.URL.Path),
-					slog.Duration("duration", duration),
-					slog.Bool("streaming", true))
-			} else {
-				slog.Info("Completed request",
-					slog.String("method", r.Method),
-					slog.String("path", r.URL.Path),
-					slog.Duration("duration", duration),
-					slog.Bool("streaming", false))
-			}
+			next.ServeHTTP(rec, r)
 
-			// Summary log for provider routes
-			if isProvRoute {
-				if willBeTracked {
-					slog.Info("Provider route tracked",
-						slog.String("method", r.Method),
-						slog.String("path", r.URL.Path),
-						slog.Bool("cost_tracked", true))
-				} else {
-					slog.Warn("Provider route not tracked",
-						slog.String("method", r.Method),
-						slog.String("path", r.URL.Path),
-						slog.Bool("cost_tracked", false))
-				}
-			}
+			entry.log(r, requestLogger, rec, start, isStreaming, isProvRoute, willBeTracked)
 		})
 	}
 }
+
+// accessLogEntry collects request details from later middleware and
+// callbacks for the access log line
+type accessLogEntry struct {
+	keyID           string
+	teamID          string
+	userID          string
+	model           string
+	upstreamID      string // the provider's ID for the response
+	inputTokens     int
+	outputTokens    int
+	cost            float64
+	rateLimit       string // allow, deny or error; empty when not rate limited
+	rateLimitScope  string
+	rateLimitMetric string
+	redactions      map[string]int // detections by detector
+	cache           string         // hit, miss or bypass; empty when not cacheable
+	coalesced       bool           // served from another request's upstream call
+}
+
+// accessLogFromRequest returns the request's access log entry, or nil
+// outside LoggingMiddleware
+func accessLogFromRequest(r *http.Request) *accessLogEntry {
+	entry, _ := r.Context().Value(accessLogContextKey).(*accessLogEntry)
+	return entry
+}
+
+// log writes the access log line for the request
+func (e *accessLogEntry) log(r *http.Request, logger *slog.Logger, rec *responseRecorder, start time.Time, streaming, providerRoute, costTracked bool) {
+	attrs := []slog.Attr{
+		slog.Int("status", rec.status),
+		slog.Bool("streaming", streaming),
+		slog.Float64("duration_ms", durationMillis(time.Since(start))),
+		slog.Int64("bytes", rec.bytes),
+		slog.String("remote_addr", r.RemoteAddr),
+	}
+	if !rec.firstByte.IsZero() {
+		attrs = append(attrs, slog.Float64("ttfb_ms", durationMillis(rec.firstByte.Sub(start))))
+	}
+	model := e.model
+	if model == "" {
+		model = rec.Header().Get("X-LLM-Model")
+	}
+	for _, a := range []struct{ key, value string }{
+		{"model", model},
+		{"upstream_request_id", e.upstreamID},
+		{"user_id", e.userID},
+		{"key_id", e.keyID},
+		{"team_id", e.teamID},
+		{"ratelimit", e.rateLimit},
+		{"ratelimit_scope", e.rateLimitScope},
+		{"ratelimit_metric", e.rateLimitMetric},
+		{"cache", e.cache},
+	} {
+		if a.value != "" {
+			attrs = append(attrs, slog.String(a.key, a.value))
+		}
+	}
+	if e.inputTokens > 0 || e.outputTokens > 0 {
+		attrs = append(attrs,
+			slog.Int("input_tokens", e.inputTokens),
+			slog.Int("output_tokens", e.outputTokens),
+			slog.Int("total_tokens", e.inputTokens+e.outputTokens))
+	}
+	if e.cost > 0 {
+		attrs = append(attrs, slog.Float64("cost_usd", e.cost))
+	}
+	if len(e.redactions) > 0 {
+		attrs = append(attrs, slog.Any("redactions", e.redactions))
+	}
+	if e.coalesced {
+		attrs = append(attrs, slog.Bool("coalesced", true))
+	}
+	if providerRoute {
+		attrs = append(attrs, slog.Bool("cost_tracked", costTracked))
+	}
+	logger.LogAttrs(r.Context(), slog.LevelInfo, "Completed request", attrs...)
+}
+
+// AccessLogCallback returns a MetadataCallback that adds the model, tokens
+// and, when estimator is non-nil, cost to the access log line
+func AccessLogCallback(estimator ratelimit.CostEstimator) MetadataCallback {
+	return func(r *http.Request, metadata *providers.LLMResponseMetadata) {
+		entry := accessLogFromRequest(r)
+		if entry == nil {
+			return
+		}
+		if metadata.Model != "" {
+			entry.model = metadata.Model
+		}
+		entry.upstreamID = metadata.RequestID
+		entry.inputTokens = metadata.InputTokens
+		entry.outputTokens = metadata.OutputTokens
+		entry.cost = estimateCost(estimator, metadata.Provider, metadata.Model, metadata.InputTokens, metadata.OutputTokens)
+	}
+}
+
+// withLogAttrs adds attributes to the request's logger, in slog's alternating
+// key/value form
+func withLogAttrs(r *http.Request, args ...any) *http.Request {
+	return r.WithContext(logging.With(r.Context(), args...))
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// responseRecorder captures the status code, bytes written and the time of the first write
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+	firstByte   time.Time
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.firstByte.IsZero() && len(b) > 0 {
+		rr.firstByte = time.Now()
+	}
+	rr.wroteHeader = true
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += int64(n)
+	return n, err
+}
+
+// Flush passes flushes through so streaming responses are not buffered
+func (rr *responseRecorder) Flush() {
+	if flusher, ok := rr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
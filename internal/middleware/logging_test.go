@@ -2,11 +2,11 @@ package middleware
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strings"
 	"testing"
 	"time"
@@ -69,12 +69,13 @@ func (mp *MockProvider) ValidateAPIKey(req *http.Request, keyStore providers.API
 	return nil
 }
 
-// captureLogOutput captures log output for testing
+// captureLogOutput captures slog output, including debug lines, for testing
 func captureLogOutput(fn func()) string {
 	var buf bytes.Buffer
-	log.SetOutput(&buf)
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(previous) // Restore default
 	fn()
-	log.SetOutput(os.Stderr) // Restore default
 	return buf.String()
 }
 
@@ -262,9 +263,9 @@ func TestLoggingMiddleware_HandlerPanic(t *testing.T) {
 		loggingHandler.ServeHTTP(recorder, req)
 	})
 
-	// Should still log the start even when handler panics
-	if !strings.Contains(logOutput, "Started request") {
-		t.Error("Expected start log even when handler panics, got log:", logOutput)
+	// Should still write the access line, as a 500, when the handler panics
+	if !strings.Contains(logOutput, "Completed request") || !strings.Contains(logOutput, "status=500") {
+		t.Error("Expected access log even when handler panics, got log:", logOutput)
 	}
 }
 
@@ -343,7 +344,7 @@ func TestLoggingMiddleware_NonTrackedProviderRoutes(t *testing.T) {
 		{
 			name:     "OpenAI chat completions (should be tracked)",
 			path:     "/openai/v1/chat/completions",
-			expected: "Provider route tracked",
+			expected: "cost_tracked=true",
 		},
 		{
 			name:     "Anthropic non-API endpoint",
@@ -386,6 +387,53 @@ func TestLoggingMiddleware_NonTrackedProviderRoutes(t *testing.T) {
 	}
 }
 
+func TestLoggingMiddleware_AccessLogLine(t *testing.T) {
+	manager := providers.NewProviderManager()
+	manager.RegisterProvider(&MockProvider{name: "openai"})
+
+	handler := TokenParsingMiddleware(manager, AccessLogCallback(nil))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recordRateLimitDecision(r, "allow", nil)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("provider response"))
+	}))
+	loggingHandler := RequestIDMiddleware("")(LoggingMiddleware(manager)(handler))
+
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(previous)
+
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader("{}"))
+	recorder := httptest.NewRecorder()
+	loggingHandler.ServeHTTP(recorder, req)
+
+	// Info level hides "Started request", leaving only the access line
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single JSON access line, got %q: %v", buf.String(), err)
+	}
+	if id, _ := line["request_id"].(string); line["msg"] != "Completed request" || id == "" || id != recorder.Header().Get("X-Request-ID") {
+		t.Fatalf("unexpected access line %v", line)
+	}
+	for key, want := range map[string]any{
+		"provider":      "openai",
+		"model":         "test-model",
+		"status":        float64(http.StatusOK),
+		"input_tokens":  float64(10),
+		"output_tokens": float64(5),
+		"total_tokens":  float64(15),
+		"ratelimit":     "allow",
+		"cost_tracked":  true,
+	} {
+		if line[key] != want {
+			t.Errorf("expected %s=%v, got %v", key, want, line[key])
+		}
+	}
+	if _, ok := line["ttfb_ms"]; !ok {
+		t.Error("expected ttfb_ms in access line")
+	}
+}
+
 func TestLoggingMiddleware_ProviderHelperFunctions(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/metrics"
+	"github.com/Instawork/llm-proxy/internal/providers"
+	"github.com/Instawork/llm-proxy/internal/ratelimit"
+)
+
+// Prometheus metrics for provider requests, served by metrics.Handler
+var (
+	requestsTotal = metrics.NewCounterVec("llm_proxy_requests_total",
+		"Provider requests handled by the proxy", "provider", "model", "status", "streaming")
+	requestDuration = metrics.NewHistogramVec("llm_proxy_request_duration_seconds",
+		"Time to fully serve provider requests", metrics.DurationBuckets, "provider", "model", "status", "streaming")
+	timeToFirstByte = metrics.NewHistogramVec("llm_proxy_time_to_first_byte_seconds",
+		"Time until the first response byte of streaming requests", metrics.DurationBuckets, "provider", "model")
+	tokensTotal = metrics.NewCounterVec("llm_proxy_tokens_total",
+		"Tokens reported by providers, by type (input, output)", "provider", "model", "type")
+	costTotal = metrics.NewCounterVec("llm_proxy_cost_usd_total",
+		"Cost of provider requests in USD at configured pricing", "provider", "model")
+	rateLimitDecisions = metrics.NewCounterVec("llm_proxy_ratelimit_decisions_total",
+		"Rate limit decisions, by the scope type and metric of the tightest limit", "decision", "scope", "metric")
+	keyLookupDuration = metrics.NewHistogramVec("llm_proxy_keystore_lookup_duration_seconds",
+		"Latency of iw: key lookups in the key store, by result (ok, rejected, error)", metrics.LookupBuckets, "result")
+	redactionDetections = metrics.NewCounterVec("llm_proxy_redaction_detections_total",
+		"Personal data and secrets detected in prompts, by detector and redaction mode", "detector", "mode")
+)
+
+// unknownModel labels requests whose model was not reported by the provider
+const unknownModel = "unknown"
+
+// MetricsMiddleware records request counts, latency and, for streams, time to
+// first byte. It should run early so the latency covers the whole chain; the
+// model is read from the X-LLM-Model header set by TokenParsingMiddleware.
+func MetricsMiddleware(providerManager *providers.ProviderManager) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provider := GetProviderFromRequest(providerManager, r)
+			if provider == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			streaming := providerManager.IsStreamingRequest(r)
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			model := w.Header().Get("X-LLM-Model")
+			if model == "" {
+				model = unknownModel
+			}
+			labels := []string{provider.GetName(), model, strconv.Itoa(rec.status), strconv.FormatBool(streaming)}
+			requestsTotal.WithLabelValues(labels...).Inc()
+			requestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+			if streaming && !rec.firstByte.IsZero() {
+				timeToFirstByte.WithLabelValues(provider.GetName(), model).Observe(rec.firstByte.Sub(start).Seconds())
+			}
+		})
+	}
+}
+
+// MetricsCallback returns a MetadataCallback that counts tokens and, when
+// estimator is non-nil, cost per provider and model
+func MetricsCallback(estimator ratelimit.CostEstimator) MetadataCallback {
+	return func(r *http.Request, metadata *providers.LLMResponseMetadata) {
+		if metadata.TotalTokens == 0 {
+			return
+		}
+		model := metadata.Model
+		if model == "" {
+			model = unknownModel
+		}
+		tokensTotal.WithLabelValues(metadata.Provider, model, "input").Add(float64(metadata.InputTokens))
+		tokensTotal.WithLabelValues(metadata.Provider, model, "output").Add(float64(metadata.OutputTokens))
+		if c := estimateCost(estimator, metadata.Provider, metadata.Model, metadata.InputTokens, metadata.OutputTokens); c > 0 {
+			costTotal.WithLabelValues(metadata.Provider, model).Add(c)
+		}
+	}
+}
+
+// recordRateLimitDecision counts a rate limit decision and notes it for the
+// access log. The metric's scope label is reduced to the scope type (e.g.
+// team:eng becomes team) to keep label cardinality bounded.
+func recordRateLimitDecision(r *http.Request, decision string, details *ratelimit.LimitDetails) {
+	scope, metric := "none", "none"
+	if details != nil {
+		scope, _, _ = strings.Cut(details.ScopeKey, ":")
+		metric = details.Metric
+	}
+	rateLimitDecisions.WithLabelValues(decision, scope, metric).Inc()
+
+	if entry := accessLogFromRequest(r); entry != nil {
+		entry.rateLimit = decision
+		if details != nil {
+			entry.rateLimitScope = details.ScopeKey
+			entry.rateLimitMetric = details.Metric
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Instawork/llm-proxy/internal/providers"
+	"github.com/Instawork/llm-proxy/internal/ratelimit"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+
+	handler := MetricsMiddleware(pm)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-LLM-Model", "gpt-metrics")
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("data: {}\n\n"))
+	}))
+
+	requests := requestsTotal.WithLabelValues("openai", "gpt-metrics", "418", "true")
+	ttfb := timeToFirstByte.WithLabelValues("openai", "gpt-metrics")
+	before, beforeTTFB := requests.Value(), ttfb.Count()
+
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{"model":"gpt-metrics","stream":true}`))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	// Non-provider routes are not counted
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+
+	if got := requests.Value() - before; got != 1 {
+		t.Fatalf("expected 1 counted request, got %v", got)
+	}
+	if got := ttfb.Count() - beforeTTFB; got != 1 {
+		t.Fatalf("expected 1 time-to-first-byte observation, got %d", got)
+	}
+}
+
+func TestMetricsCallbackAndDecisions(t *testing.T) {
+	input := tokensTotal.WithLabelValues("openai", "gpt-cb", "input")
+	output := tokensTotal.WithLabelValues("openai", "gpt-cb", "output")
+	before := input.Value() + output.Value()
+
+	MetricsCallback(nil)(httptest.NewRequest("POST", "/", nil), &providers.LLMResponseMetadata{
+		Provider: "openai", Model: "gpt-cb", InputTokens: 10, OutputTokens: 5, TotalTokens: 15,
+	})
+	if got := input.Value() + output.Value() - before; got != 15 {
+		t.Fatalf("expected 15 tokens counted, got %v", got)
+	}
+
+	denied := rateLimitDecisions.WithLabelValues("deny", "team", "tokens")
+	beforeDenied := denied.Value()
+	recordRateLimitDecision(httptest.NewRequest("POST", "/", nil), "deny", &ratelimit.LimitDetails{ScopeKey: "team:eng", Metric: "tokens"})
+	if got := denied.Value() - beforeDenied; got != 1 {
+		t.Fatalf("expected scope reduced to its type, got %v denials", got)
+	}
+}
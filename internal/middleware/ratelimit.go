@@ -1,31 +1,29 @@
 package middleware
 
 import (
-	"log"
+	"fmt"
+	"math"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/logging"
 	"github.com/Instawork/llm-proxy/internal/providers"
 	"github.com/Instawork/llm-proxy/internal/ratelimit"
 )
 
 // RateLimitingMiddleware enforces rate limits using the provided limiter.
 // It does a provisional token reservation based on estimation and reconciles after response parsing.
-func RateLimitingMiddleware(pm *providers.ProviderManager, cfg *config.YAMLConfig, limiter ratelimit.RateLimiter) func(http.Handler) http.Handler {
+// When estimator is non-nil, the estimated request cost (input plus the
+// request's max_tokens of output) is reserved as well and reconciled against
+// the actual cost once usage is known. Failed requests that report no usage
+// give back their reservation.
+func RateLimitingMiddleware(pm *providers.ProviderManager, cfg *config.YAMLConfig, limiter ratelimit.RateLimiter, estimator ratelimit.CostEstimator) func(http.Handler) http.Handler {
 	if limiter == nil || cfg == nil || !cfg.Features.RateLimiting.Enabled {
 		return func(next http.Handler) http.Handler { return next }
 	}
 
-	estCfg := providers.YAMLConfigEstimationAdapter{
-		MaxSampleBytes:        cfg.Features.RateLimiting.Estimation.MaxSampleBytes,
-		BytesPerToken:         cfg.Features.RateLimiting.Estimation.BytesPerToken,
-		CharsPerToken:         cfg.Features.RateLimiting.Estimation.CharsPerToken,
-		ProviderCharsPerToken: cfg.Features.RateLimiting.Estimation.ProviderCharsPerToken,
-	}
-
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			prov := GetProviderFromRequest(pm, r)
@@ -33,14 +31,19 @@ func RateLimitingMiddleware(pm *providers.ProviderManager, cfg *config.YAMLConfi
 				next.ServeHTTP(w, r)
 				return
 			}
+			// Estimation and upstream throttling follow configuration reloads
+			cfg := limiter.Config()
+			estCfg := providers.YAMLConfigEstimationAdapter{
+				MaxSampleBytes:        cfg.Features.RateLimiting.Estimation.MaxSampleBytes,
+				BytesPerToken:         cfg.Features.RateLimiting.Estimation.BytesPerToken,
+				CharsPerToken:         cfg.Features.RateLimiting.Estimation.CharsPerToken,
+				ProviderCharsPerToken: cfg.Features.RateLimiting.Estimation.ProviderCharsPerToken,
+			}
 
 			// Scope keys
 			userID := ExtractUserIDFromRequest(r, prov)
-			auth := r.Header.Get("Authorization")
-			apiKey := auth
-			if strings.HasPrefix(auth, "Bearer ") {
-				apiKey = auth[7:]
-			}
+			teamID := ExtractTeamIDFromRequest(r)
+			keyID := rateLimitKeyID(r)
 			model := ""
 
 			estTokens, parsedModel := providers.EstimateRequestTokens(r, estCfg, prov)
@@ -48,71 +51,269 @@ func RateLimitingMiddleware(pm *providers.ProviderManager, cfg *config.YAMLConfi
 				model = parsedModel
 			}
 
-			scope := ratelimit.ScopeKeys{Provider: prov.GetName(), Model: model, APIKey: apiKey, UserID: userID}
-			res, err := limiter.CheckAndReserve(r.Context(), newReservationID(), scope, estTokens, time.Now())
+			// Output is priced at the request's own limit, or the configured default
+			estOutput := requestMaxTokens(r)
+			if estOutput == 0 {
+				estOutput = cfg.Features.RateLimiting.Estimation.DefaultOutputTokens
+			}
+			estCost := estimateCost(estimator, prov.GetName(), model, estTokens, estOutput)
+
+			// Later log lines for the request carry the user and requested model
+			r = withLogAttrs(r, "user_id", userID)
+			if model != "" {
+				r = withLogAttrs(r, "model", model)
+			}
+			logger := logging.FromContext(r.Context())
+			if entry := accessLogFromRequest(r); entry != nil {
+				entry.userID = userID
+				entry.model = model
+			}
+
+			// Shed or hold requests the upstream provider is about to reject
+			upstreamID := ""
+			if upCfg := cfg.Features.RateLimiting.Upstream; upCfg.Enabled {
+				upstreamID = providers.UpstreamKeyID(r)
+				if state, ok := providers.UpstreamLimits().Get(prov.GetName(), upstreamID); ok {
+					if metric, wait, exhausted := upstreamExhausted(state, estTokens, upCfg, time.Now()); exhausted {
+						if wait > time.Duration(upCfg.MaxQueueWaitMs)*time.Millisecond {
+							w.Header().Set("Retry-After", fmtInt(int(math.Ceil(wait.Seconds()))))
+							w.Header().Set("X-RateLimit-Reason", "upstream limit exhausted")
+							w.Header().Set("X-RateLimit-Metric", metric)
+							w.Header().Set("X-RateLimit-Scope", "upstream:"+prov.GetName())
+							logger.Info("🚦 Rate limit: Shed request, upstream limit exhausted",
+								"upstream", upstreamID, "metric", metric, "reset_in", wait)
+							recordRateLimitDecision(r, "deny", &ratelimit.LimitDetails{ScopeKey: "upstream", Metric: metric})
+							http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+							return
+						}
+						logger.Info("🚦 Rate limit: Holding request until upstream limit resets",
+							"upstream", upstreamID, "metric", metric, "wait", wait)
+						timer := time.NewTimer(wait)
+						select {
+						case <-timer.C:
+						case <-r.Context().Done():
+							timer.Stop()
+							return
+						}
+					}
+				}
+			}
+
+			scope := ratelimit.ScopeKeys{Provider: prov.GetName(), Model: model, APIKey: keyID, UserID: userID, TeamID: teamID}
+			// The reservation is named after the request so limiter state can be traced back to it
+			reservationID := ExtractRequestIDFromRequest(r)
+			res, err := limiter.CheckAndReserve(r.Context(), reservationID, scope, estTokens, estCost, time.Now())
 			if err != nil {
-				log.Printf("ratelimit: error reserving: %v", err)
+				logger.Error("🚦 Rate limit: Failed to reserve", "error", err)
+				recordRateLimitDecision(r, "error", nil)
 				http.Error(w, "rate limit error", http.StatusInternalServerError)
 				return
 			}
 			if !res.Allowed {
-				w.Header().Set("Retry-After", fmtInt(res.RetryAfterSeconds))
-				// Standard-ish headers inspired by GitHub/Stripe style and custom reason
-				if res.Details != nil {
-					// Metric and window
-					w.Header().Set("X-RateLimit-Reason", res.Reason)
-					w.Header().Set("X-RateLimit-Metric", res.Details.Metric)  // "requests" or "tokens"
-					w.Header().Set("X-RateLimit-Window", res.Details.Window)  // "minute" or "day"
-					w.Header().Set("X-RateLimit-Scope", res.Details.ScopeKey) // e.g. user:123, key:abc, model:..., provider:..., global
-					w.Header().Set("X-RateLimit-Limit", fmtInt(res.Details.Limit))
-					w.Header().Set("X-RateLimit-Remaining", fmtInt(res.Details.Remaining))
-				}
-				log.Printf("ratelimit: throttle provider=%s model=%s user=%s key_prefix=%s reason=%s",
-					prov.GetName(), model, userID, prefix(apiKey), res.Reason)
-				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				writeRateLimited(w, r, res)
 				return
 			}
 
-			log.Printf("ratelimit: allow provider=%s model=%s user=%s key_prefix=%s est_tokens=%d",
-				prov.GetName(), model, userID, prefix(apiKey), estTokens)
+			// Advertise the tightest applicable limit so clients can back off before a 429
+			if res.Details != nil {
+				setRateLimitHeaders(w.Header(), res.Details)
+			}
+
+			recordRateLimitDecision(r, "allow", res.Details)
+
+			if upstreamID != "" {
+				providers.UpstreamLimits().Consume(prov.GetName(), upstreamID, estTokens)
+			}
+
+			logger.Debug("🚦 Rate limit: Allowed request", "est_tokens", estTokens, "est_output_tokens", estOutput, "est_cost", estCost)
 
 			// Proceed to next middleware/handler; TokenParsingMiddleware later
 			// in the chain will set X-LLM-Total-Tokens if available.
-			next.ServeHTTP(w, r)
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
 
 			// Reconcile using input token metadata set by token parsing middleware headers if present
 			actualInput := headerToInt(w.Header().Get("X-LLM-Input-Tokens"))
+			actualOutput := headerToInt(w.Header().Get("X-LLM-Output-Tokens"))
+			if actualInput == 0 && actualOutput == 0 && rec.status >= http.StatusBadRequest {
+				releaseReservation(r, limiter, reservationID, scope, rec.status, estTokens, estCost)
+				return
+			}
+			delta := 0
 			if actualInput > 0 {
-				delta := actualInput - estTokens
-				if err := limiter.Adjust(r.Context(), "", scope, delta, time.Now()); err != nil {
-					log.Printf("ratelimit: adjust error: %v", err)
-				} else if delta != 0 {
-					log.Printf("ratelimit: adjust (input) provider=%s model=%s user=%s key_prefix=%s delta_input_tokens=%d",
-						prov.GetName(), model, userID, prefix(apiKey), delta)
+				delta = actualInput - estTokens
+			}
+			costDelta := 0.0
+			if estimator != nil && (actualInput > 0 || estCost > 0) {
+				actualModel := w.Header().Get("X-LLM-Model")
+				if actualModel == "" {
+					actualModel = model
+				}
+				if actualInput > 0 || actualOutput > 0 {
+					costDelta = estimateCost(estimator, prov.GetName(), actualModel, actualInput, actualOutput) - estCost
+				}
+			}
+			if actualInput > 0 || costDelta != 0 {
+				if err := limiter.Adjust(r.Context(), reservationID, scope, delta, costDelta, time.Now()); err != nil {
+					logger.Warn("🚦 Rate limit: Failed to adjust reservation", "error", err)
+				} else if delta != 0 || costDelta != 0 {
+					logger.Debug("🚦 Rate limit: Adjusted reservation", "delta_input_tokens", delta, "delta_cost", costDelta)
 				}
 			}
 		})
 	}
 }
 
+// releaseReservation returns what a failed request without reported usage
+// reserved. Upstream failures (5xx) release the request as well; other
+// errors still count as a request but used no tokens.
+func releaseReservation(r *http.Request, limiter ratelimit.RateLimiter, id string, scope ratelimit.ScopeKeys, status, estTokens int, estCost float64) {
+	logger := logging.FromContext(r.Context())
+	var err error
+	if status >= http.StatusInternalServerError {
+		err = limiter.Cancel(r.Context(), id, scope, estTokens, estCost, time.Now())
+	} else if estTokens != 0 || estCost != 0 {
+		err = limiter.Adjust(r.Context(), id, scope, -estTokens, -estCost, time.Now())
+	}
+	if err != nil {
+		logger.Warn("🚦 Rate limit: Failed to release reservation", "error", err)
+		return
+	}
+	logger.Debug("🚦 Rate limit: Released reservation", "status", status, "est_tokens", estTokens, "est_cost", estCost)
+}
+
+// AdmitFunc decides whether a response the proxy already holds, such as a
+// cache hit or a response shared from an identical in-flight request, may be
+// served. When it returns false it has already written the rejection.
+type AdmitFunc func(w http.ResponseWriter, r *http.Request) bool
+
+// RequestLimitGate returns an AdmitFunc that counts requests answered
+// without an upstream call against request limits. They reserve no tokens
+// or cost, since they use none. It returns nil when rate limiting is disabled.
+func RequestLimitGate(pm *providers.ProviderManager, cfg *config.YAMLConfig, limiter ratelimit.RateLimiter) AdmitFunc {
+	if limiter == nil || cfg == nil || !cfg.Features.RateLimiting.Enabled {
+		return nil
+	}
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		prov := GetProviderFromRequest(pm, r)
+		if prov == nil {
+			return true
+		}
+		cfg := limiter.Config()
+		estCfg := providers.YAMLConfigEstimationAdapter{
+			MaxSampleBytes:        cfg.Features.RateLimiting.Estimation.MaxSampleBytes,
+			BytesPerToken:         cfg.Features.RateLimiting.Estimation.BytesPerToken,
+			CharsPerToken:         cfg.Features.RateLimiting.Estimation.CharsPerToken,
+			ProviderCharsPerToken: cfg.Features.RateLimiting.Estimation.ProviderCharsPerToken,
+		}
+		// Only the model is needed, for per-model request limits
+		_, model := providers.EstimateRequestTokens(r, estCfg, prov)
+		scope := ratelimit.ScopeKeys{
+			Provider: prov.GetName(),
+			Model:    model,
+			APIKey:   rateLimitKeyID(r),
+			UserID:   ExtractUserIDFromRequest(r, prov),
+			TeamID:   ExtractTeamIDFromRequest(r),
+		}
+		res, err := limiter.ReserveRequest(r.Context(), ExtractRequestIDFromRequest(r), scope, time.Now())
+		if err != nil {
+			logging.FromContext(r.Context()).Error("🚦 Rate limit: Failed to reserve", "error", err)
+			recordRateLimitDecision(r, "error", nil)
+			http.Error(w, "rate limit error", http.StatusInternalServerError)
+			return false
+		}
+		if !res.Allowed {
+			writeRateLimited(w, r, res)
+			return false
+		}
+		if res.Details != nil {
+			setRateLimitHeaders(w.Header(), res.Details)
+		}
+		recordRateLimitDecision(r, "allow", res.Details)
+		return true
+	}
+}
+
+// rateLimitKeyID returns the key scope ID for r. Rotated iw: keys share the
+// limits of their lineage; other keys are hashed, since the raw key would
+// end up in backend storage.
+func rateLimitKeyID(r *http.Request) string {
+	if id := ExtractRateLimitKeyIDFromRequest(r); id != "" {
+		return id
+	}
+	return ratelimit.KeyID(ExtractAPIKeyFromRequest(r))
+}
+
+// writeRateLimited rejects a request that exceeded a limit with a 429
+func writeRateLimited(w http.ResponseWriter, r *http.Request, res ratelimit.ReservationResult) {
+	w.Header().Set("Retry-After", fmtInt(res.RetryAfterSeconds))
+	// Standard-ish headers inspired by GitHub/Stripe style and custom reason
+	if res.Details != nil {
+		w.Header().Set("X-RateLimit-Reason", res.Reason)
+		setRateLimitHeaders(w.Header(), res.Details)
+	}
+	logging.FromContext(r.Context()).Info("🚦 Rate limit: Throttled request", "reason", res.Reason)
+	recordRateLimitDecision(r, "deny", res.Details)
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
+
+// setRateLimitHeaders writes X-RateLimit-* headers and the IETF
+// RateLimit-Policy/RateLimit headers (draft-ietf-httpapi-ratelimit-headers)
+// describing the given limit. The IETF headers take integer quotas, so cost
+// limits are expressed there in whole US cents.
+func setRateLimitHeaders(h http.Header, d *ratelimit.LimitDetails) {
+	h.Set("X-RateLimit-Metric", d.Metric)  // "requests", "tokens" or "cost"
+	h.Set("X-RateLimit-Window", d.Window)  // "minute", "hour" or "day"
+	h.Set("X-RateLimit-Scope", d.ScopeKey) // e.g. user:123, key:<id>, team:..., model:..., provider:..., global
+	h.Set("X-RateLimit-Reset", fmtInt(d.ResetSeconds))
+	limit, remaining := d.Limit, d.Remaining
+	if d.Metric == "cost" {
+		// Cost limits are expressed in USD
+		h.Set("X-RateLimit-Limit", fmtCost(d.CostLimit))
+		h.Set("X-RateLimit-Remaining", fmtCost(d.CostRemaining))
+		limit = int(math.Round(d.CostLimit * 100))
+		remaining = max(int(math.Floor(d.CostRemaining*100)), 0)
+	} else {
+		h.Set("X-RateLimit-Limit", fmtInt(limit))
+		h.Set("X-RateLimit-Remaining", fmtInt(remaining))
+	}
+
+	policy := strconv.Quote(d.Window + "-" + d.Metric)
+	h.Set("RateLimit-Policy", fmt.Sprintf("%s;q=%d;w=%d", policy, limit, windowSeconds(d.Window)))
+	h.Set("RateLimit", fmt.Sprintf("%s;r=%d;t=%d", policy, remaining, d.ResetSeconds))
+}
+
+func windowSeconds(window string) int {
+	switch window {
+	case "hour":
+		return 3600
+	case "day":
+		return 86400
+	}
+	return 60
+}
+
 func fmtInt(v int) string { return strconv.FormatInt(int64(v), 10) }
 
-func headerToInt(s string) int {
-	if s == "" {
+func fmtCost(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+
+// estimateCost returns the USD cost for the given usage, or 0 when no
+// estimator is configured, the model is unknown, or pricing is missing.
+func estimateCost(estimator ratelimit.CostEstimator, provider, model string, inputTokens, outputTokens int) float64 {
+	if estimator == nil || model == "" {
 		return 0
 	}
-	v, _ := strconv.Atoi(s)
-	return v
+	c, err := estimator.EstimateCost(provider, model, inputTokens, outputTokens)
+	if err != nil {
+		return 0
+	}
+	return c
 }
 
-func newReservationID() string { return "" }
-
-func prefix(s string) string {
+func headerToInt(s string) int {
 	if s == "" {
-		return ""
-	}
-	if len(s) > 12 {
-		return s[:12]
+		return 0
 	}
-	return s
+	v, _ := strconv.Atoi(s)
+	return v
 }
@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Instawork/llm-proxy/internal/capture"
+	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/logging"
+	"github.com/Instawork/llm-proxy/internal/providers"
+	"github.com/Instawork/llm-proxy/internal/redaction"
+)
+
+// redactionContextKey stores the request's redaction.Counts
+const redactionContextKey contextKey = "redactions"
+
+// RedactionMiddleware scans every string in the JSON body of provider
+// requests (messages in any content form, system prompts, tool inputs, and
+// inputs to endpoints such as Responses and embeddings) for personal data
+// and secrets. Depending on
+// the mode for the request's key or team it logs detections, masks them in
+// the request body, or rejects the request. It must run after API key
+// validation so per-key and per-team modes apply.
+func RedactionMiddleware(providerManager *providers.ProviderManager, redactor *redaction.Redactor, cfg config.RedactionConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provider := GetProviderFromRequest(providerManager, r)
+			if provider == nil || !hasPromptBody(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			mode := redaction.Mode(cfg.ModeFor(ExtractKeyIDFromRequest(r), ExtractTeamIDFromRequest(r)))
+			if mode == redaction.ModeOff {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			texts, err := requestTexts(r)
+			if err != nil {
+				// Fail closed rather than forward a body we could not scan
+				logging.FromContext(r.Context()).Error("🛡️ Redaction: Failed to read request body", "error", err)
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			var findings []redaction.Finding
+			for _, text := range texts {
+				findings = append(findings, redactor.Scan(text)...)
+			}
+			if len(findings) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			counts := make(redaction.Counts)
+			for _, f := range findings {
+				counts[f.Detector]++
+			}
+			logger := logging.FromContext(r.Context())
+			for detector, n := range counts {
+				redactionDetections.WithLabelValues(detector, string(mode)).Add(float64(n))
+			}
+			if entry := accessLogFromRequest(r); entry != nil {
+				entry.redactions = counts
+			}
+
+			if mode == redaction.ModeMask {
+				if err := maskRequestBody(r, findings); err != nil {
+					// Fail closed rather than forward a value we could not mask
+					logger.Error("🛡️ Redaction: Failed to mask request; blocking it", "detectors", counts.Names(), "error", err)
+					mode = redaction.ModeBlock
+				}
+			}
+			logger.Warn("🛡️ Redaction: Sensitive data in prompt", "mode", mode, "detections", map[string]int(counts))
+
+			if mode == redaction.ModeBlock {
+				http.Error(w, "request blocked: prompt contains sensitive data ("+strings.Join(counts.Names(), ", ")+")", http.StatusBadRequest)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), redactionContextKey, counts))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestTexts returns every string value in the request's JSON body, so
+// prompts are scanned whatever their shape: string or block content,
+// Anthropic's system, Gemini's systemInstruction, tool inputs and results.
+// Inline binary data is skipped. A body that is not JSON is returned whole.
+func requestTexts(r *http.Request) ([]string, error) {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	var parsed interface{}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return []string{string(body)}, nil
+	}
+	var texts []string
+	var walk func(key string, v interface{})
+	walk = func(key string, v interface{}) {
+		switch v := v.(type) {
+		case string:
+			// Base64 image and file payloads (Anthropic source.data, Gemini
+			// inlineData.data, data: URLs) hold no text to scan
+			if key != "data" && !strings.HasPrefix(v, "data:") {
+				texts = append(texts, v)
+			}
+		case map[string]interface{}:
+			for k, child := range v {
+				walk(k, child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(key, child)
+			}
+		}
+	}
+	walk("", parsed)
+	return texts, nil
+}
+
+// maskRequestBody replaces each finding's value in the raw request body,
+// matching it as plain text or as a JSON string literal would encode it
+func maskRequestBody(r *http.Request, findings []redaction.Finding) error {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	for _, f := range findings {
+		placeholder := []byte(redaction.Placeholder(f.Detector))
+		found := false
+		for _, form := range jsonForms(f.Value) {
+			if bytes.Contains(body, form) {
+				body = bytes.ReplaceAll(body, form, placeholder)
+				found = true
+			}
+		}
+		if !found && !bytes.Contains(body, placeholder) {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			return fmt.Errorf("%s value not found in request body", f.Detector)
+		}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// jsonForms returns the ways value can appear in a JSON body: as-is, and
+// escaped with and without HTML escaping
+func jsonForms(value string) [][]byte {
+	forms := [][]byte{[]byte(value)}
+	for _, escapeHTML := range []bool{false, true} {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(escapeHTML)
+		if enc.Encode(value) != nil {
+			continue
+		}
+		// Strip the quotes and trailing newline
+		encoded := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+		forms = append(forms, encoded[1:len(encoded)-1])
+	}
+	return forms
+}
+
+// ExtractRedactionsFromRequest returns the detections masked or logged in
+// the request's prompt, or nil when there were none
+func ExtractRedactionsFromRequest(req *http.Request) map[string]int {
+	counts, _ := req.Context().Value(redactionContextKey).(redaction.Counts)
+	return counts
+}
+
+// CaptureRedactor returns a capture.Redactor that masks captured requests
+// and responses unless redaction is off for the record's key or team
+func CaptureRedactor(redactor *redaction.Redactor, cfg config.RedactionConfig) capture.Redactor {
+	return func(record *capture.Record) {
+		if redaction.Mode(cfg.ModeFor(record.KeyID, record.TeamID)) == redaction.ModeOff {
+			return
+		}
+		record.Request, _ = redactor.Mask(record.Request)
+		record.Response, _ = redactor.Mask(record.Response)
+	}
+}
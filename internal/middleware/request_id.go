@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Instawork/llm-proxy/internal/requestid"
+)
+
+// RequestIDMiddleware gives each request an ID: the client's X-Request-ID
+// when it is valid, otherwise a new ULID. The ID is stored in the request
+// context, returned in the X-Request-ID response header and, when
+// upstreamHeader is non-empty, forwarded to the provider in that header.
+func RequestIDMiddleware(upstreamHeader string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(requestid.Header)
+			if !requestid.Valid(id) {
+				id = requestid.New()
+			}
+			w.Header().Set(requestid.Header, id)
+
+			r = r.WithContext(requestid.NewContext(r.Context(), id))
+			if upstreamHeader != "" {
+				// Provider proxies forward incoming headers, so setting it here reaches upstream
+				r.Header.Set(upstreamHeader, id)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ExtractRequestIDFromRequest returns the proxy's ID for the request, or an
+// empty string outside RequestIDMiddleware
+func ExtractRequestIDFromRequest(req *http.Request) string {
+	return requestid.FromContext(req.Context())
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Instawork/llm-proxy/internal/requestid"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var gotID, gotUpstream string
+	handler := RequestIDMiddleware("X-Upstream-Request-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = ExtractRequestIDFromRequest(r)
+		gotUpstream = r.Header.Get("X-Upstream-Request-ID")
+	}))
+
+	// A valid client ID is kept
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	req.Header.Set(requestid.Header, "client-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if gotID != "client-123" || gotUpstream != "client-123" || rec.Header().Get(requestid.Header) != "client-123" {
+		t.Fatalf("client ID not propagated: context=%q upstream=%q response=%q", gotID, gotUpstream, rec.Header().Get(requestid.Header))
+	}
+
+	// Missing or unusable IDs are replaced with a ULID
+	req = httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	req.Header.Set(requestid.Header, "not a valid id")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if len(gotID) != 26 || rec.Header().Get(requestid.Header) != gotID || gotUpstream != gotID {
+		t.Fatalf("expected a generated ULID everywhere, got context=%q upstream=%q response=%q", gotID, gotUpstream, rec.Header().Get(requestid.Header))
+	}
+}
@@ -0,0 +1,303 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/apikeys"
+	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/logging"
+	"github.com/Instawork/llm-proxy/internal/providers"
+	"github.com/Instawork/llm-proxy/internal/responsecache"
+)
+
+// CacheHeader reports hit, miss or bypass on cacheable responses. Clients
+// send it with the value "bypass" to skip the cache.
+const CacheHeader = "X-LLM-Cache"
+
+// cacheContextKey stores the request's *cacheState
+const cacheContextKey contextKey = "response_cache"
+
+// cacheState receives the response metadata from TokenParsingMiddleware
+type cacheState struct {
+	metadata *providers.LLMResponseMetadata
+}
+
+// cacheFromRequest returns the request's cache state, or nil when the
+// response is not being cached
+func cacheFromRequest(r *http.Request) *cacheState {
+	state, _ := r.Context().Value(cacheContextKey).(*cacheState)
+	return state
+}
+
+// ResponseCacheMiddleware answers repeated requests from opted-in keys and
+// teams with the stored response. Streaming requests are answered by
+// replaying the stored completion in the provider's streaming format, when
+// the provider supports it. Hits skip the rest of the chain, so they reach
+// neither the rate limiter nor the provider; admit, when set, counts them
+// against request limits instead, and onHit callbacks receive the cached
+// response's metadata. It must run after API key validation, CORS and
+// CaptureMiddleware, and before rate limiting and TokenParsingMiddleware.
+func ResponseCacheMiddleware(providerManager *providers.ProviderManager, cache *responsecache.Cache, cfg config.ResponseCacheConfig, admit AdmitFunc, onHit ...MetadataCallback) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provider := GetProviderFromRequest(providerManager, r)
+			if provider == nil || r.Method != http.MethodPost || !isIdempotentEndpoint(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			streaming := providerManager.IsStreamingRequest(r)
+			replayer, canReplay := provider.(providers.StreamReplayer)
+			if streaming && (!canReplay || !replayer.CanReplayStream(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			keyID := ExtractKeyIDFromRequest(r)
+			teamID := ExtractTeamIDFromRequest(r)
+			scope := cacheScope(r, cfg.Scope, keyID, teamID)
+			if scope == "" || !cache.Enabled(keyID, teamID) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			entry := accessLogFromRequest(r)
+			if strings.EqualFold(r.Header.Get(CacheHeader), "bypass") {
+				cache.Bypassed()
+				w.Header().Set(CacheHeader, "bypass")
+				if entry != nil {
+					entry.cache = "bypass"
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("🗄️ Response Cache: Failed to read request body", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := responsecache.Key(scope, provider.GetName(), cacheVariant(r), body)
+
+			if cached := cache.Get(r.Context(), key, streaming); cached != nil {
+				if admit != nil && !admit(w, r) {
+					return
+				}
+				metadata := cacheHitMetadata(r, provider.GetName(), cached, streaming)
+				if streaming {
+					replayCached(w, r, providerManager, replayer, cached, streamIncludesUsage(body))
+				} else {
+					serveCached(w, cached)
+				}
+				if captured := captureFromRequest(r); captured != nil {
+					captured.served, captured.cached = true, true
+					captured.body, captured.metadata = cached.Body, metadata
+					if streaming {
+						captured.text = cached.Text
+					}
+				}
+				for _, callback := range onHit {
+					if callback != nil {
+						callback(r, metadata)
+					}
+				}
+				return
+			}
+
+			w.Header().Set(CacheHeader, "miss")
+			if entry != nil {
+				entry.cache = "miss"
+			}
+			state := &cacheState{}
+			cw := &cacheWriter{ResponseWriter: w, status: http.StatusOK, maxBytes: cache.MaxBodyBytes()}
+			r = r.WithContext(context.WithValue(r.Context(), cacheContextKey, state))
+			next.ServeHTTP(cw, r)
+
+			// Only store complete, successful responses whose usage is known
+			m := state.metadata
+			if cw.status != http.StatusOK || cw.overflow || m == nil {
+				return
+			}
+			stored := &responsecache.Entry{
+				StoredAt:          time.Now().UTC(),
+				Model:             m.Model,
+				UpstreamRequestID: m.RequestID,
+				InputTokens:       m.InputTokens,
+				OutputTokens:      m.OutputTokens,
+				TotalTokens:       m.TotalTokens,
+				FinishReason:      m.FinishReason,
+			}
+			if canReplay {
+				stored.Text, stored.Replayable = replayer.ReplayableText(bytes.NewReader(cw.body.Bytes()), streaming)
+			}
+			if streaming {
+				// Streams are kept only as text to replay
+				if !stored.Replayable {
+					return
+				}
+			} else {
+				if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+					return
+				}
+				stored.Status = cw.status
+				stored.ContentType = w.Header().Get("Content-Type")
+				stored.ContentEncoding = w.Header().Get("Content-Encoding")
+				stored.Body = cw.body.Bytes()
+			}
+			cache.Set(r.Context(), key, stored)
+		})
+	}
+}
+
+// cacheScope returns the identity whose requests share cache entries: the
+// team when scope is "team" and the request has one, otherwise the key. It
+// returns an empty string for anonymous requests, which are never cached.
+func cacheScope(r *http.Request, scope, keyID, teamID string) string {
+	if scope == "team" && teamID != "" {
+		return "team:" + teamID
+	}
+	if keyID != "" {
+		return "key:" + keyID
+	}
+	// Provider keys are hashed so they are not kept in the cache key's input
+	if apiKey := ExtractAPIKeyFromRequest(r); apiKey != "" {
+		return "key:" + apikeys.KeyID(apiKey)
+	}
+	return ""
+}
+
+// cacheVariant returns the parts of a request besides its body that change
+// the response: the path (which holds the model for Gemini), the query
+// without any API key, and the accepted encodings. Gemini's streaming
+// method and alt parameter are normalized away so streaming and
+// non-streaming requests share entries.
+func cacheVariant(r *http.Request) string {
+	path := strings.Replace(r.URL.Path, ":streamGenerateContent", ":generateContent", 1)
+	query := r.URL.Query()
+	query.Del("key")
+	query.Del("alt")
+	return path + "?" + query.Encode() + "\x00" + r.Header.Get("Accept-Encoding")
+}
+
+// cacheHitMetadata fills in the access log for a cache hit and returns the
+// cached response's usage for onHit callbacks
+func cacheHitMetadata(r *http.Request, providerName string, cached *responsecache.Entry, streaming bool) *providers.LLMResponseMetadata {
+	if entry := accessLogFromRequest(r); entry != nil {
+		entry.cache = "hit"
+		entry.model = cached.Model
+		entry.upstreamID = cached.UpstreamRequestID
+		entry.inputTokens = cached.InputTokens
+		entry.outputTokens = cached.OutputTokens
+	}
+	logging.FromContext(r.Context()).Debug("🗄️ Response Cache: Hit",
+		"model", cached.Model, "replayed", streaming, "age", time.Since(cached.StoredAt).Round(time.Second).String())
+	return &providers.LLMResponseMetadata{
+		Model:        cached.Model,
+		InputTokens:  cached.InputTokens,
+		OutputTokens: cached.OutputTokens,
+		TotalTokens:  cached.TotalTokens,
+		Provider:     providerName,
+		RequestID:    cached.UpstreamRequestID,
+		IsStreaming:  streaming,
+		FinishReason: cached.FinishReason,
+	}
+}
+
+// serveCached writes a stored non-streaming response
+func serveCached(w http.ResponseWriter, cached *responsecache.Entry) {
+	h := w.Header()
+	h.Set(CacheHeader, "hit")
+	if cached.ContentType != "" {
+		h.Set("Content-Type", cached.ContentType)
+	}
+	if cached.ContentEncoding != "" {
+		h.Set("Content-Encoding", cached.ContentEncoding)
+	}
+	h.Set("Content-Length", strconv.Itoa(len(cached.Body)))
+	h.Set("Age", strconv.Itoa(int(time.Since(cached.StoredAt).Seconds())))
+	w.WriteHeader(cached.Status)
+	_, _ = w.Write(cached.Body)
+}
+
+// replayCached synthesizes the provider's stream from a stored completion,
+// flushing each event through StreamingMiddleware
+func replayCached(w http.ResponseWriter, r *http.Request, providerManager *providers.ProviderManager, replayer providers.StreamReplayer, cached *responsecache.Entry, includeUsage bool) {
+	completion := &providers.Completion{
+		ID:           cached.UpstreamRequestID,
+		Model:        cached.Model,
+		Text:         cached.Text,
+		FinishReason: cached.FinishReason,
+		InputTokens:  cached.InputTokens,
+		OutputTokens: cached.OutputTokens,
+		TotalTokens:  cached.TotalTokens,
+		IncludeUsage: includeUsage,
+	}
+	replay := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set(CacheHeader, "hit")
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("Age", strconv.Itoa(int(time.Since(cached.StoredAt).Seconds())))
+		w.WriteHeader(http.StatusOK)
+		if err := replayer.WriteStream(w, completion); err != nil {
+			logging.FromContext(r.Context()).Warn("🗄️ Response Cache: Failed to replay stream", "error", err)
+		}
+	})
+	StreamingMiddleware(providerManager)(replay).ServeHTTP(w, r)
+}
+
+// streamIncludesUsage reports whether an OpenAI-style request body sets
+// stream_options.include_usage
+func streamIncludesUsage(body []byte) bool {
+	var req struct {
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+	}
+	return json.Unmarshal(body, &req) == nil && req.StreamOptions.IncludeUsage
+}
+
+// cacheWriter copies the response into a buffer of up to maxBytes so it
+// can be stored
+type cacheWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	maxBytes int
+	overflow bool
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	cw.status = status
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if !cw.overflow {
+		if cw.body.Len()+len(b) > cw.maxBytes {
+			cw.overflow = true
+			cw.body.Reset()
+		} else {
+			cw.body.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *cacheWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Instawork/llm-proxy/internal/capture"
+	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/providers"
+	"github.com/Instawork/llm-proxy/internal/ratelimit"
+	"github.com/Instawork/llm-proxy/internal/responsecache"
+)
+
+func TestResponseCacheMiddleware(t *testing.T) {
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+	cache := responsecache.New(responsecache.Options{Store: responsecache.NewMemoryStore(0), All: true})
+
+	upstreamCalls := 0
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"4"},"finish_reason":"stop"}],"usage":{"prompt_tokens":8,"completion_tokens":1,"total_tokens":9}}`))
+	})
+	var hits []*providers.LLMResponseMetadata
+	onHit := func(r *http.Request, metadata *providers.LLMResponseMetadata) {
+		hits = append(hits, metadata)
+	}
+	handler := ResponseCacheMiddleware(pm, cache, config.ResponseCacheConfig{}, nil, onHit)(TokenParsingMiddleware(pm)(upstream))
+
+	send := func(apiKey, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"2+2?"}]}`
+	reordered := `{"messages":[{"role":"user","content":"2+2?"}], "temperature":0, "model":"gpt-4o"}`
+
+	first := send("sk-a", body)
+	if got := first.Header().Get(CacheHeader); got != "miss" {
+		t.Fatalf("expected a miss, got %q", got)
+	}
+	second := send("sk-a", reordered)
+	if got := second.Header().Get(CacheHeader); got != "hit" {
+		t.Fatalf("expected a hit, got %q", got)
+	}
+	if second.Body.String() != first.Body.String() || second.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("cached response differs: %q", second.Body.String())
+	}
+	if upstreamCalls != 1 {
+		t.Fatalf("expected 1 upstream call, got %d", upstreamCalls)
+	}
+	if len(hits) != 1 || hits[0].TotalTokens != 9 || hits[0].Model != "gpt-4o" || hits[0].Provider != "openai" {
+		t.Fatalf("unexpected hit metadata %+v", hits)
+	}
+
+	// Other keys do not share entries, and clients can bypass the cache
+	if got := send("sk-b", body).Header().Get(CacheHeader); got != "miss" {
+		t.Fatalf("expected another key to miss, got %q", got)
+	}
+	if got := send("sk-a", body, CacheHeader, "bypass").Header().Get(CacheHeader); got != "bypass" {
+		t.Fatalf("expected a bypass, got %q", got)
+	}
+	if upstreamCalls != 3 {
+		t.Fatalf("expected 3 upstream calls, got %d", upstreamCalls)
+	}
+
+	// A streaming request replays the stored completion as SSE, with the
+	// usage chunk only when the request asks for it
+	streaming := `{"model":"gpt-4o","temperature":0,"stream":true,"messages":[{"role":"user","content":"2+2?"}]}`
+	if replay := send("sk-a", streaming); replay.Header().Get(CacheHeader) != "hit" || strings.Contains(replay.Body.String(), `"usage"`) {
+		t.Fatalf("expected a replay without usage, got %q\n%s", replay.Header().Get(CacheHeader), replay.Body.String())
+	}
+	withUsage := strings.Replace(streaming, `"stream":true,`, `"stream":true,"stream_options":{"include_usage":true},`, 1)
+	replay := send("sk-a", withUsage)
+	if got := replay.Header().Get(CacheHeader); got != "hit" || replay.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected a replayed stream, got %q (%s)", got, replay.Header().Get("Content-Type"))
+	}
+	openai := pm.GetProvider("openai")
+	metadata, err := openai.ParseResponseMetadata(strings.NewReader(replay.Body.String()), true)
+	if err != nil || metadata.TotalTokens != 9 || metadata.FinishReason != "stop" || metadata.RequestID != "chatcmpl-1" {
+		t.Fatalf("replayed stream did not parse: %+v, %v\n%s", metadata, err, replay.Body.String())
+	}
+	if text := openai.(providers.ResponseTextExtractor).ExtractResponseText(strings.NewReader(replay.Body.String()), true); text != "4" {
+		t.Fatalf("expected the replayed text to be %q, got %q", "4", text)
+	}
+	if upstreamCalls != 3 || len(hits) != 3 || !hits[2].IsStreaming {
+		t.Fatalf("expected the replay to skip upstream, got %d calls and hits %+v", upstreamCalls, hits)
+	}
+}
+
+func TestResponseCacheMiddlewareStoresStreams(t *testing.T) {
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewAnthropicProxy())
+	cache := responsecache.New(responsecache.Options{Store: responsecache.NewMemoryStore(0), Teams: []string{"batch"}})
+
+	upstreamCalls := 0
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		if !pm.IsStreamingRequest(r) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"id":"msg_2","type":"message","role":"assistant","content":[{"type":"text","text":"Hi"}],"model":"claude-3-5-haiku","stop_reason":"end_turn","usage":{"input_tokens":5,"output_tokens":1}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude-3-5-haiku","usage":{"input_tokens":5,"output_tokens":0}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":1}}`,
+			`{"type":"message_stop"}`,
+		} {
+			_, _ = w.Write([]byte("data: " + event + "\n\n"))
+		}
+	})
+	handler := TeamHeaderMiddleware(ResponseCacheMiddleware(pm, cache, config.ResponseCacheConfig{Scope: "team"}, nil)(TokenParsingMiddleware(pm)(upstream)))
+
+	send := func(apiKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/anthropic/v1/messages", strings.NewReader(body))
+		req.Header.Set("x-api-key", apiKey)
+		req.Header.Set("X-Team-ID", "batch")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := `{"model":"claude-3-5-haiku","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"Say hi"}]}`
+	if got := send("sk-a", body).Header().Get(CacheHeader); got != "miss" {
+		t.Fatalf("expected a miss, got %q", got)
+	}
+	// Keys of the same team share the stored stream
+	replay := send("sk-b", body)
+	if got := replay.Header().Get(CacheHeader); got != "hit" {
+		t.Fatalf("expected a hit, got %q", got)
+	}
+	metadata, err := pm.GetProvider("anthropic").ParseResponseMetadata(strings.NewReader(replay.Body.String()), true)
+	if err != nil || metadata.InputTokens != 5 || metadata.OutputTokens != 1 || metadata.RequestID != "msg_1" {
+		t.Fatalf("replayed stream did not parse: %+v, %v", metadata, err)
+	}
+
+	// Entries stored from streams hold no body for non-streaming requests
+	nonStreaming := strings.Replace(body, `"stream":true,`, "", 1)
+	if got := send("sk-a", nonStreaming).Header().Get(CacheHeader); got != "miss" {
+		t.Fatalf("expected a non-streaming miss, got %q", got)
+	}
+	if upstreamCalls != 2 {
+		t.Fatalf("expected 2 upstream calls, got %d", upstreamCalls)
+	}
+}
+
+func TestResponseCacheHitsInChain(t *testing.T) {
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+	cache := responsecache.New(responsecache.Options{Store: responsecache.NewMemoryStore(0), All: true})
+	sink := &recordingSink{}
+	capturer := capture.New(capture.Options{Sink: sink, Users: []string{"alice"}})
+
+	cfg := makeCfg()
+	cfg.Features.RateLimiting.Limits = config.LimitsConfig{RequestsPerMinute: 2, TokensPerMinute: 1}
+	lim := ratelimit.NewMemoryLimiter(cfg)
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"4"},"finish_reason":"stop"}],"usage":{"prompt_tokens":8,"completion_tokens":1,"total_tokens":9}}`))
+	})
+	// The production order: CORS and capture wrap the cache, which runs before rate limiting
+	handler := CORSMiddleware(pm)(CaptureMiddleware(pm, capturer, fakeEstimator{})(
+		ResponseCacheMiddleware(pm, cache, config.ResponseCacheConfig{}, RequestLimitGate(pm, cfg, lim))(
+			RateLimitingMiddleware(pm, cfg, lim, nil)(TokenParsingMiddleware(pm)(upstream)))))
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"2+2?"}]}`))
+		req.Header.Set("Authorization", "Bearer sk-a")
+		req.Header.Set("X-User-ID", "alice")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(); rec.Code != http.StatusOK || rec.Header().Get(CacheHeader) != "miss" {
+		t.Fatalf("expected a miss, got %d %q", rec.Code, rec.Header().Get(CacheHeader))
+	}
+	// The token budget is spent, but hits use no tokens
+	hit := send()
+	if hit.Code != http.StatusOK || hit.Header().Get(CacheHeader) != "hit" {
+		t.Fatalf("expected a hit, got %d %q", hit.Code, hit.Header().Get(CacheHeader))
+	}
+	if hit.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatal("expected CORS headers on a cache hit")
+	}
+	// Hits still count against the request limit
+	if rec := send(); rec.Code != http.StatusTooManyRequests || rec.Header().Get("X-RateLimit-Metric") != "requests" {
+		t.Fatalf("expected the request limit to apply to hits, got %d %q", rec.Code, rec.Header().Get("X-RateLimit-Metric"))
+	}
+
+	if err := capturer.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if len(sink.records) != 2 {
+		t.Fatalf("expected the miss and the hit to be captured, got %d records", len(sink.records))
+	}
+	miss, cached := sink.records[0], sink.records[1]
+	if miss.Cached || miss.CostUSD == 0 {
+		t.Fatalf("unexpected miss record %+v", miss)
+	}
+	if !cached.Cached || cached.CostUSD != 0 || cached.Response != "4" || cached.TotalTokens != 9 {
+		t.Fatalf("unexpected hit record %+v", cached)
+	}
+}
+
+func TestResponseCacheSkipsNonIdempotentEndpoints(t *testing.T) {
+	pm := providers.NewProviderManager()
+	pm.RegisterProvider(providers.NewAnthropicProxy())
+	pm.RegisterProvider(providers.NewOpenAIProxy())
+	cache := responsecache.New(responsecache.Options{Store: responsecache.NewMemoryStore(0), All: true})
+
+	upstreamCalls := 0
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"ok"}],"model":"claude-3-5-haiku","stop_reason":"end_turn","usage":{"input_tokens":5,"output_tokens":1}}`))
+	})
+	handler := ResponseCacheMiddleware(pm, cache, config.ResponseCacheConfig{}, nil)(TokenParsingMiddleware(pm)(upstream))
+
+	for _, path := range []string{"/anthropic/v1/messages/batches", "/openai/v1/threads/t1/messages"} {
+		upstreamCalls = 0
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("POST", path, strings.NewReader(`{"model":"claude-3-5-haiku","requests":[]}`))
+			req.Header.Set("Authorization", "Bearer sk-a")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if got := rec.Header().Get(CacheHeader); got != "" {
+				t.Fatalf("%s: expected no cache header, got %q", path, got)
+			}
+		}
+		if upstreamCalls != 2 {
+			t.Fatalf("%s: expected every request to reach upstream, got %d calls", path, upstreamCalls)
+		}
+	}
+}
@@ -1,9 +1,9 @@
 package middleware
 
 import (
-	"log"
 	"net/http"
 
+	"github.com/Instawork/llm-proxy/internal/logging"
 	"github.com/Instawork/llm-proxy/internal/providers"
 )
 
@@ -22,7 +22,7 @@ func StreamingMiddleware(providerManager *providers.ProviderManager) func(http.H
 					}
 					next.ServeHTTP(streamingWriter, r)
 				} else {
-					log.Printf("Warning: ResponseWriter does not support flushing for streaming request")
+					logging.FromContext(r.Context()).Warn("ResponseWriter does not support flushing for streaming request")
 					next.ServeHTTP(w, r)
 				}
 			} else {
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// headerTeamContextKey stores the team from a trusted X-Team-ID header
+const headerTeamContextKey contextKey = "header_team_id"
+
+// TeamHeaderMiddleware trusts the client's X-Team-ID header as the team of
+// requests whose key has none, e.g. raw provider keys. The header can name
+// any team, so only register it when every client is trusted, such as
+// behind an internal gateway that sets it.
+func TeamHeaderMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if teamID := strings.TrimSpace(r.Header.Get("X-Team-ID")); teamID != "" {
+			r = r.WithContext(context.WithValue(r.Context(), headerTeamContextKey, teamID))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -5,10 +5,11 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 
+	"github.com/Instawork/llm-proxy/internal/logging"
 	"github.com/Instawork/llm-proxy/internal/providers"
 )
 
@@ -71,50 +72,40 @@ func TokenParsingMiddleware(providerManager *providers.ProviderManager, callback
 				lastMetadata:   nil,
 			}
 
-			// Debug logging
-			log.Printf("🔍 Debug: Request path: %s, Provider: %v", r.URL.Path, provider != nil)
+			logger := logging.FromContext(r.Context())
 
 			next.ServeHTTP(captureWriter, r)
 
-			// Debug logging for endpoint matching
-			isAPIEndpoint := strings.Contains(r.URL.Path, "/chat/completions") ||
-				strings.Contains(r.URL.Path, "/completions") ||
-				strings.Contains(r.URL.Path, "/messages") ||
-				strings.Contains(r.URL.Path, ":generateContent") ||
-				strings.Contains(r.URL.Path, ":streamGenerateContent")
-
-			log.Printf("🔍 Debug: Provider: %v, API endpoint: %v, Response body length: %d",
-				provider != nil, isAPIEndpoint, captureWriter.body.Len())
+			// Hand the buffered response to CaptureMiddleware
+			captured := captureFromRequest(r)
+			if captured != nil {
+				captured.served = true
+				captured.body = captureWriter.body.Bytes()
+			}
 
 			// Only process if we have a provider and this is an API endpoint
-			if provider != nil && isAPIEndpoint {
+			if provider != nil && isAPIEndpoint(r.URL.Path) {
 				var metadata *providers.LLMResponseMetadata
 				var err error
 
 				// For streaming responses, use the last metadata captured during streaming
 				if isStreaming && captureWriter.lastMetadata != nil {
 					metadata = captureWriter.lastMetadata
-					log.Printf("🔍 Token Parser: Using captured streaming metadata - Input: %d, Output: %d, Total: %d",
-						metadata.InputTokens, metadata.OutputTokens, metadata.TotalTokens)
 				} else {
 					// For non-streaming responses, parse the final response
 					bodyReader := bytes.NewReader(captureWriter.body.Bytes())
 					metadata, err = provider.ParseResponseMetadata(bodyReader, isStreaming)
-					if isStreaming && metadata != nil {
-						log.Printf("🔍 Token Parser: Got final parse metadata - Input: %d, Output: %d, Total: %d",
-							metadata.InputTokens, metadata.OutputTokens, metadata.TotalTokens)
-					}
 				}
 
 				if err != nil {
 					// For streaming responses, partial data is expected and not necessarily an error
 					if isStreaming {
-						log.Printf("Info: Partial streaming response data for %s: %v", provider.GetName(), err)
+						logger.Debug("Partial streaming response data", "error", err)
 					} else {
-						log.Printf("Warning: Failed to parse response metadata for %s: %v", provider.GetName(), err)
+						logger.Warn("Failed to parse response metadata", "error", err)
 					}
 					// Add debug logging for response body if parsing fails
-					if captureWriter.body.Len() > 0 {
+					if captureWriter.body.Len() > 0 && logger.Enabled(r.Context(), slog.LevelDebug) {
 						bodyBytes := captureWriter.body.Bytes()
 						previewBytes := bodyBytes[:min(200, len(bodyBytes))]
 
@@ -123,41 +114,24 @@ func TokenParsingMiddleware(providerManager *providers.ProviderManager, callback
 							// Try to decompress for preview
 							if decompressed, err := decompressForPreview(bodyBytes); err == nil {
 								previewLen := min(200, len(decompressed))
-								log.Printf("🔍 Debug: Response body is gzip compressed, decompressed preview: %s", string(decompressed[:previewLen]))
+								logger.Debug("Response body preview", "gzip", true, "preview", string(decompressed[:previewLen]))
 							} else {
-								log.Printf("🔍 Debug: Response body is gzip compressed (failed to decompress for preview): %v", err)
+								logger.Debug("Response body is gzip compressed (failed to decompress for preview)", "error", err)
 							}
 						} else {
-							log.Printf("🔍 Debug: Response body preview: %s", string(previewBytes))
+							logger.Debug("Response body preview", "preview", string(previewBytes))
 						}
 					}
 				} else if metadata != nil {
-					// Log the metadata for cost tracking
-					log.Printf("🔢 LLM Response Metadata:\n"+
-						"   Provider: %s\n"+
-						"   Model: %s\n"+
-						"   Request ID: %s\n"+
-						"   Input Tokens: %d\n"+
-						"   Output Tokens: %d\n"+
-						"   Total Tokens: %d\n"+
-						"   Streaming: %t\n"+
-						"   Finish Reason: %s",
-						metadata.Provider, metadata.Model, metadata.RequestID, metadata.InputTokens, metadata.OutputTokens,
-						metadata.TotalTokens, metadata.IsStreaming, metadata.FinishReason)
-
-					// Additional detailed logging for cost tracking
-					if metadata.TotalTokens > 0 {
-						// Include thought tokens in the logging if available
-						log.Printf("💰 Token Usage Summary:\n"+
-							"   Provider/Model: %s/%s\n"+
-							"   Input Tokens: %d\n"+
-							"   Output Tokens: %d\n"+
-							"   Thought Tokens: %d\n"+
-							"   Total Tokens: %d",
-							metadata.Provider, metadata.Model, metadata.InputTokens, metadata.OutputTokens, metadata.ThoughtTokens, metadata.TotalTokens)
-					} else if metadata.IsStreaming {
-						log.Printf("ℹ️  Streaming Response: Usage information not yet available (partial response captured)")
-					}
+					logger.Debug("🔢 LLM response metadata",
+						"model", metadata.Model,
+						"upstream_request_id", metadata.RequestID,
+						"input_tokens", metadata.InputTokens,
+						"output_tokens", metadata.OutputTokens,
+						"thought_tokens", metadata.ThoughtTokens,
+						"total_tokens", metadata.TotalTokens,
+						"streaming", metadata.IsStreaming,
+						"finish_reason", metadata.FinishReason)
 
 					// Add custom header with token usage information
 					w.Header().Set("X-LLM-Input-Tokens", fmt.Sprintf("%d", metadata.InputTokens))
@@ -170,6 +144,18 @@ func TokenParsingMiddleware(providerManager *providers.ProviderManager, callback
 						w.Header().Set("X-LLM-Request-ID", metadata.RequestID)
 					}
 
+					if captured != nil {
+						captured.metadata = metadata
+					}
+					// Hand the usage to ResponseCacheMiddleware for the cache entry
+					if cached := cacheFromRequest(r); cached != nil {
+						cached.metadata = metadata
+					}
+					// Hand the usage to CoalescingMiddleware for waiting requests
+					if coalesced := coalesceFromRequest(r); coalesced != nil {
+						coalesced.metadata = metadata
+					}
+
 					// Execute all registered callbacks with the metadata
 					for _, callback := range callbacks {
 						if callback != nil {
@@ -177,8 +163,7 @@ func TokenParsingMiddleware(providerManager *providers.ProviderManager, callback
 						}
 					}
 				} else if isStreaming {
-					// For streaming responses without metadata, just log that we're still waiting
-					log.Printf("ℹ️  Streaming Response: Still waiting for complete usage information")
+					logger.Debug("Streaming response ended without usage information")
 				}
 			}
 		})
@@ -206,19 +191,13 @@ func (rc *responseCapture) Write(b []byte) (int, error) {
 
 		// Only parse if we have new data since the last parse
 		if len(allData) > rc.lastParsedPos {
-			log.Printf("🔍 Token Parser: Parsing streaming data, buffer size: %d, new data: %d bytes",
-				len(allData), len(allData)-rc.lastParsedPos)
-
 			// For streaming, we need to parse the entire buffer since usage info
 			// comes at the end and we might have partial events
 			bodyReader := bytes.NewReader(allData)
+			// Parse errors are expected while events are still partial
 			if metadata, err := rc.provider.ParseResponseMetadata(bodyReader, true); err == nil && metadata != nil {
-				log.Printf("🔍 Token Parser: Got metadata - Input: %d, Output: %d, Total: %d",
-					metadata.InputTokens, metadata.OutputTokens, metadata.TotalTokens)
 				// Update the last successful metadata
 				rc.lastMetadata = metadata
-			} else if err != nil {
-				log.Printf("🔍 Token Parser: Parse error (expected for partial data): %v", err)
 			}
 			// Update the last parsed position
 			rc.lastParsedPos = len(allData)
@@ -228,6 +207,19 @@ func (rc *responseCapture) Write(b []byte) (int, error) {
 	return rc.ResponseWriter.Write(b)
 }
 
+// Flush forwards to the underlying writer so streaming keeps working
+// through the capture
+func (rc *responseCapture) Flush() {
+	if f, ok := rc.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rc *responseCapture) Unwrap() http.ResponseWriter {
+	return rc.ResponseWriter
+}
+
 // Helper function to find minimum of two integers
 func min(a, b int) int {
 	if a < b {
@@ -236,12 +228,17 @@ func min(a, b int) int {
 	return b
 }
 
+// debugUserID logs where the user ID of a request came from
+func debugUserID(req *http.Request, source, userID string) {
+	logging.FromContext(req.Context()).Debug("🔍 Resolved user ID", "source", source, "user_id", userID)
+}
+
 // ExtractUserIDFromRequest extracts user ID from request headers, query parameters, or provider-specific methods
 // Follows the priority order: context (from meta URL) → URL path → headers → query parameters → provider-specific extraction → fallback to IP
 func ExtractUserIDFromRequest(req *http.Request, provider providers.Provider) string {
 	// Priority 0: Check for user ID in request context (from meta URL rewriting)
 	if userID, ok := req.Context().Value(userIDContextKey).(string); ok && userID != "" {
-		log.Printf("🔍 User ID from context: %s", userID)
+		debugUserID(req, "context", userID)
 		return userID
 	}
 
@@ -252,7 +249,7 @@ func ExtractUserIDFromRequest(req *http.Request, provider providers.Provider) st
 		if len(parts) >= 3 { // ["", "meta", "userID", ...]
 			userID := parts[2]
 			if userID != "" {
-				log.Printf("🔍 User ID from URL path: %s", userID)
+				debugUserID(req, "path", userID)
 				return userID
 			}
 		}
@@ -260,21 +257,21 @@ func ExtractUserIDFromRequest(req *http.Request, provider providers.Provider) st
 
 	// Priority 2: Check for custom user ID header
 	if userID := req.Header.Get("X-User-ID"); userID != "" {
-		log.Printf("🔍 User ID from X-User-ID header: %s", userID)
+		debugUserID(req, "header", userID)
 		return userID
 	}
 
 	// Priority 3: Provider-specific extraction from request body
 	if provider != nil {
 		if userID := provider.UserIDFromRequest(req); userID != "" {
-			log.Printf("🔍 User ID from provider-specific extraction: %s", userID)
+			debugUserID(req, "provider", userID)
 			return userID
 		}
 	}
 
 	// Priority 4: Check query parameters
 	if userID := req.URL.Query().Get("llm_user_id"); userID != "" {
-		log.Printf("🔍 User ID from query parameter: %s", userID)
+		debugUserID(req, "query", userID)
 		return userID
 	}
 
@@ -286,21 +283,41 @@ func ExtractUserIDFromRequest(req *http.Request, provider providers.Provider) st
 			token := auth[7:]
 			if len(token) > 8 {
 				tokenID := fmt.Sprintf("token:%s", token[:8])
-				log.Printf("🔍 User ID from Authorization header: %s", tokenID)
+				debugUserID(req, "authorization", tokenID)
 				return tokenID
 			}
 			tokenID := fmt.Sprintf("token:%s", token)
-			log.Printf("🔍 User ID from Authorization header: %s", tokenID)
+			debugUserID(req, "authorization", tokenID)
 			return tokenID
 		}
 	}
 
 	// Fallback to IP address if no user identification
 	ipAddr := ExtractIPAddressFromRequest(req)
-	log.Printf("🔍 User ID fallback to IP address: %s", ipAddr)
+	debugUserID(req, "ip", ipAddr)
 	return fmt.Sprintf("ip:%s", ipAddr)
 }
 
+// ExtractTeamIDFromRequest extracts the team ID used for team-scoped limits,
+// redaction modes, cache scopes and cost attribution: the team of the iw:
+// key that authenticated the request, else the X-Team-ID header when
+// TeamHeaderMiddleware trusts it. Returns an empty string when the request
+// is not associated with a team.
+func ExtractTeamIDFromRequest(req *http.Request) string {
+	if teamID, ok := req.Context().Value(keyTeamContextKey).(string); ok && teamID != "" {
+		return teamID
+	}
+	teamID, _ := req.Context().Value(headerTeamContextKey).(string)
+	return teamID
+}
+
+// ExtractProjectIDFromRequest returns the project of the iw: key that
+// authenticated the request, or an empty string
+func ExtractProjectIDFromRequest(req *http.Request) string {
+	projectID, _ := req.Context().Value(keyProjectContextKey).(string)
+	return projectID
+}
+
 // ExtractIPAddressFromRequest extracts IP address from request headers
 func ExtractIPAddressFromRequest(req *http.Request) string {
 	// Check for forwarded headers
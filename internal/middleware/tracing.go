@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Instawork/llm-proxy/internal/providers"
+	"github.com/Instawork/llm-proxy/internal/tracing"
+)
+
+// TracingCallback returns a MetadataCallback that adds OpenTelemetry GenAI
+// semantic convention attributes to the request's server span
+func TracingCallback() MetadataCallback {
+	return func(r *http.Request, metadata *providers.LLMResponseMetadata) {
+		span := tracing.LocalRootSpan(r.Context())
+		if span == nil {
+			return
+		}
+		attrs := []tracing.Attribute{
+			tracing.String("gen_ai.system", metadata.Provider),
+			tracing.Int("gen_ai.usage.input_tokens", metadata.InputTokens),
+			tracing.Int("gen_ai.usage.output_tokens", metadata.OutputTokens),
+		}
+		if metadata.Model != "" {
+			attrs = append(attrs, tracing.String("gen_ai.request.model", metadata.Model),
+				tracing.String("gen_ai.response.model", metadata.Model))
+		}
+		if metadata.FinishReason != "" {
+			attrs = append(attrs, tracing.Strings("gen_ai.response.finish_reasons", metadata.FinishReason))
+		}
+		if metadata.RequestID != "" {
+			attrs = append(attrs, tracing.String("gen_ai.response.id", metadata.RequestID))
+		}
+		span.SetAttributes(attrs...)
+	}
+}
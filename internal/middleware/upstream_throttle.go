@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/providers"
+)
+
+// upstreamExhausted reports whether the last known upstream state leaves too
+// little headroom for a request, along with the metric that is exhausted and
+// how long until the upstream window resets. State whose reset time has
+// passed is treated as replenished.
+func upstreamExhausted(state providers.UpstreamLimitState, estTokens int, cfg config.UpstreamThrottleConfig, now time.Time) (string, time.Duration, bool) {
+	metric := ""
+	var wait time.Duration
+	if state.RemainingRequests >= 0 && now.Before(state.ResetRequests) &&
+		state.RemainingRequests <= cfg.MinRemainingRequests {
+		metric = "requests"
+		wait = state.ResetRequests.Sub(now)
+	}
+	if state.RemainingTokens >= 0 && now.Before(state.ResetTokens) &&
+		state.RemainingTokens-estTokens < cfg.MinRemainingTokens {
+		if d := state.ResetTokens.Sub(now); d > wait {
+			metric = "tokens"
+			wait = d
+		}
+	}
+	return metric, wait, metric != ""
+}
@@ -8,12 +8,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
 
+	"github.com/Instawork/llm-proxy/internal/logging"
 	"github.com/gorilla/mux"
 )
 
@@ -32,7 +33,7 @@ func NewAnthropicProxy() *AnthropicProxy {
 	// Parse the Anthropic API URL
 	targetURL, err := url.Parse(anthropicBaseURL)
 	if err != nil {
-		log.Fatalf("Failed to parse Anthropic API URL: %v", err)
+		panic(fmt.Sprintf("failed to parse Anthropic API URL: %v", err))
 	}
 
 	// Create the reverse proxy
@@ -46,13 +47,17 @@ func NewAnthropicProxy() *AnthropicProxy {
 	proxy.Director = CreateGenericDirector(anthropicProxy, targetURL, originalDirector)
 
 	// Customize the transport for optimal streaming performance
-	proxy.Transport = newProxyTransport()
+	proxy.Transport = newInstrumentedTransport("anthropic")
 
 	// Add custom response modifier for streaming support
 	proxy.ModifyResponse = func(resp *http.Response) error {
+		// Track upstream rate-limit headers so we can throttle before upstream rejects
+		RecordUpstreamLimits("anthropic", resp)
+		moveUpstreamRequestID(resp)
+
 		// Handle streaming responses
 		if anthropicProxy.isStreamingResponse(resp) {
-			log.Printf("Detected streaming response from Anthropic")
+			logging.FromContext(resp.Request.Context()).Debug("Detected streaming response", "provider", "anthropic")
 
 			// Ensure proper headers for streaming
 			resp.Header.Set("Cache-Control", "no-cache")
@@ -68,13 +73,13 @@ func NewAnthropicProxy() *AnthropicProxy {
 
 	// Add error handler with streaming-specific error handling
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("Anthropic proxy error: %v", err)
+		logger := logging.FromContext(r.Context())
+		logger.Error("Anthropic proxy error", "error", err)
 
 		// For streaming requests, we need to handle errors differently
 		if anthropicProxy.IsStreamingRequest(r) {
 			// If we're in a streaming context, we might have already started writing
 			// the response, so we need to handle this gracefully
-			log.Printf("Error occurred during streaming request")
 
 			// Try to write an error in SSE format if possible
 			if w.Header().Get("Content-Type") == "" {
@@ -85,7 +90,7 @@ func NewAnthropicProxy() *AnthropicProxy {
 				fmt.Fprintf(w, "data: [DONE]\n\n")
 			} else {
 				// Headers already sent, just log the error
-				log.Printf("Cannot send error response, headers already sent")
+				logger.Warn("Cannot send error response, headers already sent")
 			}
 		} else {
 			// Regular error handling for non-streaming requests
@@ -137,20 +142,20 @@ func (a *AnthropicProxy) checkStreamingInBody(req *http.Request) bool {
 		// Body was already cached, use GetBody to get a fresh reader
 		bodyReader, err := req.GetBody()
 		if err != nil {
-			log.Printf("Error getting cached request body for streaming check: %v", err)
+			logging.FromContext(req.Context()).Warn("Error getting cached request body for streaming check", "error", err)
 			return false
 		}
 		defer bodyReader.Close()
 		bodyBytes, err = io.ReadAll(bodyReader)
 		if err != nil {
-			log.Printf("Error reading cached request body for streaming check: %v", err)
+			logging.FromContext(req.Context()).Warn("Error reading cached request body for streaming check", "error", err)
 			return false
 		}
 	} else {
 		// Read the body for the first time
 		bodyBytes, err = io.ReadAll(req.Body)
 		if err != nil {
-			log.Printf("Error reading request body for streaming check: %v", err)
+			logging.FromContext(req.Context()).Warn("Error reading request body for streaming check", "error", err)
 			return false
 		}
 
@@ -164,7 +169,7 @@ func (a *AnthropicProxy) checkStreamingInBody(req *http.Request) bool {
 	// Parse the JSON to check for stream field
 	var requestData map[string]interface{}
 	if err := json.Unmarshal(bodyBytes, &requestData); err != nil {
-		log.Printf("Error parsing request body JSON for streaming check: %v", err)
+		logging.FromContext(req.Context()).Debug("Error parsing request body JSON for streaming check", "error", err)
 		return false
 	}
 
@@ -192,11 +197,12 @@ func (a *AnthropicProxy) Proxy() http.Handler {
 // GetHealthStatus returns the health status of the Anthropic proxy
 func (a *AnthropicProxy) GetHealthStatus() map[string]interface{} {
 	return map[string]interface{}{
-		"provider":          "anthropic",
-		"status":            "healthy",
-		"baseURL":           anthropicBaseURL,
-		"streaming_support": true,
-		"body_parsing":      true,
+		"provider":             "anthropic",
+		"status":               "healthy",
+		"baseURL":              anthropicBaseURL,
+		"streaming_support":    true,
+		"body_parsing":         true,
+		"upstream_rate_limits": UpstreamLimits().Snapshot("anthropic"),
 	}
 }
 
@@ -281,7 +287,7 @@ func (a *AnthropicProxy) parseNonStreamingResponse(responseBody io.Reader) (*LLM
 	}
 
 	// Log the response body preview for debugging
-	log.Printf("🔍 Debug: Response body preview: %s", string(bodyBytes[:min(100, len(bodyBytes))]))
+	slog.Debug("Anthropic response body preview", "preview", string(bodyBytes[:min(100, len(bodyBytes))]))
 
 	var response AnthropicResponse
 	if err := json.Unmarshal(bodyBytes, &response); err != nil {
@@ -347,7 +353,7 @@ func (a *AnthropicProxy) parseStreamingResponse(responseBody io.Reader) (*LLMRes
 		var streamResponse AnthropicStreamResponse
 		if err := json.Unmarshal([]byte(jsonData), &streamResponse); err != nil {
 			// Log error but continue processing other chunks
-			log.Printf("Warning: failed to parse Anthropic streaming chunk: %v", err)
+			slog.Debug("Failed to parse Anthropic streaming chunk", "error", err)
 			continue
 		}
 
@@ -364,8 +370,6 @@ func (a *AnthropicProxy) parseStreamingResponse(responseBody io.Reader) (*LLMRes
 				if streamResponse.Message.Usage.OutputTokens > 0 {
 					outputTokens = streamResponse.Message.Usage.OutputTokens
 				}
-				log.Printf("🔍 Anthropic: message_start - Input: %d, Output: %d",
-					inputTokens, outputTokens)
 			}
 		case "message_delta":
 			if streamResponse.Delta != nil && streamResponse.Delta.StopReason != "" {
@@ -376,8 +380,6 @@ func (a *AnthropicProxy) parseStreamingResponse(responseBody io.Reader) (*LLMRes
 				if streamResponse.Usage.OutputTokens > 0 {
 					// For delta events, add the additional output tokens
 					outputTokens += streamResponse.Usage.OutputTokens
-					log.Printf("🔍 Anthropic: message_delta - Added %d output tokens, total output: %d",
-						streamResponse.Usage.OutputTokens, outputTokens)
 				}
 			}
 		case "message_stop":
@@ -393,8 +395,7 @@ func (a *AnthropicProxy) parseStreamingResponse(responseBody io.Reader) (*LLMRes
 					IsStreaming:  true,
 					FinishReason: finishReason,
 				}
-				log.Printf("🔍 Anthropic: message_stop - Final tokens - Input: %d, Output: %d, Total: %d",
-					inputTokens, outputTokens, inputTokens+outputTokens)
+				slog.Debug("Found Anthropic streaming usage", "input_tokens", inputTokens, "output_tokens", outputTokens)
 			}
 		}
 	}
@@ -410,8 +411,7 @@ func (a *AnthropicProxy) parseStreamingResponse(responseBody io.Reader) (*LLMRes
 
 	// If we have accumulated token counts even without message_stop, create metadata
 	if hasData && (inputTokens > 0 || outputTokens > 0) && (model != "" || requestID != "") {
-		log.Printf("🔍 Anthropic: Creating metadata from accumulated usage - Input: %d, Output: %d",
-			inputTokens, outputTokens)
+		slog.Debug("Using accumulated Anthropic streaming usage", "input_tokens", inputTokens, "output_tokens", outputTokens)
 		return &LLMResponseMetadata{
 			Model:        model,
 			InputTokens:  inputTokens,
@@ -442,6 +442,146 @@ func (a *AnthropicProxy) parseStreamingResponse(responseBody io.Reader) (*LLMRes
 	return nil, fmt.Errorf("no usage information found in streaming response")
 }
 
+// ExtractResponseText reassembles the text blocks of an Anthropic response
+func (a *AnthropicProxy) ExtractResponseText(responseBody io.Reader, isStreaming bool) string {
+	var text strings.Builder
+	if isStreaming {
+		for _, data := range sseEvents(responseBody) {
+			var chunk AnthropicStreamResponse
+			if json.Unmarshal([]byte(data), &chunk) != nil {
+				continue
+			}
+			if chunk.Type == "content_block_delta" && chunk.Delta != nil {
+				text.WriteString(chunk.Delta.Text)
+			}
+		}
+		return text.String()
+	}
+
+	reader, err := DecompressResponseIfNeeded(responseBody)
+	if err != nil {
+		return ""
+	}
+	var response AnthropicResponse
+	if json.NewDecoder(reader).Decode(&response) != nil {
+		return ""
+	}
+	for _, c := range response.Content {
+		if c.Type == "text" {
+			text.WriteString(c.Text)
+		}
+	}
+	return text.String()
+}
+
+// ReplayableText returns the text of a message made only of text blocks,
+// without tool use or thinking
+func (a *AnthropicProxy) ReplayableText(responseBody io.Reader, isStreaming bool) (string, bool) {
+	var text strings.Builder
+	if isStreaming {
+		started := false
+		for _, data := range sseEvents(responseBody) {
+			var chunk AnthropicStreamResponse
+			if json.Unmarshal([]byte(data), &chunk) != nil {
+				return "", false
+			}
+			switch chunk.Type {
+			case "message_start":
+				started = true
+			case "content_block_start":
+				if chunk.ContentBlock == nil || chunk.ContentBlock.Type != "text" {
+					return "", false
+				}
+			case "content_block_delta":
+				if chunk.Delta == nil || chunk.Delta.Type != "text_delta" {
+					return "", false
+				}
+				text.WriteString(chunk.Delta.Text)
+			case "error":
+				return "", false
+			}
+		}
+		return text.String(), started
+	}
+
+	reader, err := DecompressResponseIfNeeded(responseBody)
+	if err != nil {
+		return "", false
+	}
+	var response AnthropicResponse
+	if json.NewDecoder(reader).Decode(&response) != nil || response.Type != "message" {
+		return "", false
+	}
+	for _, c := range response.Content {
+		if c.Type != "text" {
+			return "", false
+		}
+		text.WriteString(c.Text)
+	}
+	return text.String(), true
+}
+
+// CanReplayStream reports whether req is a streaming Messages API request
+func (a *AnthropicProxy) CanReplayStream(req *http.Request) bool {
+	return strings.HasSuffix(req.URL.Path, "/messages")
+}
+
+// WriteStream writes c as Messages API events, from message_start to
+// message_stop, with the text in a single content block
+func (a *AnthropicProxy) WriteStream(w io.Writer, c *Completion) error {
+	stopReason := c.FinishReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	type event struct {
+		name    string
+		payload map[string]any
+	}
+	events := []event{
+		{"message_start", map[string]any{
+			"type": "message_start",
+			"message": map[string]any{
+				"id":            c.ID,
+				"type":          "message",
+				"role":          "assistant",
+				"content":       []any{},
+				"model":         c.Model,
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage":         map[string]int{"input_tokens": c.InputTokens, "output_tokens": 0},
+			},
+		}},
+		{"content_block_start", map[string]any{
+			"type":          "content_block_start",
+			"index":         0,
+			"content_block": map[string]any{"type": "text", "text": ""},
+		}},
+	}
+	for _, piece := range replayChunks(c.Text) {
+		events = append(events, event{"content_block_delta", map[string]any{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": map[string]any{"type": "text_delta", "text": piece},
+		}})
+	}
+	events = append(events,
+		event{"content_block_stop", map[string]any{"type": "content_block_stop", "index": 0}},
+		event{"message_delta", map[string]any{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": stopReason, "stop_sequence": nil},
+			"usage": map[string]int{"output_tokens": c.OutputTokens},
+		}},
+		event{"message_stop", map[string]any{"type": "message_stop"}},
+	)
+
+	for _, e := range events {
+		if err := writeSSE(w, e.name, e.payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // UserIDFromRequest extracts user ID from Anthropic request body
 // Anthropic supports passing user ID in the "metadata.user_id" field
 func (a *AnthropicProxy) UserIDFromRequest(req *http.Request) string {
@@ -457,7 +597,7 @@ func (a *AnthropicProxy) UserIDFromRequest(req *http.Request) string {
 	// Read request body
 	bodyBytes, err := a.readRequestBodyForUserID(req)
 	if err != nil {
-		log.Printf("Error reading Anthropic request body for user ID extraction: %v", err)
+		logging.FromContext(req.Context()).Warn("Error reading Anthropic request body for user ID extraction", "error", err)
 		return ""
 	}
 
@@ -468,14 +608,14 @@ func (a *AnthropicProxy) UserIDFromRequest(req *http.Request) string {
 	// Parse JSON to extract metadata.user_id field
 	var data map[string]interface{}
 	if err := json.Unmarshal(bodyBytes, &data); err != nil {
-		log.Printf("Error parsing Anthropic request JSON for user ID extraction: %v", err)
+		logging.FromContext(req.Context()).Debug("Error parsing Anthropic request JSON for user ID extraction", "error", err)
 		return ""
 	}
 
 	// Extract user ID from the "metadata.user_id" field
 	if metadata, ok := data["metadata"].(map[string]interface{}); ok {
 		if userValue, ok := metadata["user_id"].(string); ok && userValue != "" {
-			log.Printf("🔍 Anthropic: Extracted user ID: %s", userValue)
+			logging.FromContext(req.Context()).Debug("Extracted user ID from request body", "provider", "anthropic", "user_id", userValue)
 			return userValue
 		}
 	}
@@ -576,20 +716,15 @@ func (a *AnthropicProxy) ValidateAPIKey(req *http.Request, keyStore APIKeyStore)
 	}
 
 	// Validate and potentially replace the key
-	actualKey, provider, err := keyStore.ValidateAndGetActualKey(context.Background(), apiKey)
+	actualKey, err := keyStore.ValidateAndGetProviderKey(context.Background(), apiKey, "anthropic")
 	if err != nil {
 		return fmt.Errorf("API key validation failed: %w", err)
 	}
 
-	// If a provider was returned, verify it matches
-	if provider != "" && provider != "anthropic" {
-		return fmt.Errorf("API key is for provider %s, not anthropic", provider)
-	}
-
 	// Replace the key in the request header if it was translated
 	if actualKey != apiKey {
 		req.Header.Set("x-api-key", actualKey)
-		log.Printf("🔑 Anthropic: Translated API key from iw: format")
+		logging.FromContext(req.Context()).Debug("🔑 Translated API key from iw: format", "provider", "anthropic")
 	}
 
 	return nil
@@ -286,7 +286,7 @@ func TestAnthropic_TokenRateLimit_ByKeyAndUser(t *testing.T) {
 	scope := ratelimit.ScopeKeys{Provider: "anthropic", Model: "claude-test", APIKey: "devkey", UserID: "example-user"}
 
 	// First reservation: 10 tokens -> allowed
-	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 10, now)
+	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 10, 0, now)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -295,7 +295,7 @@ func TestAnthropic_TokenRateLimit_ByKeyAndUser(t *testing.T) {
 	}
 
 	// Second reservation: +15 tokens -> should be denied (total 25 > 20)
-	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, now)
+	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, 0, now)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -323,7 +323,7 @@ func TestAnthropic_TokenRateLimit_UserLimited_KeyOK(t *testing.T) {
 	now := time.Now()
 	scope := ratelimit.ScopeKeys{Provider: "anthropic", Model: "claude-test", APIKey: "devkey", UserID: "example-user"}
 
-	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, now)
+	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, 0, now)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -332,7 +332,7 @@ func TestAnthropic_TokenRateLimit_UserLimited_KeyOK(t *testing.T) {
 	}
 
 	// total would be 30 (>20 user limit), key still under 100
-	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, now)
+	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, 0, now)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -360,7 +360,7 @@ func TestAnthropic_TokenRateLimit_KeyLimited_UserOK(t *testing.T) {
 	now := time.Now()
 	scope := ratelimit.ScopeKeys{Provider: "anthropic", Model: "claude-test", APIKey: "devkey", UserID: "example-user"}
 
-	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, now)
+	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, 0, now)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -369,7 +369,7 @@ func TestAnthropic_TokenRateLimit_KeyLimited_UserOK(t *testing.T) {
 	}
 
 	// total would be 30 (>20 key limit), user still under 100
-	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, now)
+	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, 0, now)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -13,12 +13,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
 
+	"github.com/Instawork/llm-proxy/internal/logging"
 	"github.com/gorilla/mux"
 )
 
@@ -37,7 +38,7 @@ func NewGeminiProxy() *GeminiProxy {
 	// Parse the Gemini API URL
 	targetURL, err := url.Parse(geminiBaseURL)
 	if err != nil {
-		log.Fatalf("Failed to parse Gemini API URL: %v", err)
+		panic(fmt.Sprintf("failed to parse Gemini API URL: %v", err))
 	}
 
 	// Create the reverse proxy
@@ -51,13 +52,15 @@ func NewGeminiProxy() *GeminiProxy {
 	proxy.Director = CreateGenericDirector(geminiProxy, targetURL, originalDirector)
 
 	// Customize the transport for optimal streaming performance
-	proxy.Transport = newProxyTransport()
+	proxy.Transport = newInstrumentedTransport("gemini")
 
 	// Add custom response modifier for streaming support
 	proxy.ModifyResponse = func(resp *http.Response) error {
+		moveUpstreamRequestID(resp)
+
 		// Handle streaming responses
 		if geminiProxy.isStreamingResponse(resp) {
-			log.Printf("Detected streaming response from Gemini")
+			logging.FromContext(resp.Request.Context()).Debug("Detected streaming response", "provider", "gemini")
 
 			// Ensure proper headers for streaming
 			resp.Header.Set("Cache-Control", "no-cache")
@@ -73,13 +76,13 @@ func NewGeminiProxy() *GeminiProxy {
 
 	// Add error handler with streaming-specific error handling
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("Gemini proxy error: %v", err)
+		logger := logging.FromContext(r.Context())
+		logger.Error("Gemini proxy error", "error", err)
 
 		// For streaming requests, we need to handle errors differently
 		if geminiProxy.IsStreamingRequest(r) {
 			// If we're in a streaming context, we might have already started writing
 			// the response, so we need to handle this gracefully
-			log.Printf("Error occurred during streaming request")
 
 			// Try to write an error in SSE format if possible
 			if w.Header().Get("Content-Type") == "" {
@@ -90,7 +93,7 @@ func NewGeminiProxy() *GeminiProxy {
 				fmt.Fprintf(w, "data: [DONE]\n\n")
 			} else {
 				// Headers already sent, just log the error
-				log.Printf("Cannot send error response, headers already sent")
+				logger.Warn("Cannot send error response, headers already sent")
 			}
 		} else {
 			// Regular error handling for non-streaming requests
@@ -302,7 +305,7 @@ func (g *GeminiProxy) parseStreamingResponse(responseBody io.Reader) (*LLMRespon
 		var streamResponse GeminiStreamResponse
 		if err := json.Unmarshal([]byte(jsonData), &streamResponse); err != nil {
 			// Log error but continue processing other chunks
-			log.Printf("Warning: failed to parse Gemini streaming chunk: %v", err)
+			slog.Debug("Failed to parse Gemini streaming chunk", "error", err)
 			continue
 		}
 
@@ -365,6 +368,160 @@ func (g *GeminiProxy) parseStreamingResponse(responseBody io.Reader) (*LLMRespon
 	return nil, fmt.Errorf("no usage information found in streaming response")
 }
 
+// ExtractResponseText reassembles the text parts of the first candidate of a
+// Gemini response. Streams may be server-sent events (alt=sse) or a JSON
+// array of responses.
+func (g *GeminiProxy) ExtractResponseText(responseBody io.Reader, isStreaming bool) string {
+	reader, err := DecompressResponseIfNeeded(responseBody)
+	if err != nil {
+		return ""
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return ""
+	}
+
+	var responses []GeminiResponse
+	switch trimmed := bytes.TrimSpace(body); {
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		_ = json.Unmarshal(trimmed, &responses)
+	case isStreaming:
+		for _, data := range sseEvents(bytes.NewReader(body)) {
+			var chunk GeminiResponse
+			if json.Unmarshal([]byte(data), &chunk) == nil {
+				responses = append(responses, chunk)
+			}
+		}
+	default:
+		var response GeminiResponse
+		if json.Unmarshal(trimmed, &response) == nil {
+			responses = append(responses, response)
+		}
+	}
+
+	var text strings.Builder
+	for _, response := range responses {
+		if len(response.Candidates) == 0 {
+			continue
+		}
+		for _, part := range response.Candidates[0].Content.Parts {
+			text.WriteString(part.Text)
+		}
+	}
+	return text.String()
+}
+
+// geminiReplayResponse is the part of a Gemini response, or of one of its
+// stream chunks, that decides whether it can be replayed. Parts are kept
+// as raw fields so function calls, thoughts and inline data are noticed.
+type geminiReplayResponse struct {
+	Candidates []struct {
+		Content struct {
+			Parts []map[string]json.RawMessage `json:"parts"`
+		} `json:"content"`
+	} `json:"candidates"`
+}
+
+// ReplayableText returns the text of a single-candidate response made only
+// of text parts
+func (g *GeminiProxy) ReplayableText(responseBody io.Reader, isStreaming bool) (string, bool) {
+	reader, err := DecompressResponseIfNeeded(responseBody)
+	if err != nil {
+		return "", false
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return "", false
+	}
+
+	var responses []geminiReplayResponse
+	switch trimmed := bytes.TrimSpace(body); {
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		if json.Unmarshal(trimmed, &responses) != nil {
+			return "", false
+		}
+	case isStreaming:
+		for _, data := range sseEvents(bytes.NewReader(body)) {
+			var chunk geminiReplayResponse
+			if json.Unmarshal([]byte(data), &chunk) != nil {
+				return "", false
+			}
+			responses = append(responses, chunk)
+		}
+	default:
+		var response geminiReplayResponse
+		if json.Unmarshal(trimmed, &response) != nil {
+			return "", false
+		}
+		responses = append(responses, response)
+	}
+
+	var text strings.Builder
+	sawCandidate := false
+	for _, response := range responses {
+		if len(response.Candidates) > 1 {
+			return "", false
+		}
+		for _, candidate := range response.Candidates {
+			for _, part := range candidate.Content.Parts {
+				raw, ok := part["text"]
+				if !ok || len(part) != 1 {
+					return "", false
+				}
+				var t string
+				if json.Unmarshal(raw, &t) != nil {
+					return "", false
+				}
+				text.WriteString(t)
+			}
+			sawCandidate = true
+		}
+	}
+	return text.String(), sawCandidate
+}
+
+// CanReplayStream reports whether req asks for server-sent events
+// (alt=sse); without it Gemini streams a JSON array instead
+func (g *GeminiProxy) CanReplayStream(req *http.Request) bool {
+	return req.URL.Query().Get("alt") == "sse"
+}
+
+// WriteStream writes c as alt=sse chunks; the last one carries the finish
+// reason and usage
+func (g *GeminiProxy) WriteStream(w io.Writer, c *Completion) error {
+	pieces := replayChunks(c.Text)
+	if len(pieces) == 0 {
+		pieces = []string{""}
+	}
+	finishReason := c.FinishReason
+	if finishReason == "" {
+		finishReason = "STOP"
+	}
+	for i, piece := range pieces {
+		candidate := map[string]any{
+			"content": map[string]any{"parts": []map[string]string{{"text": piece}}, "role": "model"},
+			"index":   0,
+		}
+		chunk := map[string]any{
+			"candidates":   []map[string]any{candidate},
+			"modelVersion": c.Model,
+			"responseId":   c.ID,
+		}
+		if i == len(pieces)-1 {
+			candidate["finishReason"] = finishReason
+			chunk["usageMetadata"] = map[string]int{
+				"promptTokenCount":     c.InputTokens,
+				"candidatesTokenCount": c.OutputTokens,
+				"totalTokenCount":      c.TotalTokens,
+			}
+		}
+		if err := writeSSE(w, "", chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // UserIDFromRequest extracts user ID from Gemini request body
 // For Gemini, we only support passing user ID down, not extracting it
 func (g *GeminiProxy) UserIDFromRequest(req *http.Request) string {
@@ -402,16 +559,11 @@ func (g *GeminiProxy) ValidateAPIKey(req *http.Request, keyStore APIKeyStore) er
 	}
 
 	// Validate and potentially replace the key
-	actualKey, provider, err := keyStore.ValidateAndGetActualKey(context.Background(), apiKey)
+	actualKey, err := keyStore.ValidateAndGetProviderKey(context.Background(), apiKey, "gemini")
 	if err != nil {
 		return fmt.Errorf("API key validation failed: %w", err)
 	}
 
-	// If a provider was returned, verify it matches
-	if provider != "" && provider != "gemini" {
-		return fmt.Errorf("API key is for provider %s, not gemini", provider)
-	}
-
 	// Replace the key in the appropriate location if it was translated
 	if actualKey != apiKey {
 		if apiKeyFromQuery != "" {
@@ -422,7 +574,7 @@ func (g *GeminiProxy) ValidateAPIKey(req *http.Request, keyStore APIKeyStore) er
 			// Replace in header
 			req.Header.Set("x-goog-api-key", actualKey)
 		}
-		log.Printf("🔑 Gemini: Translated API key from iw: format")
+		logging.FromContext(req.Context()).Debug("🔑 Translated API key from iw: format", "provider", "gemini")
 	}
 
 	return nil
@@ -174,7 +174,7 @@ func TestGemini_TokenRateLimit_ByKeyAndUser(t *testing.T) {
 	now := time.Now()
 	scope := ratelimit.ScopeKeys{Provider: "gemini", Model: "gemini-2.5-flash-preview-05-20", APIKey: "devkey", UserID: "example-user"}
 
-	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 20, now)
+	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 20, 0, now)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -182,7 +182,7 @@ func TestGemini_TokenRateLimit_ByKeyAndUser(t *testing.T) {
 		t.Fatalf("expected first reservation allowed, got denied: %+v", res1)
 	}
 
-	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, now)
+	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, 0, now)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -210,7 +210,7 @@ func TestGemini_TokenRateLimit_UserLimited_KeyOK(t *testing.T) {
 	now := time.Now()
 	scope := ratelimit.ScopeKeys{Provider: "gemini", Model: "gemini-2.5-flash-preview-05-20", APIKey: "devkey", UserID: "example-user"}
 
-	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 20, now)
+	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 20, 0, now)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -219,7 +219,7 @@ func TestGemini_TokenRateLimit_UserLimited_KeyOK(t *testing.T) {
 	}
 
 	// total 35 (>30 user limit), key still under 100
-	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, now)
+	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, 0, now)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -247,7 +247,7 @@ func TestGemini_TokenRateLimit_KeyLimited_UserOK(t *testing.T) {
 	now := time.Now()
 	scope := ratelimit.ScopeKeys{Provider: "gemini", Model: "gemini-2.5-flash-preview-05-20", APIKey: "devkey", UserID: "example-user"}
 
-	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 20, now)
+	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 20, 0, now)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -256,7 +256,7 @@ func TestGemini_TokenRateLimit_KeyLimited_UserOK(t *testing.T) {
 	}
 
 	// total 35 (>30 key limit), user still under 100
-	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, now)
+	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 15, 0, now)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -5,12 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
 
+	"github.com/Instawork/llm-proxy/internal/logging"
 	"github.com/gorilla/mux"
 )
 
@@ -26,7 +26,7 @@ type GroqProxy struct {
 func NewGroqProxy() *GroqProxy {
 	targetURL, err := url.Parse(groqBaseURL)
 	if err != nil {
-		log.Fatalf("Failed to parse Groq API URL: %v", err)
+		panic(fmt.Sprintf("failed to parse Groq API URL: %v", err))
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
@@ -43,11 +43,15 @@ func NewGroqProxy() *GroqProxy {
 			req.URL.Path = "/openai" + req.URL.Path
 		}
 	}
-	proxy.Transport = newProxyTransport()
+	proxy.Transport = newInstrumentedTransport("groq")
 
 	proxy.ModifyResponse = func(resp *http.Response) error {
+		// Track upstream rate-limit headers so we can throttle before upstream rejects
+		RecordUpstreamLimits(groqProxy.GetName(), resp)
+		moveUpstreamRequestID(resp)
+
 		if groqProxy.isStreamingResponse(resp) {
-			log.Printf("Detected streaming response from Groq")
+			logging.FromContext(resp.Request.Context()).Debug("Detected streaming response", "provider", "groq")
 
 			resp.Header.Set("Cache-Control", "no-cache")
 			resp.Header.Set("Connection", "keep-alive")
@@ -59,7 +63,8 @@ func NewGroqProxy() *GroqProxy {
 	}
 
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("Groq proxy error: %v", err)
+		logger := logging.FromContext(r.Context())
+		logger.Error("Groq proxy error", "error", err)
 
 		if groqProxy.IsStreamingRequest(r) {
 			if w.Header().Get("Content-Type") == "" {
@@ -69,7 +74,7 @@ func NewGroqProxy() *GroqProxy {
 				fmt.Fprintf(w, "data: {\"error\": \"Proxy error: %v\"}\n\n", err)
 				fmt.Fprintf(w, "data: [DONE]\n\n")
 			} else {
-				log.Printf("Cannot send error response, headers already sent")
+				logger.Warn("Cannot send error response, headers already sent")
 			}
 		} else {
 			w.WriteHeader(http.StatusBadGateway)
@@ -113,6 +118,26 @@ func (g *GroqProxy) ParseResponseMetadata(responseBody io.Reader, isStreaming bo
 	return metadata, err
 }
 
+// ExtractResponseText reuses OpenAI text extraction
+func (g *GroqProxy) ExtractResponseText(responseBody io.Reader, isStreaming bool) string {
+	return g.parser.ExtractResponseText(responseBody, isStreaming)
+}
+
+// ReplayableText reuses OpenAI replay checks
+func (g *GroqProxy) ReplayableText(responseBody io.Reader, isStreaming bool) (string, bool) {
+	return g.parser.ReplayableText(responseBody, isStreaming)
+}
+
+// CanReplayStream reuses OpenAI replay checks
+func (g *GroqProxy) CanReplayStream(req *http.Request) bool {
+	return g.parser.CanReplayStream(req)
+}
+
+// WriteStream reuses OpenAI chunk synthesis
+func (g *GroqProxy) WriteStream(w io.Writer, c *Completion) error {
+	return g.parser.WriteStream(w, c)
+}
+
 // Proxy returns underlying reverse proxy
 func (g *GroqProxy) Proxy() http.Handler {
 	return g.proxy
@@ -121,11 +146,12 @@ func (g *GroqProxy) Proxy() http.Handler {
 // GetHealthStatus returns readiness info
 func (g *GroqProxy) GetHealthStatus() map[string]interface{} {
 	return map[string]interface{}{
-		"provider":          g.GetName(),
-		"status":            "healthy",
-		"baseURL":           groqBaseURL,
-		"streaming_support": true,
-		"body_parsing":      true,
+		"provider":             g.GetName(),
+		"status":               "healthy",
+		"baseURL":              groqBaseURL,
+		"streaming_support":    true,
+		"body_parsing":         true,
+		"upstream_rate_limits": UpstreamLimits().Snapshot(g.GetName()),
 	}
 }
 
@@ -141,7 +167,7 @@ func (g *GroqProxy) UserIDFromRequest(req *http.Request) string {
 
 	bodyBytes, err := g.parser.readRequestBodyForUserID(req)
 	if err != nil {
-		log.Printf("Error reading Groq request body for user ID extraction: %v", err)
+		logging.FromContext(req.Context()).Warn("Error reading Groq request body for user ID extraction", "error", err)
 		return ""
 	}
 
@@ -151,12 +177,12 @@ func (g *GroqProxy) UserIDFromRequest(req *http.Request) string {
 
 	var data map[string]interface{}
 	if err := json.Unmarshal(bodyBytes, &data); err != nil {
-		log.Printf("Error parsing Groq request JSON for user ID extraction: %v", err)
+		logging.FromContext(req.Context()).Debug("Error parsing Groq request JSON for user ID extraction", "error", err)
 		return ""
 	}
 
 	if userValue, ok := data["user"].(string); ok && userValue != "" {
-		log.Printf("🔍 Groq: Extracted user ID: %s", userValue)
+		logging.FromContext(req.Context()).Debug("Extracted user ID from request body", "provider", "groq", "user_id", userValue)
 		return userValue
 	}
 
@@ -180,18 +206,14 @@ func (g *GroqProxy) ValidateAPIKey(req *http.Request, keyStore APIKeyStore) erro
 
 	apiKey := strings.TrimPrefix(authHeader, bearerPrefix)
 
-	actualKey, provider, err := keyStore.ValidateAndGetActualKey(context.Background(), apiKey)
+	actualKey, err := keyStore.ValidateAndGetProviderKey(context.Background(), apiKey, g.GetName())
 	if err != nil {
 		return fmt.Errorf("API key validation failed: %w", err)
 	}
 
-	if provider != "" && provider != g.GetName() {
-		return fmt.Errorf("API key is for provider %s, not %s", provider, g.GetName())
-	}
-
 	if actualKey != apiKey {
 		req.Header.Set("Authorization", bearerPrefix+actualKey)
-		log.Printf("🔑 Groq: Translated API key from iw: format")
+		logging.FromContext(req.Context()).Debug("🔑 Translated API key from iw: format", "provider", "groq")
 	}
 
 	return nil
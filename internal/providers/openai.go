@@ -8,12 +8,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/Instawork/llm-proxy/internal/logging"
 	"github.com/gorilla/mux"
 )
 
@@ -32,7 +34,7 @@ func NewOpenAIProxy() *OpenAIProxy {
 	// Parse the OpenAI API URL
 	targetURL, err := url.Parse(openAIBaseURL)
 	if err != nil {
-		log.Fatalf("Failed to parse OpenAI API URL: %v", err)
+		panic(fmt.Sprintf("failed to parse OpenAI API URL: %v", err))
 	}
 
 	// Create the reverse proxy
@@ -46,13 +48,17 @@ func NewOpenAIProxy() *OpenAIProxy {
 	proxy.Director = CreateGenericDirector(openAIProxy, targetURL, originalDirector)
 
 	// Customize the transport for optimal streaming performance
-	proxy.Transport = newProxyTransport()
+	proxy.Transport = newInstrumentedTransport("openai")
 
 	// Add custom response modifier for streaming support
 	proxy.ModifyResponse = func(resp *http.Response) error {
+		// Track upstream rate-limit headers so we can throttle before upstream rejects
+		RecordUpstreamLimits("openai", resp)
+		moveUpstreamRequestID(resp)
+
 		// Handle streaming responses
 		if openAIProxy.isStreamingResponse(resp) {
-			log.Printf("Detected streaming response from OpenAI")
+			logging.FromContext(resp.Request.Context()).Debug("Detected streaming response", "provider", "openai")
 
 			// Ensure proper headers for streaming
 			resp.Header.Set("Cache-Control", "no-cache")
@@ -68,13 +74,13 @@ func NewOpenAIProxy() *OpenAIProxy {
 
 	// Add error handler with streaming-specific error handling
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("OpenAI proxy error: %v", err)
+		logger := logging.FromContext(r.Context())
+		logger.Error("OpenAI proxy error", "error", err)
 
 		// For streaming requests, we need to handle errors differently
 		if openAIProxy.IsStreamingRequest(r) {
 			// If we're in a streaming context, we might have already started writing
 			// the response, so we need to handle this gracefully
-			log.Printf("Error occurred during streaming request")
 
 			// Try to write an error in SSE format if possible
 			if w.Header().Get("Content-Type") == "" {
@@ -85,7 +91,7 @@ func NewOpenAIProxy() *OpenAIProxy {
 				fmt.Fprintf(w, "data: [DONE]\n\n")
 			} else {
 				// Headers already sent, just log the error
-				log.Printf("Cannot send error response, headers already sent")
+				logger.Warn("Cannot send error response, headers already sent")
 			}
 		} else {
 			// Regular error handling for non-streaming requests
@@ -138,20 +144,20 @@ func (o *OpenAIProxy) checkStreamingInBody(req *http.Request) bool {
 		// Body was already cached, use GetBody to get a fresh reader
 		bodyReader, err := req.GetBody()
 		if err != nil {
-			log.Printf("Error getting cached request body for streaming check: %v", err)
+			logging.FromContext(req.Context()).Warn("Error getting cached request body for streaming check", "error", err)
 			return false
 		}
 		defer bodyReader.Close()
 		bodyBytes, err = io.ReadAll(bodyReader)
 		if err != nil {
-			log.Printf("Error reading cached request body for streaming check: %v", err)
+			logging.FromContext(req.Context()).Warn("Error reading cached request body for streaming check", "error", err)
 			return false
 		}
 	} else {
 		// Read the body for the first time
 		bodyBytes, err = io.ReadAll(req.Body)
 		if err != nil {
-			log.Printf("Error reading request body for streaming check: %v", err)
+			logging.FromContext(req.Context()).Warn("Error reading request body for streaming check", "error", err)
 			return false
 		}
 
@@ -165,7 +171,7 @@ func (o *OpenAIProxy) checkStreamingInBody(req *http.Request) bool {
 	// Parse the JSON to check for stream field
 	var requestData map[string]interface{}
 	if err := json.Unmarshal(bodyBytes, &requestData); err != nil {
-		log.Printf("Error parsing request body JSON for streaming check: %v", err)
+		logging.FromContext(req.Context()).Debug("Error parsing request body JSON for streaming check", "error", err)
 		return false
 	}
 
@@ -193,11 +199,12 @@ func (o *OpenAIProxy) Proxy() http.Handler {
 // GetHealthStatus returns the health status of the OpenAI proxy
 func (o *OpenAIProxy) GetHealthStatus() map[string]interface{} {
 	return map[string]interface{}{
-		"provider":          "openai",
-		"status":            "healthy",
-		"baseURL":           openAIBaseURL,
-		"streaming_support": true,
-		"body_parsing":      true,
+		"provider":             "openai",
+		"status":               "healthy",
+		"baseURL":              openAIBaseURL,
+		"streaming_support":    true,
+		"body_parsing":         true,
+		"upstream_rate_limits": UpstreamLimits().Snapshot("openai"),
 	}
 }
 
@@ -360,7 +367,7 @@ func (o *OpenAIProxy) parseNonStreamingResponse(responseBody io.Reader) (*LLMRes
 	}
 
 	// Log the response body preview for debugging
-	log.Printf("🔍 Debug: OpenAI response body preview: %s", string(bodyBytes[:min(100, len(bodyBytes))]))
+	slog.Debug("OpenAI response body preview", "preview", string(bodyBytes[:min(100, len(bodyBytes))]))
 
 	var response OpenAIResponse
 	if err := json.Unmarshal(bodyBytes, &response); err != nil {
@@ -409,8 +416,6 @@ func (o *OpenAIProxy) parseUnifiedStreamingResponse(responseBody io.Reader) (*LL
 	var apiType string // "responses" or "completions"
 	var thoughtTokens int
 
-	log.Printf("🔄 OpenAI: Starting to parse unified streaming response")
-
 	for scanner.Scan() {
 		line := scanner.Text()
 
@@ -424,7 +429,6 @@ func (o *OpenAIProxy) parseUnifiedStreamingResponse(responseBody io.Reader) (*LL
 
 		// Skip [DONE] marker
 		if strings.TrimSpace(jsonData) == "[DONE]" {
-			log.Printf("🔄 OpenAI: Found [DONE] marker, ending stream parse")
 			break
 		}
 
@@ -448,7 +452,7 @@ func (o *OpenAIProxy) parseUnifiedStreamingResponse(responseBody io.Reader) (*LL
 		return nil, fmt.Errorf("error reading streaming response: %w", err)
 	}
 
-	log.Printf("🔄 OpenAI: Processed %d chunks, hasData: %v, hasUsage: %v, API Type: %s", chunkCount, hasData, metadata != nil, apiType)
+	slog.Debug("Parsed OpenAI streaming response", "chunks", chunkCount, "has_data", hasData, "has_usage", metadata != nil, "api_type", apiType)
 
 	// If we have usage metadata, return it
 	if metadata != nil {
@@ -458,7 +462,6 @@ func (o *OpenAIProxy) parseUnifiedStreamingResponse(responseBody io.Reader) (*LL
 	// If we found streaming data but no usage information, return partial metadata
 	// This can happen when the stream is not yet complete or usage info is in a later chunk
 	if hasData && (model != "" || requestID != "") {
-		log.Printf("🔄 OpenAI: Returning partial metadata - usage data not yet available")
 		return &LLMResponseMetadata{
 			Model:         model,
 			InputTokens:   0, // Unknown at this point
@@ -483,13 +486,10 @@ func (o *OpenAIProxy) detectAPIType(jsonData string) string {
 		// or has "output" field in the event
 		if typeField, hasType := checkData["type"].(string); hasType &&
 			strings.HasPrefix(typeField, "response.") {
-			log.Printf("🔄 OpenAI: Detected Responses API based on type field: %s", typeField)
 			return "responses"
 		} else if _, hasOutput := checkData["output"]; hasOutput {
-			log.Printf("🔄 OpenAI: Detected Responses API based on output field")
 			return "responses"
 		} else if _, hasChoices := checkData["choices"]; hasChoices {
-			log.Printf("🔄 OpenAI: Detected Chat Completions API based on choices field")
 			return "completions"
 		}
 	}
@@ -502,7 +502,7 @@ func (o *OpenAIProxy) parseResponsesStreamingChunk(jsonData string, model, reque
 	var chunkData map[string]interface{}
 	if err := json.Unmarshal([]byte(jsonData), &chunkData); err != nil {
 		// Log error but continue processing other chunks
-		log.Printf("Warning: failed to parse Responses API streaming chunk: %v", err)
+		slog.Debug("Failed to parse OpenAI Responses API streaming chunk", "error", err)
 		return nil, model, requestID, finishReason, thoughtTokens
 	}
 
@@ -512,9 +512,6 @@ func (o *OpenAIProxy) parseResponsesStreamingChunk(jsonData string, model, reque
 		// Handle different types of Responses API streaming chunks
 		if typeField == "response.created" || typeField == "response.done" {
 			// These events may contain usage information
-			log.Printf("🔄 OpenAI Responses API: Found %s event", typeField)
-			log.Printf("🔄 OpenAI Responses API: Full chunk data: %+v", chunkData)
-
 			// Try to extract usage information from either response or event field
 			var dataField map[string]interface{}
 			var fieldName string
@@ -523,12 +520,10 @@ func (o *OpenAIProxy) parseResponsesStreamingChunk(jsonData string, model, reque
 			if responseField, hasResponse := chunkData["response"].(map[string]interface{}); hasResponse {
 				dataField = responseField
 				fieldName = "response"
-				log.Printf("🔄 OpenAI Responses API: Response field found: %+v", responseField)
 			} else if eventField, hasEvent := chunkData["event"].(map[string]interface{}); hasEvent {
 				// Fallback to event field (test data format)
 				dataField = eventField
 				fieldName = "event"
-				log.Printf("🔄 OpenAI Responses API: Event field found: %+v", eventField)
 			}
 
 			if dataField != nil {
@@ -536,13 +531,11 @@ func (o *OpenAIProxy) parseResponsesStreamingChunk(jsonData string, model, reque
 				if model == "" {
 					if modelVal, ok := dataField["model"].(string); ok {
 						model = modelVal
-						log.Printf("🔄 OpenAI Responses API: Captured model: %s", model)
 					}
 				}
 				if requestID == "" {
 					if idVal, ok := dataField["id"].(string); ok {
 						requestID = idVal
-						log.Printf("🔄 OpenAI Responses API: Captured request ID: %s", requestID)
 					}
 				}
 
@@ -569,8 +562,8 @@ func (o *OpenAIProxy) parseResponsesStreamingChunk(jsonData string, model, reque
 					}
 
 					if inputTokens > 0 || outputTokens > 0 || totalTokens > 0 {
-						log.Printf("🔄 OpenAI Responses API: Found usage data in %s (%s field)! Input: %d, Output: %d, Total: %d, Reasoning: %d",
-							typeField, fieldName, inputTokens, outputTokens, totalTokens, reasoningTokens)
+						slog.Debug("Found OpenAI Responses API usage", "event", typeField, "field", fieldName,
+							"input_tokens", inputTokens, "output_tokens", outputTokens, "total_tokens", totalTokens, "reasoning_tokens", reasoningTokens)
 
 						// Extract finish reason from output if available
 						if outputField, hasOutput := dataField["output"].([]interface{}); hasOutput {
@@ -578,7 +571,6 @@ func (o *OpenAIProxy) parseResponsesStreamingChunk(jsonData string, model, reque
 								if outputMap, ok := output.(map[string]interface{}); ok {
 									if status, ok := outputMap["status"].(string); ok && status != "" && status != "in_progress" {
 										finishReason = status
-										log.Printf("🔄 OpenAI Responses API: Captured finish reason: %s", finishReason)
 										break
 									}
 								}
@@ -618,18 +610,15 @@ func (o *OpenAIProxy) parseResponsesStreamingChunk(jsonData string, model, reque
 	// Capture model and request ID from any chunk
 	if model == "" && event.Model != "" {
 		model = event.Model
-		log.Printf("🔄 OpenAI Responses API: Captured model: %s", model)
 	}
 	if requestID == "" && event.ID != "" {
 		requestID = event.ID
-		log.Printf("🔄 OpenAI Responses API: Captured request ID: %s", requestID)
 	}
 
 	// Extract finish reason from output
 	for _, output := range event.Output {
 		if output.Status != "" && output.Status != "in_progress" {
 			finishReason = output.Status
-			log.Printf("🔄 OpenAI Responses API: Captured finish reason: %s", finishReason)
 		}
 	}
 
@@ -642,8 +631,8 @@ func (o *OpenAIProxy) parseResponsesStreamingChunk(jsonData string, model, reque
 			reasoningTokens = event.Usage.OutputTokensDetails.ReasoningTokens
 		}
 
-		log.Printf("🔄 OpenAI Responses API: Found usage data! Input: %d, Output: %d, Total: %d, Reasoning: %d",
-			event.Usage.InputTokens, event.Usage.OutputTokens, event.Usage.TotalTokens, reasoningTokens)
+		slog.Debug("Found OpenAI Responses API usage", "input_tokens", event.Usage.InputTokens,
+			"output_tokens", event.Usage.OutputTokens, "total_tokens", event.Usage.TotalTokens, "reasoning_tokens", reasoningTokens)
 
 		metadata = &LLMResponseMetadata{
 			Model:         model,
@@ -667,32 +656,29 @@ func (o *OpenAIProxy) parseCompletionsStreamingChunk(jsonData string, model, req
 	var streamResponse OpenAIStreamResponse
 	if err := json.Unmarshal([]byte(jsonData), &streamResponse); err != nil {
 		// Log error but continue processing other chunks
-		log.Printf("Warning: failed to parse streaming chunk: %v", err)
+		slog.Debug("Failed to parse OpenAI streaming chunk", "error", err)
 		return nil, model, requestID, finishReason
 	}
 
 	// Capture model and request ID from any chunk
 	if model == "" && streamResponse.Model != "" {
 		model = streamResponse.Model
-		log.Printf("🔄 OpenAI: Captured model: %s", model)
 	}
 	if requestID == "" && streamResponse.ID != "" {
 		requestID = streamResponse.ID
-		log.Printf("🔄 OpenAI: Captured request ID: %s", requestID)
 	}
 
 	// Extract finish reason from choices
 	if len(streamResponse.Choices) > 0 && streamResponse.Choices[0].FinishReason != "" {
 		finishReason = streamResponse.Choices[0].FinishReason
-		log.Printf("🔄 OpenAI: Captured finish reason: %s", finishReason)
 	}
 
 	var metadata *LLMResponseMetadata
 
 	// The usage information is typically in the last chunk
 	if streamResponse.Usage != nil {
-		log.Printf("🔄 OpenAI: Found usage data! Input: %d, Output: %d, Total: %d",
-			streamResponse.Usage.PromptTokens, streamResponse.Usage.CompletionTokens, streamResponse.Usage.TotalTokens)
+		slog.Debug("Found OpenAI streaming usage", "input_tokens", streamResponse.Usage.PromptTokens,
+			"output_tokens", streamResponse.Usage.CompletionTokens, "total_tokens", streamResponse.Usage.TotalTokens)
 		metadata = &LLMResponseMetadata{
 			Model:        model,
 			InputTokens:  streamResponse.Usage.PromptTokens,
@@ -727,7 +713,7 @@ func (o *OpenAIProxy) parseResponsesNonStreamingResponse(responseBody io.Reader)
 	}
 
 	// Log the response body preview for debugging
-	log.Printf("🔍 Debug: OpenAI Responses API response body preview: %s", string(bodyBytes[:min(100, len(bodyBytes))]))
+	slog.Debug("OpenAI Responses API response body preview", "preview", string(bodyBytes[:min(100, len(bodyBytes))]))
 
 	var response OpenAIResponsesAPIResponse
 	if err := json.Unmarshal(bodyBytes, &response); err != nil {
@@ -763,6 +749,190 @@ func (o *OpenAIProxy) parseResponsesNonStreamingResponse(responseBody io.Reader)
 	return metadata, nil
 }
 
+// openAITextChunk holds the fields that carry generated text across the Chat
+// Completions, legacy Completions and Responses APIs
+type openAITextChunk struct {
+	Type    string `json:"type"`  // Responses API stream event type
+	Delta   string `json:"delta"` // Responses API text delta
+	Choices []struct {
+		Index   int           `json:"index"`
+		Text    string        `json:"text"`
+		Message OpenAIMessage `json:"message"`
+		Delta   OpenAIMessage `json:"delta"`
+	} `json:"choices"`
+	Output []OpenAIResponseOutput `json:"output"`
+}
+
+// ExtractResponseText reassembles the generated text of an OpenAI response.
+// With several choices, their texts are separated by blank lines.
+func (o *OpenAIProxy) ExtractResponseText(responseBody io.Reader, isStreaming bool) string {
+	var chunks []openAITextChunk
+	if isStreaming {
+		for _, data := range sseEvents(responseBody) {
+			var chunk openAITextChunk
+			if json.Unmarshal([]byte(data), &chunk) == nil {
+				chunks = append(chunks, chunk)
+			}
+		}
+	} else {
+		reader, err := DecompressResponseIfNeeded(responseBody)
+		if err != nil {
+			return ""
+		}
+		var chunk openAITextChunk
+		if json.NewDecoder(reader).Decode(&chunk) != nil {
+			return ""
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	var choices []strings.Builder
+	appendText := func(index int, text string) {
+		for len(choices) <= index {
+			choices = append(choices, strings.Builder{})
+		}
+		choices[index].WriteString(text)
+	}
+	for _, chunk := range chunks {
+		if chunk.Type == "response.output_text.delta" {
+			appendText(0, chunk.Delta)
+		}
+		for _, out := range chunk.Output {
+			for _, c := range out.Content {
+				if c.Type == "output_text" {
+					appendText(0, c.Text)
+				}
+			}
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Index < 0 {
+				continue
+			}
+			appendText(choice.Index, choice.Text+choice.Message.Content+choice.Delta.Content)
+		}
+	}
+
+	texts := make([]string, 0, len(choices))
+	for i := range choices {
+		if choices[i].Len() > 0 {
+			texts = append(texts, choices[i].String())
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// openAIReplayChunk is the part of a chat completion, or of one of its
+// stream chunks, that decides whether it can be replayed
+type openAIReplayChunk struct {
+	Object  string `json:"object"`
+	Choices []struct {
+		Index    int               `json:"index"`
+		Message  openAIReplayDelta `json:"message"`
+		Delta    openAIReplayDelta `json:"delta"`
+		Logprobs json.RawMessage   `json:"logprobs"`
+	} `json:"choices"`
+}
+
+type openAIReplayDelta struct {
+	Content      string          `json:"content"`
+	ToolCalls    json.RawMessage `json:"tool_calls"`
+	FunctionCall json.RawMessage `json:"function_call"`
+}
+
+// ReplayableText returns the text of a single-choice chat completion
+// without tool calls or logprobs
+func (o *OpenAIProxy) ReplayableText(responseBody io.Reader, isStreaming bool) (string, bool) {
+	var chunks []openAIReplayChunk
+	if isStreaming {
+		for _, data := range sseEvents(responseBody) {
+			var chunk openAIReplayChunk
+			if json.Unmarshal([]byte(data), &chunk) != nil || chunk.Object != "chat.completion.chunk" {
+				return "", false
+			}
+			chunks = append(chunks, chunk)
+		}
+	} else {
+		reader, err := DecompressResponseIfNeeded(responseBody)
+		if err != nil {
+			return "", false
+		}
+		var chunk openAIReplayChunk
+		if json.NewDecoder(reader).Decode(&chunk) != nil || chunk.Object != "chat.completion" {
+			return "", false
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	var text strings.Builder
+	sawChoice := false
+	for _, chunk := range chunks {
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 || isJSONValue(choice.Logprobs) {
+				return "", false
+			}
+			for _, m := range []openAIReplayDelta{choice.Message, choice.Delta} {
+				if isJSONValue(m.ToolCalls) || isJSONValue(m.FunctionCall) {
+					return "", false
+				}
+				text.WriteString(m.Content)
+			}
+			sawChoice = true
+		}
+	}
+	return text.String(), sawChoice
+}
+
+// CanReplayStream reports whether req is a streaming chat completion
+func (o *OpenAIProxy) CanReplayStream(req *http.Request) bool {
+	return strings.HasSuffix(req.URL.Path, "/chat/completions")
+}
+
+// WriteStream writes c as chat completion chunks, followed by a usage chunk
+// when c.IncludeUsage is set (stream_options.include_usage) and the [DONE]
+// marker
+func (o *OpenAIProxy) WriteStream(w io.Writer, c *Completion) error {
+	created := time.Now().Unix()
+	chunk := func(choices []map[string]any) map[string]any {
+		return map[string]any{
+			"id":      c.ID,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   c.Model,
+			"choices": choices,
+		}
+	}
+	choice := func(delta map[string]any, finishReason any) []map[string]any {
+		return []map[string]any{{"index": 0, "delta": delta, "finish_reason": finishReason}}
+	}
+
+	events := []map[string]any{chunk(choice(map[string]any{"role": "assistant", "content": ""}, nil))}
+	for _, piece := range replayChunks(c.Text) {
+		events = append(events, chunk(choice(map[string]any{"content": piece}, nil)))
+	}
+	finishReason := c.FinishReason
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+	events = append(events, chunk(choice(map[string]any{}, finishReason)))
+	if c.IncludeUsage {
+		usage := chunk([]map[string]any{})
+		usage["usage"] = map[string]int{
+			"prompt_tokens":     c.InputTokens,
+			"completion_tokens": c.OutputTokens,
+			"total_tokens":      c.TotalTokens,
+		}
+		events = append(events, usage)
+	}
+
+	for _, event := range events {
+		if err := writeSSE(w, "", event); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "data: [DONE]\n\n")
+	return err
+}
+
 // UserIDFromRequest extracts user ID from OpenAI request body
 // OpenAI supports passing user ID in the "user" field for safety tracking
 // See: https://platform.openai.com/docs/guides/safety-best-practices#end-user-ids
@@ -779,7 +949,7 @@ func (o *OpenAIProxy) UserIDFromRequest(req *http.Request) string {
 	// Read request body
 	bodyBytes, err := o.readRequestBodyForUserID(req)
 	if err != nil {
-		log.Printf("Error reading OpenAI request body for user ID extraction: %v", err)
+		logging.FromContext(req.Context()).Warn("Error reading OpenAI request body for user ID extraction", "error", err)
 		return ""
 	}
 
@@ -790,13 +960,13 @@ func (o *OpenAIProxy) UserIDFromRequest(req *http.Request) string {
 	// Parse JSON to extract user field
 	var data map[string]interface{}
 	if err := json.Unmarshal(bodyBytes, &data); err != nil {
-		log.Printf("Error parsing OpenAI request JSON for user ID extraction: %v", err)
+		logging.FromContext(req.Context()).Debug("Error parsing OpenAI request JSON for user ID extraction", "error", err)
 		return ""
 	}
 
 	// Extract user ID from the "user" field
 	if userValue, ok := data["user"].(string); ok && userValue != "" {
-		log.Printf("🔍 OpenAI: Extracted user ID: %s", userValue)
+		logging.FromContext(req.Context()).Debug("Extracted user ID from request body", "provider", "openai", "user_id", userValue)
 		return userValue
 	}
 
@@ -930,20 +1100,15 @@ func (o *OpenAIProxy) ValidateAPIKey(req *http.Request, keyStore APIKeyStore) er
 	apiKey := strings.TrimPrefix(authHeader, bearerPrefix)
 
 	// Validate and potentially replace the key
-	actualKey, provider, err := keyStore.ValidateAndGetActualKey(context.Background(), apiKey)
+	actualKey, err := keyStore.ValidateAndGetProviderKey(context.Background(), apiKey, "openai")
 	if err != nil {
 		return fmt.Errorf("API key validation failed: %w", err)
 	}
 
-	// If a provider was returned, verify it matches
-	if provider != "" && provider != "openai" {
-		return fmt.Errorf("API key is for provider %s, not openai", provider)
-	}
-
 	// Replace the key in the request header if it was translated
 	if actualKey != apiKey {
 		req.Header.Set("Authorization", bearerPrefix+actualKey)
-		log.Printf("🔑 OpenAI: Translated API key from iw: format")
+		logging.FromContext(req.Context()).Debug("🔑 Translated API key from iw: format", "provider", "openai")
 	}
 
 	return nil
@@ -566,11 +566,11 @@ func TestOpenAI_TokenRateLimit_ByKeyAndUser(t *testing.T) {
 	now := time.Now()
 	scope := ratelimit.ScopeKeys{Provider: "openai", Model: "gpt-4o-mini-2024-07-18", APIKey: "devkey", UserID: "example-user"}
 
-	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 7, now)
+	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 7, 0, now)
 	require.NoError(t, err)
 	require.True(t, res1.Allowed, "first reservation should be allowed")
 
-	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 5, now)
+	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 5, 0, now)
 	require.NoError(t, err)
 	require.False(t, res2.Allowed, "second reservation should be rate limited")
 }
@@ -594,12 +594,12 @@ func TestOpenAI_TokenRateLimit_UserLimited_KeyOK(t *testing.T) {
 	now := time.Now()
 	scope := ratelimit.ScopeKeys{Provider: "openai", Model: "gpt-4o-mini-2024-07-18", APIKey: "devkey", UserID: "example-user"}
 
-	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 7, now)
+	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 7, 0, now)
 	require.NoError(t, err)
 	require.True(t, res1.Allowed)
 
 	// total would be 12 (>10 user limit), key still under 100
-	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 5, now)
+	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 5, 0, now)
 	require.NoError(t, err)
 	require.False(t, res2.Allowed)
 }
@@ -623,12 +623,12 @@ func TestOpenAI_TokenRateLimit_KeyLimited_UserOK(t *testing.T) {
 	now := time.Now()
 	scope := ratelimit.ScopeKeys{Provider: "openai", Model: "gpt-4o-mini-2024-07-18", APIKey: "devkey", UserID: "example-user"}
 
-	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 7, now)
+	res1, err := lim.CheckAndReserve(context.TODO(), "", scope, 7, 0, now)
 	require.NoError(t, err)
 	require.True(t, res1.Allowed)
 
 	// total would be 12 (>10 key limit), user still under 100
-	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 5, now)
+	res2, err := lim.CheckAndReserve(context.TODO(), "", scope, 5, 0, now)
 	require.NoError(t, err)
 	require.False(t, res2.Allowed)
 }
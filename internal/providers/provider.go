@@ -5,15 +5,20 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/Instawork/llm-proxy/internal/logging"
+	"github.com/Instawork/llm-proxy/internal/requestid"
+	"github.com/Instawork/llm-proxy/internal/tracing"
 	"github.com/gorilla/mux"
 )
 
@@ -76,12 +81,123 @@ type Provider interface {
 	ExtractRequestModelAndMessages(req *http.Request) (string, []string)
 }
 
+// ResponseTextExtractor is implemented by providers that can reassemble the
+// generated text of a response, stitching streamed chunks back together.
+// Request/response capture uses it to record completions as plain text.
+type ResponseTextExtractor interface {
+	ExtractResponseText(responseBody io.Reader, isStreaming bool) string
+}
+
+// Completion is a finished single-choice text response. The response cache
+// keeps completions in this form so they can be replayed to streaming
+// requests.
+type Completion struct {
+	ID           string
+	Model        string
+	Text         string
+	FinishReason string
+	InputTokens  int
+	OutputTokens int
+	TotalTokens  int
+	// IncludeUsage asks for the usage event where the streaming format makes
+	// it optional. It comes from the request being answered, not from the
+	// stored response, since cache keys ignore stream options.
+	IncludeUsage bool
+}
+
+// StreamReplayer is implemented by providers that can synthesize their
+// streaming format from a completed response
+type StreamReplayer interface {
+	// ReplayableText returns the generated text of a response, or false when
+	// the response cannot be rebuilt from its text alone, e.g. because it
+	// calls tools or has several choices
+	ReplayableText(responseBody io.Reader, isStreaming bool) (string, bool)
+	// CanReplayStream reports whether WriteStream produces the format the
+	// streaming request req expects
+	CanReplayStream(req *http.Request) bool
+	// WriteStream writes c as server-sent events, one Write per event
+	WriteStream(w io.Writer, c *Completion) error
+}
+
+// isJSONValue reports whether raw holds a value other than null
+func isJSONValue(raw json.RawMessage) bool {
+	return len(raw) > 0 && string(raw) != "null"
+}
+
+// replayChunkBytes is the most text sent in one synthesized stream event
+const replayChunkBytes = 64
+
+// replayChunks splits text into pieces of at most replayChunkBytes, without
+// splitting UTF-8 sequences
+func replayChunks(text string) []string {
+	var chunks []string
+	for len(text) > replayChunkBytes {
+		n := replayChunkBytes
+		for n > 0 && !utf8.RuneStart(text[n]) {
+			n--
+		}
+		if n == 0 {
+			n = replayChunkBytes
+		}
+		chunks = append(chunks, text[:n])
+		text = text[n:]
+	}
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// writeSSE writes one server-sent event with a JSON payload in a single
+// Write, so streaming writers flush each event as it is written
+func writeSSE(w io.Writer, event string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode stream event: %w", err)
+	}
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// sseEvents returns the data payloads of a server-sent event stream, up to
+// the [DONE] marker
+func sseEvents(responseBody io.Reader) []string {
+	reader, err := DecompressResponseIfNeeded(responseBody)
+	if err != nil {
+		return nil
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	var events []string
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+		if data != "" {
+			events = append(events, data)
+		}
+	}
+	return events
+}
+
 // APIKeyStore defines the interface for API key storage operations
 type APIKeyStore interface {
-	// ValidateAndGetActualKey validates a key and returns the actual provider key
-	// If the key doesn't start with "iw:", it returns the key as-is
-	// Returns (actualKey, provider, error)
-	ValidateAndGetActualKey(ctx context.Context, key string) (string, string, error)
+	// ValidateAndGetProviderKey validates a key and returns the upstream key to
+	// use for provider. If the key doesn't start with "iw:", it returns the key
+	// as-is. Virtual keys without an upstream key for provider are rejected.
+	ValidateAndGetProviderKey(ctx context.Context, key, provider string) (string, error)
 }
 
 // ProviderManager manages multiple providers
@@ -156,24 +272,35 @@ func CreateGenericDirector(provider Provider, targetURL *url.URL, originalDirect
 		req.URL.Path = strings.TrimPrefix(req.URL.Path, providerPrefix)
 
 		// Log the request, including streaming detection
-		isStreaming := provider.IsStreamingRequest(req)
-		if isStreaming {
-			log.Printf("Proxying %s streaming request: %s %s", provider.GetName(), req.Method, req.URL.Path)
-		} else {
-			log.Printf("Proxying %s request: %s %s", provider.GetName(), req.Method, req.URL.Path)
-		}
+		logging.FromContext(req.Context()).Debug("Proxying request upstream",
+			"provider", provider.GetName(), "upstream_path", req.URL.Path, "streaming", provider.IsStreamingRequest(req))
+	}
+}
+
+// moveUpstreamRequestID moves the provider's X-Request-ID to
+// X-Upstream-Request-ID. The proxy sets its own X-Request-ID before proxying,
+// and ReverseProxy would otherwise add the upstream value alongside it.
+func moveUpstreamRequestID(resp *http.Response) {
+	ids := resp.Header.Values(requestid.Header)
+	if len(ids) == 0 {
+		return
+	}
+	resp.Header.Del(requestid.Header)
+	for _, id := range ids {
+		resp.Header.Add(requestid.UpstreamHeader, id)
 	}
 }
 
 // newProxyTransport creates a new http.Transport with optimized settings for proxying LLM requests.
-func newProxyTransport() *http.Transport {
+// Connections it dials are counted in the upstream connection metrics for provider.
+func newProxyTransport(provider string) *http.Transport {
 	// These settings are based on http.DefaultTransport, but customized for the proxy.
 	return &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
+		DialContext: countingDialer(provider, (&net.Dialer{
 			Timeout:   30 * time.Second,
 			KeepAlive: 30 * time.Second,
-		}).DialContext,
+		}).DialContext),
 		ForceAttemptHTTP2:     true,
 		MaxIdleConns:          100, // Each provider gets its own transport, so this is 100 idle connections per provider.
 		IdleConnTimeout:       90 * time.Second,
@@ -188,6 +315,13 @@ func newProxyTransport() *http.Transport {
 	}
 }
 
+// newInstrumentedTransport returns a proxy transport for provider that also
+// records connection reuse and traces each upstream request
+func newInstrumentedTransport(provider string) http.RoundTripper {
+	return tracing.NewTransport(&instrumentedTransport{Transport: newProxyTransport(provider), provider: provider},
+		tracing.String("gen_ai.system", provider))
+}
+
 // DecompressResponseIfNeeded checks if the response is gzip compressed and decompresses it.
 // This is a shared utility function that all providers can use to handle gzip-compressed responses
 // when DisableCompression is set to true in the transport.
@@ -202,7 +336,7 @@ func DecompressResponseIfNeeded(reader io.Reader) (io.Reader, error) {
 
 	// Check if this looks like gzip (magic number 0x1f, 0x8b)
 	if n >= 2 && buffer[0] == 0x1f && buffer[1] == 0x8b {
-		log.Printf("🔍 Debug: Detected gzip compressed response, decompressing...")
+		slog.Debug("Detected gzip compressed response, decompressing")
 
 		// Create a new reader that includes the peeked bytes
 		combinedReader := io.MultiReader(bytes.NewReader(buffer[:n]), peekReader)
@@ -270,7 +404,7 @@ func EstimateRequestTokens(req *http.Request, cfg estimationConfig, provider Pro
 	est := 0
 	if req.ContentLength > 0 {
 		est = int(req.ContentLength) / bytesPerToken
-		log.Printf("🔍 Debug: Estimated tokens via Content-Length: %v, model: %v", est, model)
+		logging.FromContext(req.Context()).Debug("Estimated tokens via Content-Length", "estimated_tokens", est, "model", model)
 	}
 
 	// Optional sampling: only if small and JSON
@@ -1,9 +1,15 @@
 package providers
 
 import (
+	"bytes"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/Instawork/llm-proxy/internal/requestid"
 )
 
 // Test ProviderManager functionality
@@ -162,7 +168,7 @@ func TestProviderManager_GetHealthStatus(t *testing.T) {
 
 // Test newProxyTransport function
 func TestNewProxyTransport(t *testing.T) {
-	transport := newProxyTransport()
+	transport := newProxyTransport("test")
 
 	if transport == nil {
 		t.Fatal("newProxyTransport() returned nil")
@@ -241,3 +247,198 @@ func TestLLMResponseMetadata_Struct(t *testing.T) {
 		t.Error("Expected IsStreaming=false")
 	}
 }
+
+func TestExtractResponseText(t *testing.T) {
+	testCases := []struct {
+		name      string
+		extractor ResponseTextExtractor
+		body      string
+		streaming bool
+		expected  string
+	}{
+		{
+			name:      "OpenAI chat stream",
+			extractor: NewOpenAIProxy(),
+			body:      "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"Hel\"}}]}\n\ndata: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"lo\"}}]}\n\ndata: {\"choices\":[],\"usage\":{\"total_tokens\":3}}\n\ndata: [DONE]\n\n",
+			streaming: true,
+			expected:  "Hello",
+		},
+		{
+			name:      "OpenAI Responses API stream",
+			extractor: NewOpenAIProxy(),
+			body:      "event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"Hi \"}\n\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"there\"}\n\n",
+			streaming: true,
+			expected:  "Hi there",
+		},
+		{
+			name:      "OpenAI chat with two choices",
+			extractor: NewOpenAIProxy(),
+			body:      `{"choices":[{"index":0,"message":{"content":"one"}},{"index":1,"message":{"content":"two"}}]}`,
+			expected:  "one\n\ntwo",
+		},
+		{
+			name:      "Anthropic stream",
+			extractor: NewAnthropicProxy(),
+			body:      "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Bon\"}}\n\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"jour\"}}\n\ndata: {\"type\":\"message_stop\"}\n\n",
+			streaming: true,
+			expected:  "Bonjour",
+		},
+		{
+			name:      "Anthropic message",
+			extractor: NewAnthropicProxy(),
+			body:      `{"content":[{"type":"text","text":"Bonjour"}]}`,
+			expected:  "Bonjour",
+		},
+		{
+			name:      "Gemini SSE stream",
+			extractor: NewGeminiProxy(),
+			body:      "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Ho\"}]}}]}\n\ndata: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"la\"}]}}]}\n\n",
+			streaming: true,
+			expected:  "Hola",
+		},
+		{
+			name:      "Gemini JSON array stream",
+			extractor: NewGeminiProxy(),
+			body:      `[{"candidates":[{"content":{"parts":[{"text":"Ho"}]}}]},{"candidates":[{"content":{"parts":[{"text":"la"}]}}]}]`,
+			streaming: true,
+			expected:  "Hola",
+		},
+		{
+			name:      "Groq uses OpenAI format",
+			extractor: NewGroqProxy(),
+			body:      `{"choices":[{"index":0,"message":{"content":"fast"}}]}`,
+			expected:  "fast",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.extractor.ExtractResponseText(strings.NewReader(tc.body), tc.streaming); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestStreamReplay(t *testing.T) {
+	// Long enough to be split across several events, with multi-byte runes
+	text := strings.Repeat("Caché de réponses — ", 8)
+	testCases := []struct {
+		name     string
+		provider Provider
+		path     string
+		id       string
+		finish   string
+	}{
+		{"OpenAI", NewOpenAIProxy(), "/openai/v1/chat/completions", "chatcmpl-1", "stop"},
+		{"Groq", NewGroqProxy(), "/groq/openai/v1/chat/completions", "chatcmpl-2", "length"},
+		{"Anthropic", NewAnthropicProxy(), "/anthropic/v1/messages", "msg_1", "end_turn"},
+		{"Gemini", NewGeminiProxy(), "/gemini/v1beta/models/gemini-2.0-flash:streamGenerateContent?alt=sse", "resp-1", "STOP"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			replayer := tc.provider.(StreamReplayer)
+			if !replayer.CanReplayStream(httptest.NewRequest("POST", tc.path, nil)) {
+				t.Fatalf("expected %s to replay streams", tc.path)
+			}
+			c := &Completion{ID: tc.id, Model: "model-x", Text: text, FinishReason: tc.finish, InputTokens: 11, OutputTokens: 7, TotalTokens: 18, IncludeUsage: true}
+			var stream bytes.Buffer
+			if err := replayer.WriteStream(&stream, c); err != nil {
+				t.Fatalf("WriteStream: %v", err)
+			}
+
+			metadata, err := tc.provider.ParseResponseMetadata(bytes.NewReader(stream.Bytes()), true)
+			if err != nil {
+				t.Fatalf("ParseResponseMetadata: %v\n%s", err, stream.String())
+			}
+			if metadata.InputTokens != 11 || metadata.OutputTokens != 7 || metadata.TotalTokens != 18 ||
+				metadata.Model != "model-x" || metadata.FinishReason != tc.finish {
+				t.Fatalf("unexpected metadata %+v", metadata)
+			}
+			if got := tc.provider.(ResponseTextExtractor).ExtractResponseText(bytes.NewReader(stream.Bytes()), true); got != text {
+				t.Fatalf("ExtractResponseText = %q, want %q", got, text)
+			}
+			// A replayed stream can itself be cached and replayed
+			if got, ok := replayer.ReplayableText(bytes.NewReader(stream.Bytes()), true); !ok || got != text {
+				t.Fatalf("ReplayableText = %q, %v", got, ok)
+			}
+		})
+	}
+}
+
+func TestReplayableText(t *testing.T) {
+	testCases := []struct {
+		name       string
+		replayer   StreamReplayer
+		body       string
+		streaming  bool
+		expected   string
+		replayable bool
+	}{
+		{"OpenAI chat", NewOpenAIProxy(), `{"object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"4"},"finish_reason":"stop"}]}`, false, "4", true},
+		{"OpenAI tool call", NewOpenAIProxy(), `{"object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1"}]}}]}`, false, "", false},
+		{"OpenAI two choices", NewOpenAIProxy(), `{"object":"chat.completion","choices":[{"index":0,"message":{"content":"a"}},{"index":1,"message":{"content":"b"}}]}`, false, "", false},
+		{"OpenAI Responses API", NewOpenAIProxy(), `{"object":"response","output":[]}`, false, "", false},
+		{"Anthropic text", NewAnthropicProxy(), `{"type":"message","content":[{"type":"text","text":"Hi"}]}`, false, "Hi", true},
+		{"Anthropic tool use", NewAnthropicProxy(), `{"type":"message","content":[{"type":"text","text":"Let me check"},{"type":"tool_use","id":"toolu_1"}]}`, false, "", false},
+		{"Anthropic thinking stream", NewAnthropicProxy(), "data: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\"}}\n\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"thinking\"}}\n\n", true, "", false},
+		{"Gemini text", NewGeminiProxy(), `{"candidates":[{"content":{"parts":[{"text":"Hi"}],"role":"model"}}]}`, false, "Hi", true},
+		{"Gemini function call", NewGeminiProxy(), `{"candidates":[{"content":{"parts":[{"functionCall":{"name":"f"}}],"role":"model"}}]}`, false, "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			text, ok := tc.replayer.ReplayableText(strings.NewReader(tc.body), tc.streaming)
+			if ok != tc.replayable || text != tc.expected {
+				t.Errorf("ReplayableText = %q, %v; want %q, %v", text, ok, tc.expected, tc.replayable)
+			}
+		})
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestProxyMovesUpstreamRequestID(t *testing.T) {
+	upstream := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"X-Request-Id": []string{"req_upstream"}, "Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{}`)),
+			Request:    req,
+		}, nil
+	})
+	openai := NewOpenAIProxy()
+	openai.proxy.Transport = upstream
+	groq := NewGroqProxy()
+	groq.proxy.Transport = upstream
+
+	tests := []struct {
+		name     string
+		provider Provider
+		path     string
+	}{
+		{name: "openai", provider: openai, path: "/openai/v1/chat/completions"},
+		{name: "groq", provider: groq, path: "/groq/openai/v1/chat/completions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// RequestIDMiddleware sets the proxy's ID before the provider proxy runs
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(requestid.Header, "proxy-id")
+				tt.provider.Proxy().ServeHTTP(w, r)
+			})
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest("POST", tt.path, strings.NewReader(`{}`)))
+
+			if got := rr.Header().Values(requestid.Header); len(got) != 1 || got[0] != "proxy-id" {
+				t.Fatalf("X-Request-ID = %q, want only the proxy's ID", got)
+			}
+			if got := rr.Header().Get(requestid.UpstreamHeader); got != "req_upstream" {
+				t.Fatalf("X-Upstream-Request-ID = %q, want req_upstream", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package providers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+
+	"github.com/Instawork/llm-proxy/internal/metrics"
+)
+
+// Upstream connection pool metrics. http.Transport does not expose its pool,
+// so connections are counted as they are dialed and closed, and each request
+// records whether it reused an idle connection.
+var (
+	upstreamOpenConnections = metrics.NewGaugeVec("llm_proxy_upstream_open_connections",
+		"Open connections to upstream providers", "provider")
+	upstreamDials = metrics.NewCounterVec("llm_proxy_upstream_dials_total",
+		"Connection attempts to upstream providers", "provider", "result")
+	upstreamConnsAcquired = metrics.NewCounterVec("llm_proxy_upstream_connections_acquired_total",
+		"Connections taken from the pool for upstream requests, by whether an idle connection was reused", "provider", "reused")
+)
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// countingDialer wraps dial to track open connections and dial outcomes for provider
+func countingDialer(provider string, dial dialFunc) dialFunc {
+	open := upstreamOpenConnections.WithLabelValues(provider)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			upstreamDials.WithLabelValues(provider, "error").Inc()
+			return nil, err
+		}
+		upstreamDials.WithLabelValues(provider, "ok").Inc()
+		open.Inc()
+		return &countedConn{Conn: conn, open: open}, nil
+	}
+}
+
+// countedConn decrements the open connection gauge once when closed
+type countedConn struct {
+	net.Conn
+	open *metrics.Gauge
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(c.open.Dec)
+	return c.Conn.Close()
+}
+
+// instrumentedTransport records connection reuse for each upstream request
+type instrumentedTransport struct {
+	*http.Transport
+	provider string
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			upstreamConnsAcquired.WithLabelValues(t.provider, strconv.FormatBool(info.Reused)).Inc()
+		},
+	}
+	return t.Transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
@@ -0,0 +1,200 @@
+package providers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UpstreamLimitState is the most recent rate-limit view reported by a provider
+// for a single upstream key. Negative counts mean the header was not present.
+type UpstreamLimitState struct {
+	Organization      string    `json:"organization,omitempty"`
+	LimitRequests     int       `json:"limit_requests"`
+	RemainingRequests int       `json:"remaining_requests"`
+	ResetRequests     time.Time `json:"reset_requests"`
+	LimitTokens       int       `json:"limit_tokens"`
+	RemainingTokens   int       `json:"remaining_tokens"`
+	ResetTokens       time.Time `json:"reset_tokens"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// UpstreamLimitTracker stores upstream rate-limit state per provider and
+// upstream key. It is safe for concurrent use.
+type UpstreamLimitTracker struct {
+	mu     sync.RWMutex
+	states map[string]map[string]*UpstreamLimitState
+}
+
+// NewUpstreamLimitTracker creates an empty tracker
+func NewUpstreamLimitTracker() *UpstreamLimitTracker {
+	return &UpstreamLimitTracker{states: make(map[string]map[string]*UpstreamLimitState)}
+}
+
+var defaultUpstreamLimits = NewUpstreamLimitTracker()
+
+// UpstreamLimits returns the tracker shared by all providers
+func UpstreamLimits() *UpstreamLimitTracker {
+	return defaultUpstreamLimits
+}
+
+// Record stores the latest state for an upstream key
+func (t *UpstreamLimitTracker) Record(provider, upstreamID string, state UpstreamLimitState) {
+	if upstreamID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.states[provider] == nil {
+		t.states[provider] = make(map[string]*UpstreamLimitState)
+	}
+	s := state
+	t.states[provider][upstreamID] = &s
+}
+
+// Get returns the latest state for an upstream key
+func (t *UpstreamLimitTracker) Get(provider, upstreamID string) (UpstreamLimitState, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	s, ok := t.states[provider][upstreamID]
+	if !ok {
+		return UpstreamLimitState{}, false
+	}
+	return *s, true
+}
+
+// Consume optimistically deducts a request and estimated tokens from the known
+// remaining capacity so concurrent requests see it before the next response
+// refreshes the state.
+func (t *UpstreamLimitTracker) Consume(provider, upstreamID string, tokens int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.states[provider][upstreamID]
+	if !ok {
+		return
+	}
+	if s.RemainingRequests > 0 {
+		s.RemainingRequests--
+	}
+	if s.RemainingTokens > 0 {
+		s.RemainingTokens -= tokens
+		if s.RemainingTokens < 0 {
+			s.RemainingTokens = 0
+		}
+	}
+}
+
+// Snapshot returns a copy of all states for a provider keyed by upstream ID
+func (t *UpstreamLimitTracker) Snapshot(provider string) map[string]UpstreamLimitState {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	out := make(map[string]UpstreamLimitState, len(t.states[provider]))
+	for id, s := range t.states[provider] {
+		out[id] = *s
+	}
+	return out
+}
+
+// UpstreamKeyID returns a short, non-reversible identifier for the upstream
+// API key carried by a request (after any iw: key translation).
+func UpstreamKeyID(req *http.Request) string {
+	key := ""
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	} else if k := req.Header.Get("x-api-key"); k != "" {
+		key = k
+	} else if k := req.Header.Get("x-goog-api-key"); k != "" {
+		key = k
+	}
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// RecordUpstreamLimits parses OpenAI-style (x-ratelimit-*) or Anthropic-style
+// (anthropic-ratelimit-*) headers from a response and records them in the
+// shared tracker. Responses without rate-limit headers are ignored.
+func RecordUpstreamLimits(provider string, resp *http.Response) {
+	if resp == nil || resp.Request == nil {
+		return
+	}
+	state, ok := parseUpstreamLimitHeaders(resp.Header, time.Now())
+	if !ok {
+		return
+	}
+	defaultUpstreamLimits.Record(provider, UpstreamKeyID(resp.Request), state)
+}
+
+func parseUpstreamLimitHeaders(h http.Header, now time.Time) (UpstreamLimitState, bool) {
+	s := UpstreamLimitState{
+		LimitRequests: -1, RemainingRequests: -1,
+		LimitTokens: -1, RemainingTokens: -1,
+		UpdatedAt: now,
+	}
+	found := false
+
+	// OpenAI / Groq: reset values are durations such as "1s", "6m0s" or "20ms"
+	if v := h.Get("x-ratelimit-remaining-requests"); v != "" {
+		found = true
+		s.Organization = h.Get("openai-organization")
+		s.LimitRequests = headerInt(h.Get("x-ratelimit-limit-requests"))
+		s.RemainingRequests = headerInt(v)
+		s.ResetRequests = resetFromDuration(h.Get("x-ratelimit-reset-requests"), now)
+	}
+	if v := h.Get("x-ratelimit-remaining-tokens"); v != "" {
+		found = true
+		s.LimitTokens = headerInt(h.Get("x-ratelimit-limit-tokens"))
+		s.RemainingTokens = headerInt(v)
+		s.ResetTokens = resetFromDuration(h.Get("x-ratelimit-reset-tokens"), now)
+	}
+
+	// Anthropic: reset values are RFC 3339 timestamps
+	if v := h.Get("anthropic-ratelimit-requests-remaining"); v != "" {
+		found = true
+		s.Organization = h.Get("anthropic-organization-id")
+		s.LimitRequests = headerInt(h.Get("anthropic-ratelimit-requests-limit"))
+		s.RemainingRequests = headerInt(v)
+		s.ResetRequests = resetFromTimestamp(h.Get("anthropic-ratelimit-requests-reset"))
+	}
+	for _, prefix := range []string{"anthropic-ratelimit-tokens", "anthropic-ratelimit-input-tokens"} {
+		if v := h.Get(prefix + "-remaining"); v != "" {
+			found = true
+			s.LimitTokens = headerInt(h.Get(prefix + "-limit"))
+			s.RemainingTokens = headerInt(v)
+			s.ResetTokens = resetFromTimestamp(h.Get(prefix + "-reset"))
+			break
+		}
+	}
+
+	return s, found
+}
+
+func headerInt(v string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func resetFromDuration(v string, now time.Time) time.Time {
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		return time.Time{}
+	}
+	return now.Add(d)
+}
+
+func resetFromTimestamp(v string) time.Time {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(v))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,81 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseUpstreamLimitHeadersOpenAI(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	h := http.Header{}
+	h.Set("openai-organization", "org-123")
+	h.Set("x-ratelimit-limit-requests", "5000")
+	h.Set("x-ratelimit-remaining-requests", "4999")
+	h.Set("x-ratelimit-reset-requests", "12ms")
+	h.Set("x-ratelimit-limit-tokens", "450000")
+	h.Set("x-ratelimit-remaining-tokens", "449000")
+	h.Set("x-ratelimit-reset-tokens", "1m0s")
+
+	s, ok := parseUpstreamLimitHeaders(h, now)
+	if !ok {
+		t.Fatalf("expected headers to be parsed")
+	}
+	if s.Organization != "org-123" || s.LimitRequests != 5000 || s.RemainingRequests != 4999 || s.LimitTokens != 450000 || s.RemainingTokens != 449000 {
+		t.Fatalf("unexpected state: %+v", s)
+	}
+	if !s.ResetRequests.Equal(now.Add(12*time.Millisecond)) || !s.ResetTokens.Equal(now.Add(time.Minute)) {
+		t.Fatalf("unexpected reset times: %v %v", s.ResetRequests, s.ResetTokens)
+	}
+}
+
+func TestParseUpstreamLimitHeadersAnthropic(t *testing.T) {
+	h := http.Header{}
+	h.Set("anthropic-ratelimit-requests-limit", "50")
+	h.Set("anthropic-ratelimit-requests-remaining", "0")
+	h.Set("anthropic-ratelimit-requests-reset", "2025-01-01T12:00:30Z")
+	h.Set("anthropic-ratelimit-input-tokens-limit", "40000")
+	h.Set("anthropic-ratelimit-input-tokens-remaining", "1000")
+	h.Set("anthropic-ratelimit-input-tokens-reset", "2025-01-01T12:00:10Z")
+
+	s, ok := parseUpstreamLimitHeaders(h, time.Now())
+	if !ok {
+		t.Fatalf("expected headers to be parsed")
+	}
+	if s.RemainingRequests != 0 || s.LimitRequests != 50 || s.RemainingTokens != 1000 || s.LimitTokens != 40000 {
+		t.Fatalf("unexpected state: %+v", s)
+	}
+	if s.ResetRequests.Format(time.RFC3339) != "2025-01-01T12:00:30Z" {
+		t.Fatalf("unexpected reset: %v", s.ResetRequests)
+	}
+
+	if _, ok := parseUpstreamLimitHeaders(http.Header{}, time.Now()); ok {
+		t.Fatalf("expected no state without rate-limit headers")
+	}
+}
+
+func TestRecordUpstreamLimitsAndConsume(t *testing.T) {
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	req.Header.Set("Authorization", "Bearer sk-record-test")
+	resp := &http.Response{Header: http.Header{}, Request: req}
+	resp.Header.Set("x-ratelimit-remaining-requests", "2")
+	resp.Header.Set("x-ratelimit-reset-requests", "1s")
+	resp.Header.Set("x-ratelimit-remaining-tokens", "100")
+	resp.Header.Set("x-ratelimit-reset-tokens", "1s")
+
+	RecordUpstreamLimits("openai", resp)
+	id := UpstreamKeyID(req)
+	UpstreamLimits().Consume("openai", id, 30)
+
+	s, ok := UpstreamLimits().Get("openai", id)
+	if !ok {
+		t.Fatalf("expected recorded state")
+	}
+	if s.RemainingRequests != 1 || s.RemainingTokens != 70 {
+		t.Fatalf("unexpected state after consume: %+v", s)
+	}
+	if _, ok := UpstreamLimits().Snapshot("openai")[id]; !ok {
+		t.Fatalf("expected state in snapshot")
+	}
+}
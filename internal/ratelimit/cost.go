@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/config"
+)
+
+// Cost windows. Requests and tokens only use minute and day windows; cost
+// limits additionally support an hourly window.
+const (
+	windowMinute = "minute"
+	windowHour   = "hour"
+	windowDay    = "day"
+)
+
+// costLimitFor returns the USD limit for a scope key and window, applying
+// per-model, per-key, per-user and per-team overrides when present.
+func costLimitFor(cfg *limiterConfig, key, window string) float64 {
+	limit := costForWindow(cfg.Features.RateLimiting.Limits, window)
+	if o, ok := cfg.configuredOverride(key); ok {
+		if v := costForWindow(o, window); v > 0 {
+			limit = v
+		}
+	}
+	return limit
+}
+
+func costForWindow(l config.LimitsConfig, window string) float64 {
+	switch window {
+	case windowMinute:
+		return l.CostPerMinute
+	case windowHour:
+		return l.CostPerHour
+	case windowDay:
+		return l.CostPerDay
+	}
+	return 0
+}
+
+// toMicros converts USD to integer micro-dollars for backends that only
+// support integer counters.
+func toMicros(usd float64) int64 {
+	return int64(math.Round(usd * 1_000_000))
+}
+
+func fromMicros(micros int64) float64 {
+	return float64(micros) / 1_000_000
+}
+
+func secToHourEnd(t time.Time) int {
+	s := int(t.Unix() % 3600)
+	if s == 0 {
+		return 3600
+	}
+	return 3600 - s
+}
+
+func costReason(window string) string {
+	switch window {
+	case windowHour:
+		return "hourly cost limit exceeded"
+	case windowDay:
+		return "daily cost limit exceeded"
+	}
+	return "minute cost limit exceeded"
+}
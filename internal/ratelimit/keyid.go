@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"github.com/Instawork/llm-proxy/internal/apikeys"
+	"github.com/Instawork/llm-proxy/internal/config"
+)
+
+// KeyID returns the identifier used in place of an API key in scope keys and
+// backend storage. It is the key store's record ID (see apikeys.KeyID), so
+// per_key overrides and X-RateLimit-Scope use the ID shown by logs, the admin
+// API and llm-proxy-keys.
+func KeyID(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	return apikeys.KeyID(apiKey)
+}
+
+// limiterConfig is the configuration a limiter runs with, plus its per_key
+// overrides indexed by hashed key ID. It is built when the configuration is
+// set, so lookups do not hash every entry on each request.
+type limiterConfig struct {
+	*config.YAMLConfig
+	perKey map[string]config.LimitsConfig
+}
+
+// newLimiterConfig indexes cfg's per_key overrides. Entries may be written as
+// the key ID itself or, for backward compatibility, as the raw key, which is
+// hashed here; an entry written as the ID wins over a raw key hashing to it.
+func newLimiterConfig(cfg *config.YAMLConfig) *limiterConfig {
+	perKey := cfg.Features.RateLimiting.Overrides.PerKey
+	c := &limiterConfig{YAMLConfig: cfg, perKey: make(map[string]config.LimitsConfig, 2*len(perKey))}
+	for name, o := range perKey {
+		if !apikeys.IsKeyID(name) {
+			c.perKey[apikeys.KeyID(name)] = o
+		}
+	}
+	for name, o := range perKey {
+		c.perKey[name] = o
+	}
+	return c
+}
+
+// perKeyOverride finds the per_key override for a hashed key ID
+func (c *limiterConfig) perKeyOverride(id string) (config.LimitsConfig, bool) {
+	o, ok := c.perKey[id]
+	return o, ok
+}
@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/apikeys"
+	"github.com/Instawork/llm-proxy/internal/config"
+)
+
+func TestKeyIDMatchesStoreID(t *testing.T) {
+	id := KeyID("iw:secret")
+	if id != apikeys.KeyID("iw:secret") || strings.Contains(id, "secret") {
+		t.Fatalf("unexpected key ID %q", id)
+	}
+	if KeyID(id) != id {
+		t.Fatalf("a key ID should map to itself")
+	}
+	if KeyID("") != "" {
+		t.Fatalf("empty key should produce empty ID")
+	}
+}
+
+func TestMemoryLimiterPerKeyOverrideByHashOrRawKey(t *testing.T) {
+	cfg := baseCfg()
+	cfg.Features.RateLimiting.Limits = config.LimitsConfig{}
+	cfg.Features.RateLimiting.Overrides.PerKey = map[string]config.LimitsConfig{
+		KeyID("iw:hashed"): {RequestsPerMinute: 1},
+		"iw:legacy":        {RequestsPerMinute: 1},
+	}
+	lim := NewMemoryLimiter(cfg)
+	now := time.Now()
+
+	for _, key := range []string{"iw:hashed", "iw:legacy"} {
+		scope := ScopeKeys{APIKey: KeyID(key)}
+		if res, _ := lim.CheckAndReserve(context.Background(), "1", scope, 0, 0, now); !res.Allowed {
+			t.Fatalf("%s: first request should be allowed", key)
+		}
+		res, _ := lim.CheckAndReserve(context.Background(), "2", scope, 0, 0, now)
+		if res.Allowed {
+			t.Fatalf("%s: second request should be blocked by per-key override", key)
+		}
+		if res.Details.ScopeKey != "key:"+scope.APIKey {
+			t.Fatalf("%s: unexpected scope %q", key, res.Details.ScopeKey)
+		}
+	}
+}
+
+func TestMemoryLimiterSetConfigReindexesPerKey(t *testing.T) {
+	cfg := baseCfg()
+	cfg.Features.RateLimiting.Limits = config.LimitsConfig{}
+	lim := NewMemoryLimiter(cfg)
+	scope := ScopeKeys{APIKey: KeyID("iw:reloaded")}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if res, _ := lim.CheckAndReserve(context.Background(), "before", scope, 0, 0, now); !res.Allowed {
+			t.Fatalf("request %d should be allowed without an override", i)
+		}
+	}
+
+	next := *cfg
+	next.Features.RateLimiting.Overrides.PerKey = map[string]config.LimitsConfig{"iw:reloaded": {RequestsPerMinute: 3}}
+	lim.SetConfig(&next)
+	if res, _ := lim.CheckAndReserve(context.Background(), "after", scope, 0, 0, now); res.Allowed {
+		t.Fatalf("raw-key override added on reload should apply")
+	}
+}
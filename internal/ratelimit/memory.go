@@ -2,8 +2,10 @@ package ratelimit
 
 import (
 	"context"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Instawork/llm-proxy/internal/config"
@@ -11,41 +13,76 @@ import (
 
 // memoryLimiter is a thread-safe in-memory rate limiter.
 type memoryLimiter struct {
-	cfg     *config.YAMLConfig
-	mu      sync.Mutex
-	minute  map[string]*counters
-	day     map[string]*counters
-	minTick time.Time
-	dayTick time.Time
+	cfg      atomic.Pointer[limiterConfig]
+	mu       sync.Mutex
+	minute   map[string]*counters
+	hour     map[string]*counters
+	day      map[string]*counters
+	minTick  time.Time
+	hourTick time.Time
+	dayTick  time.Time
+	// temporary overrides set at runtime, keyed by scope key
+	overrides map[string]*TemporaryOverride
 }
 
 type counters struct {
 	Requests int
 	Tokens   int
+	Cost     float64
 }
 
 func NewMemoryLimiter(cfg *config.YAMLConfig) RateLimiter {
-	return &memoryLimiter{
-		cfg:     cfg,
-		minute:  make(map[string]*counters),
-		day:     make(map[string]*counters),
-		minTick: time.Now().Truncate(time.Minute),
-		dayTick: time.Now().Truncate(24 * time.Hour),
+	m := &memoryLimiter{
+		minute:   make(map[string]*counters),
+		hour:     make(map[string]*counters),
+		day:      make(map[string]*counters),
+		minTick:  time.Now().Truncate(time.Minute),
+		hourTick: time.Now().Truncate(time.Hour),
+		dayTick:  time.Now().Truncate(24 * time.Hour),
+
+		overrides: make(map[string]*TemporaryOverride),
 	}
+	m.cfg.Store(newLimiterConfig(cfg))
+	return m
+}
+
+// SetConfig swaps in the limits and overrides of cfg; counters and
+// temporary overrides are kept
+func (m *memoryLimiter) SetConfig(cfg *config.YAMLConfig) {
+	m.cfg.Store(newLimiterConfig(cfg))
+}
+
+// Config returns the configuration in effect
+func (m *memoryLimiter) Config() *config.YAMLConfig {
+	return m.cfg.Load().YAMLConfig
+}
+
+func (m *memoryLimiter) CheckAndReserve(ctx context.Context, id string, scope ScopeKeys, estTokens int, estCost float64, now time.Time) (ReservationResult, error) {
+	_ = ctx
+	return m.reserve(id, scope, estTokens, estCost, false, now)
 }
 
-func (m *memoryLimiter) CheckAndReserve(ctx context.Context, id string, scope ScopeKeys, estTokens int, now time.Time) (ReservationResult, error) {
+func (m *memoryLimiter) ReserveRequest(ctx context.Context, id string, scope ScopeKeys, now time.Time) (ReservationResult, error) {
 	_ = ctx
+	return m.reserve(id, scope, 0, 0, true, now)
+}
+
+// reserve checks and applies a reservation; requestsOnly skips token and cost limits
+func (m *memoryLimiter) reserve(id string, scope ScopeKeys, estTokens int, estCost float64, requestsOnly bool, now time.Time) (ReservationResult, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	m.rotateWindowsLocked(now)
+	m.pruneOverridesLocked(now)
 
 	keys := m.scopeKeys(scope)
 	for _, k := range keys {
 		// minute window
 		minC := m.getCounterLocked(m.minute, k)
 		minLim := m.limitFor(k, true)
+		if requestsOnly {
+			minLim.tokPerWindow = 0
+		}
 		if m.exceeds(minC, minLim, estTokens) {
 			remaining := 0
 			if minLim.tokPerWindow > 0 {
@@ -60,12 +97,15 @@ func (m *memoryLimiter) CheckAndReserve(ctx context.Context, id string, scope Sc
 			} else {
 				limitVal = minLim.reqPerWindow
 			}
-			details := &LimitDetails{ScopeKey: k, Metric: metric, Window: "minute", Limit: limitVal, Remaining: remaining}
+			details := &LimitDetails{ScopeKey: k, Metric: metric, Window: "minute", Limit: limitVal, Remaining: remaining, ResetSeconds: 60}
 			return ReservationResult{Allowed: false, RetryAfterSeconds: 60, Reason: "minute limit exceeded", Details: details}, nil
 		}
 		// day window
 		dayC := m.getCounterLocked(m.day, k)
 		dayLim := m.limitFor(k, false)
+		if requestsOnly {
+			dayLim.tokPerWindow = 0
+		}
 		if m.exceeds(dayC, dayLim, estTokens) {
 			remaining := 0
 			if dayLim.tokPerWindow > 0 {
@@ -80,23 +120,61 @@ func (m *memoryLimiter) CheckAndReserve(ctx context.Context, id string, scope Sc
 			} else {
 				limitVal = dayLim.reqPerWindow
 			}
-			details := &LimitDetails{ScopeKey: k, Metric: metric, Window: "day", Limit: limitVal, Remaining: remaining}
-			return ReservationResult{Allowed: false, RetryAfterSeconds: int(time.Until(m.dayTick.Add(24 * time.Hour)).Seconds()), Reason: "daily limit exceeded", Details: details}, nil
+			retry := int(time.Until(m.dayTick.Add(24 * time.Hour)).Seconds())
+			details := &LimitDetails{ScopeKey: k, Metric: metric, Window: "day", Limit: limitVal, Remaining: remaining, ResetSeconds: retry}
+			return ReservationResult{Allowed: false, RetryAfterSeconds: retry, Reason: "daily limit exceeded", Details: details}, nil
+		}
+		// cost windows
+		for _, w := range []struct {
+			name   string
+			bucket map[string]*counters
+			retry  int
+		}{
+			{windowMinute, m.minute, 60},
+			{windowHour, m.hour, int(m.hourTick.Add(time.Hour).Sub(now).Seconds())},
+			{windowDay, m.day, int(time.Until(m.dayTick.Add(24 * time.Hour)).Seconds())},
+		} {
+			c := m.getCounterLocked(w.bucket, k)
+			costLim := m.costLimitFor(k, w.name)
+			if !requestsOnly && exceedsCost(c, costLim, estCost) {
+				remaining := costLim - (c.Cost + estCost)
+				if remaining < 0 {
+					remaining = 0
+				}
+				details := &LimitDetails{ScopeKey: k, Metric: "cost", Window: w.name, CostLimit: costLim, CostRemaining: remaining, ResetSeconds: maxInt(w.retry, 1)}
+				return ReservationResult{Allowed: false, RetryAfterSeconds: maxInt(w.retry, 1), Reason: costReason(w.name), Details: details}, nil
+			}
 		}
 	}
 
 	// Apply reservation
+	usages := make([]windowUsage, 0, len(keys)*3)
+	minReset := int(m.minTick.Add(time.Minute).Sub(now).Seconds())
+	hourReset := int(m.hourTick.Add(time.Hour).Sub(now).Seconds())
+	dayReset := int(m.dayTick.Add(24 * time.Hour).Sub(now).Seconds())
 	for _, k := range keys {
-		m.getCounterLocked(m.minute, k).Requests++
-		m.getCounterLocked(m.minute, k).Tokens += estTokens
-		m.getCounterLocked(m.day, k).Requests++
-		m.getCounterLocked(m.day, k).Tokens += estTokens
+		minC := m.getCounterLocked(m.minute, k)
+		minC.Requests++
+		minC.Tokens += estTokens
+		minC.Cost += estCost
+		hourC := m.getCounterLocked(m.hour, k)
+		hourC.Cost += estCost
+		dayC := m.getCounterLocked(m.day, k)
+		dayC.Requests++
+		dayC.Tokens += estTokens
+		dayC.Cost += estCost
+		usages = append(usages,
+			windowUsage{scopeKey: k, window: windowMinute, requests: minC.Requests, tokens: minC.Tokens, cost: minC.Cost,
+				lim: m.limitFor(k, true), costLimit: m.costLimitFor(k, windowMinute), resetSeconds: minReset},
+			windowUsage{scopeKey: k, window: windowHour, cost: hourC.Cost, costLimit: m.costLimitFor(k, windowHour), resetSeconds: hourReset},
+			windowUsage{scopeKey: k, window: windowDay, requests: dayC.Requests, tokens: dayC.Tokens, cost: dayC.Cost,
+				lim: m.limitFor(k, false), costLimit: m.costLimitFor(k, windowDay), resetSeconds: dayReset})
 	}
 
-	return ReservationResult{Allowed: true, ReservationID: id}, nil
+	return ReservationResult{Allowed: true, ReservationID: id, Details: tightestLimit(usages)}, nil
 }
 
-func (m *memoryLimiter) Adjust(ctx context.Context, id string, scope ScopeKeys, tokenDelta int, now time.Time) error {
+func (m *memoryLimiter) Adjust(ctx context.Context, id string, scope ScopeKeys, tokenDelta int, costDelta float64, now time.Time) error {
 	_ = ctx
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -106,11 +184,18 @@ func (m *memoryLimiter) Adjust(ctx context.Context, id string, scope ScopeKeys,
 	for _, k := range keys {
 		m.getCounterLocked(m.minute, k).Tokens += tokenDelta
 		m.getCounterLocked(m.day, k).Tokens += tokenDelta
+		for _, bucket := range []map[string]*counters{m.minute, m.hour, m.day} {
+			c := m.getCounterLocked(bucket, k)
+			c.Cost += costDelta
+			if c.Cost < 0 {
+				c.Cost = 0
+			}
+		}
 	}
 	return nil
 }
 
-func (m *memoryLimiter) Cancel(ctx context.Context, id string, scope ScopeKeys, now time.Time) error {
+func (m *memoryLimiter) Cancel(ctx context.Context, id string, scope ScopeKeys, estTokens int, estCost float64, now time.Time) error {
 	_ = ctx
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -118,20 +203,126 @@ func (m *memoryLimiter) Cancel(ctx context.Context, id string, scope ScopeKeys,
 	m.rotateWindowsLocked(now)
 	keys := m.scopeKeys(scope)
 	for _, k := range keys {
-		m.getCounterLocked(m.minute, k).Requests--
-		m.getCounterLocked(m.minute, k).Tokens = max0(m.getCounterLocked(m.minute, k).Tokens)
-		m.getCounterLocked(m.day, k).Requests--
-		m.getCounterLocked(m.day, k).Tokens = max0(m.getCounterLocked(m.day, k).Tokens)
+		for _, bucket := range []map[string]*counters{m.minute, m.day} {
+			c := m.getCounterLocked(bucket, k)
+			c.Requests = max0(c.Requests - 1)
+			c.Tokens = max0(c.Tokens - estTokens)
+		}
+		for _, bucket := range []map[string]*counters{m.minute, m.hour, m.day} {
+			c := m.getCounterLocked(bucket, k)
+			c.Cost -= estCost
+			if c.Cost < 0 {
+				c.Cost = 0
+			}
+		}
 	}
 	return nil
 }
 
+// costLimitFor returns the USD limit for a scope key and window, including temporary overrides.
+func (m *memoryLimiter) costLimitFor(key, window string) float64 {
+	return overrideCost(costLimitFor(m.cfg.Load(), key, window), m.overrides[key], window)
+}
+
+func (m *memoryLimiter) pruneOverridesLocked(now time.Time) {
+	for k, o := range m.overrides {
+		if o.expired(now) {
+			delete(m.overrides, k)
+		}
+	}
+}
+
+func (m *memoryLimiter) Usage(ctx context.Context, prefix string, now time.Time) ([]ScopeUsage, error) {
+	_ = ctx
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rotateWindowsLocked(now)
+	m.pruneOverridesLocked(now)
+
+	seen := make(map[string]bool)
+	for _, bucket := range []map[string]*counters{m.minute, m.hour, m.day} {
+		for k := range bucket {
+			seen[k] = true
+		}
+	}
+	for k := range m.overrides {
+		seen[k] = true
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	out := make([]ScopeUsage, 0, len(keys))
+	for _, k := range keys {
+		u := ScopeUsage{
+			ScopeKey: k,
+			Limits: effectiveLimits(m.limitFor(k, true), m.limitFor(k, false),
+				m.costLimitFor(k, windowMinute), m.costLimitFor(k, windowHour), m.costLimitFor(k, windowDay)),
+		}
+		if c, ok := m.minute[k]; ok {
+			u.Minute = UsageCounters(*c)
+		}
+		if c, ok := m.hour[k]; ok {
+			u.Hour = UsageCounters(*c)
+		}
+		if c, ok := m.day[k]; ok {
+			u.Day = UsageCounters(*c)
+		}
+		if o, ok := m.overrides[k]; ok {
+			cp := *o
+			u.Override = &cp
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+func (m *memoryLimiter) Reset(ctx context.Context, scopeKey string, now time.Time) error {
+	_ = ctx
+	_ = now
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.minute, scopeKey)
+	delete(m.hour, scopeKey)
+	delete(m.day, scopeKey)
+	return nil
+}
+
+func (m *memoryLimiter) SetOverride(ctx context.Context, scopeKey string, limits config.LimitsConfig, expiresAt time.Time) error {
+	_ = ctx
+	if err := checkOverrideExpiry(expiresAt); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overrides[scopeKey] = &TemporaryOverride{Limits: limits, ExpiresAt: expiresAt}
+	return nil
+}
+
+func (m *memoryLimiter) ClearOverride(ctx context.Context, scopeKey string) error {
+	_ = ctx
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.overrides, scopeKey)
+	return nil
+}
+
 func (m *memoryLimiter) rotateWindowsLocked(now time.Time) {
 	min := now.Truncate(time.Minute)
 	if !min.Equal(m.minTick) {
 		m.minute = make(map[string]*counters)
 		m.minTick = min
 	}
+	hour := now.Truncate(time.Hour)
+	if !hour.Equal(m.hourTick) {
+		m.hour = make(map[string]*counters)
+		m.hourTick = hour
+	}
 	day := now.Truncate(24 * time.Hour)
 	if !day.Equal(m.dayTick) {
 		m.day = make(map[string]*counters)
@@ -163,80 +354,25 @@ func (m *memoryLimiter) exceeds(c *counters, lim limits, addTokens int) bool {
 	return false
 }
 
+// exceedsCost mirrors the optimistic token policy: the first cost-bearing request
+// in a window is allowed, after which the estimate must fit under the limit.
+func exceedsCost(c *counters, limit, addCost float64) bool {
+	if limit <= 0 || c.Cost == 0 {
+		return false
+	}
+	return c.Cost+addCost > limit
+}
+
 type limits struct {
 	reqPerWindow int
 	tokPerWindow int
 }
 
 func (m *memoryLimiter) limitFor(key string, minute bool) limits {
-	base := m.cfg.Features.RateLimiting.Limits
-	lim := limits{}
-	if minute {
-		lim.reqPerWindow = base.RequestsPerMinute
-		lim.tokPerWindow = base.TokensPerMinute
-	} else {
-		lim.reqPerWindow = base.RequestsPerDay
-		lim.tokPerWindow = base.TokensPerDay
-	}
-	// Apply overrides by key namespace
-	overrides := m.cfg.Features.RateLimiting.Overrides
-	if strings.HasPrefix(key, "model:") {
-		name := strings.TrimPrefix(key, "model:")
-		if o, ok := overrides.PerModel[name]; ok {
-			if minute {
-				if o.RequestsPerMinute > 0 {
-					lim.reqPerWindow = o.RequestsPerMinute
-				}
-				if o.TokensPerMinute > 0 {
-					lim.tokPerWindow = o.TokensPerMinute
-				}
-			} else {
-				if o.RequestsPerDay > 0 {
-					lim.reqPerWindow = o.RequestsPerDay
-				}
-				if o.TokensPerDay > 0 {
-					lim.tokPerWindow = o.TokensPerDay
-				}
-			}
-		}
-	} else if strings.HasPrefix(key, "key:") {
-		id := strings.TrimPrefix(key, "key:")
-		if o, ok := overrides.PerKey[id]; ok {
-			if minute {
-				if o.RequestsPerMinute > 0 {
-					lim.reqPerWindow = o.RequestsPerMinute
-				}
-				if o.TokensPerMinute > 0 {
-					lim.tokPerWindow = o.TokensPerMinute
-				}
-			} else {
-				if o.RequestsPerDay > 0 {
-					lim.reqPerWindow = o.RequestsPerDay
-				}
-				if o.TokensPerDay > 0 {
-					lim.tokPerWindow = o.TokensPerDay
-				}
-			}
-		}
-	} else if strings.HasPrefix(key, "user:") {
-		id := strings.TrimPrefix(key, "user:")
-		if o, ok := overrides.PerUser[id]; ok {
-			if minute {
-				if o.RequestsPerMinute > 0 {
-					lim.reqPerWindow = o.RequestsPerMinute
-				}
-				if o.TokensPerMinute > 0 {
-					lim.tokPerWindow = o.TokensPerMinute
-				}
-			} else {
-				if o.RequestsPerDay > 0 {
-					lim.reqPerWindow = o.RequestsPerDay
-				}
-				if o.TokensPerDay > 0 {
-					lim.tokPerWindow = o.TokensPerDay
-				}
-			}
-		}
+	lim := m.cfg.Load().limitsFor(key, minute)
+	// Temporary overrides from the admin API take precedence
+	if o, ok := m.overrides[key]; ok {
+		lim = applyOverride(lim, o.Limits, minute)
 	}
 	return lim
 }
@@ -257,6 +393,9 @@ func (m *memoryLimiter) scopeKeys(scope ScopeKeys) []string {
 	if scope.UserID != "" {
 		keys = append(keys, "user:"+scope.UserID)
 	}
+	if scope.TeamID != "" {
+		keys = append(keys, "team:"+scope.TeamID)
+	}
 	return keys
 }
 
@@ -2,6 +2,8 @@ package ratelimit
 
 import (
 	"context"
+	"errors"
+	"math"
 	"testing"
 	"time"
 
@@ -28,14 +30,14 @@ func TestMemoryLimiterRequestsPerMinute(t *testing.T) {
 	scope := ScopeKeys{Provider: "openai", Model: "gpt-4o", UserID: "u1"}
 	now := time.Now()
 	// First two allowed
-	if res, _ := lim.CheckAndReserve(context.Background(), "1", scope, 10, now); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "1", scope, 10, 0, now); !res.Allowed {
 		t.Fatalf("first should be allowed")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "2", scope, 10, now); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "2", scope, 10, 0, now); !res.Allowed {
 		t.Fatalf("second should be allowed")
 	}
 	// Third should be blocked
-	if res, _ := lim.CheckAndReserve(context.Background(), "3", scope, 10, now); res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "3", scope, 10, 0, now); res.Allowed {
 		t.Fatalf("third should be blocked by minute limit")
 	}
 }
@@ -46,10 +48,10 @@ func TestMemoryLimiterTokensPerMinute(t *testing.T) {
 	scope := ScopeKeys{Provider: "openai", Model: "gpt-4o", UserID: "u2"}
 	now := time.Now()
 
-	if res, _ := lim.CheckAndReserve(context.Background(), "1", scope, 60, now); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "1", scope, 60, 0, now); !res.Allowed {
 		t.Fatalf("first should be allowed")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "2", scope, 50, now); res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "2", scope, 50, 0, now); res.Allowed {
 		t.Fatalf("second should be blocked by token per minute limit")
 	}
 }
@@ -63,11 +65,11 @@ func TestMemoryLimiterDailyWindow(t *testing.T) {
 	now := time.Now()
 
 	for i := 0; i < 5; i++ {
-		if res, _ := lim.CheckAndReserve(context.Background(), "x", scope, 10, now); !res.Allowed {
+		if res, _ := lim.CheckAndReserve(context.Background(), "x", scope, 10, 0, now); !res.Allowed {
 			t.Fatalf("request %d should be allowed", i)
 		}
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "y", scope, 10, now); res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "y", scope, 10, 0, now); res.Allowed {
 		t.Fatalf("sixth should be blocked by day limit")
 	}
 }
@@ -81,10 +83,10 @@ func TestMemoryLimiterOverrides(t *testing.T) {
 	scope := ScopeKeys{UserID: "special"}
 	now := time.Now()
 
-	if res, _ := lim.CheckAndReserve(context.Background(), "1", scope, 1, now); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "1", scope, 1, 0, now); !res.Allowed {
 		t.Fatalf("first should be allowed for override")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "2", scope, 1, now); res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "2", scope, 1, 0, now); res.Allowed {
 		t.Fatalf("second should be blocked by override")
 	}
 }
@@ -99,10 +101,10 @@ func TestMemoryLimiterUserTokensPerMinute(t *testing.T) {
 	scope := ScopeKeys{UserID: "tu"}
 	now := time.Now()
 
-	if res, _ := lim.CheckAndReserve(context.Background(), "1", scope, 40, now); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "1", scope, 40, 0, now); !res.Allowed {
 		t.Fatalf("first should be allowed")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "2", scope, 20, now); res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "2", scope, 20, 0, now); res.Allowed {
 		t.Fatalf("second should be blocked by user token override")
 	}
 }
@@ -117,13 +119,13 @@ func TestMemoryLimiterUserRequestsPerDay(t *testing.T) {
 	scope := ScopeKeys{UserID: "ud"}
 	now := time.Now()
 
-	if res, _ := lim.CheckAndReserve(context.Background(), "1", scope, 1, now); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "1", scope, 1, 0, now); !res.Allowed {
 		t.Fatalf("first should be allowed")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "2", scope, 1, now); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "2", scope, 1, 0, now); !res.Allowed {
 		t.Fatalf("second should be allowed")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "3", scope, 1, now); res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "3", scope, 1, 0, now); res.Allowed {
 		t.Fatalf("third should be blocked by user daily request override")
 	}
 }
@@ -143,33 +145,33 @@ func TestMemoryLimiterDailyWindowReset(t *testing.T) {
 	day1 := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
 
 	// Consume daily request limit
-	if res, _ := lim.CheckAndReserve(context.Background(), "r1", scope, 10, day1); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "r1", scope, 10, 0, day1); !res.Allowed {
 		t.Fatalf("first request should be allowed on day1")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "r2", scope, 10, day1); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "r2", scope, 10, 0, day1); !res.Allowed {
 		t.Fatalf("second request should be allowed on day1")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "r3", scope, 10, day1); res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "r3", scope, 10, 0, day1); res.Allowed {
 		t.Fatalf("third request should be blocked on day1 by daily request limit")
 	}
 
 	// Advance to next day and verify counters reset
 	day2 := day1.Add(25 * time.Hour)
-	if res, _ := lim.CheckAndReserve(context.Background(), "r4", scope, 10, day2); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "r4", scope, 10, 0, day2); !res.Allowed {
 		t.Fatalf("request should be allowed on day2 after daily reset")
 	}
 
 	// Test tokens-per-day reset as well: consume up to 100 then block, then allow next day
 	// Finish consuming tokens on day2
-	if res, _ := lim.CheckAndReserve(context.Background(), "t1", scope, 90, day2); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "t1", scope, 90, 0, day2); !res.Allowed {
 		t.Fatalf("token reservation should be allowed to reach daily token limit on day2")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "t2", scope, 1, day2); res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "t2", scope, 1, 0, day2); res.Allowed {
 		t.Fatalf("token reservation should be blocked after exceeding daily token limit on day2")
 	}
 
 	day3 := day2.Add(25 * time.Hour)
-	if res, _ := lim.CheckAndReserve(context.Background(), "t3", scope, 50, day3); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "t3", scope, 50, 0, day3); !res.Allowed {
 		t.Fatalf("token reservation should be allowed on day3 after daily reset")
 	}
 }
@@ -190,39 +192,243 @@ func TestMemoryLimiterPerAPIKeyMinuteAndDay(t *testing.T) {
 	now := time.Now()
 
 	// Minute requests (key k1)
-	if res, _ := lim.CheckAndReserve(context.Background(), "m1", ScopeKeys{APIKey: "k1"}, 1, now); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "m1", ScopeKeys{APIKey: "k1"}, 1, 0, now); !res.Allowed {
 		t.Fatalf("first request per minute for key should be allowed")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "m2", ScopeKeys{APIKey: "k1"}, 1, now); res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "m2", ScopeKeys{APIKey: "k1"}, 1, 0, now); res.Allowed {
 		t.Fatalf("second request per minute for key should be blocked")
 	}
 
 	// Minute tokens (key k1, rotate minute)
 	now2 := now.Add(61 * time.Second)
-	if res, _ := lim.CheckAndReserve(context.Background(), "t1", ScopeKeys{APIKey: "k1"}, 25, now2); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "t1", ScopeKeys{APIKey: "k1"}, 25, 0, now2); !res.Allowed {
 		t.Fatalf("first token reservation per minute should be allowed")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "t2", ScopeKeys{APIKey: "k1"}, 10, now2); res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "t2", ScopeKeys{APIKey: "k1"}, 10, 0, now2); res.Allowed {
 		t.Fatalf("second token reservation per minute should be blocked")
 	}
 
 	// Day requests (key k2) across different minutes within same day
 	day := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
-	if res, _ := lim.CheckAndReserve(context.Background(), "d1", ScopeKeys{APIKey: "k2"}, 1, day); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "d1", ScopeKeys{APIKey: "k2"}, 1, 0, day); !res.Allowed {
 		t.Fatalf("first day request per key should be allowed")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "d2", ScopeKeys{APIKey: "k2"}, 1, day.Add(2*time.Minute)); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "d2", ScopeKeys{APIKey: "k2"}, 1, 0, day.Add(2*time.Minute)); !res.Allowed {
 		t.Fatalf("second day request per key should be allowed")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "d3", ScopeKeys{APIKey: "k2"}, 1, day.Add(4*time.Minute)); res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "d3", ScopeKeys{APIKey: "k2"}, 1, 0, day.Add(4*time.Minute)); res.Allowed {
 		t.Fatalf("third day request per key should be blocked")
 	}
 
 	// Day tokens (key k3) across different minutes within same day
-	if res, _ := lim.CheckAndReserve(context.Background(), "dt1", ScopeKeys{APIKey: "k3"}, 45, day.Add(6*time.Minute)); !res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "dt1", ScopeKeys{APIKey: "k3"}, 45, 0, day.Add(6*time.Minute)); !res.Allowed {
 		t.Fatalf("first day token reservation per key should be allowed")
 	}
-	if res, _ := lim.CheckAndReserve(context.Background(), "dt2", ScopeKeys{APIKey: "k3"}, 10, day.Add(8*time.Minute)); res.Allowed {
+	if res, _ := lim.CheckAndReserve(context.Background(), "dt2", ScopeKeys{APIKey: "k3"}, 10, 0, day.Add(8*time.Minute)); res.Allowed {
 		t.Fatalf("second day token reservation per key should be blocked")
 	}
 }
+
+func TestMemoryLimiterCostLimits(t *testing.T) {
+	cfg := baseCfg()
+	cfg.Features.RateLimiting.Limits = config.LimitsConfig{CostPerDay: 2}
+	cfg.Features.RateLimiting.Overrides.PerTeam = map[string]config.LimitsConfig{
+		"t1": {CostPerHour: 1},
+	}
+	lim := NewMemoryLimiter(cfg)
+	scope := ScopeKeys{TeamID: "t1"}
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	if res, _ := lim.CheckAndReserve(context.Background(), "c1", scope, 0, 0.6, now); !res.Allowed {
+		t.Fatalf("first cost reservation should be allowed")
+	}
+	res, _ := lim.CheckAndReserve(context.Background(), "c2", scope, 0, 0.6, now)
+	if res.Allowed {
+		t.Fatalf("second cost reservation should exceed hourly team limit")
+	}
+	if res.Reason != "hourly cost limit exceeded" || res.Details == nil || res.Details.Metric != "cost" || res.Details.ScopeKey != "team:t1" {
+		t.Fatalf("unexpected denial: reason=%q details=%+v", res.Reason, res.Details)
+	}
+
+	// Reconcile down to actual cost; the same reservation now fits
+	if err := lim.Adjust(context.Background(), "c1", scope, 0, -0.5, now); err != nil {
+		t.Fatalf("adjust failed: %v", err)
+	}
+	if res, _ := lim.CheckAndReserve(context.Background(), "c3", scope, 0, 0.6, now); !res.Allowed {
+		t.Fatalf("reservation after downward adjust should be allowed")
+	}
+
+	// Next hour resets the hourly window, but the daily global limit still applies
+	next := now.Add(time.Hour)
+	if res, _ := lim.CheckAndReserve(context.Background(), "c4", scope, 0, 0.9, next); !res.Allowed {
+		t.Fatalf("reservation in next hour should be allowed")
+	}
+	res, _ = lim.CheckAndReserve(context.Background(), "c5", scope, 0, 0.5, next.Add(time.Hour))
+	if res.Allowed || res.Reason != "daily cost limit exceeded" || res.Details.ScopeKey != "global" {
+		t.Fatalf("expected daily global cost denial, got allowed=%v reason=%q", res.Allowed, res.Reason)
+	}
+}
+
+func TestMemoryLimiterAllowedReportsTightestLimit(t *testing.T) {
+	cfg := baseCfg() // 2 rpm, 100 tpm, 5 rpd, 500 tpd
+	lim := NewMemoryLimiter(cfg)
+	scope := ScopeKeys{Provider: "openai"}
+	now := time.Now()
+
+	res, _ := lim.CheckAndReserve(context.Background(), "1", scope, 10, 0, now)
+	if !res.Allowed || res.Details == nil {
+		t.Fatalf("expected allowed reservation with details, got %+v", res)
+	}
+	// 1 of 2 requests per minute leaves the least headroom
+	d := res.Details
+	if d.Metric != "requests" || d.Window != "minute" || d.Limit != 2 || d.Remaining != 1 {
+		t.Fatalf("unexpected tightest limit: %+v", d)
+	}
+	if d.ResetSeconds < 1 || d.ResetSeconds > 60 {
+		t.Fatalf("unexpected reset: %d", d.ResetSeconds)
+	}
+
+	// A cost window with less headroom is reported instead
+	cfg.Features.RateLimiting.Limits.CostPerHour = 1
+	res, _ = NewMemoryLimiter(cfg).CheckAndReserve(context.Background(), "2", scope, 10, 0.75, now)
+	if d := res.Details; !res.Allowed || d == nil || d.Metric != "cost" || d.Window != "hour" || d.CostLimit != 1 || d.CostRemaining != 0.25 {
+		t.Fatalf("expected the hourly cost limit, got %+v", res.Details)
+	}
+	if d := res.Details; d.ResetSeconds < 1 || d.ResetSeconds > 3600 {
+		t.Fatalf("unexpected cost reset: %d", d.ResetSeconds)
+	}
+
+	cfg.Features.RateLimiting.Limits = config.LimitsConfig{}
+	res, _ = NewMemoryLimiter(cfg).CheckAndReserve(context.Background(), "3", scope, 10, 0, now)
+	if !res.Allowed || res.Details != nil {
+		t.Fatalf("expected no details without limits, got %+v", res.Details)
+	}
+}
+
+func TestMemoryLimiterTemporaryOverrideExpires(t *testing.T) {
+	cfg := baseCfg()
+	cfg.Features.RateLimiting.Limits = config.LimitsConfig{}
+	lim := NewMemoryLimiter(cfg)
+	ctx := context.Background()
+	scope := ScopeKeys{UserID: "u1"}
+	now := time.Now()
+
+	if err := lim.SetOverride(ctx, "user:u1", config.LimitsConfig{RequestsPerDay: 1, CostPerDay: 5}, now.Add(time.Minute)); err != nil {
+		t.Fatalf("set override: %v", err)
+	}
+	if res, _ := lim.CheckAndReserve(ctx, "1", scope, 0, 0, now); !res.Allowed {
+		t.Fatalf("first request should be allowed")
+	}
+	if res, _ := lim.CheckAndReserve(ctx, "2", scope, 0, 0, now); res.Allowed {
+		t.Fatalf("second request should be blocked by temporary override")
+	}
+
+	usage, _ := lim.Usage(ctx, "user:", now)
+	if len(usage) != 1 || usage[0].Override == nil || usage[0].Limits.RequestsPerDay != 1 || usage[0].Limits.CostPerDay != 5 || usage[0].Day.Requests != 1 {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+
+	// After expiry the configured (unlimited) limits apply again
+	later := now.Add(2 * time.Minute)
+	if res, _ := lim.CheckAndReserve(ctx, "3", scope, 0, 0, later); !res.Allowed {
+		t.Fatalf("request after override expiry should be allowed")
+	}
+	if usage, _ := lim.Usage(ctx, "user:", later); usage[0].Override != nil {
+		t.Fatalf("expired override should not be reported")
+	}
+}
+
+func TestMemoryLimiterRejectsExpiredOverride(t *testing.T) {
+	lim := NewMemoryLimiter(baseCfg())
+	ctx := context.Background()
+	if err := lim.SetOverride(ctx, "user:u1", config.LimitsConfig{RequestsPerDay: 1}, time.Now().Add(-time.Minute)); !errors.Is(err, ErrOverrideExpired) {
+		t.Fatalf("expected ErrOverrideExpired for a past expiry, got %v", err)
+	}
+	if usage, _ := lim.Usage(ctx, "user:", time.Now()); len(usage) != 0 {
+		t.Fatalf("expired override should not be stored: %+v", usage)
+	}
+}
+
+func TestMemoryLimiterReserveRequest(t *testing.T) {
+	cfg := baseCfg()
+	cfg.Features.RateLimiting.Limits = config.LimitsConfig{RequestsPerMinute: 3, TokensPerMinute: 10, CostPerMinute: 0.01}
+	lim := NewMemoryLimiter(cfg)
+	ctx := context.Background()
+	scope := ScopeKeys{UserID: "u1"}
+	now := time.Now()
+
+	// The first request is allowed optimistically and spends the token and cost budgets
+	if res, _ := lim.CheckAndReserve(ctx, "1", scope, 50, 0.05, now); !res.Allowed {
+		t.Fatalf("first request should be allowed")
+	}
+	if res, _ := lim.CheckAndReserve(ctx, "2", scope, 1, 0.001, now); res.Allowed {
+		t.Fatalf("expected the token budget to be exhausted")
+	}
+	if res, _ := lim.ReserveRequest(ctx, "3", scope, now); !res.Allowed {
+		t.Fatalf("request-only reservations should ignore token and cost limits, got %q", res.Reason)
+	}
+	if res, _ := lim.ReserveRequest(ctx, "4", scope, now); !res.Allowed {
+		t.Fatalf("third request should be allowed")
+	}
+	res, _ := lim.ReserveRequest(ctx, "5", scope, now)
+	if res.Allowed || res.Details == nil || res.Details.Metric != "requests" {
+		t.Fatalf("expected the request limit to apply, got %+v", res)
+	}
+	usage, _ := lim.Usage(ctx, "user:", now)
+	if len(usage) != 1 || usage[0].Minute.Tokens != 50 {
+		t.Fatalf("request-only reservations should not add tokens: %+v", usage)
+	}
+}
+
+func TestMemoryLimiterCancelRefunds(t *testing.T) {
+	cfg := baseCfg()
+	cfg.Features.RateLimiting.Limits = config.LimitsConfig{RequestsPerMinute: 10, TokensPerMinute: 1000, CostPerHour: 1}
+	lim := NewMemoryLimiter(cfg)
+	ctx := context.Background()
+	scope := ScopeKeys{UserID: "u1"}
+	now := time.Now()
+
+	for _, id := range []string{"1", "2"} {
+		if res, _ := lim.CheckAndReserve(ctx, id, scope, 50, 0.05, now); !res.Allowed {
+			t.Fatalf("request %s should be allowed", id)
+		}
+	}
+	if err := lim.Cancel(ctx, "2", scope, 50, 0.05, now); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	usage, _ := lim.Usage(ctx, "user:", now)
+	if len(usage) != 1 {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+	u := usage[0]
+	if u.Minute.Requests != 1 || u.Minute.Tokens != 50 || u.Day.Requests != 1 || u.Day.Tokens != 50 {
+		t.Fatalf("expected one request and its tokens to remain, got %+v", u)
+	}
+	if math.Abs(u.Minute.Cost-0.05) > 1e-9 || math.Abs(u.Hour.Cost-0.05) > 1e-9 || math.Abs(u.Day.Cost-0.05) > 1e-9 {
+		t.Fatalf("expected the cancelled cost to be refunded in every window, got %+v", u)
+	}
+}
+
+func TestMemoryLimiterSetConfigKeepsCounters(t *testing.T) {
+	lim := NewMemoryLimiter(baseCfg())
+	scope := ScopeKeys{Provider: "openai", Model: "gpt-4o", UserID: "u9"}
+	now := time.Now()
+	for i, id := range []string{"1", "2"} {
+		if res, _ := lim.CheckAndReserve(context.Background(), id, scope, 10, 0, now); !res.Allowed {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+
+	// Raising the limit lets the third request through; lowering it again
+	// blocks the fourth, since counters survive the swap
+	raised := baseCfg()
+	raised.Features.RateLimiting.Limits.RequestsPerMinute = 3
+	lim.SetConfig(raised)
+	if res, _ := lim.CheckAndReserve(context.Background(), "3", scope, 10, 0, now); !res.Allowed {
+		t.Fatalf("third should be allowed after raising the limit")
+	}
+	lim.SetConfig(baseCfg())
+	if res, _ := lim.CheckAndReserve(context.Background(), "4", scope, 10, 0, now); res.Allowed {
+		t.Fatalf("fourth should be blocked after lowering the limit")
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/config"
+)
+
+// ErrOverrideExpired is returned by SetOverride for an expiry that is not in the future
+var ErrOverrideExpired = errors.New("override expiry must be in the future")
+
+// checkOverrideExpiry rejects temporary overrides that would already have
+// expired, so every backend refuses the same requests
+func checkOverrideExpiry(expiresAt time.Time) error {
+	if !expiresAt.After(time.Now()) {
+		return ErrOverrideExpired
+	}
+	return nil
+}
+
+// configuredOverride returns the per_model, per_key, per_user or per_team
+// override configured for a scope key, by its namespace
+func (c *limiterConfig) configuredOverride(key string) (config.LimitsConfig, bool) {
+	overrides := c.Features.RateLimiting.Overrides
+	var group map[string]config.LimitsConfig
+	namespace, id, _ := strings.Cut(key, ":")
+	switch namespace {
+	case "model":
+		group = overrides.PerModel
+	case "key":
+		return c.perKeyOverride(id)
+	case "user":
+		group = overrides.PerUser
+	case "team":
+		group = overrides.PerTeam
+	}
+	o, ok := group[id]
+	return o, ok
+}
+
+// limitsFor returns the configured request/token limits for a scope key and
+// window, with its configured override applied
+func (c *limiterConfig) limitsFor(key string, minute bool) limits {
+	base := c.Features.RateLimiting.Limits
+	lim := limits{reqPerWindow: base.RequestsPerDay, tokPerWindow: base.TokensPerDay}
+	if minute {
+		lim = limits{reqPerWindow: base.RequestsPerMinute, tokPerWindow: base.TokensPerMinute}
+	}
+	if o, ok := c.configuredOverride(key); ok {
+		lim = applyOverride(lim, o, minute)
+	}
+	return lim
+}
+
+// applyOverride returns lim with positive request/token values from o for the window.
+func applyOverride(lim limits, o config.LimitsConfig, minute bool) limits {
+	if minute {
+		if o.RequestsPerMinute > 0 {
+			lim.reqPerWindow = o.RequestsPerMinute
+		}
+		if o.TokensPerMinute > 0 {
+			lim.tokPerWindow = o.TokensPerMinute
+		}
+		return lim
+	}
+	if o.RequestsPerDay > 0 {
+		lim.reqPerWindow = o.RequestsPerDay
+	}
+	if o.TokensPerDay > 0 {
+		lim.tokPerWindow = o.TokensPerDay
+	}
+	return lim
+}
+
+// overrideCost returns the temporary cost limit for the window when set, else limit.
+func overrideCost(limit float64, o *TemporaryOverride, window string) float64 {
+	if o != nil {
+		if v := costForWindow(o.Limits, window); v > 0 {
+			return v
+		}
+	}
+	return limit
+}
+
+// effectiveLimits assembles the limits that currently apply to a scope key.
+func effectiveLimits(minLim, dayLim limits, costMin, costHour, costDay float64) config.LimitsConfig {
+	return config.LimitsConfig{
+		RequestsPerMinute: minLim.reqPerWindow,
+		TokensPerMinute:   minLim.tokPerWindow,
+		RequestsPerDay:    dayLim.reqPerWindow,
+		TokensPerDay:      dayLim.tokPerWindow,
+		CostPerMinute:     costMin,
+		CostPerHour:       costHour,
+		CostPerDay:        costDay,
+	}
+}
+
+func (o *TemporaryOverride) expired(now time.Time) bool {
+	return !now.Before(o.ExpiresAt)
+}
@@ -2,17 +2,22 @@ package ratelimit
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Instawork/llm-proxy/internal/config"
+	"github.com/Instawork/llm-proxy/internal/tracing"
 	redis "github.com/redis/go-redis/v9"
 )
 
 // redisLimiter is a Redis-backed rate limiter mirroring memoryLimiter behavior.
 type redisLimiter struct {
-	cfg *config.YAMLConfig
+	cfg atomic.Pointer[limiterConfig]
 	rdb *redis.Client
 }
 
@@ -26,7 +31,20 @@ func NewRedisLimiter(cfg *config.YAMLConfig) (RateLimiter, error) {
 		Password: r.Password,
 		DB:       r.DB,
 	})
-	return &redisLimiter{cfg: cfg, rdb: client}, nil
+	client.AddHook(tracing.RedisHook{})
+	limiter := &redisLimiter{rdb: client}
+	limiter.cfg.Store(newLimiterConfig(cfg))
+	return limiter, nil
+}
+
+// SetConfig swaps in the limits and overrides of cfg
+func (r *redisLimiter) SetConfig(cfg *config.YAMLConfig) {
+	r.cfg.Store(newLimiterConfig(cfg))
+}
+
+// Config returns the configuration in effect
+func (r *redisLimiter) Config() *config.YAMLConfig {
+	return r.cfg.Load().YAMLConfig
 }
 
 func (r *redisLimiter) scopeKeys(scope ScopeKeys) []string {
@@ -43,6 +61,9 @@ func (r *redisLimiter) scopeKeys(scope ScopeKeys) []string {
 	if scope.UserID != "" {
 		keys = append(keys, "user:"+scope.UserID)
 	}
+	if scope.TeamID != "" {
+		keys = append(keys, "team:"+scope.TeamID)
+	}
 	return keys
 }
 
@@ -52,80 +73,15 @@ type rlLimits struct {
 }
 
 func (r *redisLimiter) limitFor(key string, minute bool) rlLimits {
-	base := r.cfg.Features.RateLimiting.Limits
-	lim := rlLimits{}
-	if minute {
-		lim.reqPerWindow = base.RequestsPerMinute
-		lim.tokPerWindow = base.TokensPerMinute
-	} else {
-		lim.reqPerWindow = base.RequestsPerDay
-		lim.tokPerWindow = base.TokensPerDay
-	}
-
-	overrides := r.cfg.Features.RateLimiting.Overrides
-	if strings.HasPrefix(key, "model:") {
-		name := strings.TrimPrefix(key, "model:")
-		if o, ok := overrides.PerModel[name]; ok {
-			if minute {
-				if o.RequestsPerMinute > 0 {
-					lim.reqPerWindow = o.RequestsPerMinute
-				}
-				if o.TokensPerMinute > 0 {
-					lim.tokPerWindow = o.TokensPerMinute
-				}
-			} else {
-				if o.RequestsPerDay > 0 {
-					lim.reqPerWindow = o.RequestsPerDay
-				}
-				if o.TokensPerDay > 0 {
-					lim.tokPerWindow = o.TokensPerDay
-				}
-			}
-		}
-	} else if strings.HasPrefix(key, "key:") {
-		id := strings.TrimPrefix(key, "key:")
-		if o, ok := overrides.PerKey[id]; ok {
-			if minute {
-				if o.RequestsPerMinute > 0 {
-					lim.reqPerWindow = o.RequestsPerMinute
-				}
-				if o.TokensPerMinute > 0 {
-					lim.tokPerWindow = o.TokensPerMinute
-				}
-			} else {
-				if o.RequestsPerDay > 0 {
-					lim.reqPerWindow = o.RequestsPerDay
-				}
-				if o.TokensPerDay > 0 {
-					lim.tokPerWindow = o.TokensPerDay
-				}
-			}
-		}
-	} else if strings.HasPrefix(key, "user:") {
-		id := strings.TrimPrefix(key, "user:")
-		if o, ok := overrides.PerUser[id]; ok {
-			if minute {
-				if o.RequestsPerMinute > 0 {
-					lim.reqPerWindow = o.RequestsPerMinute
-				}
-				if o.TokensPerMinute > 0 {
-					lim.tokPerWindow = o.TokensPerMinute
-				}
-			} else {
-				if o.RequestsPerDay > 0 {
-					lim.reqPerWindow = o.RequestsPerDay
-				}
-				if o.TokensPerDay > 0 {
-					lim.tokPerWindow = o.TokensPerDay
-				}
-			}
-		}
-	}
-	return lim
+	return rlLimits(r.cfg.Load().limitsFor(key, minute))
 }
 
 func minuteKey(scopeKey string) string { return "rl:min:" + scopeKey }
-func dayKey(scopeKey string) string    { return "rl:day:" + scopeKey }
+func hourKey(scopeKey string) string   { return "rl:hour:" + scopeKey }
+
+// overrideKey stores a JSON TemporaryOverride; its TTL matches the expiry.
+func overrideKey(scopeKey string) string { return "rl:ovr:" + scopeKey }
+func dayKey(scopeKey string) string      { return "rl:day:" + scopeKey }
 
 func secToMinuteEnd(t time.Time) int {
 	s := int(t.Unix() % 60)
@@ -142,23 +98,29 @@ func secToDayEnd(t time.Time) int {
 	return int(time.Until(end).Seconds()) + 1
 }
 
+// Each scope key maps to three hashes (minute, hour, day). Requests and tokens
+// are tracked in the minute and day hashes; cost (in micro-dollars) is tracked
+// in all three.
 var luaCheckAndReserve = redis.NewScript(`
 local est = tonumber(ARGV[1])
-local ttlMin = tonumber(ARGV[2])
-local ttlDay = tonumber(ARGV[3])
-local pairsCount = tonumber(ARGV[4])
-local limitsBase = 5
-for i = 0, pairsCount - 1 do
-  local kMin = KEYS[2*i + 1]
-  local kDay = KEYS[2*i + 2]
+local estCost = tonumber(ARGV[2])
+local ttlMin = tonumber(ARGV[3])
+local ttlHour = tonumber(ARGV[4])
+local ttlDay = tonumber(ARGV[5])
+local scopeCount = tonumber(ARGV[6])
+local limitsBase = 7
+for i = 0, scopeCount - 1 do
+  local kMin = KEYS[3*i + 1]
+  local kHour = KEYS[3*i + 2]
+  local kDay = KEYS[3*i + 3]
   local minReq = tonumber(redis.call('HGET', kMin, 'req') or '0')
   local minTok = tonumber(redis.call('HGET', kMin, 'tok') or '0')
   local dayReq = tonumber(redis.call('HGET', kDay, 'req') or '0')
   local dayTok = tonumber(redis.call('HGET', kDay, 'tok') or '0')
-  local limMinReq = tonumber(ARGV[limitsBase + 4*i])
-  local limMinTok = tonumber(ARGV[limitsBase + 4*i + 1])
-  local limDayReq = tonumber(ARGV[limitsBase + 4*i + 2])
-  local limDayTok = tonumber(ARGV[limitsBase + 4*i + 3])
+  local limMinReq = tonumber(ARGV[limitsBase + 7*i])
+  local limMinTok = tonumber(ARGV[limitsBase + 7*i + 1])
+  local limDayReq = tonumber(ARGV[limitsBase + 7*i + 2])
+  local limDayTok = tonumber(ARGV[limitsBase + 7*i + 3])
   if limMinReq > 0 and (minReq + 1 > limMinReq) then
     local rem = limMinReq - (minReq + 1)
     if rem < 0 then rem = 0 end
@@ -187,73 +149,142 @@ for i = 0, pairsCount - 1 do
       end
     end
   end
+  local costWindows = {{kMin, 'minute', ttlMin}, {kHour, 'hour', ttlHour}, {kDay, 'day', ttlDay}}
+  for j = 1, 3 do
+    local limCost = tonumber(ARGV[limitsBase + 7*i + 3 + j])
+    if limCost > 0 then
+      local cur = tonumber(redis.call('HGET', costWindows[j][1], 'cost') or '0')
+      if not (cur == 0) then
+        if (cur + estCost > limCost) then
+          local rem = limCost - (cur + estCost)
+          if rem < 0 then rem = 0 end
+          return {0, costWindows[j][2], 'cost', limCost, rem, i, costWindows[j][3]}
+        end
+      end
+    end
+  end
 end
-for i = 0, pairsCount - 1 do
-  local kMin = KEYS[2*i + 1]
-  local kDay = KEYS[2*i + 2]
-  redis.call('HINCRBY', kMin, 'req', 1)
-  redis.call('HINCRBY', kMin, 'tok', est)
+-- Reserve and return post-reservation counters per scope:
+-- minReq, minTok, minCost, hourCost, dayReq, dayTok, dayCost
+local out = {1}
+for i = 0, scopeCount - 1 do
+  local kMin = KEYS[3*i + 1]
+  local kHour = KEYS[3*i + 2]
+  local kDay = KEYS[3*i + 3]
+  table.insert(out, redis.call('HINCRBY', kMin, 'req', 1))
+  table.insert(out, redis.call('HINCRBY', kMin, 'tok', est))
+  table.insert(out, redis.call('HINCRBY', kMin, 'cost', estCost))
   redis.call('EXPIRE', kMin, ttlMin)
-  redis.call('HINCRBY', kDay, 'req', 1)
-  redis.call('HINCRBY', kDay, 'tok', est)
+  table.insert(out, redis.call('HINCRBY', kHour, 'cost', estCost))
+  redis.call('EXPIRE', kHour, ttlHour)
+  table.insert(out, redis.call('HINCRBY', kDay, 'req', 1))
+  table.insert(out, redis.call('HINCRBY', kDay, 'tok', est))
+  table.insert(out, redis.call('HINCRBY', kDay, 'cost', estCost))
   redis.call('EXPIRE', kDay, ttlDay)
 end
-return {1}
+return out
 `)
 
 var luaAdjust = redis.NewScript(`
 local delta = tonumber(ARGV[1])
-local ttlMin = tonumber(ARGV[2])
-local ttlDay = tonumber(ARGV[3])
-local pairsCount = tonumber(ARGV[4])
-for i = 0, pairsCount - 1 do
-  local kMin = KEYS[2*i + 1]
-  local kDay = KEYS[2*i + 2]
+local costDelta = tonumber(ARGV[2])
+local ttlMin = tonumber(ARGV[3])
+local ttlHour = tonumber(ARGV[4])
+local ttlDay = tonumber(ARGV[5])
+local scopeCount = tonumber(ARGV[6])
+for i = 0, scopeCount - 1 do
+  local kMin = KEYS[3*i + 1]
+  local kHour = KEYS[3*i + 2]
+  local kDay = KEYS[3*i + 3]
   local newMin = redis.call('HINCRBY', kMin, 'tok', delta)
   if tonumber(newMin) < 0 then redis.call('HSET', kMin, 'tok', 0) end
-  redis.call('EXPIRE', kMin, ttlMin)
   local newDay = redis.call('HINCRBY', kDay, 'tok', delta)
   if tonumber(newDay) < 0 then redis.call('HSET', kDay, 'tok', 0) end
+  if not (costDelta == 0) then
+    for _, k in ipairs({kMin, kHour, kDay}) do
+      local newCost = redis.call('HINCRBY', k, 'cost', costDelta)
+      if tonumber(newCost) < 0 then redis.call('HSET', k, 'cost', 0) end
+    end
+  end
+  redis.call('EXPIRE', kMin, ttlMin)
+  redis.call('EXPIRE', kHour, ttlHour)
   redis.call('EXPIRE', kDay, ttlDay)
 end
 return {1}
 `)
 
 var luaCancel = redis.NewScript(`
-local ttlMin = tonumber(ARGV[1])
-local ttlDay = tonumber(ARGV[2])
-local pairsCount = tonumber(ARGV[3])
-for i = 0, pairsCount - 1 do
-  local kMin = KEYS[2*i + 1]
-  local kDay = KEYS[2*i + 2]
-  local newMinReq = redis.call('HINCRBY', kMin, 'req', -1)
-  if tonumber(newMinReq) < 0 then redis.call('HSET', kMin, 'req', 0) end
-  local minTok = tonumber(redis.call('HGET', kMin, 'tok') or '0')
-  if minTok < 0 then redis.call('HSET', kMin, 'tok', 0) end
+local est = tonumber(ARGV[1])
+local estCost = tonumber(ARGV[2])
+local ttlMin = tonumber(ARGV[3])
+local ttlHour = tonumber(ARGV[4])
+local ttlDay = tonumber(ARGV[5])
+local scopeCount = tonumber(ARGV[6])
+for i = 0, scopeCount - 1 do
+  local kMin = KEYS[3*i + 1]
+  local kHour = KEYS[3*i + 2]
+  local kDay = KEYS[3*i + 3]
+  for _, k in ipairs({kMin, kDay}) do
+    local newReq = redis.call('HINCRBY', k, 'req', -1)
+    if tonumber(newReq) < 0 then redis.call('HSET', k, 'req', 0) end
+    local newTok = redis.call('HINCRBY', k, 'tok', -est)
+    if tonumber(newTok) < 0 then redis.call('HSET', k, 'tok', 0) end
+  end
+  if not (estCost == 0) then
+    for _, k in ipairs({kMin, kHour, kDay}) do
+      local newCost = redis.call('HINCRBY', k, 'cost', -estCost)
+      if tonumber(newCost) < 0 then redis.call('HSET', k, 'cost', 0) end
+    end
+  end
   redis.call('EXPIRE', kMin, ttlMin)
-  local newDayReq = redis.call('HINCRBY', kDay, 'req', -1)
-  if tonumber(newDayReq) < 0 then redis.call('HSET', kDay, 'req', 0) end
-  local dayTok = tonumber(redis.call('HGET', kDay, 'tok') or '0')
-  if dayTok < 0 then redis.call('HSET', kDay, 'tok', 0) end
+  redis.call('EXPIRE', kHour, ttlHour)
   redis.call('EXPIRE', kDay, ttlDay)
 end
 return {1}
 `)
 
-func (r *redisLimiter) CheckAndReserve(ctx context.Context, id string, scope ScopeKeys, estTokens int, now time.Time) (ReservationResult, error) {
-	scopeKeys := r.scopeKeys(scope)
-	keys := make([]string, 0, len(scopeKeys)*2)
-	argLimits := make([]interface{}, 0, len(scopeKeys)*4)
+// redisKeys expands scope keys into the minute/hour/day hash keys expected by the scripts.
+func (r *redisLimiter) redisKeys(scopeKeys []string) []string {
+	keys := make([]string, 0, len(scopeKeys)*3)
 	for _, sk := range scopeKeys {
-		keys = append(keys, minuteKey(sk))
-		keys = append(keys, dayKey(sk))
-		minLim := r.limitFor(sk, true)
-		dayLim := r.limitFor(sk, false)
-		argLimits = append(argLimits, minLim.reqPerWindow, minLim.tokPerWindow, dayLim.reqPerWindow, dayLim.tokPerWindow)
+		keys = append(keys, minuteKey(sk), hourKey(sk), dayKey(sk))
 	}
-	argv := make([]interface{}, 0, 4+len(argLimits))
+	return keys
+}
+
+func (r *redisLimiter) CheckAndReserve(ctx context.Context, id string, scope ScopeKeys, estTokens int, estCost float64, now time.Time) (ReservationResult, error) {
+	return r.reserve(ctx, id, scope, estTokens, estCost, false, now)
+}
+
+func (r *redisLimiter) ReserveRequest(ctx context.Context, id string, scope ScopeKeys, now time.Time) (ReservationResult, error) {
+	return r.reserve(ctx, id, scope, 0, 0, true, now)
+}
+
+// reserve runs the reservation script; requestsOnly passes token and cost
+// limits as unlimited so only request limits apply
+func (r *redisLimiter) reserve(ctx context.Context, id string, scope ScopeKeys, estTokens int, estCost float64, requestsOnly bool, now time.Time) (ReservationResult, error) {
+	scopeKeys := r.scopeKeys(scope)
+	keys := r.redisKeys(scopeKeys)
+	overrides, err := r.loadOverrides(ctx, scopeKeys)
+	if err != nil {
+		return ReservationResult{}, err
+	}
+	effective := make([]config.LimitsConfig, len(scopeKeys))
+	argLimits := make([]interface{}, 0, len(scopeKeys)*7)
+	for i, sk := range scopeKeys {
+		l := r.effectiveLimits(sk, overrides[sk])
+		effective[i] = l
+		if requestsOnly {
+			l = config.LimitsConfig{RequestsPerMinute: l.RequestsPerMinute, RequestsPerDay: l.RequestsPerDay}
+		}
+		argLimits = append(argLimits, l.RequestsPerMinute, l.TokensPerMinute, l.RequestsPerDay, l.TokensPerDay,
+			toMicros(l.CostPerMinute), toMicros(l.CostPerHour), toMicros(l.CostPerDay))
+	}
+	argv := make([]interface{}, 0, 6+len(argLimits))
 	argv = append(argv, estTokens)
+	argv = append(argv, toMicros(estCost))
 	argv = append(argv, secToMinuteEnd(now))
+	argv = append(argv, secToHourEnd(now))
 	argv = append(argv, secToDayEnd(now))
 	argv = append(argv, len(scopeKeys))
 	argv = append(argv, argLimits...)
@@ -267,7 +298,21 @@ func (r *redisLimiter) CheckAndReserve(ctx context.Context, id string, scope Sco
 	}
 	okFlag, _ := arr[0].(int64)
 	if okFlag == 1 {
-		return ReservationResult{Allowed: true, ReservationID: id}, nil
+		var usages []windowUsage
+		if len(arr) == 1+7*len(scopeKeys) {
+			usages = make([]windowUsage, 0, len(scopeKeys)*3)
+			minReset, hourReset, dayReset := secToMinuteEnd(now), secToHourEnd(now), secToDayEnd(now)
+			for i, sk := range scopeKeys {
+				base, l := 1+7*i, effective[i]
+				usages = append(usages,
+					windowUsage{scopeKey: sk, window: windowMinute, requests: toInt(arr[base]), tokens: toInt(arr[base+1]), cost: fromMicros(int64(toInt(arr[base+2]))),
+						lim: limits{l.RequestsPerMinute, l.TokensPerMinute}, costLimit: l.CostPerMinute, resetSeconds: minReset},
+					windowUsage{scopeKey: sk, window: windowHour, cost: fromMicros(int64(toInt(arr[base+3]))), costLimit: l.CostPerHour, resetSeconds: hourReset},
+					windowUsage{scopeKey: sk, window: windowDay, requests: toInt(arr[base+4]), tokens: toInt(arr[base+5]), cost: fromMicros(int64(toInt(arr[base+6]))),
+						lim: limits{l.RequestsPerDay, l.TokensPerDay}, costLimit: l.CostPerDay, resetSeconds: dayReset})
+			}
+		}
+		return ReservationResult{Allowed: true, ReservationID: id, Details: tightestLimit(usages)}, nil
 	}
 	window, _ := arr[1].(string)
 	metric, _ := arr[2].(string)
@@ -279,40 +324,36 @@ func (r *redisLimiter) CheckAndReserve(ctx context.Context, id string, scope Sco
 	if idx >= 0 && idx < len(scopeKeys) {
 		scopeKey = scopeKeys[idx]
 	}
-	details := &LimitDetails{ScopeKey: scopeKey, Metric: metric, Window: window, Limit: limit, Remaining: remaining}
+	details := &LimitDetails{ScopeKey: scopeKey, Metric: metric, Window: window, Limit: limit, Remaining: remaining, ResetSeconds: retry}
 	reason := window + " limit exceeded"
+	if metric == "cost" {
+		details.Limit, details.Remaining = 0, 0
+		details.CostLimit = fromMicros(int64(limit))
+		details.CostRemaining = fromMicros(int64(remaining))
+		reason = costReason(window)
+	}
 	return ReservationResult{Allowed: false, RetryAfterSeconds: retry, Reason: reason, Details: details}, nil
 }
 
-func (r *redisLimiter) Adjust(ctx context.Context, id string, scope ScopeKeys, tokenDelta int, now time.Time) error {
+func (r *redisLimiter) Adjust(ctx context.Context, id string, scope ScopeKeys, tokenDelta int, costDelta float64, now time.Time) error {
 	_ = id
 	scopeKeys := r.scopeKeys(scope)
 	if len(scopeKeys) == 0 {
 		return nil
 	}
-	keys := make([]string, 0, len(scopeKeys)*2)
-	for _, sk := range scopeKeys {
-		keys = append(keys, minuteKey(sk))
-		keys = append(keys, dayKey(sk))
-	}
-	argv := []interface{}{tokenDelta, secToMinuteEnd(now), secToDayEnd(now), len(scopeKeys)}
-	_, err := luaAdjust.Run(ctx, r.rdb, keys, argv...).Result()
+	argv := []interface{}{tokenDelta, toMicros(costDelta), secToMinuteEnd(now), secToHourEnd(now), secToDayEnd(now), len(scopeKeys)}
+	_, err := luaAdjust.Run(ctx, r.rdb, r.redisKeys(scopeKeys), argv...).Result()
 	return err
 }
 
-func (r *redisLimiter) Cancel(ctx context.Context, id string, scope ScopeKeys, now time.Time) error {
+func (r *redisLimiter) Cancel(ctx context.Context, id string, scope ScopeKeys, estTokens int, estCost float64, now time.Time) error {
 	_ = id
 	scopeKeys := r.scopeKeys(scope)
 	if len(scopeKeys) == 0 {
 		return nil
 	}
-	keys := make([]string, 0, len(scopeKeys)*2)
-	for _, sk := range scopeKeys {
-		keys = append(keys, minuteKey(sk))
-		keys = append(keys, dayKey(sk))
-	}
-	argv := []interface{}{secToMinuteEnd(now), secToDayEnd(now), len(scopeKeys)}
-	_, err := luaCancel.Run(ctx, r.rdb, keys, argv...).Result()
+	argv := []interface{}{estTokens, toMicros(estCost), secToMinuteEnd(now), secToHourEnd(now), secToDayEnd(now), len(scopeKeys)}
+	_, err := luaCancel.Run(ctx, r.rdb, r.redisKeys(scopeKeys), argv...).Result()
 	return err
 }
 
@@ -330,3 +371,138 @@ func toInt(v interface{}) int {
 		return 0
 	}
 }
+
+// effectiveLimits combines configured limits and a temporary override for a scope key.
+func (r *redisLimiter) effectiveLimits(sk string, o *TemporaryOverride) config.LimitsConfig {
+	minLim := limits(r.limitFor(sk, true))
+	dayLim := limits(r.limitFor(sk, false))
+	if o != nil {
+		minLim = applyOverride(minLim, o.Limits, true)
+		dayLim = applyOverride(dayLim, o.Limits, false)
+	}
+	cfg := r.cfg.Load()
+	return effectiveLimits(minLim, dayLim,
+		overrideCost(costLimitFor(cfg, sk, windowMinute), o, windowMinute),
+		overrideCost(costLimitFor(cfg, sk, windowHour), o, windowHour),
+		overrideCost(costLimitFor(cfg, sk, windowDay), o, windowDay))
+}
+
+// loadOverrides fetches active temporary overrides for the given scope keys.
+func (r *redisLimiter) loadOverrides(ctx context.Context, scopeKeys []string) (map[string]*TemporaryOverride, error) {
+	if len(scopeKeys) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, len(scopeKeys))
+	for i, sk := range scopeKeys {
+		keys[i] = overrideKey(sk)
+	}
+	vals, err := r.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]*TemporaryOverride)
+	for i, v := range vals {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var o TemporaryOverride
+		if err := json.Unmarshal([]byte(str), &o); err != nil {
+			continue
+		}
+		out[scopeKeys[i]] = &o
+	}
+	return out, nil
+}
+
+func (r *redisLimiter) Usage(ctx context.Context, prefix string, now time.Time) ([]ScopeUsage, error) {
+	_ = now
+	seen := make(map[string]bool)
+	for _, ns := range []string{"rl:min:", "rl:hour:", "rl:day:", "rl:ovr:"} {
+		iter := r.rdb.Scan(ctx, 0, ns+escapeGlob(prefix)+"*", 200).Iterator()
+		for iter.Next(ctx) {
+			seen[strings.TrimPrefix(iter.Val(), ns)] = true
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+	scopeKeys := make([]string, 0, len(seen))
+	for sk := range seen {
+		scopeKeys = append(scopeKeys, sk)
+	}
+	sort.Strings(scopeKeys)
+
+	overrides, err := r.loadOverrides(ctx, scopeKeys)
+	if err != nil {
+		return nil, err
+	}
+	pipe := r.rdb.Pipeline()
+	cmds := make([][3]*redis.MapStringStringCmd, len(scopeKeys))
+	for i, sk := range scopeKeys {
+		cmds[i] = [3]*redis.MapStringStringCmd{
+			pipe.HGetAll(ctx, minuteKey(sk)),
+			pipe.HGetAll(ctx, hourKey(sk)),
+			pipe.HGetAll(ctx, dayKey(sk)),
+		}
+	}
+	if len(scopeKeys) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, err
+		}
+	}
+
+	out := make([]ScopeUsage, 0, len(scopeKeys))
+	for i, sk := range scopeKeys {
+		u := ScopeUsage{
+			ScopeKey: sk,
+			Minute:   usageFromHash(cmds[i][0].Val()),
+			Hour:     usageFromHash(cmds[i][1].Val()),
+			Day:      usageFromHash(cmds[i][2].Val()),
+			Limits:   r.effectiveLimits(sk, overrides[sk]),
+			Override: overrides[sk],
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+func (r *redisLimiter) Reset(ctx context.Context, scopeKey string, now time.Time) error {
+	_ = now
+	return r.rdb.Del(ctx, minuteKey(scopeKey), hourKey(scopeKey), dayKey(scopeKey)).Err()
+}
+
+func (r *redisLimiter) SetOverride(ctx context.Context, scopeKey string, limits config.LimitsConfig, expiresAt time.Time) error {
+	if err := checkOverrideExpiry(expiresAt); err != nil {
+		return err
+	}
+	data, err := json.Marshal(TemporaryOverride{Limits: limits, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	return r.rdb.Set(ctx, overrideKey(scopeKey), data, time.Until(expiresAt)).Err()
+}
+
+func (r *redisLimiter) ClearOverride(ctx context.Context, scopeKey string) error {
+	return r.rdb.Del(ctx, overrideKey(scopeKey)).Err()
+}
+
+func usageFromHash(h map[string]string) UsageCounters {
+	req, _ := strconv.Atoi(h["req"])
+	tok, _ := strconv.Atoi(h["tok"])
+	cost, _ := strconv.ParseInt(h["cost"], 10, 64)
+	return UsageCounters{Requests: req, Tokens: tok, Cost: fromMicros(cost)}
+}
+
+// escapeGlob escapes Redis glob metacharacters in a literal prefix.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import "math"
+
+// windowUsage holds post-reservation request/token/cost counts for one scope key and window.
+type windowUsage struct {
+	scopeKey     string
+	window       string
+	requests     int
+	tokens       int
+	cost         float64
+	lim          limits
+	costLimit    float64
+	resetSeconds int
+}
+
+// tightestLimit returns the configured request, token or cost limit that has
+// the least remaining headroom relative to its size, so that clients see the
+// limit they will hit first. Ties prefer the window that resets sooner.
+// Returns nil when no limits apply.
+func tightestLimit(usages []windowUsage) *LimitDetails {
+	var best *LimitDetails
+	var bestRatio float64
+	consider := func(cand LimitDetails, ratio float64) {
+		if best == nil || ratio < bestRatio || (ratio == bestRatio && cand.ResetSeconds < best.ResetSeconds) {
+			best, bestRatio = &cand, ratio
+		}
+	}
+	for _, u := range usages {
+		reset := maxInt(u.resetSeconds, 1)
+		for _, c := range []struct {
+			metric      string
+			limit, used int
+		}{
+			{"requests", u.lim.reqPerWindow, u.requests},
+			{"tokens", u.lim.tokPerWindow, u.tokens},
+		} {
+			if c.limit <= 0 {
+				continue
+			}
+			remaining := max0(c.limit - c.used)
+			consider(LimitDetails{ScopeKey: u.scopeKey, Metric: c.metric, Window: u.window, Limit: c.limit, Remaining: remaining, ResetSeconds: reset},
+				float64(remaining)/float64(c.limit))
+		}
+		if u.costLimit > 0 {
+			remaining := math.Max(u.costLimit-u.cost, 0)
+			consider(LimitDetails{ScopeKey: u.scopeKey, Metric: "cost", Window: u.window, CostLimit: u.costLimit, CostRemaining: remaining, ResetSeconds: reset},
+				remaining/u.costLimit)
+		}
+	}
+	return best
+}
@@ -12,11 +12,14 @@ import (
 type ScopeKeys struct {
 	Provider string
 	Model    string
-	APIKey   string
+	APIKey   string // key ID from KeyID, never the raw key
 	UserID   string
+	TeamID   string
 }
 
 // ReservationResult contains the outcome of a reservation attempt.
+// For allowed requests, Details (if non-nil) describes the tightest applicable
+// request or token limit so callers can advertise remaining capacity.
 type ReservationResult struct {
 	Allowed           bool
 	ReservationID     string
@@ -28,31 +31,94 @@ type ReservationResult struct {
 // LimitDetails describes which limit triggered and its characteristics
 type LimitDetails struct {
 	// ScopeKey is the specific scoped key that triggered the limit, e.g., "global",
-	// "provider:openai", "model:gpt-4o", "key:abc...", or "user:123".
+	// "provider:openai", "model:gpt-4o", "key:abc...", "user:123", or "team:eng".
 	ScopeKey string
-	// Metric is either "requests" or "tokens".
+	// Metric is "requests", "tokens" or "cost".
 	Metric string
-	// Window indicates the time window of the exceeded limit: "minute" or "day".
+	// Window indicates the time window of the exceeded limit: "minute", "hour" or "day".
+	// The hour window only applies to cost limits.
 	Window string
 	// Limit is the configured maximum for the window (0 if unlimited/unknown).
 	Limit int
-	// Remaining is the best-effort estimate of remaining capacity at the time of denial.
+	// Remaining is the best-effort estimate of remaining capacity after the
+	// reservation (allowed) or at the time of denial.
 	Remaining int
+	// ResetSeconds is the time until the window resets.
+	ResetSeconds int
+	// CostLimit and CostRemaining are set instead of Limit/Remaining when Metric is "cost" (USD).
+	CostLimit     float64
+	CostRemaining float64
 }
 
 // RateLimiter defines the minimum functionality for enforcing limits.
 type RateLimiter interface {
-	// CheckAndReserve attempts to atomically count 1 request and estTokens across
-	// all applicable limits for the provided scope. If any limit would be exceeded,
+	// CheckAndReserve attempts to atomically count 1 request, estTokens and estCost (USD)
+	// across all applicable limits for the provided scope. If any limit would be exceeded,
 	// the call returns Allowed=false and does not mutate counters.
-	CheckAndReserve(ctx context.Context, id string, scope ScopeKeys, estTokens int, now time.Time) (ReservationResult, error)
+	CheckAndReserve(ctx context.Context, id string, scope ScopeKeys, estTokens int, estCost float64, now time.Time) (ReservationResult, error)
 
-	// Adjust reconciles a prior reservation by applying the token delta
+	// ReserveRequest counts 1 request against request limits only, for requests
+	// answered without an upstream call (e.g. response cache hits). Token and
+	// cost limits neither apply nor change.
+	ReserveRequest(ctx context.Context, id string, scope ScopeKeys, now time.Time) (ReservationResult, error)
+
+	// Adjust reconciles a prior reservation by applying the token and cost deltas
 	// (actual-estimated) across the same scope. Negative deltas credit back.
-	Adjust(ctx context.Context, id string, scope ScopeKeys, tokenDelta int, now time.Time) error
+	Adjust(ctx context.Context, id string, scope ScopeKeys, tokenDelta int, costDelta float64, now time.Time) error
+
+	// Cancel releases the effects of a prior reservation entirely (e.g., upstream error):
+	// the request and the estTokens and estCost it reserved.
+	Cancel(ctx context.Context, id string, scope ScopeKeys, estTokens int, estCost float64, now time.Time) error
+
+	// Usage returns current counters and effective limits for every tracked scope
+	// key starting with prefix ("" for all), for inspection by operators.
+	Usage(ctx context.Context, prefix string, now time.Time) ([]ScopeUsage, error)
+
+	// Reset clears all counters for a scope key (e.g. "team:eng").
+	Reset(ctx context.Context, scopeKey string, now time.Time) error
+
+	// SetOverride applies temporary limits to a scope key until expiresAt. Positive
+	// values replace the configured limits; zero values keep them.
+	SetOverride(ctx context.Context, scopeKey string, limits config.LimitsConfig, expiresAt time.Time) error
+
+	// ClearOverride removes a temporary override before it expires.
+	ClearOverride(ctx context.Context, scopeKey string) error
+
+	// SetConfig replaces the configured limits and overrides, e.g. after a
+	// configuration reload. Counters and temporary overrides are kept.
+	SetConfig(cfg *config.YAMLConfig)
+
+	// Config returns the configuration in effect
+	Config() *config.YAMLConfig
+}
+
+// ScopeUsage describes the current counters and effective limits of one scope key.
+type ScopeUsage struct {
+	ScopeKey string              `json:"scope_key"`
+	Minute   UsageCounters       `json:"minute"`
+	Hour     UsageCounters       `json:"hour"`
+	Day      UsageCounters       `json:"day"`
+	Limits   config.LimitsConfig `json:"limits"`
+	Override *TemporaryOverride  `json:"override,omitempty"`
+}
+
+// UsageCounters holds the counts for a single window. The hour window only tracks cost.
+type UsageCounters struct {
+	Requests int     `json:"requests"`
+	Tokens   int     `json:"tokens"`
+	Cost     float64 `json:"cost"`
+}
+
+// TemporaryOverride is a limit override applied at runtime with an expiry.
+type TemporaryOverride struct {
+	Limits    config.LimitsConfig `json:"limits"`
+	ExpiresAt time.Time           `json:"expires_at"`
+}
 
-	// Cancel releases the effects of a prior reservation entirely (e.g., upstream error).
-	Cancel(ctx context.Context, id string, scope ScopeKeys, now time.Time) error
+// CostEstimator prices token usage for cost-based limits. It is satisfied by
+// cost.CostTracker so that limits and cost records share the same pricing data.
+type CostEstimator interface {
+	EstimateCost(provider, model string, inputTokens, outputTokens int) (float64, error)
 }
 
 // Factory creates a RateLimiter based on configuration.
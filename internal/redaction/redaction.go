@@ -0,0 +1,208 @@
+// Package redaction detects personal data and secrets in prompt text with
+// regular expressions, confirmed by checksums where the format has one, and
+// masks them.
+package redaction
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Mode is what the proxy does with prompts containing detections
+type Mode string
+
+const (
+	// ModeOff skips detection
+	ModeOff Mode = "off"
+	// ModeLog logs and counts detections but forwards prompts unchanged
+	ModeLog Mode = "log"
+	// ModeMask replaces detections with [REDACTED:<detector>]
+	ModeMask Mode = "mask"
+	// ModeBlock rejects requests containing detections
+	ModeBlock Mode = "block"
+)
+
+// ParseMode validates a mode name
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeOff, ModeLog, ModeMask, ModeBlock:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown redaction mode %q (want off, log, mask or block)", s)
+}
+
+// Detector finds one kind of sensitive value
+type Detector struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Valid filters pattern matches, e.g. with a checksum (optional)
+	Valid func(match string) bool
+}
+
+// Detectors are the built-in detectors, in priority order: when matches
+// overlap, the earlier detector wins
+var Detectors = []Detector{
+	{
+		Name:    "private_key",
+		Pattern: regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----[\s\S]*?-----END (?:[A-Z0-9]+ )*PRIVATE KEY-----`),
+	},
+	{
+		Name:    "aws_access_key",
+		Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[A-Z2-7]{16}\b`),
+	},
+	{
+		// Secret keys are only recognizable next to their usual names
+		Name:    "aws_secret_key",
+		Pattern: regexp.MustCompile(`(?i)aws_?secret_?(?:access_?)?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+]{40}\b`),
+	},
+	{
+		Name:    "credit_card",
+		Pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		Valid:   luhnValid,
+	},
+	{
+		Name:    "ssn",
+		Pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`),
+		Valid:   ssnValid,
+	},
+	{
+		Name:    "email",
+		Pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+	},
+	{
+		// North American numbers with separators, or international E.164
+		Name:    "phone",
+		Pattern: regexp.MustCompile(`(?:\+1[ .-]?)?(?:\(\d{3}\)\s?|\b\d{3}[ .-])\d{3}[ .-]\d{4}\b|\+[1-9]\d{7,14}\b`),
+	},
+}
+
+// luhnValid checks a card number's Luhn checksum, ignoring separators
+func luhnValid(match string) bool {
+	sum, digits := 0, 0
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+// ssnValid rejects numbers the SSA never issues: area 000, 666 or 900-999,
+// group 00 and serial 0000
+func ssnValid(match string) bool {
+	area, group, serial := match[:3], match[4:6], match[7:]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// Finding is one detected value
+type Finding struct {
+	Detector string
+	Value    string
+	Start    int
+	End      int
+}
+
+// Counts maps detector names to the number of detections
+type Counts map[string]int
+
+// Names returns the detector names in c, sorted
+func (c Counts) Names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Redactor scans text with a set of detectors
+type Redactor struct {
+	detectors []Detector
+}
+
+// New creates a Redactor using the named built-in detectors, or all of them
+// when names is empty
+func New(names []string) (*Redactor, error) {
+	if len(names) == 0 {
+		return &Redactor{detectors: Detectors}, nil
+	}
+	enabled := make(map[string]bool, len(names))
+	for _, name := range names {
+		enabled[name] = true
+	}
+	r := &Redactor{}
+	for _, d := range Detectors {
+		if enabled[d.Name] {
+			r.detectors = append(r.detectors, d)
+			delete(enabled, d.Name)
+		}
+	}
+	for name := range enabled {
+		return nil, fmt.Errorf("unknown redaction detector %q", name)
+	}
+	return r, nil
+}
+
+// Scan returns the non-overlapping detections in text, in order
+func (r *Redactor) Scan(text string) []Finding {
+	var findings []Finding
+	for _, d := range r.detectors {
+		for _, loc := range d.Pattern.FindAllStringIndex(text, -1) {
+			value := text[loc[0]:loc[1]]
+			if d.Valid != nil && !d.Valid(value) {
+				continue
+			}
+			if overlaps(findings, loc[0], loc[1]) {
+				continue
+			}
+			findings = append(findings, Finding{Detector: d.Name, Value: value, Start: loc[0], End: loc[1]})
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Start < findings[j].Start })
+	return findings
+}
+
+func overlaps(findings []Finding, start, end int) bool {
+	for _, f := range findings {
+		if start < f.End && f.Start < end {
+			return true
+		}
+	}
+	return false
+}
+
+// Placeholder is the text that replaces a value found by detector
+func Placeholder(detector string) string {
+	return "[REDACTED:" + detector + "]"
+}
+
+// Mask replaces detections in text with placeholders and counts them
+func (r *Redactor) Mask(text string) (string, Counts) {
+	findings := r.Scan(text)
+	if len(findings) == 0 {
+		return text, nil
+	}
+	counts := make(Counts)
+	var b strings.Builder
+	last := 0
+	for _, f := range findings {
+		b.WriteString(text[last:f.Start])
+		b.WriteString(Placeholder(f.Detector))
+		last = f.End
+		counts[f.Detector]++
+	}
+	b.WriteString(text[last:])
+	return b.String(), counts
+}
@@ -0,0 +1,113 @@
+// Package requestid generates the proxy's request IDs and carries them
+// through contexts. IDs are ULIDs unless the client supplied its own.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Header is the header clients may set to choose the request ID, and that
+// carries the ID on responses
+const Header = "X-Request-ID"
+
+// UpstreamHeader carries a provider's own request ID on responses, so that
+// Header holds only the proxy's
+const UpstreamHeader = "X-Upstream-Request-ID"
+
+// maxLength bounds client-supplied IDs so they stay cheap to log and store
+const maxLength = 128
+
+// crockford is the Crockford base32 alphabet used by ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type contextKey struct{}
+
+var (
+	mu       sync.Mutex
+	lastMS   uint64
+	lastRand [10]byte
+)
+
+// New returns a ULID for the current time. IDs generated in the same
+// millisecond increase monotonically so they sort in generation order.
+func New() string {
+	return newAt(time.Now())
+}
+
+func newAt(t time.Time) string {
+	ms := uint64(t.UnixMilli())
+
+	mu.Lock()
+	if ms <= lastMS {
+		ms = lastMS
+		// Increment the 80-bit random part; on overflow, move to the next millisecond
+		i := len(lastRand) - 1
+		for ; i >= 0; i-- {
+			lastRand[i]++
+			if lastRand[i] != 0 {
+				break
+			}
+		}
+		if i < 0 {
+			ms++
+		}
+	} else {
+		_, _ = rand.Read(lastRand[:])
+	}
+	lastMS = ms
+	entropy := lastRand
+	mu.Unlock()
+
+	var raw [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(raw[:6], ts[2:])
+	copy(raw[6:], entropy[:])
+	return encode(raw)
+}
+
+// encode writes the 128-bit ULID as 26 base32 characters, most significant
+// bits first
+func encode(raw [16]byte) string {
+	hi := binary.BigEndian.Uint64(raw[:8])
+	lo := binary.BigEndian.Uint64(raw[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// Valid reports whether a client-supplied ID is usable: non-empty, at most
+// 128 characters, and printable ASCII without spaces
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext returns a copy of ctx that carries id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID stored in ctx, or an empty string
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,46 @@
+package requestid
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewIsMonotonicULID(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	prev := newAt(now)
+	if len(prev) != 26 || strings.Trim(prev, crockford) != "" {
+		t.Fatalf("expected a 26 character ULID, got %q", prev)
+	}
+	// The first 10 characters encode the millisecond timestamp
+	if got := newAt(now.Add(time.Millisecond))[:10]; got <= prev[:10] {
+		t.Fatalf("timestamp prefix did not advance: %q then %q", prev[:10], got)
+	}
+	prev = newAt(now.Add(time.Millisecond))
+	for i := 0; i < 1000; i++ {
+		// Same (or an earlier) millisecond still sorts after the previous ID
+		id := newAt(now)
+		if id <= prev {
+			t.Fatalf("IDs not increasing: %q then %q", prev, id)
+		}
+		prev = id
+	}
+}
+
+func TestValid(t *testing.T) {
+	for id, want := range map[string]bool{
+		"01JA2Z6F3YV3Q1W7K9X8M5N4PB":           true,
+		"req-123_abc.def:1":                    true,
+		"":                                     false,
+		"has space":                            false,
+		"line\nbreak":                          false,
+		"ünicode":                              false,
+		strings.Repeat("a", maxLength+1):       false,
+		strings.Repeat("a", maxLength):         true,
+		"4bf92f35-77b3-4da6-a3ce-929d0e0e4736": true,
+	} {
+		if got := Valid(id); got != want {
+			t.Errorf("Valid(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
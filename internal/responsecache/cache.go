@@ -0,0 +1,207 @@
+// Package responsecache stores provider responses to non-streaming requests
+// so that identical requests from the same key or team can be answered
+// without calling the provider again.
+package responsecache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/Instawork/llm-proxy/internal/metrics"
+)
+
+// Defaults used when Options leaves them unset
+const (
+	DefaultTTL          = time.Hour
+	DefaultMaxBodyBytes = 1024 * 1024
+	DefaultMaxEntries   = 10000
+)
+
+var requestsTotal = metrics.NewCounterVec("llm_proxy_response_cache_requests_total",
+	"Cacheable requests, by result (hit, miss, bypass, error)", "result")
+
+// Entry is a stored response and the usage it was billed for
+type Entry struct {
+	// Status, ContentType, ContentEncoding and Body hold a non-streaming
+	// response; Body is nil for entries stored from streams
+	Status          int       `json:"status"`
+	ContentType     string    `json:"content_type,omitempty"`
+	ContentEncoding string    `json:"content_encoding,omitempty"`
+	Body            []byte    `json:"body,omitempty"`
+	StoredAt        time.Time `json:"stored_at"`
+
+	// Text is the completion's text when Replayable, i.e. when the response
+	// can be replayed to streaming requests from its text alone
+	Text       string `json:"text,omitempty"`
+	Replayable bool   `json:"replayable,omitempty"`
+
+	Model             string `json:"model,omitempty"`
+	UpstreamRequestID string `json:"upstream_request_id,omitempty"`
+	InputTokens       int    `json:"input_tokens"`
+	OutputTokens      int    `json:"output_tokens"`
+	TotalTokens       int    `json:"total_tokens"`
+	FinishReason      string `json:"finish_reason,omitempty"`
+}
+
+// Store holds entries until they expire
+type Store interface {
+	// Get returns the entry stored under key, or nil when there is none
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error
+	Close() error
+}
+
+// Options configures a Cache
+type Options struct {
+	Store Store
+	// All caches requests from every key; otherwise only requests from the
+	// listed key IDs or teams are cached
+	All   bool
+	Keys  []string
+	Teams []string
+	// TTL is how long responses are served from the cache
+	TTL time.Duration
+	// MaxBodyBytes is the largest response body that is stored
+	MaxBodyBytes int
+	Logger       *slog.Logger
+}
+
+// Cache decides which requests are cacheable and stores their responses
+type Cache struct {
+	store        Store
+	all          bool
+	keys         map[string]bool
+	teams        map[string]bool
+	ttl          time.Duration
+	maxBodyBytes int
+	logger       *slog.Logger
+}
+
+// New creates a Cache backed by opts.Store
+func New(opts Options) *Cache {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &Cache{
+		store:        opts.Store,
+		all:          opts.All,
+		keys:         toSet(opts.Keys),
+		teams:        toSet(opts.Teams),
+		ttl:          opts.TTL,
+		maxBodyBytes: opts.MaxBodyBytes,
+		logger:       opts.Logger,
+	}
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// Enabled reports whether requests from keyID or teamID are cached
+func (c *Cache) Enabled(keyID, teamID string) bool {
+	return c.all || (keyID != "" && c.keys[keyID]) || (teamID != "" && c.teams[teamID])
+}
+
+// MaxBodyBytes is the largest response body that is stored
+func (c *Cache) MaxBodyBytes() int {
+	return c.maxBodyBytes
+}
+
+// Serves reports whether the entry can answer a streaming or non-streaming
+// request
+func (e *Entry) Serves(streaming bool) bool {
+	if streaming {
+		return e.Replayable
+	}
+	return e.Body != nil
+}
+
+// Get returns the entry stored under key if it can answer a streaming or
+// non-streaming request, or nil on a miss. Store errors are logged and
+// treated as misses so the cache never fails a request.
+func (c *Cache) Get(ctx context.Context, key string, streaming bool) *Entry {
+	entry, err := c.store.Get(ctx, key)
+	if err != nil {
+		requestsTotal.WithLabelValues("error").Inc()
+		c.logger.Warn("🗄️ Response Cache: Failed to read entry", "error", err)
+		return nil
+	}
+	if entry == nil || !entry.Serves(streaming) {
+		requestsTotal.WithLabelValues("miss").Inc()
+		return nil
+	}
+	requestsTotal.WithLabelValues("hit").Inc()
+	return entry
+}
+
+// Set stores entry under key for the configured TTL
+func (c *Cache) Set(ctx context.Context, key string, entry *Entry) {
+	if len(entry.Body) > c.maxBodyBytes || len(entry.Text) > c.maxBodyBytes {
+		return
+	}
+	if err := c.store.Set(ctx, key, entry, c.ttl); err != nil {
+		c.logger.Warn("🗄️ Response Cache: Failed to store entry", "error", err)
+	}
+}
+
+// Bypassed counts a request whose client asked to skip the cache
+func (c *Cache) Bypassed() {
+	requestsTotal.WithLabelValues("bypass").Inc()
+}
+
+// Close releases the store's resources
+func (c *Cache) Close() error {
+	return c.store.Close()
+}
+
+// Key derives the cache key of a request. scope keeps keys or teams from
+// sharing entries; variant holds anything else the response depends on,
+// such as the path and Accept-Encoding. JSON bodies, which carry the model
+// for most providers, are normalized so that whitespace and field order do
+// not matter, and their top-level stream and stream_options fields are
+// dropped so streaming and non-streaming requests share entries.
+func Key(scope, provider, variant string, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{scope, provider, variant} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(normalize(body))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalize re-encodes a JSON body with sorted object keys, no
+// insignificant whitespace and no streaming options, or returns it
+// unchanged if it is not JSON
+func normalize(body []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return body
+	}
+	if obj, ok := v.(map[string]any); ok {
+		delete(obj, "stream")
+		delete(obj, "stream_options")
+	}
+	normalized, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return normalized
+}
@@ -0,0 +1,66 @@
+package responsecache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyNormalizesJSON(t *testing.T) {
+	a := Key("key:1", "openai", "/v1/chat/completions", []byte(`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`))
+	b := Key("key:1", "openai", "/v1/chat/completions", []byte("{\n  \"messages\": [{\"content\": \"hi\", \"role\": \"user\"}],\n  \"temperature\": 0, \"model\": \"gpt-4o\"}"))
+	if a != b {
+		t.Fatal("expected field order and whitespace not to change the key")
+	}
+	streaming := Key("key:1", "openai", "/v1/chat/completions", []byte(`{"model":"gpt-4o","temperature":0,"stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hi"}]}`))
+	if a != streaming {
+		t.Fatal("expected streaming requests to share the key")
+	}
+	if a == Key("team:1", "openai", "/v1/chat/completions", []byte(`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`)) {
+		t.Fatal("expected scopes not to share keys")
+	}
+	if a == Key("key:1", "openai", "/v1/chat/completions", []byte(`{"model":"gpt-4o","temperature":0.0001,"messages":[{"role":"user","content":"hi"}]}`)) {
+		t.Fatal("expected different bodies to have different keys")
+	}
+}
+
+func TestMemoryStoreTTLAndEviction(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	s := NewMemoryStore(2)
+	s.now = func() time.Time { return now }
+
+	for _, key := range []string{"a", "b"} {
+		if err := s.Set(ctx, key, &Entry{Body: []byte(key)}, time.Minute); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	// Touch a so b is the least recently used
+	if e, _ := s.Get(ctx, "a"); e == nil {
+		t.Fatal("expected a to be cached")
+	}
+	_ = s.Set(ctx, "c", &Entry{Body: []byte("c")}, time.Minute)
+	if e, _ := s.Get(ctx, "b"); e != nil {
+		t.Fatal("expected b to be evicted")
+	}
+	if s.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", s.Len())
+	}
+
+	now = now.Add(time.Minute)
+	if e, _ := s.Get(ctx, "a"); e != nil {
+		t.Fatal("expected a to expire")
+	}
+}
+
+func TestCacheEnabledAndMaxBody(t *testing.T) {
+	store := NewMemoryStore(0)
+	c := New(Options{Store: store, Keys: []string{"k1"}, Teams: []string{"batch"}, MaxBodyBytes: 4})
+	if !c.Enabled("k1", "") || !c.Enabled("k2", "batch") || c.Enabled("k2", "web") || c.Enabled("", "") {
+		t.Fatal("unexpected opt-in decision")
+	}
+	c.Set(context.Background(), "big", &Entry{Body: []byte("too large")})
+	if store.Len() != 0 {
+		t.Fatal("expected bodies over MaxBodyBytes not to be stored")
+	}
+}
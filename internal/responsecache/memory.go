@@ -0,0 +1,87 @@
+package responsecache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryItem struct {
+	key       string
+	entry     *Entry
+	expiresAt time.Time
+}
+
+// MemoryStore is an in-process LRU store
+type MemoryStore struct {
+	maxEntries int
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	lru   *list.List
+
+	// now is overridable in tests
+	now func() time.Time
+}
+
+// NewMemoryStore creates a store holding at most maxEntries entries
+// (default 10000), evicting the least recently used first
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		items:      make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// Get returns the unexpired entry stored under key
+func (s *MemoryStore) Get(_ context.Context, key string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := el.Value.(*memoryItem)
+	if !s.now().Before(item.expiresAt) {
+		s.lru.Remove(el)
+		delete(s.items, key)
+		return nil, nil
+	}
+	s.lru.MoveToFront(el)
+	return item.entry, nil
+}
+
+// Set stores entry under key until ttl elapses
+func (s *MemoryStore) Set(_ context.Context, key string, entry *Entry, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := &memoryItem{key: key, entry: entry, expiresAt: s.now().Add(ttl)}
+	if el, ok := s.items[key]; ok {
+		el.Value = item
+		s.lru.MoveToFront(el)
+		return nil
+	}
+	s.items[key] = s.lru.PushFront(item)
+	for s.lru.Len() > s.maxEntries {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoryItem).key)
+	}
+	return nil
+}
+
+// Len returns the number of stored entries, including expired ones not yet evicted
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+// Close is a no-op
+func (s *MemoryStore) Close() error { return nil }
@@ -0,0 +1,57 @@
+package responsecache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces cache entries in a shared Redis
+const redisKeyPrefix = "llm-proxy:response-cache:"
+
+// RedisStore keeps entries in Redis so they are shared between instances
+type RedisStore struct {
+	rdb *redis.Client
+}
+
+// NewRedisStore creates a store using rdb
+func NewRedisStore(rdb *redis.Client) *RedisStore {
+	return &RedisStore{rdb: rdb}
+}
+
+// Get returns the entry stored under key
+func (s *RedisStore) Get(ctx context.Context, key string) (*Entry, error) {
+	data, err := s.rdb.Get(ctx, redisKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache entry: %w", err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode cache entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// Set stores entry under key until ttl elapses
+func (s *RedisStore) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+	if err := s.rdb.Set(ctx, redisKeyPrefix+key, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set cache entry: %w", err)
+	}
+	return nil
+}
+
+// Close closes the Redis client
+func (s *RedisStore) Close() error {
+	return s.rdb.Close()
+}
@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Exporter sends finished spans to a tracing backend
+type Exporter = sdktrace.SpanExporter
+
+// NewStdoutExporter creates an exporter that writes each span to w as a JSON
+// line, for tests and local debugging
+func NewStdoutExporter(w io.Writer) (Exporter, error) {
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+	}
+	return exporter, nil
+}
+
+// NewOTLPExporter creates an exporter for the OTLP/HTTP collector at endpoint
+// (e.g. http://localhost:4318); spans are posted to endpoint/v1/traces
+func NewOTLPExporter(ctx context.Context, endpoint string, headers map[string]string) (Exporter, error) {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	exporter, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(url),
+		otlptracehttp.WithHeaders(headers),
+		otlptracehttp.WithTimeout(10*time.Second),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	return exporter, nil
+}
@@ -0,0 +1,147 @@
+package tracing
+
+import (
+	"io"
+	"net/http"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// ServerMiddleware starts a server span for each request, continuing the
+// trace from incoming traceparent and tracestate headers. It should be the
+// outermost middleware so every other span nests under it.
+func ServerMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := Start(ctx, r.Method,
+				WithKind(SpanKindServer),
+				WithAttributes(
+					String("http.request.method", r.Method),
+					String("url.path", r.URL.Path),
+					String("user_agent.original", r.UserAgent()),
+				),
+			)
+			defer span.End()
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			span.SetAttributes(Int("http.response.status_code", sw.status))
+			if sw.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(sw.status))
+			}
+		})
+	}
+}
+
+// Middleware wraps mw so each request through it is timed as a span named
+// name. The span covers everything mw calls, so nested middleware show up as
+// children. mw is returned unchanged while tracing is disabled.
+func Middleware(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	if !Enabled() {
+		return mw
+	}
+	return func(next http.Handler) http.Handler {
+		inner := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := Start(r.Context(), name)
+			defer span.End()
+			inner.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// NewTransport wraps base so each request is a client span and carries
+// traceparent and tracestate headers for the span. The span ends when the response body is
+// closed, so streamed responses are timed to their last byte.
+func NewTransport(base http.RoundTripper, attrs ...Attribute) http.RoundTripper {
+	return &transport{base: base, attrs: attrs}
+}
+
+type transport struct {
+	base  http.RoundTripper
+	attrs []Attribute
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !Enabled() {
+		return t.base.RoundTrip(req)
+	}
+	attrs := append([]Attribute{
+		String("http.request.method", req.Method),
+		String("server.address", req.URL.Hostname()),
+		String("url.path", req.URL.Path),
+	}, t.attrs...)
+	ctx, span := Start(req.Context(), req.Method, WithKind(SpanKindClient), WithAttributes(attrs...))
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(ctx)
+	propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		RecordError(span, err)
+		span.End()
+		return nil, err
+	}
+	span.SetAttributes(Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// Upgraded bodies must stay io.ReadWriteCloser for the reverse proxy
+		span.End()
+		return resp, nil
+	}
+	resp.Body = &spanBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// spanBody ends its span when the response body is closed
+type spanBody struct {
+	io.ReadCloser
+	span Span
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.span.End()
+	return err
+}
+
+// statusWriter captures the response status code
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush passes flushes through so streaming responses are not buffered
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
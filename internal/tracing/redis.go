@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// RedisHook is a go-redis hook that records a client span per command or pipeline
+type RedisHook struct{}
+
+var _ redis.Hook = RedisHook{}
+
+// DialHook passes dials through untraced
+func (RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+// ProcessHook wraps a single command in a span named after it
+func (RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := Start(ctx, "redis "+cmd.Name(), WithKind(SpanKindClient),
+			WithAttributes(String("db.system", "redis"), String("db.operation.name", strings.ToUpper(cmd.Name()))))
+		err := next(ctx, cmd)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			RecordError(span, err)
+		}
+		span.End()
+		return err
+	}
+}
+
+// ProcessPipelineHook wraps a pipeline or transaction in one span
+func (RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := Start(ctx, "redis pipeline", WithKind(SpanKindClient),
+			WithAttributes(String("db.system", "redis"), Int("db.operation.batch.size", len(cmds))))
+		err := next(ctx, cmds)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			RecordError(span, err)
+		}
+		span.End()
+		return err
+	}
+}
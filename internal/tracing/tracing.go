@@ -0,0 +1,196 @@
+// Package tracing records spans with the OpenTelemetry SDK. Trace context is
+// read from and written to W3C traceparent and tracestate headers, and
+// finished spans are exported in batches over OTLP/HTTP or as JSON for local
+// debugging. The tracer provider and propagator are installed globally, so
+// other OpenTelemetry instrumentation joins the same traces.
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// scopeName is the instrumentation scope reported to tracing backends
+const scopeName = "github.com/Instawork/llm-proxy"
+
+// propagator reads and writes W3C trace context and baggage headers
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Attribute is a span attribute
+type Attribute = attribute.KeyValue
+
+// String returns a string attribute
+func String(key, value string) Attribute { return attribute.String(key, value) }
+
+// Int returns an integer attribute
+func Int(key string, value int) Attribute { return attribute.Int(key, value) }
+
+// Float64 returns a floating point attribute
+func Float64(key string, value float64) Attribute { return attribute.Float64(key, value) }
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute { return attribute.Bool(key, value) }
+
+// Strings returns a string array attribute
+func Strings(key string, values ...string) Attribute { return attribute.StringSlice(key, values) }
+
+// SpanKind is the OpenTelemetry span kind
+type SpanKind = trace.SpanKind
+
+// Span kinds used by the proxy
+const (
+	SpanKindInternal = trace.SpanKindInternal
+	SpanKindServer   = trace.SpanKindServer
+	SpanKindClient   = trace.SpanKindClient
+)
+
+// Span is an operation being timed. Spans started while tracing is disabled
+// record nothing.
+type Span = trace.Span
+
+// StartOption configures a span created by Start
+type StartOption = trace.SpanStartOption
+
+// WithKind sets the span kind (default SpanKindInternal)
+func WithKind(kind SpanKind) StartOption { return trace.WithSpanKind(kind) }
+
+// WithAttributes sets initial span attributes
+func WithAttributes(attrs ...Attribute) StartOption { return trace.WithAttributes(attrs...) }
+
+// RecordError records err on span and marks the span as failed, if err is non-nil
+func RecordError(span Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+type localRootContextKey struct{}
+
+// LocalRootSpan returns the first span started in this process for the
+// current request, usually the server span, or nil
+func LocalRootSpan(ctx context.Context) Span {
+	s, _ := ctx.Value(localRootContextKey{}).(Span)
+	return s
+}
+
+// Options configures a Tracer
+type Options struct {
+	ServiceName string
+	Exporter    Exporter
+	// SampleRatio is the fraction of new traces recorded; 0 records all.
+	// Incoming traces keep the caller's sampling decision.
+	SampleRatio   float64
+	BatchSize     int           // Spans per export (default 512)
+	FlushInterval time.Duration // Maximum delay before export (default 5s)
+	QueueSize     int           // Spans buffered before dropping (default 2048)
+	Logger        *slog.Logger
+}
+
+// Tracer creates spans and exports them in the background
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	logger   *slog.Logger
+}
+
+// NewTracer creates a tracer provider that batches spans to opts.Exporter
+func NewTracer(opts Options) *Tracer {
+	if opts.ServiceName == "" {
+		opts.ServiceName = "llm-proxy"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 512
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 2048
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if opts.SampleRatio > 0 && opts.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(opts.SampleRatio)
+	}
+	providerOpts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", opts.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	}
+	if opts.Exporter != nil {
+		providerOpts = append(providerOpts, sdktrace.WithBatcher(opts.Exporter,
+			sdktrace.WithMaxExportBatchSize(opts.BatchSize),
+			sdktrace.WithBatchTimeout(opts.FlushInterval),
+			sdktrace.WithMaxQueueSize(opts.QueueSize),
+			sdktrace.WithExportTimeout(10*time.Second),
+		))
+	}
+	provider := sdktrace.NewTracerProvider(providerOpts...)
+	return &Tracer{provider: provider, tracer: provider.Tracer(scopeName), logger: opts.Logger}
+}
+
+// Start begins a span that is a child of the span in ctx, if any, including
+// a remote parent extracted from incoming headers
+func (t *Tracer) Start(ctx context.Context, name string, opts ...StartOption) (context.Context, Span) {
+	ctx, span := t.tracer.Start(ctx, name, opts...)
+	if LocalRootSpan(ctx) == nil {
+		ctx = context.WithValue(ctx, localRootContextKey{}, span)
+	}
+	return ctx, span
+}
+
+// ForceFlush exports all queued spans
+func (t *Tracer) ForceFlush(ctx context.Context) error {
+	return t.provider.ForceFlush(ctx)
+}
+
+// Shutdown exports queued spans and shuts down the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+// global is the process-wide tracer; nil disables tracing
+var global atomic.Pointer[Tracer]
+
+// SetTracer installs t as the process-wide tracer and as the global
+// OpenTelemetry tracer provider and propagator; nil disables tracing
+func SetTracer(t *Tracer) {
+	global.Store(t)
+	if t == nil {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return
+	}
+	otel.SetTracerProvider(t.provider)
+	otel.SetTextMapPropagator(propagator)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		t.logger.Warn("🔭 Tracing: OpenTelemetry error", "error", err)
+	}))
+}
+
+// Enabled reports whether a process-wide tracer is installed
+func Enabled() bool { return global.Load() != nil }
+
+// Start begins a span with the process-wide tracer. While tracing is
+// disabled it returns ctx unchanged and a span that records nothing.
+func Start(ctx context.Context, name string, opts ...StartOption) (context.Context, Span) {
+	t := global.Load()
+	if t == nil {
+		return ctx, noop.Span{}
+	}
+	return t.Start(ctx, name, opts...)
+}
@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// spansByKind returns the spans recorded by exporter, one per kind
+func spansByKind(exporter *tracetest.InMemoryExporter) map[SpanKind]tracetest.SpanStub {
+	spans := make(map[SpanKind]tracetest.SpanStub)
+	for _, s := range exporter.GetSpans() {
+		spans[s.SpanKind] = s
+	}
+	return spans
+}
+
+func TestMiddlewareChainPropagatesUpstream(t *testing.T) {
+	var upstreamHeaders http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeaders = r.Header.Clone()
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := NewTracer(Options{Exporter: exporter})
+	SetTracer(tracer)
+	defer SetTracer(nil)
+	defer tracer.Shutdown(context.Background())
+
+	client := &http.Client{Transport: NewTransport(http.DefaultTransport, String("gen_ai.system", "test"))}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), "POST", upstream.URL+"/v1/chat", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("upstream: %v", err)
+			return
+		}
+		_, _ = io.Copy(w, resp.Body)
+		resp.Body.Close()
+	})
+	passthrough := func(next http.Handler) http.Handler { return next }
+	chain := ServerMiddleware()(Middleware("rate_limiting", passthrough)(handler))
+
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader("{}"))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=abc")
+	chain.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := tracer.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush: %v", err)
+	}
+	spans := spansByKind(exporter)
+	server, mw, upstreamSpan := spans[SpanKindServer], spans[SpanKindInternal], spans[SpanKindClient]
+	if server.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent.SpanID().String() != "00f067aa0ba902b7" || !server.Parent.IsRemote() {
+		t.Fatalf("server span did not continue the incoming trace: %+v", server)
+	}
+	if mw.Parent.SpanID() != server.SpanContext.SpanID() || upstreamSpan.Parent.SpanID() != mw.SpanContext.SpanID() {
+		t.Fatalf("spans not nested: server=%s middleware=%+v client=%+v", server.SpanContext.SpanID(), mw, upstreamSpan)
+	}
+	found := false
+	for _, a := range upstreamSpan.Attributes {
+		found = found || (a.Key == "gen_ai.system" && a.Value.AsString() == "test")
+	}
+	if !found {
+		t.Fatalf("client span missing attributes: %+v", upstreamSpan.Attributes)
+	}
+	want := "00-" + upstreamSpan.SpanContext.TraceID().String() + "-" + upstreamSpan.SpanContext.SpanID().String() + "-01"
+	if got := upstreamHeaders.Get("traceparent"); got != want {
+		t.Fatalf("upstream traceparent %q, want %q", got, want)
+	}
+	if got := upstreamHeaders.Get("tracestate"); got != "vendor=abc" {
+		t.Fatalf("upstream tracestate %q, want the caller's", got)
+	}
+}
+
+func TestStartWhileDisabled(t *testing.T) {
+	SetTracer(nil)
+	ctx, span := Start(context.Background(), "noop")
+	span.SetAttributes(String("k", "v"))
+	RecordError(span, io.EOF)
+	span.End()
+	if span.IsRecording() || LocalRootSpan(ctx) != nil {
+		t.Fatalf("expected a span that records nothing while tracing is disabled")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	received := make(chan *coltracepb.ExportTraceServiceRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("X-Api-Key") != "secret" {
+			t.Errorf("unexpected request %s headers=%v", r.URL.Path, r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			t.Errorf("decode OTLP request: %v", err)
+		}
+		received <- &req
+	}))
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(context.Background(), collector.URL, map[string]string{"X-Api-Key": "secret"})
+	if err != nil {
+		t.Fatalf("NewOTLPExporter: %v", err)
+	}
+	tracer := NewTracer(Options{ServiceName: "llm-proxy-test", Exporter: exporter})
+	_, span := tracer.Start(context.Background(), "POST", WithKind(SpanKindServer), WithAttributes(Int("gen_ai.usage.input_tokens", 12)))
+	span.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	req := <-received
+	resourceSpans := req.GetResourceSpans()[0]
+	if attr := resourceSpans.GetResource().GetAttributes()[0]; attr.GetKey() != "service.name" || attr.GetValue().GetStringValue() != "llm-proxy-test" {
+		t.Fatalf("unexpected resource attribute %v", attr)
+	}
+	got := resourceSpans.GetScopeSpans()[0].GetSpans()[0]
+	if got.GetName() != "POST" || got.GetAttributes()[0].GetValue().GetIntValue() != 12 {
+		t.Fatalf("unexpected OTLP span %v", got)
+	}
+}